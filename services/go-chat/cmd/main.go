@@ -1,13 +1,19 @@
 package main
 
 import (
+	"fmt"
+	"go-chat/internal/auth"
 	"go-chat/internal/config"
 	"go-chat/internal/database"
 	"go-chat/internal/elasticsearch"
 	"go-chat/internal/logging"
 	"go-chat/internal/module/chat"
+	"go-chat/internal/numbering"
 	"go-chat/internal/queue"
+	"go-chat/internal/ratelimit"
+	"go-chat/internal/search"
 	"go-chat/internal/server"
+	"go-chat/internal/stream"
 
 	"go.uber.org/dig"
 )
@@ -18,8 +24,13 @@ func buildDigContainer() *dig.Container {
 	container.Provide(config.NewConfig)
 	container.Provide(logging.NewLogger)
 	container.Provide(database.ConnectDatabase)
-	container.Provide(queue.NewAMQP)
-	container.Provide(elasticsearch.NewClient)
+	container.Provide(newPublisher)
+	container.Provide(ratelimit.NewLimiter)
+	container.Provide(auth.NewAuthenticator)
+	container.Provide(stream.NewHub)
+	container.Provide(newSearchBackend)
+	container.Provide(search.NewCache)
+	container.Provide(numbering.NewAllocator)
 
 	// Chat dependencies
 	container.Provide(chat.NewRepo)
@@ -31,6 +42,34 @@ func buildDigContainer() *dig.Container {
 	return container
 }
 
+// newSearchBackend builds the search backend selected by SEARCH_BACKEND
+func newSearchBackend(cfg *config.Config, db *database.Database, hub *stream.Hub, logger *logging.Logger) (search.Backend, error) {
+	switch cfg.SearchBackend {
+	case "elasticsearch":
+		return elasticsearch.NewClient(cfg, db, logger)
+	case "memory":
+		return search.NewMemoryBackend(db, hub, logger), nil
+	default:
+		return nil, fmt.Errorf("unknown SEARCH_BACKEND %q", cfg.SearchBackend)
+	}
+}
+
+// newPublisher builds the message broker selected by BROKER
+func newPublisher(cfg *config.Config, db *database.Database, logger *logging.Logger) (queue.Publisher, error) {
+	switch cfg.Broker {
+	case queue.BrokerRabbitMQ:
+		return queue.NewAMQP(logger, cfg)
+	case queue.BrokerRedis:
+		return queue.NewRedisStreams(db), nil
+	case queue.BrokerMemory:
+		// Nothing published there would reach go-worker, tests build it
+		// with queue.NewMemory instead
+		return nil, fmt.Errorf("BROKER %q is only for tests, go-chat needs rabbitmq or redis", cfg.Broker)
+	default:
+		return nil, fmt.Errorf("unknown BROKER %q", cfg.Broker)
+	}
+}
+
 func main() {
 	var logger *logging.Logger
 	container := buildDigContainer()
@@ -0,0 +1,180 @@
+// numbench compares strict and block number allocation against a real Redis:
+// throughput, numbers handed out behind a higher one (arrival order lost
+// between instances), and the gaps an instance crash would leave.
+//
+//	go run ./cmd/numbench -requests 200000 -concurrency 64 -instances 2 -chats 1
+//
+// Redis is reached with the REDIS_* settings go-chat reads. Keys are written
+// under a throwaway application token and deleted afterwards.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"go-chat/internal/config"
+	"go-chat/internal/contract"
+	"go-chat/internal/database"
+	"go-chat/internal/logging"
+	"go-chat/internal/numbering"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type result struct {
+	mode       string
+	elapsed    time.Duration
+	handedOut  int64
+	outOfOrder int64
+	crashGaps  int64
+	released   int64
+}
+
+func main() {
+	requests := flag.Int("requests", 100000, "numbers to allocate per mode")
+	concurrency := flag.Int("concurrency", 50, "concurrent callers")
+	instances := flag.Int("instances", 2, "allocators sharing the counters, as go-chat instances would")
+	chats := flag.Int("chats", 1, "chats the requests are spread over")
+	blockSize := flag.Int("block", 100, "largest block leased in block mode")
+	flag.Parse()
+
+	cfg, err := config.NewConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	cfg.AppName = "numbench"
+	cfg.NumberBlockSize = *blockSize
+	// Leases must outlive the run, what is left of them is what a crash loses
+	cfg.NumberLeaseTTL = time.Hour
+
+	client, err := database.NewRedisClient(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "redis unreachable: %v\n", err)
+		os.Exit(1)
+	}
+	defer client.Close()
+
+	db := &database.Database{RedisDB: client}
+	logger := logging.NewLogger(cfg)
+
+	fmt.Printf("%d requests, %d callers, %d instances, %d chats, blocks up to %d\n\n",
+		*requests, *concurrency, *instances, *chats, *blockSize)
+	fmt.Printf("%-8s %10s %12s %14s %12s %10s\n", "mode", "elapsed", "numbers/s", "out of order", "crash gaps", "released")
+
+	for _, mode := range []string{numbering.ModeStrict, numbering.ModeBlock} {
+		cfg.NumberAllocation = mode
+		r, err := run(cfg, db, logger, *requests, *concurrency, *instances, *chats)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", mode, err)
+			os.Exit(1)
+		}
+		fmt.Printf("%-8s %10s %12.0f %14d %12d %10d\n",
+			r.mode, r.elapsed.Round(time.Millisecond), float64(r.handedOut)/r.elapsed.Seconds(),
+			r.outOfOrder, r.crashGaps, r.released)
+	}
+}
+
+func run(cfg *config.Config, db *database.Database, logger *logging.Logger, requests, concurrency, instances, chats int) (*result, error) {
+	ctx := context.Background()
+	token := fmt.Sprintf("numbench-%d-%s", time.Now().UnixNano(), cfg.NumberAllocation)
+
+	allocators := make([]*numbering.Allocator, instances)
+	for i := range allocators {
+		a, err := numbering.NewAllocator(cfg, db, logger)
+		if err != nil {
+			return nil, err
+		}
+		allocators[i] = a
+	}
+
+	// Highest number seen so far per chat, anything below it arrived out of order
+	highest := make([]int64, chats)
+	seen := make([]map[int64]bool, chats)
+	locks := make([]sync.Mutex, chats)
+	for i := range seen {
+		seen[i] = make(map[int64]bool)
+	}
+
+	var next, handedOut, outOfOrder int64
+	var failure atomic.Value
+	var wg sync.WaitGroup
+
+	start := time.Now()
+	for c := 0; c < concurrency; c++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := atomic.AddInt64(&next, 1) - 1
+				if i >= int64(requests) {
+					return
+				}
+				chat := int(i % int64(chats))
+				number, err := allocators[int(i)%instances].NextMessageNumber(token, chat+1)
+				if err != nil {
+					failure.Store(err)
+					return
+				}
+
+				locks[chat].Lock()
+				if seen[chat][number] {
+					failure.Store(fmt.Errorf("number %d of chat %d handed out twice", number, chat+1))
+				}
+				seen[chat][number] = true
+				if number < highest[chat] {
+					atomic.AddInt64(&outOfOrder, 1)
+				} else {
+					highest[chat] = number
+				}
+				locks[chat].Unlock()
+				atomic.AddInt64(&handedOut, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	if err, ok := failure.Load().(error); ok {
+		return nil, err
+	}
+
+	// Numbers taken from Redis but not handed out, lost if the instances died now
+	var allocated int64
+	for chat := 1; chat <= chats; chat++ {
+		n, err := db.RedisDB.Get(ctx, contract.MessageCounter(token, chat)).Int64()
+		if err != nil {
+			return nil, err
+		}
+		allocated += n
+	}
+
+	for _, a := range allocators {
+		a.Stop()
+	}
+	released, err := db.RedisDB.SCard(ctx, contract.ReleasedNumbers(token)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	// One DEL per key, the released set is in another slot than the counters
+	pipe := db.RedisDB.Pipeline()
+	pipe.Del(ctx, contract.ReleasedNumbers(token))
+	for chat := 1; chat <= chats; chat++ {
+		pipe.Del(ctx, contract.MessageCounter(token, chat))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	return &result{
+		mode:       cfg.NumberAllocation,
+		elapsed:    elapsed,
+		handedOut:  handedOut,
+		outOfOrder: outOfOrder,
+		crashGaps:  allocated - handedOut,
+		released:   released,
+	}, nil
+}
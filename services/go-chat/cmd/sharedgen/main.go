@@ -0,0 +1,118 @@
+// sharedgen writes go-worker's copy of a package go-chat shares with it,
+// contract or estransport. go-chat owns the package: its Go files, tests and
+// testdata fixtures are copied as they are, the Go files under a header
+// marking them generated, and files no longer in go-chat are removed from
+// the copy. It runs from the package's directory:
+//
+//	go generate ./internal/contract ./internal/estransport
+//
+// Both services build on their own, in their own Docker context, so the copy
+// is committed rather than shared through a module.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// shared are the packages under internal/ that go-worker gets a copy of
+var shared = []string{"contract", "estransport"}
+
+// patterns are the files of a package that are copied
+var patterns = []string{"*.go", filepath.Join("testdata", "*.json")}
+
+// ownFiles stay in go-chat, they only drive the generation
+var ownFiles = map[string]bool{"generate.go": true}
+
+func main() {
+	src := flag.String("src", ".", "shared package of go-chat")
+	flag.Parse()
+
+	abs, err := filepath.Abs(*src)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sharedgen: %v\n", err)
+		os.Exit(1)
+	}
+	pkg := filepath.Base(abs)
+	dst := filepath.Join(abs, "..", "..", "..", "go-worker", "internal", pkg)
+
+	if err := write(pkg, abs, dst); err != nil {
+		fmt.Fprintf(os.Stderr, "sharedgen: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func header(pkg string) string {
+	return fmt.Sprintf("// Code generated by go-chat/cmd/sharedgen from go-chat/internal/%s. DO NOT EDIT.\n\n", pkg)
+}
+
+// generate returns the copy of every file of src, by path relative to it
+func generate(pkg, src string) (map[string][]byte, error) {
+	files, err := packageFiles(src)
+	if err != nil {
+		return nil, err
+	}
+	for name, content := range files {
+		if ownFiles[name] {
+			delete(files, name)
+		} else if strings.HasSuffix(name, ".go") {
+			files[name] = append([]byte(header(pkg)), content...)
+		}
+	}
+	return files, nil
+}
+
+func write(pkg, src, dst string) error {
+	want, err := generate(pkg, src)
+	if err != nil {
+		return err
+	}
+	have, err := packageFiles(dst)
+	if err != nil {
+		return err
+	}
+
+	for name := range have {
+		if _, ok := want[name]; !ok {
+			if err := os.Remove(filepath.Join(dst, name)); err != nil {
+				return err
+			}
+		}
+	}
+	for name, content := range want {
+		if bytes.Equal(have[name], content) {
+			continue
+		}
+		path := filepath.Join(dst, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, content, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func packageFiles(dir string) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range matches {
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			rel, _ := filepath.Rel(dir, path)
+			files[rel] = content
+		}
+	}
+	return files, nil
+}
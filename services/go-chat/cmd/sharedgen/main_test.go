@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestWorkerCopiesUpToDate fails when a change to a shared package in
+// go-chat has not been generated into go-worker
+func TestWorkerCopiesUpToDate(t *testing.T) {
+	for _, pkg := range shared {
+		t.Run(pkg, func(t *testing.T) {
+			src := filepath.Join("..", "..", "internal", pkg)
+			dst := filepath.Join("..", "..", "..", "go-worker", "internal", pkg)
+			if _, err := os.Stat(dst); err != nil {
+				t.Skipf("go-worker is not checked out next to go-chat: %v", err)
+			}
+
+			want, err := generate(pkg, src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			have, err := packageFiles(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for name, content := range want {
+				if theirs, ok := have[name]; !ok {
+					t.Errorf("%s is missing from go-worker, run go generate ./internal/%s", name, pkg)
+				} else if !bytes.Equal(content, theirs) {
+					t.Errorf("%s differs in go-worker, run go generate ./internal/%s", name, pkg)
+				}
+			}
+			for name := range have {
+				if _, ok := want[name]; !ok {
+					t.Errorf("%s only exists in go-worker", name)
+				}
+			}
+		})
+	}
+}
+
+func TestWrite(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	files := map[string]string{
+		filepath.Join(src, "envelope.go"):                   "package contract\n",
+		filepath.Join(src, "generate.go"):                   "package contract\n",
+		filepath.Join(src, "testdata", "chat.created.json"): "{}\n",
+		filepath.Join(dst, "removed.go"):                    "package contract\n",
+		filepath.Join(dst, "testdata", "removed.json"):      "{}\n",
+	}
+	for path, content := range files {
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := write("contract", src, dst); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := packageFiles(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"envelope.go": header("contract") + "package contract\n",
+		filepath.Join("testdata", "chat.created.json"): "{}\n",
+	}
+	if len(got) != len(want) {
+		t.Errorf("copy holds %d files, want %d", len(got), len(want))
+	}
+	for name, content := range want {
+		if string(got[name]) != content {
+			t.Errorf("%s = %q, want %q", name, got[name], content)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go-chat/internal/config"
+	"go-chat/internal/database"
+	"go-chat/internal/logging"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Scope is what an API key is allowed to do, mirroring ApiKey::SCOPES in Rails
+type Scope string
+
+const (
+	ScopeFull   Scope = "full"
+	ScopeWrite  Scope = "write"
+	ScopeSearch Scope = "search"
+)
+
+const (
+	keyCacheTTL      = 5 * time.Minute
+	notFoundCacheTTL = 30 * time.Second
+)
+
+var (
+	ErrMissingKey = errors.New("missing API key")
+	ErrInvalidKey = errors.New("invalid API key")
+)
+
+// APIKey is the cached view of an api_keys row. Rails deletes the cache entry
+// when a key is rotated or revoked.
+type APIKey struct {
+	Prefix           string `redis:"prefix"`
+	ApplicationToken string `redis:"application_token"`
+	SecretDigest     string `redis:"secret_digest"`
+	Scope            Scope  `redis:"scope"`
+}
+
+func (k *APIKey) Allows(scope Scope) bool {
+	return k.Scope == ScopeFull || k.Scope == scope
+}
+
+type Authenticator struct {
+	redis   redis.UniversalClient
+	mysql   *sql.DB
+	logger  *logging.Logger
+	enabled bool
+}
+
+func NewAuthenticator(db *database.Database, cfg *config.Config, logger *logging.Logger) *Authenticator {
+	return &Authenticator{
+		redis:   db.RedisDB,
+		mysql:   db.MySqlDB,
+		logger:  logger.WithPrefix("Auth"),
+		enabled: cfg.AuthEnabled,
+	}
+}
+
+// Verify checks a "<prefix>.<secret>" key and returns the key it belongs to
+func (a *Authenticator) Verify(ctx context.Context, rawKey string) (*APIKey, error) {
+	if rawKey == "" {
+		return nil, ErrMissingKey
+	}
+
+	prefix, secret, ok := strings.Cut(rawKey, ".")
+	if !ok || prefix == "" || secret == "" {
+		return nil, ErrInvalidKey
+	}
+
+	key, err := a.lookup(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, ErrInvalidKey
+	}
+
+	digest := sha256.Sum256([]byte(secret))
+	if subtle.ConstantTimeCompare([]byte(hex.EncodeToString(digest[:])), []byte(key.SecretDigest)) != 1 {
+		return nil, ErrInvalidKey
+	}
+
+	return key, nil
+}
+
+// lookup reads an active key by prefix from Redis, falling back to MySQL.
+// Unknown prefixes are cached briefly as empty hashes to absorb guessing.
+func (a *Authenticator) lookup(ctx context.Context, prefix string) (*APIKey, error) {
+	cacheKey := fmt.Sprintf("apikey:%s", prefix)
+
+	var cached APIKey
+	res := a.redis.HGetAll(ctx, cacheKey)
+	if err := res.Err(); err != nil {
+		a.logger.Error("failed to read API key cache: %v", err)
+	} else if len(res.Val()) > 0 {
+		if err := res.Scan(&cached); err != nil {
+			return nil, err
+		}
+		if cached.SecretDigest == "" {
+			return nil, nil
+		}
+		return &cached, nil
+	}
+
+	key := APIKey{Prefix: prefix}
+	query := `SELECT applications.token, api_keys.secret_digest, api_keys.scope
+		FROM api_keys INNER JOIN applications ON applications.id = api_keys.application_id
+		WHERE api_keys.prefix = ? AND api_keys.revoked_at IS NULL`
+	err := a.mysql.QueryRowContext(ctx, query, prefix).Scan(&key.ApplicationToken, &key.SecretDigest, &key.Scope)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	ttl := keyCacheTTL
+	if err == sql.ErrNoRows {
+		ttl = notFoundCacheTTL
+	}
+
+	pipe := a.redis.Pipeline()
+	pipe.HSet(ctx, cacheKey,
+		"prefix", key.Prefix,
+		"application_token", key.ApplicationToken,
+		"secret_digest", key.SecretDigest,
+		"scope", string(key.Scope),
+	)
+	pipe.Expire(ctx, cacheKey, ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		a.logger.Error("failed to cache API key %s: %v", prefix, err)
+	}
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return &key, nil
+}
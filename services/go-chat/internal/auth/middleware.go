@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"go-chat/internal/logging"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Require authenticates the request with a bearer API key that belongs to the
+// :token application and allows scope. Missing or bad keys get 401, valid keys
+// for another application or without the scope get 403.
+func (a *Authenticator) Require(scope Scope) fiber.Handler {
+	return a.require(scope, false)
+}
+
+// RequireStream is Require for EventSource and WebSocket routes. Browsers
+// cannot set headers on those connections, so the key may come as
+// access_token in the query too. Anywhere else it would only end up in
+// access logs.
+func (a *Authenticator) RequireStream(scope Scope) fiber.Handler {
+	return a.require(scope, true)
+}
+
+func (a *Authenticator) require(scope Scope, allowQuery bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !a.enabled {
+			return c.Next()
+		}
+
+		logger := a.requestLogger(c)
+		key, err := a.Verify(context.Background(), bearerToken(c, allowQuery))
+		if err != nil {
+			if errors.Is(err, ErrMissingKey) || errors.Is(err, ErrInvalidKey) {
+				logger.Error("unauthenticated request: %v", err)
+				c.Set(fiber.HeaderWWWAuthenticate, `Bearer realm="go-chat"`)
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+					"error": err.Error(),
+				})
+			}
+			logger.Error("failed to verify API key: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "failed to verify API key",
+			})
+		}
+
+		if key.ApplicationToken != c.Params("token") {
+			logger.Error("API key %s used for another application", key.Prefix)
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "API key does not belong to this application",
+			})
+		}
+
+		if !key.Allows(scope) {
+			logger.Error("API key %s with scope %s denied %s access", key.Prefix, key.Scope, scope)
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "API key is not allowed to perform this action",
+			})
+		}
+
+		c.Locals("api_key", key)
+		return c.Next()
+	}
+}
+
+// bearerToken reads the Authorization header, or the access_token query
+// parameter when allowQuery is set
+func bearerToken(c *fiber.Ctx, allowQuery bool) string {
+	header := c.Get(fiber.HeaderAuthorization)
+	token, found := strings.CutPrefix(header, "Bearer ")
+	if !found {
+		if allowQuery {
+			return c.Query("access_token")
+		}
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+func (a *Authenticator) requestLogger(c *fiber.Ctx) *logging.Logger {
+	if logger, ok := c.Locals("logger").(*logging.Logger); ok {
+		return logger
+	}
+	return a.logger
+}
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"go-chat/internal/config"
+	"go-chat/internal/logging"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/gofiber/fiber/v2"
+)
+
+// newTestAuthenticator serves keys from the Redis cache only, the tests
+// never reach MySQL
+func newTestAuthenticator(t *testing.T) *Authenticator {
+	t.Helper()
+
+	m := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+
+	for prefix, scope := range map[string]Scope{"full1": ScopeFull, "srch1": ScopeSearch} {
+		digest := sha256.Sum256([]byte("secret"))
+		m.HSet("apikey:"+prefix,
+			"prefix", prefix,
+			"application_token", "app",
+			"secret_digest", hex.EncodeToString(digest[:]),
+			"scope", string(scope))
+	}
+
+	logger := logging.NewLogger(&config.Config{AppName: "auth-test", LogPath: t.TempDir()})
+	return &Authenticator{redis: rdb, logger: logger, enabled: true}
+}
+
+func TestRequire(t *testing.T) {
+	a := newTestAuthenticator(t)
+	ok := func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) }
+
+	app := fiber.New()
+	app.Post("/applications/:token/chats", a.Require(ScopeWrite), ok)
+	app.Get("/applications/:token/chats/:number/stream", a.RequireStream(ScopeSearch), ok)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		header string
+		want   int
+	}{
+		{"bearer key", "POST", "/applications/app/chats", "Bearer full1.secret", fiber.StatusOK},
+		{"no key", "POST", "/applications/app/chats", "", fiber.StatusUnauthorized},
+		{"wrong secret", "POST", "/applications/app/chats", "Bearer full1.guess", fiber.StatusUnauthorized},
+		{"malformed key", "POST", "/applications/app/chats", "Bearer full1", fiber.StatusUnauthorized},
+		{"another application", "POST", "/applications/other/chats", "Bearer full1.secret", fiber.StatusForbidden},
+		{"narrower scope", "POST", "/applications/app/chats", "Bearer srch1.secret", fiber.StatusForbidden},
+		// Query keys would be written to access logs, only streams take them
+		{"query key", "POST", "/applications/app/chats?access_token=full1.secret", "", fiber.StatusUnauthorized},
+		{"query key on a stream", "GET", "/applications/app/chats/1/stream?access_token=srch1.secret", "", fiber.StatusOK},
+		{"bearer key on a stream", "GET", "/applications/app/chats/1/stream", "Bearer srch1.secret", fiber.StatusOK},
+		{"bad query key on a stream", "GET", "/applications/app/chats/1/stream?access_token=srch1.guess", "", fiber.StatusUnauthorized},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			if tc.header != "" {
+				req.Header.Set(fiber.HeaderAuthorization, tc.header)
+			}
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tc.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tc.want)
+			}
+		})
+	}
+}
+
+func TestRequireDisabled(t *testing.T) {
+	a := &Authenticator{enabled: false}
+
+	app := fiber.New()
+	app.Post("/applications/:token/chats", a.Require(ScopeFull), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	resp, err := app.Test(httptest.NewRequest("POST", "/applications/app/chats", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Errorf("status with auth disabled = %d, want 200", resp.StatusCode)
+	}
+}
@@ -0,0 +1,136 @@
+package contract
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// MessageType identifies the kind of payload carried by an Envelope.
+type MessageType string
+
+const (
+	TypeChatCreated    MessageType = "chat.created"
+	TypeMessageCreated MessageType = "message.created"
+	TypeMessageIndex   MessageType = "message.index"
+
+	TypeApplicationDeleted MessageType = "application.deleted"
+	TypeChatDeleted        MessageType = "chat.deleted"
+
+	// TypeMessagePersisted is published on Redis pub/sub rather than a queue
+	TypeMessagePersisted MessageType = "message.persisted"
+)
+
+// Well-known envelope header keys used for tracing a message across services
+const (
+	HeaderTraceID   = "trace_id"
+	HeaderRequestID = "request_id"
+)
+
+var (
+	ErrInvalid            = errors.New("invalid message")
+	ErrUnknownType        = errors.New("unknown message type")
+	ErrUnsupportedVersion = errors.New("unsupported schema version")
+)
+
+// Payload is implemented by every typed queue message body
+type Payload interface {
+	MessageType() MessageType
+	SchemaVersion() int
+	Validate() error
+}
+
+// Envelope is the versioned wrapper put on the wire for every queue message.
+// This file is kept identical in go-chat and go-worker.
+type Envelope struct {
+	Type       MessageType       `json:"type"`
+	Version    int               `json:"version"`
+	ID         string            `json:"id"`
+	ProducedAt time.Time         `json:"produced_at"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Payload    json.RawMessage   `json:"payload"`
+}
+
+// NewEnvelope validates the payload and wraps it in a fresh envelope
+func NewEnvelope(payload Payload, headers map[string]string) (*Envelope, error) {
+	if err := payload.Validate(); err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	return &Envelope{
+		Type:       payload.MessageType(),
+		Version:    payload.SchemaVersion(),
+		ID:         newID(),
+		ProducedAt: time.Now().UTC(),
+		Headers:    headers,
+		Payload:    body,
+	}, nil
+}
+
+// Parse decodes and validates an envelope read from the wire
+func Parse(body []byte) (*Envelope, error) {
+	var env Envelope
+	if err := json.Unmarshal(body, &env); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if err := env.Validate(); err != nil {
+		return nil, err
+	}
+	return &env, nil
+}
+
+func (e *Envelope) Validate() error {
+	switch {
+	case e.Type == "":
+		return fmt.Errorf("%w: missing type", ErrInvalid)
+	case e.Version < 1:
+		return fmt.Errorf("%w: missing version", ErrInvalid)
+	case e.ID == "":
+		return fmt.Errorf("%w: missing id", ErrInvalid)
+	case e.ProducedAt.IsZero():
+		return fmt.Errorf("%w: missing produced_at", ErrInvalid)
+	case len(e.Payload) == 0:
+		return fmt.Errorf("%w: missing payload", ErrInvalid)
+	}
+	return nil
+}
+
+// Decode unmarshals the envelope payload into target and validates it
+func (e *Envelope) Decode(target Payload) error {
+	if e.Type != target.MessageType() {
+		return fmt.Errorf("%w: expected %s, got %s", ErrUnknownType, target.MessageType(), e.Type)
+	}
+	if e.Version != target.SchemaVersion() {
+		return fmt.Errorf("%w: %s v%d", ErrUnsupportedVersion, e.Type, e.Version)
+	}
+	if err := json.Unmarshal(e.Payload, target); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	return target.Validate()
+}
+
+func (e *Envelope) Header(key string) string {
+	if e.Headers == nil {
+		return ""
+	}
+	return e.Headers[key]
+}
+
+func (e *Envelope) Marshal() ([]byte, error) {
+	return json.Marshal(e)
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,202 @@
+package contract
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Run with -update to rewrite the fixtures after an intended change, then go
+// generate to carry them to go-worker, whose copy is checked against them.
+var update = flag.Bool("update", false, "rewrite testdata fixtures")
+
+var (
+	goldenProducedAt = time.Date(2025, 11, 26, 9, 0, 0, 0, time.UTC)
+	goldenCreatedAt  = time.Date(2025, 11, 26, 8, 59, 58, 0, time.UTC)
+)
+
+// goldenCases lists one payload for every message type and schema version,
+// a new version gets a case and a fixture of its own
+var goldenCases = []struct {
+	fixture string
+	payload Payload
+	empty   func() Payload
+}{
+	{
+		fixture: "chat.created.v1.json",
+		payload: &ChatCreated{AppToken: "unique-token-12345", ChatNumber: 3, IdempotencyKey: "req-1"},
+		empty:   func() Payload { return &ChatCreated{} },
+	},
+	{
+		fixture: "message.created.v1.json",
+		payload: &MessageCreated{AppToken: "unique-token-12345", ChatNumber: 3, MessageNumber: 42, Content: "Hello, world!", IdempotencyKey: "req-2"},
+		empty:   func() Payload { return &MessageCreated{} },
+	},
+	{
+		fixture: "message.index.v1.json",
+		payload: &MessageIndex{
+			MessageID:        901,
+			ApplicationID:    7,
+			ApplicationToken: "unique-token-12345",
+			ApplicationName:  "Test App",
+			ChatID:           55,
+			ChatNumber:       3,
+			MessageNumber:    42,
+			Content:          "Hello, world!",
+			CreatedAt:        goldenCreatedAt,
+		},
+		empty: func() Payload { return &MessageIndex{} },
+	},
+	{
+		fixture: "message.persisted.v1.json",
+		payload: &MessagePersisted{
+			ApplicationToken: "unique-token-12345",
+			ApplicationName:  "Test App",
+			ChatNumber:       3,
+			MessageNumber:    42,
+			Content:          "Hello, world!",
+			CreatedAt:        goldenCreatedAt,
+		},
+		empty: func() Payload { return &MessagePersisted{} },
+	},
+	{
+		fixture: "application.deleted.v1.json",
+		payload: &ApplicationDeleted{AppToken: "unique-token-12345"},
+		empty:   func() Payload { return &ApplicationDeleted{} },
+	},
+	{
+		fixture: "chat.deleted.v1.json",
+		payload: &ChatDeleted{AppToken: "unique-token-12345", ChatNumber: 3},
+		empty:   func() Payload { return &ChatDeleted{} },
+	},
+}
+
+func goldenEnvelope(t *testing.T, payload Payload) *Envelope {
+	t.Helper()
+
+	env, err := NewEnvelope(payload, map[string]string{
+		HeaderTraceID:   "4bf92f3577b34da6a3ce929d0e0e4736",
+		HeaderRequestID: "req-abc",
+	})
+	if err != nil {
+		t.Fatalf("NewEnvelope: %v", err)
+	}
+	env.ID = "0b9d6c1e-4f3a-4c2b-9e8d-7a6b5c4d3e2f"
+	env.ProducedAt = goldenProducedAt
+	return env
+}
+
+func TestEnvelopeEncode(t *testing.T) {
+	for _, tc := range goldenCases {
+		t.Run(tc.fixture, func(t *testing.T) {
+			got, err := goldenEnvelope(t, tc.payload).Marshal()
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+
+			path := filepath.Join("testdata", tc.fixture)
+			if *update {
+				var indented bytes.Buffer
+				if err := json.Indent(&indented, got, "", "  "); err != nil {
+					t.Fatal(err)
+				}
+				indented.WriteByte('\n')
+				if err := os.WriteFile(path, indented.Bytes(), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			golden, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("reading fixture: %v", err)
+			}
+			var want bytes.Buffer
+			if err := json.Compact(&want, golden); err != nil {
+				t.Fatalf("fixture is not JSON: %v", err)
+			}
+			if !bytes.Equal(got, want.Bytes()) {
+				t.Errorf("encoding drifted from %s\n got: %s\nwant: %s", path, got, want.Bytes())
+			}
+		})
+	}
+}
+
+func TestEnvelopeDecode(t *testing.T) {
+	for _, tc := range goldenCases {
+		t.Run(tc.fixture, func(t *testing.T) {
+			golden, err := os.ReadFile(filepath.Join("testdata", tc.fixture))
+			if err != nil {
+				t.Fatalf("reading fixture: %v", err)
+			}
+
+			env, err := Parse(golden)
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			want := goldenEnvelope(t, tc.payload)
+			if env.Type != want.Type || env.Version != want.Version || env.ID != want.ID ||
+				!env.ProducedAt.Equal(want.ProducedAt) || !reflect.DeepEqual(env.Headers, want.Headers) {
+				t.Errorf("envelope = %+v, want %+v", env, want)
+			}
+
+			got := tc.empty()
+			if err := env.Decode(got); err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.payload) {
+				t.Errorf("payload = %+v, want %+v", got, tc.payload)
+			}
+		})
+	}
+}
+
+func TestEnvelopeDecodeRejects(t *testing.T) {
+	env := goldenEnvelope(t, &ChatCreated{AppToken: "unique-token-12345", ChatNumber: 3})
+
+	tests := []struct {
+		name   string
+		mutate func(*Envelope)
+		target Payload
+		want   error
+	}{
+		{"other type", func(*Envelope) {}, &ChatDeleted{}, ErrUnknownType},
+		{"newer version", func(e *Envelope) { e.Version = 2 }, &ChatCreated{}, ErrUnsupportedVersion},
+		{"invalid payload", func(e *Envelope) { e.Payload = json.RawMessage(`{"app_token":""}`) }, &ChatCreated{}, ErrInvalid},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			e := *env
+			tc.mutate(&e)
+			if err := e.Decode(tc.target); !errors.Is(err, tc.want) {
+				t.Errorf("Decode error = %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"not JSON", `{`},
+		{"missing type", `{"version":1,"id":"x","produced_at":"2025-11-26T09:00:00Z","payload":{}}`},
+		{"missing version", `{"type":"chat.created","id":"x","produced_at":"2025-11-26T09:00:00Z","payload":{}}`},
+		{"missing id", `{"type":"chat.created","version":1,"produced_at":"2025-11-26T09:00:00Z","payload":{}}`},
+		{"missing produced_at", `{"type":"chat.created","version":1,"id":"x","payload":{}}`},
+		{"missing payload", `{"type":"chat.created","version":1,"id":"x","produced_at":"2025-11-26T09:00:00Z"}`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := Parse([]byte(tc.body)); !errors.Is(err, ErrInvalid) {
+				t.Errorf("Parse error = %v, want %v", err, ErrInvalid)
+			}
+		})
+	}
+}
@@ -0,0 +1,6 @@
+package contract
+
+// go-worker has a generated copy of this package, tests and testdata
+// included. Regenerate it after every change here.
+//
+//go:generate go run ../../cmd/sharedgen
@@ -0,0 +1,287 @@
+package contract
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ChatCreated is published by go-chat once a chat number has been allocated
+type ChatCreated struct {
+	AppToken       string `json:"app_token"`
+	ChatNumber     int    `json:"chat_number"`
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+func (p *ChatCreated) MessageType() MessageType { return TypeChatCreated }
+func (p *ChatCreated) SchemaVersion() int       { return 1 }
+
+func (p *ChatCreated) Validate() error {
+	if p.AppToken == "" {
+		return fmt.Errorf("%w: app_token is required", ErrInvalid)
+	}
+	if p.ChatNumber < 1 {
+		return fmt.Errorf("%w: chat_number must be positive", ErrInvalid)
+	}
+	return nil
+}
+
+// MessageCreated is published by go-chat once a message number has been allocated
+type MessageCreated struct {
+	AppToken       string `json:"app_token"`
+	ChatNumber     int    `json:"chat_number"`
+	MessageNumber  int    `json:"message_number"`
+	Content        string `json:"content"`
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+func (p *MessageCreated) MessageType() MessageType { return TypeMessageCreated }
+func (p *MessageCreated) SchemaVersion() int       { return 1 }
+
+func (p *MessageCreated) Validate() error {
+	if p.AppToken == "" {
+		return fmt.Errorf("%w: app_token is required", ErrInvalid)
+	}
+	if p.ChatNumber < 1 {
+		return fmt.Errorf("%w: chat_number must be positive", ErrInvalid)
+	}
+	if p.MessageNumber < 1 {
+		return fmt.Errorf("%w: message_number must be positive", ErrInvalid)
+	}
+	if p.Content == "" {
+		return fmt.Errorf("%w: content is required", ErrInvalid)
+	}
+	return nil
+}
+
+// MessageIndex is published by go-worker once a message is persisted, to be
+// picked up by the indexing worker
+type MessageIndex struct {
+	MessageID        uint      `json:"message_id"`
+	ApplicationID    uint      `json:"application_id"`
+	ApplicationToken string    `json:"application_token"`
+	ApplicationName  string    `json:"application_name"`
+	ChatID           uint      `json:"chat_id"`
+	ChatNumber       int       `json:"chat_number"`
+	MessageNumber    int       `json:"message_number"`
+	Content          string    `json:"content"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+func (p *MessageIndex) MessageType() MessageType { return TypeMessageIndex }
+func (p *MessageIndex) SchemaVersion() int       { return 1 }
+
+func (p *MessageIndex) Validate() error {
+	if p.ApplicationToken == "" {
+		return fmt.Errorf("%w: application_token is required", ErrInvalid)
+	}
+	if p.ChatNumber < 1 || p.MessageNumber < 1 {
+		return fmt.Errorf("%w: chat_number and message_number must be positive", ErrInvalid)
+	}
+	if p.CreatedAt.IsZero() {
+		return fmt.Errorf("%w: created_at is required", ErrInvalid)
+	}
+	return nil
+}
+
+// MessagePersisted is broadcast by go-worker on StreamChannel once a message
+// is stored, so go-chat can push it to connected clients
+type MessagePersisted struct {
+	ApplicationToken string    `json:"application_token"`
+	ApplicationName  string    `json:"application_name"`
+	ChatNumber       int       `json:"chat_number"`
+	MessageNumber    int       `json:"message_number"`
+	Content          string    `json:"content"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+func (p *MessagePersisted) MessageType() MessageType { return TypeMessagePersisted }
+func (p *MessagePersisted) SchemaVersion() int       { return 1 }
+
+func (p *MessagePersisted) Validate() error {
+	if p.ApplicationToken == "" {
+		return fmt.Errorf("%w: application_token is required", ErrInvalid)
+	}
+	if p.ChatNumber < 1 || p.MessageNumber < 1 {
+		return fmt.Errorf("%w: chat_number and message_number must be positive", ErrInvalid)
+	}
+	return nil
+}
+
+// ApplicationDeleted is published by go-chat once an application has been
+// tombstoned, go-worker then removes everything stored for it
+type ApplicationDeleted struct {
+	AppToken string `json:"app_token"`
+}
+
+func (p *ApplicationDeleted) MessageType() MessageType { return TypeApplicationDeleted }
+func (p *ApplicationDeleted) SchemaVersion() int       { return 1 }
+
+func (p *ApplicationDeleted) Validate() error {
+	if p.AppToken == "" {
+		return fmt.Errorf("%w: app_token is required", ErrInvalid)
+	}
+	return nil
+}
+
+// ChatDeleted is published by go-chat once a chat has been tombstoned
+type ChatDeleted struct {
+	AppToken   string `json:"app_token"`
+	ChatNumber int    `json:"chat_number"`
+}
+
+func (p *ChatDeleted) MessageType() MessageType { return TypeChatDeleted }
+func (p *ChatDeleted) SchemaVersion() int       { return 1 }
+
+func (p *ChatDeleted) Validate() error {
+	if p.AppToken == "" {
+		return fmt.Errorf("%w: app_token is required", ErrInvalid)
+	}
+	if p.ChatNumber < 1 {
+		return fmt.Errorf("%w: chat_number must be positive", ErrInvalid)
+	}
+	return nil
+}
+
+// Redis keys of one application carry its token as a hash tag, {token}, so
+// in Redis Cluster its counters, count deltas and tombstones share a slot.
+// Commands and scripts touching several of them stay on one node.
+
+// ChatCounter is the Redis counter an application's chat numbers come from
+func ChatCounter(appToken string) string {
+	return fmt.Sprintf("app:{%s}:chats_count", appToken)
+}
+
+// MessageCounter is the Redis counter a chat's message numbers come from
+func MessageCounter(appToken string, chatNumber int) string {
+	return fmt.Sprintf("app:{%s}:chat:%d:messages_count", appToken, chatNumber)
+}
+
+// ChatMessages is the Redis hash of a chat's messages by number
+func ChatMessages(appToken string, chatNumber int) string {
+	return fmt.Sprintf("app:{%s}:chat:%d:messages", appToken, chatNumber)
+}
+
+// ApplicationKeysPattern matches ChatCounter, MessageCounter and ChatMessages
+// of one application
+func ApplicationKeysPattern(appToken string) string {
+	return fmt.Sprintf("app:{%s}:*", appToken)
+}
+
+// ChatCountDelta is the change of an application's chats_count not yet
+// written to MySQL
+func ChatCountDelta(appToken string, applicationID uint) string {
+	return fmt.Sprintf("delta:{%s}:app:%d:chats", appToken, applicationID)
+}
+
+// MessageCountDelta is the change of a chat's messages_count not yet written
+// to MySQL
+func MessageCountDelta(appToken string, chatID uint) string {
+	return fmt.Sprintf("delta:{%s}:chat:%d:messages", appToken, chatID)
+}
+
+// Patterns matching every ChatCountDelta and every MessageCountDelta
+const (
+	ChatCountDeltaPattern    = "delta:*:app:*:chats"
+	MessageCountDeltaPattern = "delta:*:chat:*:messages"
+)
+
+// DeltaID returns the application or chat ID a ChatCountDelta or
+// MessageCountDelta key is about
+func DeltaID(key string) (uint, error) {
+	parts := strings.Split(key, ":")
+	if len(parts) != 5 {
+		return 0, fmt.Errorf("invalid delta key %s", key)
+	}
+	id, err := strconv.ParseUint(parts[3], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid delta key %s", key)
+	}
+	return uint(id), nil
+}
+
+// KeySchemeKey holds KeyScheme once Redis only has keys in the layout above.
+// Before it, keys carried the token without braces and deltas no token.
+const (
+	KeySchemeKey = "meta:key_scheme"
+	KeyScheme    = "hash_tagged"
+)
+
+// LegacyKeyPatterns match the keys written before KeyScheme
+var LegacyKeyPatterns = []string{"app:[^{]*", "delta:app:*", "delta:chat:*", "tombstone:app:[^{]*"}
+
+// ApplicationTombstone marks a deleted application. go-chat refuses writes
+// while it exists and go-worker drops work still queued for the application.
+func ApplicationTombstone(appToken string) string {
+	return fmt.Sprintf("tombstone:app:{%s}", appToken)
+}
+
+// ChatTombstone marks a deleted chat, like ApplicationTombstone
+func ChatTombstone(appToken string, chatNumber int) string {
+	return fmt.Sprintf("tombstone:app:{%s}:chat:%d", appToken, chatNumber)
+}
+
+// ChatTombstonesPattern matches the ChatTombstone and MessageTombstone keys
+// of an application
+func ChatTombstonesPattern(appToken string) string {
+	return fmt.Sprintf("tombstone:app:{%s}:chat:*", appToken)
+}
+
+// MessageTombstone marks a message number that will never exist, written when
+// a gap in a chat's numbering is given up on
+func MessageTombstone(appToken string, chatNumber, messageNumber int) string {
+	return fmt.Sprintf("tombstone:app:{%s}:chat:%d:message:%d", appToken, chatNumber, messageNumber)
+}
+
+// UnpublishedNumbers is the Redis set of an application's numbers go-chat
+// handed out but failed to publish. Members are NumberRef values.
+func UnpublishedNumbers(appToken string) string {
+	return fmt.Sprintf("numbers:unpublished:%s", appToken)
+}
+
+// DeadLetteredNumbers is the Redis set of an application's numbers whose
+// chat.created or message.created go-worker dead-lettered
+func DeadLetteredNumbers(appToken string) string {
+	return fmt.Sprintf("numbers:dead_lettered:%s", appToken)
+}
+
+// ReleasedNumbers is the Redis set of an application's message numbers go-chat
+// leased in a block but gave back unused, when the lease ran out or on shutdown
+func ReleasedNumbers(appToken string) string {
+	return fmt.Sprintf("numbers:released:%s", appToken)
+}
+
+// NumberRef names a chat number, or with messageNumber set a message number
+// of that chat, in UnpublishedNumbers, DeadLetteredNumbers and ReleasedNumbers
+func NumberRef(chatNumber, messageNumber int) string {
+	if messageNumber == 0 {
+		return fmt.Sprintf("%d", chatNumber)
+	}
+	return fmt.Sprintf("%d:%d", chatNumber, messageNumber)
+}
+
+// DeferralMetricsKey is the Redis hash counting, per message type, deliveries
+// go-worker deferred because they arrived before what they depend on, later
+// processed after a deferral, or dead-lettered after the last one, and chats
+// created from a message that arrived before them. Fields are "<type>:<event>".
+const DeferralMetricsKey = "metrics:deferrals"
+
+// StreamChannel is the pub/sub channel carrying MessagePersisted for one chat
+func StreamChannel(appToken string, chatNumber int) string {
+	return fmt.Sprintf("stream:app:%s:chat:%d:messages", appToken, chatNumber)
+}
+
+// StreamChannelPattern matches every StreamChannel
+const StreamChannelPattern = "stream:app:*:chat:*:messages"
+
+// QueueStream is the Redis stream carrying a queue when BROKER is redis
+func QueueStream(queue string) string {
+	return fmt.Sprintf("queue:{%s}", queue)
+}
+
+// QueueDelayed is the Redis sorted set of a queue's messages waiting out a
+// delay, scored by when they are due. It shares the hash tag of QueueStream.
+func QueueDelayed(queue string) string {
+	return fmt.Sprintf("queue:{%s}:delayed", queue)
+}
@@ -9,18 +9,22 @@ import (
 )
 
 type Database struct {
-	RedisDB *redis.Client
+	RedisDB redis.UniversalClient
 	MySqlDB *sql.DB
 }
 
 func ConnectDatabase(logger *logging.Logger, cfg *config.Config) (*Database, error) {
 	logger.Info("Connecting to Redis")
-	redisDb, err := connectRedis(cfg.RedisURL)
+	redisDb, err := NewRedisClient(cfg)
 	if err != nil {
 		logger.Error("%s", err.Error())
 		return nil, err
 	}
-	logger.Info("Connected to Redis successfully")
+	if err := checkKeyScheme(redisDb); err != nil {
+		logger.Error("%s", err.Error())
+		return nil, err
+	}
+	logger.Info("Connected to Redis successfully, mode %s", cfg.RedisMode)
 
 	logger.Info("Connecting to MySQL")
 	mysqlDb, err := NewMySQLClient(cfg.MySqlDsn)
@@ -1,22 +1,119 @@
 package database
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"go-chat/internal/config"
+	"go-chat/internal/contract"
 
 	"github.com/go-redis/redis/v8"
 )
 
-func connectRedis(redisURL string) (*redis.Client, error) {
-	options, err := redis.ParseURL(redisURL)
-	if err != nil {
-		return nil, err
-	}
+const (
+	RedisStandalone = "standalone"
+	RedisSentinel   = "sentinel"
+	RedisCluster    = "cluster"
+)
 
-	client := redis.NewClient(options)
+// NewRedisClient connects to Redis the way REDIS_MODE says. Commands and Lua
+// scripts taking several keys must use keys sharing a hash tag, in cluster
+// mode they are refused otherwise.
+func NewRedisClient(cfg *config.Config) (redis.UniversalClient, error) {
+	var client redis.UniversalClient
+	switch cfg.RedisMode {
+	case RedisStandalone:
+		options, err := redis.ParseURL(cfg.RedisURL)
+		if err != nil {
+			return nil, err
+		}
+		client = redis.NewClient(options)
+	case RedisSentinel:
+		if len(cfg.RedisAddrs) == 0 || cfg.RedisMasterName == "" {
+			return nil, fmt.Errorf("REDIS_MODE sentinel needs REDIS_ADDRS and REDIS_MASTER_NAME")
+		}
+		client = redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       cfg.RedisMasterName,
+			SentinelAddrs:    cfg.RedisAddrs,
+			SentinelPassword: cfg.RedisSentinelPassword,
+			Username:         cfg.RedisUsername,
+			Password:         cfg.RedisPassword,
+			DB:               cfg.RedisDB,
+		})
+	case RedisCluster:
+		if len(cfg.RedisAddrs) == 0 {
+			return nil, fmt.Errorf("REDIS_MODE cluster needs REDIS_ADDRS")
+		}
+		client = redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:    cfg.RedisAddrs,
+			Username: cfg.RedisUsername,
+			Password: cfg.RedisPassword,
+		})
+	default:
+		return nil, fmt.Errorf("unknown REDIS_MODE %q", cfg.RedisMode)
+	}
 
-	if err := client.Ping(client.Context()).Err(); err != nil {
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		client.Close()
 		return nil, fmt.Errorf("failed to connect to Redis: %v", err)
 	}
 
 	return client, nil
 }
+
+// ScanKeys calls fn with every batch of keys matching pattern. SCAN only sees
+// the node it runs on, so in cluster mode every master is scanned and fn
+// runs for several of them at once.
+func ScanKeys(ctx context.Context, rdb redis.UniversalClient, pattern string, fn func(keys []string) error) error {
+	if cluster, ok := rdb.(*redis.ClusterClient); ok {
+		return cluster.ForEachMaster(ctx, func(ctx context.Context, master *redis.Client) error {
+			return scanNode(ctx, master, pattern, fn)
+		})
+	}
+	return scanNode(ctx, rdb, pattern, fn)
+}
+
+func scanNode(ctx context.Context, node redis.Cmdable, pattern string, fn func(keys []string) error) error {
+	var cursor uint64
+	for {
+		keys, next, err := node.Scan(ctx, cursor, pattern, 500).Result()
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			if err := fn(keys); err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+var errLegacyKeys = errors.New("found Redis keys named before hash tags, run `go-worker migrate redis-keys` first")
+
+// checkKeyScheme refuses a Redis still holding keys in the old layout, whose
+// counters would otherwise start over under the new names. A Redis without
+// any is marked as using the new layout right away.
+func checkKeyScheme(rdb redis.UniversalClient) error {
+	ctx := context.Background()
+	scheme, err := rdb.Get(ctx, contract.KeySchemeKey).Result()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	if scheme == contract.KeyScheme {
+		return nil
+	}
+
+	for _, pattern := range contract.LegacyKeyPatterns {
+		err := ScanKeys(ctx, rdb, pattern, func(keys []string) error {
+			return errLegacyKeys
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return rdb.Set(ctx, contract.KeySchemeKey, contract.KeyScheme, 0).Err()
+}
@@ -0,0 +1,66 @@
+package elasticsearch
+
+import (
+	"go-chat/internal/search"
+	"sync"
+	"time"
+)
+
+// breaker trips after threshold consecutive failures and rejects calls for
+// cooldown. Once the cooldown is over a single probe is let through, it closes
+// the breaker on success and re-opens it on failure.
+type breaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{threshold: threshold, cooldown: cooldown}
+}
+
+func (b *breaker) allow() error {
+	if b.threshold < 1 {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return nil
+	}
+
+	now := time.Now()
+	if now.Before(b.openUntil) || b.probing {
+		retryAfter := b.openUntil.Sub(now)
+		if retryAfter < time.Second {
+			retryAfter = time.Second
+		}
+		return &search.UnavailableError{RetryAfter: retryAfter}
+	}
+
+	b.probing = true
+	return nil
+}
+
+func (b *breaker) record(failed bool) (tripped bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if !failed {
+		b.failures = 0
+		return false
+	}
+
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+		return b.failures == b.threshold
+	}
+	return false
+}
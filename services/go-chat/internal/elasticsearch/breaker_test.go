@@ -0,0 +1,118 @@
+package elasticsearch
+
+import (
+	"errors"
+	"go-chat/internal/config"
+	"go-chat/internal/logging"
+	"go-chat/internal/search"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	b := newBreaker(2, 50*time.Millisecond)
+
+	steps := []struct {
+		name    string
+		wait    time.Duration
+		failed  bool
+		allowed bool
+		tripped bool
+	}{
+		{"closed", 0, true, true, false},
+		{"success resets the count", 0, false, true, false},
+		{"first failure", 0, true, true, false},
+		{"second failure trips it", 0, true, true, true},
+		{"open", 0, false, false, false},
+		{"probe after the cooldown fails", 60 * time.Millisecond, true, true, false},
+		{"open again", 0, false, false, false},
+		{"probe after the cooldown succeeds", 60 * time.Millisecond, false, true, false},
+		{"closed again", 0, true, true, false},
+	}
+	for _, step := range steps {
+		time.Sleep(step.wait)
+		err := b.allow()
+		if allowed := err == nil; allowed != step.allowed {
+			t.Fatalf("%s: allowed = %v, want %v (%v)", step.name, allowed, step.allowed, err)
+		}
+		if err != nil {
+			var unavailable *search.UnavailableError
+			if !errors.As(err, &unavailable) || unavailable.RetryAfter < time.Second {
+				t.Errorf("%s: rejected with %v, want an UnavailableError retrying in a second or more", step.name, err)
+			}
+			continue
+		}
+		if tripped := b.record(step.failed); tripped != step.tripped {
+			t.Errorf("%s: tripped = %v, want %v", step.name, tripped, step.tripped)
+		}
+	}
+}
+
+func TestBreakerProbesOneAtATime(t *testing.T) {
+	b := newBreaker(1, 10*time.Millisecond)
+	b.allow()
+	b.record(true)
+	time.Sleep(20 * time.Millisecond)
+
+	if err := b.allow(); err != nil {
+		t.Fatalf("probe rejected: %v", err)
+	}
+	if err := b.allow(); err == nil {
+		t.Error("a second call went through while the probe was out")
+	}
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	b := newBreaker(0, time.Minute)
+	for i := 0; i < 5; i++ {
+		if err := b.allow(); err != nil {
+			t.Fatalf("call %d rejected: %v", i+1, err)
+		}
+		b.record(true)
+	}
+}
+
+func TestClientBreaker(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		// calls is how many of four reach Elasticsearch
+		calls int32
+	}{
+		{"server errors trip it", http.StatusServiceUnavailable, 2},
+		// A rejected query says nothing about the cluster's health
+		{"client errors do not", http.StatusBadRequest, 4},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var calls int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&calls, 1)
+				w.WriteHeader(tc.status)
+			}))
+			t.Cleanup(server.Close)
+
+			c := &Client{
+				baseURL: server.URL,
+				client:  server.Client(),
+				breaker: newBreaker(2, time.Minute),
+				logger:  logging.NewLogger(&config.Config{AppName: "es-test", LogPath: t.TempDir()}),
+			}
+			for i := 0; i < 4; i++ {
+				req, _ := http.NewRequest("POST", c.baseURL+"/chat_messages/_search", nil)
+				resp, err := c.do(req)
+				if err == nil {
+					resp.Body.Close()
+				} else if !errors.Is(err, search.ErrUnavailable) {
+					t.Fatalf("call %d: %v", i+1, err)
+				}
+			}
+			if got := atomic.LoadInt32(&calls); got != tc.calls {
+				t.Errorf("%d calls reached Elasticsearch, want %d", got, tc.calls)
+			}
+		})
+	}
+}
@@ -3,138 +3,420 @@ package elasticsearch
 import (
 	"bytes"
 	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"go-chat/internal/config"
+	"go-chat/internal/database"
+	"go-chat/internal/estransport"
 	"go-chat/internal/logging"
 	"go-chat/internal/model"
+	"go-chat/internal/search"
 	"io"
 	"net/http"
+	neturl "net/url"
+	"strings"
 	"time"
+
+	"github.com/go-redis/redis/v8"
 )
 
 type Client struct {
-	baseURL string
-	client  *http.Client
-	logger  *logging.Logger
+	baseURL      string
+	client       *http.Client
+	redis        redis.UniversalClient
+	mysql        *sql.DB
+	breaker      *breaker
+	bulkMaxBytes int
+	logger       *logging.Logger
+}
+
+type SearchHits struct {
+	Total struct {
+		Value int `json:"value"`
+	} `json:"total"`
+	Hits []SearchHit `json:"hits"`
+}
+
+type SearchHit struct {
+	ID        string                 `json:"_id"`
+	Source    map[string]interface{} `json:"_source"`
+	Score     *float64               `json:"_score"`
+	Sort      []interface{}          `json:"sort,omitempty"`
+	Highlight map[string][]string    `json:"highlight,omitempty"`
+	InnerHits map[string]struct {
+		Hits SearchHits `json:"hits"`
+	} `json:"inner_hits,omitempty"`
 }
 
 type SearchResult struct {
-	Hits struct {
-		Total struct {
+	PitID        string     `json:"pit_id,omitempty"`
+	Hits         SearchHits `json:"hits"`
+	Aggregations struct {
+		Chats struct {
 			Value int `json:"value"`
-		} `json:"total"`
-		Hits []struct {
-			ID     string                 `json:"_id"`
-			Source map[string]interface{} `json:"_source"`
-		} `json:"hits"`
-	} `json:"hits"`
+		} `json:"chats"`
+	} `json:"aggregations"`
 }
 
-func NewClient(cfg *config.Config, logger *logging.Logger) *Client {
+var _ search.Backend = (*Client)(nil)
+
+func NewClient(cfg *config.Config, db *database.Database, logger *logging.Logger) (*Client, error) {
+	transport, err := estransport.New(estransport.Config{
+		URLs:       estransport.SplitURLs(cfg.ElasticsearchURL),
+		Username:   cfg.ElasticsearchUsername,
+		Password:   cfg.ElasticsearchPassword,
+		APIKey:     cfg.ElasticsearchAPIKey,
+		CACert:     cfg.ElasticsearchCACert,
+		MaxRetries: cfg.ElasticsearchMaxRetries,
+		Compress:   cfg.ElasticsearchCompress,
+		Logger:     logger,
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	return &Client{
-		baseURL: cfg.ElasticsearchURL,
+		baseURL: transport.BaseURL(),
 		client: &http.Client{
-			Timeout: 10 * time.Second,
+			Timeout:   10 * time.Second,
+			Transport: transport,
 		},
-		logger: logger,
-	}
+		redis:        db.RedisDB,
+		mysql:        db.MySqlDB,
+		breaker:      newBreaker(cfg.SearchBreakerThreshold, cfg.SearchBreakerCooldown),
+		bulkMaxBytes: cfg.ElasticsearchBulkMaxBytes,
+		logger:       logger,
+	}, nil
 }
 
-func (c *Client) Search(appToken string, chatNumber int, query string, page int, pageSize int) ([]*model.Message, int, error) {
+func (c *Client) Search(appToken string, chatNumber int, query string, opts search.ChatSearchOptions) (*search.ChatSearchResult, error) {
+	parsed, err := search.ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	index := c.indexFor(appToken)
 	routing := fmt.Sprintf("%s:%d", appToken, chatNumber)
+	scope := cursorScope(appToken, chatNumber, query, opts.Sort, opts.Filters)
 
+	page := opts.Page
 	if page < 1 {
 		page = 1
 	}
+	pageSize := opts.PerPage
 	if pageSize < 1 || pageSize > 100 {
 		pageSize = 20
 	}
-	from := (page - 1) * pageSize
+
+	filters := append([]interface{}{
+		map[string]interface{}{
+			"term": map[string]interface{}{
+				"application_token": appToken,
+			},
+		},
+		map[string]interface{}{
+			"term": map[string]interface{}{
+				"chat_number": chatNumber,
+			},
+		},
+	}, filterClauses(opts.Filters)...)
 
 	searchBody := map[string]interface{}{
 		"query": map[string]interface{}{
 			"bool": map[string]interface{}{
-				"must": []interface{}{
-					map[string]interface{}{
-						"term": map[string]interface{}{
-							"application_token": appToken,
-						},
-					},
-					map[string]interface{}{
-						"term": map[string]interface{}{
-							"chat_number": chatNumber,
-						},
-					},
-					map[string]interface{}{
-						"multi_match": map[string]interface{}{
-							"query":     query,
-							"fields":    []string{"content.partial^2", "content.fuzzy"},
-							"operator":  "and",
-							"fuzziness": "AUTO",
-						},
-					},
-				},
+				"filter": filters,
+				"must":   []interface{}{queryClause(parsed)},
 			},
 		},
-		"sort": []interface{}{
-			map[string]interface{}{
-				"message_number": "asc",
+		"sort":         sortClause(opts.Sort, false),
+		"track_scores": true,
+		"size":         pageSize,
+	}
+	if opts.Highlight != nil {
+		searchBody["highlight"] = highlightClause(opts.Highlight)
+	}
+
+	var pit string
+	if opts.Cursor != "" {
+		cur, err := decodeCursor(opts.Cursor, scope)
+		if err != nil {
+			return nil, err
+		}
+
+		pit = cur.PIT
+		if pit == "" {
+			if pit, err = c.openPIT(index, routing); err != nil {
+				return nil, err
+			}
+		}
+
+		searchBody["pit"] = map[string]interface{}{
+			"id":         pit,
+			"keep_alive": pitKeepAlive,
+		}
+		searchBody["search_after"] = cur.SearchAfter
+	} else {
+		searchBody["from"] = (page - 1) * pageSize
+	}
+
+	result, err := c.doSearch(index, routing, searchBody)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &search.ChatSearchResult{
+		Messages: c.parseHits(result.Hits.Hits),
+		Total:    result.Hits.Total.Value,
+	}
+
+	hits := result.Hits.Hits
+	if len(hits) < pageSize {
+		if pit != "" {
+			c.closePIT(pit)
+		}
+		return out, nil
+	}
+
+	next := &cursor{
+		SearchAfter: hits[len(hits)-1].Sort,
+		Scope:       scope,
+	}
+	if pit != "" {
+		next.PIT = result.PitID
+	}
+	out.NextCursor = encodeCursor(next)
+
+	return out, nil
+}
+
+// SearchApplication searches every chat of an application. Without a chat
+// filter the search fans out to all shards, with one it is routed to the
+// shards holding those chats only.
+func (c *Client) SearchApplication(appToken string, query string, opts search.ApplicationSearchOptions, page int, pageSize int) (*search.ApplicationSearchResult, error) {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+	if opts.PerChat < 1 {
+		opts.PerChat = 3
+	}
+
+	parsed, err := search.ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	filters := []interface{}{
+		map[string]interface{}{
+			"term": map[string]interface{}{
+				"application_token": appToken,
 			},
 		},
-		"from": from,
-		"size": pageSize,
 	}
 
+	index := c.indexFor(appToken)
+	routing := ""
+	if len(opts.ChatNumbers) > 0 {
+		filters = append(filters, map[string]interface{}{
+			"terms": map[string]interface{}{
+				"chat_number": opts.ChatNumbers,
+			},
+		})
+
+		routes := make([]string, len(opts.ChatNumbers))
+		for i, chatNumber := range opts.ChatNumbers {
+			routes[i] = fmt.Sprintf("%s:%d", appToken, chatNumber)
+		}
+		routing = strings.Join(routes, ",")
+	}
+	filters = append(filters, filterClauses(opts.Filters)...)
+
+	searchBody := map[string]interface{}{
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"filter": filters,
+				"must":   []interface{}{queryClause(parsed)},
+			},
+		},
+		"sort":         sortClause(opts.Sort, true),
+		"track_scores": true,
+		"from":         (page - 1) * pageSize,
+		"size":         pageSize,
+	}
+	if opts.Highlight != nil {
+		searchBody["highlight"] = highlightClause(opts.Highlight)
+	}
+
+	if opts.Collapse {
+		searchBody["collapse"] = map[string]interface{}{
+			"field": "chat_number",
+			"inner_hits": map[string]interface{}{
+				"name": "top",
+				"size": opts.PerChat,
+				"sort": sortClause(opts.Sort, false),
+			},
+		}
+		searchBody["aggs"] = map[string]interface{}{
+			"chats": map[string]interface{}{
+				"cardinality": map[string]interface{}{"field": "chat_number"},
+			},
+		}
+	}
+
+	result, err := c.doSearch(index, routing, searchBody)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &search.ApplicationSearchResult{Total: result.Hits.Total.Value}
+	if !opts.Collapse {
+		out.Messages = c.parseHits(result.Hits.Hits)
+		return out, nil
+	}
+
+	out.TotalChats = result.Aggregations.Chats.Value
+	out.Chats = make([]*search.ChatHits, 0, len(result.Hits.Hits))
+	for _, hit := range result.Hits.Hits {
+		top := hit.InnerHits["top"]
+		messages := c.parseHits(top.Hits.Hits)
+		if len(messages) == 0 {
+			continue
+		}
+		out.Chats = append(out.Chats, &search.ChatHits{
+			ChatNumber: messages[0].ChatNumber,
+			Total:      top.Hits.Total.Value,
+			Messages:   messages,
+		})
+	}
+
+	return out, nil
+}
+
+// contentQuery matches a single term against the analyzed content fields
+func contentQuery(query string) map[string]interface{} {
+	return map[string]interface{}{
+		"multi_match": map[string]interface{}{
+			"query":     query,
+			"fields":    []string{"content.partial^2", "content.fuzzy"},
+			"operator":  "and",
+			"fuzziness": "AUTO",
+		},
+	}
+}
+
+func (c *Client) doSearch(index, routing string, searchBody map[string]interface{}) (*SearchResult, error) {
+	return c.doSearchWithin(index, routing, searchBody, 10*time.Second)
+}
+
+func (c *Client) doSearchWithin(index, routing string, searchBody map[string]interface{}, timeout time.Duration) (*SearchResult, error) {
 	bodyBytes, err := json.Marshal(searchBody)
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to marshal search body: %w", err)
+		return nil, fmt.Errorf("failed to marshal search body: %w", err)
 	}
 
-	url := fmt.Sprintf("%s/messages/_search?routing=%s", c.baseURL, routing)
+	// Searches on a point-in-time must not name an index or routing, both
+	// were fixed when the PIT was opened
+	url := fmt.Sprintf("%s/%s/_search", c.baseURL, index)
+	if _, onPIT := searchBody["pit"]; onPIT {
+		url = fmt.Sprintf("%s/_search", c.baseURL)
+	} else if routing != "" {
+		url += "?routing=" + neturl.QueryEscape(routing)
+	}
 	req, err := http.NewRequest("POST", url, bytes.NewReader(bodyBytes))
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 	req = req.WithContext(ctx)
 
-	resp, err := c.client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to execute search: %w", err)
+		return nil, fmt.Errorf("failed to execute search: %w", err)
 	}
 	defer resp.Body.Close()
 
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to read response: %w", err)
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound && bytes.Contains(respBody, []byte("search_context_missing_exception")) {
+		return nil, search.ErrCursorExpired
+	}
+
+	// Anything our own validation let through but Elasticsearch still refuses,
+	// such as too many expanded clauses, is still the caller's query
+	if resp.StatusCode == http.StatusBadRequest {
+		c.logger.Error("search rejected by elasticsearch: %s", string(respBody))
+		return nil, &search.QueryError{Message: "the query could not be executed"}
 	}
 
 	if resp.StatusCode >= 400 {
-		return nil, 0, fmt.Errorf("search failed (status %d): %s", resp.StatusCode, string(respBody))
+		return nil, fmt.Errorf("search failed (status %d): %s", resp.StatusCode, string(respBody))
 	}
 
+	// Sort values are kept as json.Number so large tiebreakers survive the
+	// round trip through a cursor
 	var result SearchResult
-	if err := json.Unmarshal(respBody, &result); err != nil {
-		return nil, 0, fmt.Errorf("failed to parse search result: %w", err)
+	decoder := json.NewDecoder(bytes.NewReader(respBody))
+	decoder.UseNumber()
+	if err := decoder.Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to parse search result: %w", err)
 	}
 
-	messages := make([]*model.Message, 0, len(result.Hits.Hits))
-	for _, hit := range result.Hits.Hits {
+	return &result, nil
+}
+
+// do sends a search request through the circuit breaker. Only transport errors
+// and 5xx answers count as failures, a rejected query says nothing about the
+// cluster's health.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	if err := c.breaker.allow(); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.Do(req)
+	failed := err != nil || resp.StatusCode >= http.StatusInternalServerError
+	if c.breaker.record(failed) {
+		c.logger.Error("elasticsearch circuit breaker opened for %v", c.breaker.cooldown)
+	}
+
+	return resp, err
+}
+
+func (c *Client) parseHits(hits []SearchHit) []*model.MessageHit {
+	messages := make([]*model.MessageHit, 0, len(hits))
+	for _, hit := range hits {
 		var msg model.Message
 		sourceBytes, _ := json.Marshal(hit.Source)
 		if err := json.Unmarshal(sourceBytes, &msg); err != nil {
 			c.logger.Error("[ES] Failed to parse message: %v", err)
 			continue
 		}
-		messages = append(messages, &msg)
+		messages = append(messages, &model.MessageHit{
+			Message:    &msg,
+			Score:      hit.Score,
+			Highlights: hitHighlights(hit),
+		})
 	}
+	return messages
+}
 
-	return messages, result.Hits.Total.Value, nil
+// hitHighlights prefers whole-word fragments and falls back to prefix matches
+func hitHighlights(hit SearchHit) []string {
+	for _, field := range []string{"content.fuzzy", "content.partial", "content.suggest"} {
+		if fragments := hit.Highlight[field]; len(fragments) > 0 {
+			return fragments
+		}
+	}
+	return nil
 }
 
 func (c *Client) HealthCheck() error {
@@ -0,0 +1,115 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go-chat/internal/search"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"time"
+)
+
+const pitKeepAlive = "1m"
+
+// cursor is the state behind the opaque next_cursor handed to clients. A
+// cursor without a PIT comes from a from/size page, the point-in-time is
+// opened when the client first follows it.
+type cursor struct {
+	PIT         string        `json:"pit,omitempty"`
+	SearchAfter []interface{} `json:"after"`
+	Scope       string        `json:"scope"`
+}
+
+func encodeCursor(c *cursor) string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(encoded string, scope string) (*cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, search.ErrInvalidCursor
+	}
+
+	var c cursor
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&c); err != nil || len(c.SearchAfter) == 0 {
+		return nil, search.ErrInvalidCursor
+	}
+
+	// A cursor only continues the search it was issued for
+	if c.Scope != scope {
+		return nil, fmt.Errorf("%w: cursor belongs to a different search", search.ErrInvalidCursor)
+	}
+
+	return &c, nil
+}
+
+// cursorScope fingerprints the parameters a cursor is bound to
+func cursorScope(parts ...interface{}) string {
+	sum := sha256.Sum256([]byte(fmt.Sprint(parts...)))
+	return hex.EncodeToString(sum[:8])
+}
+
+func (c *Client) openPIT(index, routing string) (string, error) {
+	url := fmt.Sprintf("%s/%s/_pit?keep_alive=%s", c.baseURL, index, pitKeepAlive)
+	if routing != "" {
+		url += "&routing=" + neturl.QueryEscape(routing)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to open point in time: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode >= 400 {
+		return "", fmt.Errorf("open point in time failed (status %d): %s", resp.StatusCode, string(body))
+	}
+
+	var result struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", fmt.Errorf("failed to parse point in time: %w", err)
+	}
+
+	return result.ID, nil
+}
+
+// closePIT releases a point-in-time once the last page has been served
+func (c *Client) closePIT(pit string) {
+	body, _ := json.Marshal(map[string]string{"id": pit})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "DELETE", c.baseURL+"/_pit", bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		c.logger.Error("[ES] Failed to close point in time: %v", err)
+		return
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,94 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"go-chat/internal/estransport"
+	"go-chat/internal/model"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"time"
+)
+
+// Index writes a single message, go-worker indexes in bulk on the normal path
+func (c *Client) Index(message *model.Message) error {
+	return c.Bulk([]*model.Message{message})
+}
+
+// Bulk writes messages with the same ids, routing and fields as go-worker's
+// IndexingWorker, each into its application's index
+func (c *Client) Bulk(messages []*model.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	writer := estransport.NewBulkWriter(c.bulkMaxBytes, func(body *bytes.Buffer, items int) error {
+		resp, err := c.write("POST", c.baseURL+"/_bulk", "application/x-ndjson", body)
+		if err != nil {
+			return err
+		}
+
+		var result struct {
+			Errors bool `json:"errors"`
+		}
+		if err := json.Unmarshal(resp, &result); err == nil && result.Errors {
+			return fmt.Errorf("bulk index had errors: %s", string(resp))
+		}
+		return nil
+	})
+
+	for _, msg := range messages {
+		action := map[string]interface{}{
+			"index": map[string]interface{}{
+				"_index":  c.indexFor(msg.ApplicationToken),
+				"_id":     documentID(msg.ApplicationToken, msg.ChatNumber, msg.MessageNumber),
+				"routing": fmt.Sprintf("%s:%d", msg.ApplicationToken, msg.ChatNumber),
+			},
+		}
+		if err := writer.Add(action, msg); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+func (c *Client) Delete(appToken string, chatNumber int, messageNumber int) error {
+	url := fmt.Sprintf("%s/%s/_doc/%s?routing=%s", c.baseURL, c.indexFor(appToken),
+		neturl.PathEscape(documentID(appToken, chatNumber, messageNumber)),
+		neturl.QueryEscape(fmt.Sprintf("%s:%d", appToken, chatNumber)))
+
+	_, err := c.write("DELETE", url, "", nil)
+	return err
+}
+
+func (c *Client) write(method, url, contentType string, body io.Reader) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode >= 400 && !(method == "DELETE" && resp.StatusCode == http.StatusNotFound) {
+		return nil, fmt.Errorf("%s failed (status %d): %s", method, resp.StatusCode, string(respBody))
+	}
+	return respBody, nil
+}
+
+func documentID(appToken string, chatNumber int, messageNumber int) string {
+	return fmt.Sprintf("%s:%d:%d", appToken, chatNumber, messageNumber)
+}
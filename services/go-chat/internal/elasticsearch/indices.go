@@ -0,0 +1,49 @@
+package elasticsearch
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// sharedIndex holds every application without a dedicated index. go-worker
+// moves applications with a content language or synonyms to one of their own.
+const sharedIndex = "messages"
+
+const indexCacheTTL = time.Minute
+
+// indexFor returns the index an application's messages are searched in,
+// cached in Redis. go-worker clears the entry when it moves the application.
+func (c *Client) indexFor(appToken string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	cacheKey := fmt.Sprintf("search:index:%s", appToken)
+	cached, err := c.redis.Get(ctx, cacheKey).Result()
+	if err == nil {
+		return cached
+	}
+	if err != redis.Nil {
+		c.logger.Error("failed to read index of %s, using %s: %v", appToken, sharedIndex, err)
+		return sharedIndex
+	}
+
+	var index sql.NullString
+	query := "SELECT search_index FROM applications WHERE token = ?"
+	err = c.mysql.QueryRowContext(ctx, query, appToken).Scan(&index)
+	if err != nil && err != sql.ErrNoRows {
+		c.logger.Error("failed to load index of %s, using %s: %v", appToken, sharedIndex, err)
+		return sharedIndex
+	}
+
+	name := sharedIndex
+	if index.Valid && index.String != "" {
+		name = index.String
+	}
+	c.redis.Set(ctx, cacheKey, name, indexCacheTTL)
+
+	return name
+}
@@ -0,0 +1,81 @@
+package elasticsearch
+
+import (
+	"go-chat/internal/search"
+	"time"
+)
+
+// sortClause always ends on unique fields so search_after cursors are stable
+func sortClause(m search.SortMode, acrossChats bool) []interface{} {
+	var clause []interface{}
+	switch m {
+	case search.SortRelevance:
+		clause = append(clause, map[string]interface{}{"_score": "desc"})
+		if acrossChats {
+			clause = append(clause, map[string]interface{}{"chat_number": "asc"})
+		}
+		clause = append(clause, map[string]interface{}{"message_number": "asc"})
+	case search.SortNewest:
+		if acrossChats {
+			clause = append(clause, map[string]interface{}{"created_at": "desc"},
+				map[string]interface{}{"chat_number": "desc"})
+		}
+		clause = append(clause, map[string]interface{}{"message_number": "desc"})
+	default:
+		if acrossChats {
+			clause = append(clause, map[string]interface{}{"created_at": "asc"},
+				map[string]interface{}{"chat_number": "asc"})
+		}
+		clause = append(clause, map[string]interface{}{"message_number": "asc"})
+	}
+	return clause
+}
+
+// highlightClause highlights both analyzed subfields the query runs against,
+// html encoding the content so only our tags end up as markup
+func highlightClause(h *search.HighlightOptions) map[string]interface{} {
+	return map[string]interface{}{
+		"pre_tags":            []string{h.PreTag},
+		"post_tags":           []string{h.PostTag},
+		"encoder":             "html",
+		"fragment_size":       150,
+		"number_of_fragments": 3,
+		"fields": map[string]interface{}{
+			"content.fuzzy":   map[string]interface{}{},
+			"content.partial": map[string]interface{}{},
+		},
+	}
+}
+
+// filterClauses turns filters into range clauses
+func filterClauses(f search.Filters) []interface{} {
+	var clauses []interface{}
+
+	created := map[string]interface{}{}
+	if !f.CreatedFrom.IsZero() {
+		created["gte"] = f.CreatedFrom.UTC().Format(time.RFC3339)
+	}
+	if !f.CreatedTo.IsZero() {
+		created["lte"] = f.CreatedTo.UTC().Format(time.RFC3339)
+	}
+	if len(created) > 0 {
+		clauses = append(clauses, map[string]interface{}{
+			"range": map[string]interface{}{"created_at": created},
+		})
+	}
+
+	number := map[string]interface{}{}
+	if f.NumberFrom > 0 {
+		number["gte"] = f.NumberFrom
+	}
+	if f.NumberTo > 0 {
+		number["lte"] = f.NumberTo
+	}
+	if len(number) > 0 {
+		clauses = append(clauses, map[string]interface{}{
+			"range": map[string]interface{}{"message_number": number},
+		})
+	}
+
+	return clauses
+}
@@ -0,0 +1,67 @@
+package elasticsearch
+
+import "go-chat/internal/search"
+
+// queryClause compiles a parsed query into an Elasticsearch bool query. It only
+// produces match/prefix clauses, user input never reaches query_string.
+func queryClause(q *search.Query) map[string]interface{} {
+	must := make([]interface{}, 0, len(q.Groups()))
+	for _, group := range q.Groups() {
+		if len(group) == 1 {
+			must = append(must, matchClause(group[0]))
+			continue
+		}
+
+		should := make([]interface{}, len(group))
+		for i, t := range group {
+			should[i] = matchClause(t)
+		}
+		must = append(must, map[string]interface{}{
+			"bool": map[string]interface{}{
+				"should":               should,
+				"minimum_should_match": 1,
+			},
+		})
+	}
+
+	boolQuery := map[string]interface{}{"must": must}
+	if excluded := q.Excluded(); len(excluded) > 0 {
+		mustNot := make([]interface{}, len(excluded))
+		for i, t := range excluded {
+			mustNot[i] = excludeClause(t)
+		}
+		boolQuery["must_not"] = mustNot
+	}
+
+	return map[string]interface{}{"bool": boolQuery}
+}
+
+func matchClause(t search.Term) map[string]interface{} {
+	switch t.Kind {
+	case search.TermPhrase:
+		return map[string]interface{}{
+			"match_phrase": map[string]interface{}{"content.fuzzy": t.Text},
+		}
+	case search.TermPrefix:
+		return map[string]interface{}{
+			"prefix": map[string]interface{}{"content.fuzzy": t.Text},
+		}
+	default:
+		return contentQuery(t.Text)
+	}
+}
+
+// excludeClause matches exactly, fuzziness would exclude too much
+func excludeClause(t search.Term) map[string]interface{} {
+	if t.Kind == search.TermWord {
+		return map[string]interface{}{
+			"match": map[string]interface{}{
+				"content.fuzzy": map[string]interface{}{
+					"query":    t.Text,
+					"operator": "and",
+				},
+			},
+		}
+	}
+	return matchClause(t)
+}
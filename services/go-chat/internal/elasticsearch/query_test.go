@@ -0,0 +1,46 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"go-chat/internal/search"
+	"testing"
+)
+
+func TestQueryClause(t *testing.T) {
+	const word = `{"multi_match":{"fields":["content.partial^2","content.fuzzy"],"fuzziness":"AUTO","operator":"and","query":"invoice"}}`
+
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"word", "invoice", `{"bool":{"must":[` + word + `]}}`},
+		{"phrase", `"next week"`, `{"bool":{"must":[{"match_phrase":{"content.fuzzy":"next week"}}]}}`},
+		{"prefix", "inv*", `{"bool":{"must":[{"prefix":{"content.fuzzy":"inv"}}]}}`},
+		{
+			"either",
+			"invoice OR inv*",
+			`{"bool":{"must":[{"bool":{"minimum_should_match":1,"should":[` + word + `,{"prefix":{"content.fuzzy":"inv"}}]}}]}}`,
+		},
+		{
+			// Excluded words match exactly rather than fuzzily
+			"excluded",
+			`invoice -draft -"next week"`,
+			`{"bool":{"must":[` + word + `],"must_not":[` +
+				`{"match":{"content.fuzzy":{"operator":"and","query":"draft"}}},` +
+				`{"match_phrase":{"content.fuzzy":"next week"}}]}}`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			parsed, err := search.ParseQuery(tc.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _ := json.Marshal(queryClause(parsed))
+			if string(got) != tc.want {
+				t.Errorf("queryClause(%q) =\n%s\nwant\n%s", tc.query, got, tc.want)
+			}
+		})
+	}
+}
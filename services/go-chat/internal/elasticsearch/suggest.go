@@ -0,0 +1,76 @@
+package elasticsearch
+
+import (
+	"fmt"
+	"go-chat/internal/model"
+	"go-chat/internal/search"
+	"time"
+)
+
+// Suggestions run on every keystroke, so they get a tight budget: Elasticsearch
+// returns whatever it found within suggestTimeout and the whole round trip is
+// abandoned after suggestDeadline.
+const (
+	suggestTimeout  = "150ms"
+	suggestDeadline = 1 * time.Second
+)
+
+// Suggest returns the best matches for a partially typed prefix using the
+// content.suggest search_as_you_type field. The last word may be incomplete.
+func (c *Client) Suggest(appToken string, chatNumber int, prefix string, opts search.SuggestOptions) ([]*model.MessageHit, error) {
+	if opts.Size < 1 || opts.Size > 10 {
+		opts.Size = 5
+	}
+
+	searchBody := map[string]interface{}{
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"filter": []interface{}{
+					map[string]interface{}{
+						"term": map[string]interface{}{
+							"application_token": appToken,
+						},
+					},
+					map[string]interface{}{
+						"term": map[string]interface{}{
+							"chat_number": chatNumber,
+						},
+					},
+				},
+				"must": []interface{}{
+					map[string]interface{}{
+						"multi_match": map[string]interface{}{
+							"query": prefix,
+							"type":  "bool_prefix",
+							"fields": []string{
+								"content.suggest",
+								"content.suggest._2gram",
+								"content.suggest._3gram",
+							},
+						},
+					},
+				},
+			},
+		},
+		"sort":             []interface{}{map[string]interface{}{"_score": "desc"}, map[string]interface{}{"message_number": "desc"}},
+		"track_scores":     true,
+		"track_total_hits": false,
+		"timeout":          suggestTimeout,
+		"size":             opts.Size,
+	}
+	if opts.Highlight != nil {
+		highlight := highlightClause(opts.Highlight)
+		highlight["number_of_fragments"] = 1
+		highlight["fields"] = map[string]interface{}{
+			"content.suggest": map[string]interface{}{},
+		}
+		searchBody["highlight"] = highlight
+	}
+
+	result, err := c.doSearchWithin(c.indexFor(appToken), fmt.Sprintf("%s:%d", appToken, chatNumber), searchBody, suggestDeadline)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.parseHits(result.Hits.Hits), nil
+}
@@ -0,0 +1,60 @@
+package estransport
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// DefaultBulkMaxBytes keeps bulk requests well below http.max_content_length
+const DefaultBulkMaxBytes = 5 << 20
+
+// BulkWriter encodes bulk actions into a buffer and hands it to flush each
+// time it grows past maxBytes, so a batch of large documents goes out as
+// several requests instead of one oversized one.
+type BulkWriter struct {
+	buf      bytes.Buffer
+	encoder  *json.Encoder
+	maxBytes int
+	items    int
+	flush    func(body *bytes.Buffer, items int) error
+}
+
+func NewBulkWriter(maxBytes int, flush func(body *bytes.Buffer, items int) error) *BulkWriter {
+	if maxBytes <= 0 {
+		maxBytes = DefaultBulkMaxBytes
+	}
+	w := &BulkWriter{maxBytes: maxBytes, flush: flush}
+	w.encoder = json.NewEncoder(&w.buf)
+	return w
+}
+
+// Add appends an action line and, unless it is nil, its source line
+func (w *BulkWriter) Add(action, source interface{}) error {
+	if err := w.encoder.Encode(action); err != nil {
+		return fmt.Errorf("failed to encode bulk action: %w", err)
+	}
+	if source != nil {
+		if err := w.encoder.Encode(source); err != nil {
+			return fmt.Errorf("failed to encode bulk source: %w", err)
+		}
+	}
+	w.items++
+
+	if w.buf.Len() >= w.maxBytes {
+		return w.Flush()
+	}
+	return nil
+}
+
+// Flush sends whatever is buffered
+func (w *BulkWriter) Flush() error {
+	if w.items == 0 {
+		return nil
+	}
+
+	err := w.flush(&w.buf, w.items)
+	w.buf.Reset()
+	w.items = 0
+	return err
+}
@@ -0,0 +1,6 @@
+package estransport
+
+// go-worker has a generated copy of this package. Regenerate it after every
+// change here.
+//
+//go:generate go run ../../cmd/sharedgen
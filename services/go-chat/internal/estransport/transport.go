@@ -0,0 +1,317 @@
+// Package estransport is the HTTP layer shared by the Elasticsearch clients of
+// go-chat and go-worker. go-chat owns it, go-worker builds from a generated
+// copy.
+package estransport
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Logger interface {
+	Info(format string, args ...any)
+	Error(format string, args ...any)
+}
+
+type Config struct {
+	// URLs are the cluster's nodes, requests are spread over them round-robin
+	URLs []string
+
+	// Username and Password enable basic auth, APIKey is the base64 encoded
+	// "id:key" credential and takes precedence when both are set
+	Username string
+	Password string
+	APIKey   string
+
+	// CACert is a PEM bundle trusted on top of the system roots
+	CACert string
+
+	// MaxRetries is how often a request is retried after a connection error,
+	// 429, 502, 503 or 504, with jittered exponential backoff from RetryBackoff
+	MaxRetries   int
+	RetryBackoff time.Duration
+
+	// DeadTimeout is how long a node that refused a connection is skipped,
+	// doubling with each further failure
+	DeadTimeout time.Duration
+
+	// Compress gzips request bodies
+	Compress bool
+
+	Logger Logger
+}
+
+const (
+	maxRetryBackoff = 10 * time.Second
+	maxDeadTimeout  = 5 * time.Minute
+)
+
+type node struct {
+	url       *url.URL
+	failures  int
+	deadUntil time.Time
+}
+
+// Transport is an http.RoundTripper that sends each request to a live node,
+// with credentials and retries. Requests are built against the first node's
+// URL, which BaseURL returns, and rewritten to the node they are sent to.
+type Transport struct {
+	mu    sync.Mutex
+	nodes []*node
+	next  int
+
+	base     http.RoundTripper
+	username string
+	password string
+	apiKey   string
+
+	maxRetries   int
+	retryBackoff time.Duration
+	deadTimeout  time.Duration
+	compress     bool
+	logger       Logger
+}
+
+func New(cfg Config) (*Transport, error) {
+	if len(cfg.URLs) == 0 {
+		return nil, errors.New("no elasticsearch URL configured")
+	}
+
+	t := &Transport{
+		username:     cfg.Username,
+		password:     cfg.Password,
+		apiKey:       cfg.APIKey,
+		maxRetries:   cfg.MaxRetries,
+		retryBackoff: cfg.RetryBackoff,
+		deadTimeout:  cfg.DeadTimeout,
+		compress:     cfg.Compress,
+		logger:       cfg.Logger,
+	}
+	if t.retryBackoff <= 0 {
+		t.retryBackoff = 100 * time.Millisecond
+	}
+	if t.deadTimeout <= 0 {
+		t.deadTimeout = 10 * time.Second
+	}
+
+	for _, raw := range cfg.URLs {
+		parsed, err := url.Parse(strings.TrimRight(strings.TrimSpace(raw), "/"))
+		if err != nil || parsed.Host == "" {
+			return nil, fmt.Errorf("invalid elasticsearch URL %q", raw)
+		}
+		t.nodes = append(t.nodes, &node{url: parsed})
+	}
+
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.CACert != "" {
+		pem, err := os.ReadFile(cfg.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CACert)
+		}
+		base.TLSClientConfig = &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}
+	}
+	t.base = base
+
+	return t, nil
+}
+
+// SplitURLs reads a comma separated list of node URLs
+func SplitURLs(value string) []string {
+	var urls []string
+	for _, raw := range strings.Split(value, ",") {
+		if raw = strings.TrimSpace(raw); raw != "" {
+			urls = append(urls, raw)
+		}
+	}
+	return urls
+}
+
+// BaseURL is the URL requests are built against
+func (t *Transport) BaseURL() string {
+	return t.nodes[0].url.String()
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := t.requestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		n := t.pick()
+		out := t.prepare(req, n, body)
+
+		resp, err := t.base.RoundTrip(out)
+		if err != nil {
+			// The caller gave up, that says nothing about the node
+			if req.Context().Err() != nil {
+				return nil, err
+			}
+			t.markDead(n, err)
+		} else {
+			t.markAlive(n)
+			if !retryableStatus(resp.StatusCode) {
+				return resp, nil
+			}
+		}
+
+		if attempt >= t.maxRetries {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		select {
+		case <-time.After(t.backoff(attempt)):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// requestBody reads the body once so every attempt can resend it, gzipped
+// when compression is on
+func (t *Transport) requestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	defer req.Body.Close()
+
+	raw, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %w", err)
+	}
+	if !t.compress {
+		return raw, nil
+	}
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	if _, err := gz.Write(raw); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return compressed.Bytes(), nil
+}
+
+// prepare copies req for one attempt against n
+func (t *Transport) prepare(req *http.Request, n *node, body []byte) *http.Request {
+	out := req.Clone(req.Context())
+
+	target := *out.URL
+	target.Scheme = n.url.Scheme
+	target.Host = n.url.Host
+	target.Path = n.url.Path + strings.TrimPrefix(out.URL.Path, t.nodes[0].url.Path)
+	target.RawPath = ""
+	out.URL = &target
+	out.Host = ""
+
+	if body != nil {
+		out.Body = io.NopCloser(bytes.NewReader(body))
+		out.ContentLength = int64(len(body))
+		out.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+		if t.compress {
+			out.Header.Set("Content-Encoding", "gzip")
+		}
+	}
+
+	switch {
+	case t.apiKey != "":
+		out.Header.Set("Authorization", "ApiKey "+t.apiKey)
+	case t.username != "":
+		out.SetBasicAuth(t.username, t.password)
+	}
+
+	return out
+}
+
+// pick returns the next live node. When every node is dead the one that comes
+// back first is tried anyway, a request is never refused outright.
+func (t *Transport) pick() *node {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	var soonest *node
+	for i := 0; i < len(t.nodes); i++ {
+		n := t.nodes[(t.next+i)%len(t.nodes)]
+		if !now.Before(n.deadUntil) {
+			t.next = (t.next + i + 1) % len(t.nodes)
+			return n
+		}
+		if soonest == nil || n.deadUntil.Before(soonest.deadUntil) {
+			soonest = n
+		}
+	}
+	return soonest
+}
+
+func (t *Transport) markDead(n *node, err error) {
+	t.mu.Lock()
+	n.failures++
+	timeout := t.deadTimeout << (n.failures - 1)
+	if timeout > maxDeadTimeout || timeout <= 0 {
+		timeout = maxDeadTimeout
+	}
+	n.deadUntil = time.Now().Add(timeout)
+	t.mu.Unlock()
+
+	if t.logger != nil {
+		t.logger.Error("[ES] Node %s is unreachable, skipping it for %v: %v", n.url.Host, timeout, err)
+	}
+}
+
+func (t *Transport) markAlive(n *node) {
+	t.mu.Lock()
+	revived := n.failures > 0
+	n.failures = 0
+	n.deadUntil = time.Time{}
+	t.mu.Unlock()
+
+	if revived && t.logger != nil {
+		t.logger.Info("[ES] Node %s is reachable again", n.url.Host)
+	}
+}
+
+// backoff is full jitter over an exponentially growing window, so clients
+// retrying together spread out
+func (t *Transport) backoff(attempt int) time.Duration {
+	window := t.retryBackoff << attempt
+	if window > maxRetryBackoff || window <= 0 {
+		window = maxRetryBackoff
+	}
+	return time.Duration(rand.Int63n(int64(window)) + 1)
+}
+
+func retryableStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,164 @@
+package estransport
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder is an Elasticsearch node answering with statuses in turn, then
+// 200, and remembering what it was sent
+type recorder struct {
+	mu       sync.Mutex
+	statuses []int
+	bodies   []string
+	auth     []string
+}
+
+func (r *recorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body := req.Body
+	if req.Header.Get("Content-Encoding") == "gzip" {
+		body, _ = gzip.NewReader(req.Body)
+	}
+	raw, _ := io.ReadAll(body)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.bodies = append(r.bodies, string(raw))
+	r.auth = append(r.auth, req.Header.Get("Authorization"))
+	status := http.StatusOK
+	if len(r.statuses) > 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func newTestNode(t *testing.T, statuses ...int) (*recorder, string) {
+	t.Helper()
+
+	r := &recorder{statuses: statuses}
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	return r, server.URL
+}
+
+func send(t *testing.T, tr *Transport, body string) int {
+	t.Helper()
+
+	req, _ := http.NewRequest("POST", tr.BaseURL()+"/_bulk", strings.NewReader(body))
+	resp, err := (&http.Client{Transport: tr}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestRoundTripRetries(t *testing.T) {
+	tests := []struct {
+		name       string
+		statuses   []int
+		maxRetries int
+		want       int
+		attempts   int
+	}{
+		{"retried until it succeeds", []int{503, 429}, 3, http.StatusOK, 3},
+		{"retries used up", []int{502, 504, 503}, 2, http.StatusServiceUnavailable, 3},
+		{"server errors are not retried", []int{500}, 3, http.StatusInternalServerError, 1},
+		{"rejected requests are not retried", []int{400}, 3, http.StatusBadRequest, 1},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			node, url := newTestNode(t, tc.statuses...)
+			tr, err := New(Config{URLs: []string{url}, MaxRetries: tc.maxRetries, RetryBackoff: time.Millisecond})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if status := send(t, tr, `{"index":{}}`); status != tc.want {
+				t.Errorf("status = %d, want %d", status, tc.want)
+			}
+			if len(node.bodies) != tc.attempts {
+				t.Errorf("%d attempts, want %d", len(node.bodies), tc.attempts)
+			}
+			for i, body := range node.bodies {
+				if body != `{"index":{}}` {
+					t.Errorf("attempt %d sent %q, want the whole body", i+1, body)
+				}
+			}
+		})
+	}
+}
+
+func TestRoundTripCredentials(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		want string
+	}{
+		{"none", Config{}, ""},
+		{"basic", Config{Username: "elastic", Password: "secret"}, "Basic ZWxhc3RpYzpzZWNyZXQ="},
+		{"API key wins", Config{Username: "elastic", Password: "secret", APIKey: "aWQ6a2V5"}, "ApiKey aWQ6a2V5"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			node, url := newTestNode(t)
+			tc.cfg.URLs = []string{url}
+			tc.cfg.Compress = true
+			tr, err := New(tc.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			send(t, tr, "hello")
+			if node.auth[0] != tc.want {
+				t.Errorf("Authorization = %q, want %q", node.auth[0], tc.want)
+			}
+			if node.bodies[0] != "hello" {
+				t.Errorf("body = %q, want it gzipped and intact", node.bodies[0])
+			}
+		})
+	}
+}
+
+func TestRoundTripSkipsDeadNodes(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	node, up := newTestNode(t)
+
+	tr, err := New(Config{URLs: []string{down.URL, up}, MaxRetries: 1, RetryBackoff: time.Millisecond, DeadTimeout: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first request fails over to the live node, later ones go straight
+	// to it while the other is marked dead
+	for i := 0; i < 3; i++ {
+		if status := send(t, tr, "{}"); status != http.StatusOK {
+			t.Fatalf("request %d: status = %d", i+1, status)
+		}
+	}
+	if len(node.bodies) != 3 {
+		t.Errorf("live node got %d requests, want 3", len(node.bodies))
+	}
+}
+
+func TestNewRejectsBadURLs(t *testing.T) {
+	for _, urls := range [][]string{nil, {"localhost:9200"}, {"http://"}} {
+		if _, err := New(Config{URLs: urls}); err == nil {
+			t.Errorf("New accepted %q", urls)
+		}
+	}
+}
+
+func TestSplitURLs(t *testing.T) {
+	got := SplitURLs(" http://a:9200, ,http://b:9200 ")
+	if len(got) != 2 || got[0] != "http://a:9200" || got[1] != "http://b:9200" {
+		t.Errorf("SplitURLs = %q", got)
+	}
+}
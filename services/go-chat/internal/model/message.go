@@ -10,3 +10,10 @@ type Message struct {
 	Content          string    `json:"content"`
 	CreatedAt        time.Time `json:"created_at"`
 }
+
+// MessageHit is a message returned by search, with why it matched
+type MessageHit struct {
+	*Message
+	Score      *float64 `json:"score"`
+	Highlights []string `json:"highlights,omitempty"`
+}
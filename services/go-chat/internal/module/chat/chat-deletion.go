@@ -0,0 +1,134 @@
+package chat
+
+import (
+	"go-chat/internal/contract"
+	"go-chat/internal/logging"
+	"go-chat/internal/queue"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// DeleteApplicationHandler tombstones an application and queues the removal
+// of its chats, messages, keys and documents. Deleting again republishes the
+// deletion, which lets an operator retry a purge that failed.
+func (s *Service) DeleteApplicationHandler(ctx *fiber.Ctx) error {
+	logger := ctx.Locals("logger").(*logging.Logger)
+	appToken := ctx.Params("token")
+
+	exists, err := s.repo.ApplicationExists(appToken)
+	if err != nil {
+		logger.Error("failed to look up application %s: %v", appToken, err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to look up application",
+		})
+	}
+	if !exists {
+		return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "application not found",
+		})
+	}
+
+	if err := s.repo.Tombstone(contract.ApplicationTombstone(appToken)); err != nil {
+		logger.Error("failed to tombstone application %s: %v", appToken, err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to delete application",
+		})
+	}
+
+	payload := &contract.ApplicationDeleted{AppToken: appToken}
+	if err := s.QueueMessage(payload, traceHeaders(ctx), queue.DeletionsQueue); err != nil {
+		logger.Error("failed to queue application deletion: %v", err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to queue deletion",
+		})
+	}
+	logger.Info("application %s deleted, purge queued", appToken)
+
+	return ctx.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"status": "deleting",
+	})
+}
+
+// DeleteChatHandler tombstones a chat and queues the removal of its messages
+// and documents
+func (s *Service) DeleteChatHandler(ctx *fiber.Ctx) error {
+	logger := ctx.Locals("logger").(*logging.Logger)
+	appToken := ctx.Params("token")
+
+	chatNumber, err := strconv.Atoi(ctx.Params("number"))
+	if err != nil || chatNumber < 1 {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "chat number must be a positive integer",
+		})
+	}
+
+	deleted, err := s.repo.IsDeleted(appToken, 0)
+	if err != nil {
+		logger.Error("failed to check application tombstone: %v", err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to delete chat",
+		})
+	}
+	if deleted {
+		return ctx.Status(fiber.StatusGone).JSON(fiber.Map{
+			"error": "application has been deleted",
+		})
+	}
+
+	exists, err := s.repo.ChatExists(appToken, chatNumber)
+	if err != nil {
+		logger.Error("failed to look up chat %d: %v", chatNumber, err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to look up chat",
+		})
+	}
+	if !exists {
+		return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "chat not found",
+		})
+	}
+
+	if err := s.repo.Tombstone(contract.ChatTombstone(appToken, chatNumber)); err != nil {
+		logger.Error("failed to tombstone chat %d: %v", chatNumber, err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to delete chat",
+		})
+	}
+
+	payload := &contract.ChatDeleted{AppToken: appToken, ChatNumber: chatNumber}
+	if err := s.QueueMessage(payload, traceHeaders(ctx), queue.DeletionsQueue); err != nil {
+		logger.Error("failed to queue chat deletion: %v", err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to queue deletion",
+		})
+	}
+	logger.Info("chat %d of %s deleted, purge queued", chatNumber, appToken)
+
+	return ctx.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"status": "deleting",
+	})
+}
+
+// RejectDeleted answers 410 Gone to writes against a deleted application or
+// chat, before a number is handed out for them
+func (s *Service) RejectDeleted(ctx *fiber.Ctx) error {
+	logger := ctx.Locals("logger").(*logging.Logger)
+
+	// Chat creation has no number, only the application is checked then
+	chatNumber, _ := strconv.Atoi(ctx.Params("number"))
+	deleted, err := s.repo.IsDeleted(ctx.Params("token"), chatNumber)
+	if err != nil {
+		logger.Error("failed to check tombstones: %v", err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to check whether the chat exists",
+		})
+	}
+	if deleted {
+		return ctx.Status(fiber.StatusGone).JSON(fiber.Map{
+			"error": "chat or application has been deleted",
+		})
+	}
+
+	return ctx.Next()
+}
@@ -0,0 +1,130 @@
+package chat
+
+import (
+	"go-chat/internal/config"
+	"go-chat/internal/contract"
+	"go-chat/internal/logging"
+	"go-chat/internal/queue"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/gofiber/fiber/v2"
+)
+
+// recordingPublisher keeps what would have been published
+type recordingPublisher struct {
+	published map[queue.QueueType][]*contract.Envelope
+}
+
+func (p *recordingPublisher) Publish(queueType queue.QueueType, msg *queue.Message) error {
+	env, err := contract.Parse(msg.Body)
+	if err != nil {
+		return err
+	}
+	p.published[queueType] = append(p.published[queueType], env)
+	return nil
+}
+
+// newDeletionTestApp routes chat deletion and creation. Chats 1 to 3 of
+// "app" have been handed out.
+func newDeletionTestApp(t *testing.T) (*fiber.App, *miniredis.Miniredis, *recordingPublisher) {
+	t.Helper()
+
+	m := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	m.Set(contract.ChatCounter("app"), "3")
+
+	publisher := &recordingPublisher{published: make(map[queue.QueueType][]*contract.Envelope)}
+	s := &Service{repo: &Repo{redisClient: rdb}, queue: publisher}
+	logger := logging.NewLogger(&config.Config{AppName: "chat-test", LogPath: t.TempDir()})
+
+	app := fiber.New()
+	app.Use(func(ctx *fiber.Ctx) error {
+		ctx.Locals("logger", logger)
+		return ctx.Next()
+	})
+	app.Delete("/applications/:token/chats/:number", s.DeleteChatHandler)
+	app.Post("/applications/:token/chats/:number/messages", s.RejectDeleted, func(ctx *fiber.Ctx) error {
+		return ctx.SendStatus(fiber.StatusCreated)
+	})
+	return app, m, publisher
+}
+
+func request(t *testing.T, app *fiber.App, method, path string) int {
+	t.Helper()
+
+	resp, err := app.Test(httptest.NewRequest(method, path, nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode
+}
+
+func TestDeleteChat(t *testing.T) {
+	app, m, publisher := newDeletionTestApp(t)
+
+	if status := request(t, app, "DELETE", "/applications/app/chats/2"); status != fiber.StatusAccepted {
+		t.Fatalf("status = %d, want 202", status)
+	}
+	if !m.Exists(contract.ChatTombstone("app", 2)) {
+		t.Error("chat 2 was not tombstoned")
+	}
+
+	deletions := publisher.published[queue.DeletionsQueue]
+	if len(deletions) != 1 || deletions[0].Type != contract.TypeChatDeleted {
+		t.Fatalf("published %v, want one chat.deleted", deletions)
+	}
+	var payload contract.ChatDeleted
+	if err := deletions[0].Decode(&payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.AppToken != "app" || payload.ChatNumber != 2 {
+		t.Errorf("chat.deleted for %+v, want chat 2 of app", payload)
+	}
+
+	// Writes to the deleted chat are refused, its neighbours carry on
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/applications/app/chats/2/messages", fiber.StatusGone},
+		{"/applications/app/chats/1/messages", fiber.StatusCreated},
+	}
+	for _, tc := range tests {
+		if status := request(t, app, "POST", tc.path); status != tc.want {
+			t.Errorf("POST %s = %d, want %d", tc.path, status, tc.want)
+		}
+	}
+}
+
+func TestDeleteChatRefused(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		// deleted is the application that was deleted beforehand
+		deleted string
+		want    int
+	}{
+		{"not a number", "/applications/app/chats/first", "", fiber.StatusBadRequest},
+		{"zero", "/applications/app/chats/0", "", fiber.StatusBadRequest},
+		{"application deleted", "/applications/app/chats/1", "app", fiber.StatusGone},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			app, m, publisher := newDeletionTestApp(t)
+			if tc.deleted != "" {
+				m.Set(contract.ApplicationTombstone(tc.deleted), "2025-11-26T09:00:00Z")
+			}
+
+			if status := request(t, app, "DELETE", tc.path); status != tc.want {
+				t.Errorf("status = %d, want %d", status, tc.want)
+			}
+			if n := len(publisher.published[queue.DeletionsQueue]); n != 0 {
+				t.Errorf("published %d deletions, want none", n)
+			}
+		})
+	}
+}
@@ -1,10 +1,17 @@
 package chat
 
 import (
+	"errors"
+	"fmt"
+	"go-chat/internal/contract"
 	"go-chat/internal/logging"
 	"go-chat/internal/model"
 	"go-chat/internal/queue"
+	"go-chat/internal/search"
+	"math"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -30,12 +37,14 @@ func (s *Service) CreateChatHandler(ctx *fiber.Ctx) error {
 	}
 	logger.Info("chat number generated: %d for app %s", chatNumber, appToken)
 
-	payload := map[string]interface{}{
-		"app_token":   appToken,
-		"chat_number": chatNumber,
+	payload := &contract.ChatCreated{
+		AppToken:       appToken,
+		ChatNumber:     int(chatNumber),
+		IdempotencyKey: idempotencyKeyFromCtx(ctx),
 	}
-	if err := s.QueueMessage(payload, queue.ChatsQueue); err != nil {
+	if err := s.QueueMessage(payload, traceHeaders(ctx), queue.ChatsQueue); err != nil {
 		logger.Error("failed to queue chat for persistence: %v", err)
+		s.recordUnpublished(logger, appToken, contract.NumberRef(int(chatNumber), 0))
 		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "failed to queue chat",
 		})
@@ -99,15 +108,17 @@ func (s *Service) CreateMessageHandler(ctx *fiber.Ctx) error {
 	}
 	logger.Info("message number generated: %d for chat %d", messageNumber, chatNumber)
 
-	payload := map[string]interface{}{
-		"app_token":      appToken,
-		"chat_number":    chatNumber,
-		"content":        input.Content,
-		"message_number": messageNumber,
+	payload := &contract.MessageCreated{
+		AppToken:       appToken,
+		ChatNumber:     chatNumber,
+		MessageNumber:  int(messageNumber),
+		Content:        input.Content,
+		IdempotencyKey: idempotencyKeyFromCtx(ctx),
 	}
 
-	if err := s.QueueMessage(payload, queue.MessagesQueue); err != nil {
+	if err := s.QueueMessage(payload, traceHeaders(ctx), queue.MessagesQueue); err != nil {
 		logger.Error("failed to queue message for persistence: %v", err)
+		s.recordUnpublished(logger, appToken, contract.NumberRef(chatNumber, int(messageNumber)))
 		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "failed to queue message",
 		})
@@ -169,9 +180,62 @@ func (s *Service) SearchMessagesHandler(ctx *fiber.Ctx) error {
 		perPage = 100
 	}
 
-	logger.Info("searching messages: app=%s, chat=%d, query=%s, page=%d, per_page=%d", appToken, chatNumber, query, page, perPage)
+	sortMode, highlight, err := parseResultOptions(ctx, search.SortOldest)
+	if err != nil {
+		logger.Error("invalid search options: %v", err)
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	filters, err := parseFilters(ctx)
+	if err != nil {
+		logger.Error("invalid search filters: %v", err)
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	cursor := ctx.Query("cursor")
+	if cursor == "" && page*perPage > maxResultWindow {
+		logger.Error("page %d is beyond the result window", page)
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "page is too deep, follow meta.next_cursor instead",
+		})
+	}
 
-	messages, total, err := s.SearchMessages(appToken, chatNumber, query, page, perPage)
+	logger.Info("searching messages: app=%s, chat=%d, query=%s, page=%d, per_page=%d, sort=%s, cursor=%t",
+		appToken, chatNumber, query, page, perPage, sortMode, cursor != "")
+
+	result, cacheStatus, err := s.SearchMessages(appToken, chatNumber, query, search.ChatSearchOptions{
+		Page:      page,
+		PerPage:   perPage,
+		Cursor:    cursor,
+		Sort:      sortMode,
+		Filters:   filters,
+		Highlight: highlight,
+	}, bypassSearchCache(ctx))
+	if cacheStatus != search.CacheSkipped {
+		ctx.Set("X-Search-Cache", string(cacheStatus))
+	}
+	if errors.Is(err, search.ErrInvalidCursor) || errors.Is(err, search.ErrCursorExpired) {
+		logger.Error("rejected search cursor: %v", err)
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	var queryErr *search.QueryError
+	if errors.As(err, &queryErr) {
+		logger.Error("rejected search query: %v", err)
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": queryErr.Error(),
+		})
+	}
+	var unavailable *search.UnavailableError
+	if errors.As(err, &unavailable) {
+		logger.Error("search unavailable: %v", err)
+		return searchUnavailable(ctx, unavailable)
+	}
 	if err != nil {
 		logger.Error("failed to search messages: %v", err)
 		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -179,17 +243,388 @@ func (s *Service) SearchMessagesHandler(ctx *fiber.Ctx) error {
 		})
 	}
 
-	totalPages := (total + perPage - 1) / perPage
+	totalPages := (result.Total + perPage - 1) / perPage
+
+	logger.Info("found %d messages matching query (total: %d)", len(result.Messages), result.Total)
+
+	meta := fiber.Map{
+		"sort":        sortMode,
+		"per_page":    perPage,
+		"total":       result.Total,
+		"total_pages": totalPages,
+		"next_cursor": nil,
+	}
+	if cursor == "" {
+		meta["page"] = page
+	}
+	if result.Degraded {
+		meta["degraded"] = true
+	}
+	if result.NextCursor != "" {
+		meta["next_cursor"] = result.NextCursor
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(fiber.Map{
+		"data": result.Messages,
+		"meta": meta,
+	})
+}
+
+// SuggestMessagesHandler serves search-as-you-type results for a partially
+// typed prefix. It is meant to be called on every keystroke, so it skips
+// pagination and totals and answers within a tight latency budget.
+func (s *Service) SuggestMessagesHandler(ctx *fiber.Ctx) error {
+	logger := ctx.Locals("logger").(*logging.Logger)
+	appToken := ctx.Params("token")
+	prefix := strings.TrimSpace(ctx.Query("prefix"))
+
+	chatNumber, err := strconv.Atoi(ctx.Params("number"))
+	if err != nil {
+		logger.Error("invalid chat number: %v", err)
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "chat number must be a valid integer",
+		})
+	}
+
+	if prefix == "" {
+		logger.Error("missing suggest prefix")
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "query parameter 'prefix' is required",
+		})
+	}
+	if len([]rune(prefix)) > maxSuggestPrefix {
+		logger.Error("suggest prefix too long: %d characters", len([]rune(prefix)))
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("prefix must be at most %d characters", maxSuggestPrefix),
+		})
+	}
+
+	size, err := strconv.Atoi(ctx.Query("size", "5"))
+	if err != nil || size < 1 {
+		size = 5
+	}
+	if size > 10 {
+		size = 10
+	}
+
+	highlight, err := parseHighlight(ctx)
+	if err != nil {
+		logger.Error("invalid suggest options: %v", err)
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	suggestions, err := s.SuggestMessages(appToken, chatNumber, prefix, search.SuggestOptions{
+		Size:      size,
+		Highlight: highlight,
+	})
+	var unavailable *search.UnavailableError
+	if errors.As(err, &unavailable) {
+		logger.Error("suggest unavailable: %v", err)
+		return searchUnavailable(ctx, unavailable)
+	}
+	if err != nil {
+		logger.Error("failed to suggest messages: %v", err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to suggest messages",
+		})
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(fiber.Map{
+		"data": suggestions,
+		"meta": fiber.Map{
+			"prefix": prefix,
+			"size":   size,
+		},
+	})
+}
+
+const maxChatFilter = 100
+
+// maxResultWindow is Elasticsearch's default index.max_result_window
+const maxResultWindow = 10000
+
+const maxHighlightTag = 64
+
+const maxSuggestPrefix = 100
+
+func (s *Service) SearchApplicationMessagesHandler(ctx *fiber.Ctx) error {
+	logger := ctx.Locals("logger").(*logging.Logger)
+	appToken := ctx.Params("token")
+	query := ctx.Query("q")
+	pageStr := ctx.Query("page", "1")
+	perPageStr := ctx.Query("per_page", "20")
+
+	if appToken == "" {
+		logger.Error("missing app token in URL")
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "app token is required",
+		})
+	}
+
+	if query == "" {
+		logger.Error("missing search query")
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "query parameter 'q' is required",
+		})
+	}
+
+	chatNumbers, err := parseChatNumbers(ctx.Query("chats"))
+	if err != nil {
+		logger.Error("invalid chats filter: %v", err)
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	page, err := strconv.Atoi(pageStr)
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	perPage, err := strconv.Atoi(perPageStr)
+	if err != nil || perPage < 1 {
+		perPage = 20
+	}
+	if perPage > 100 {
+		perPage = 100
+	}
+
+	if page*perPage > maxResultWindow {
+		logger.Error("page %d is beyond the result window", page)
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "page is too deep, narrow the search with filters or chats instead",
+		})
+	}
+
+	perChat, err := strconv.Atoi(ctx.Query("per_chat", "3"))
+	if err != nil || perChat < 1 {
+		perChat = 3
+	}
+	if perChat > 10 {
+		perChat = 10
+	}
+
+	sortMode, highlight, err := parseResultOptions(ctx, search.SortRelevance)
+	if err != nil {
+		logger.Error("invalid search options: %v", err)
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	filters, err := parseFilters(ctx)
+	if err != nil {
+		logger.Error("invalid search filters: %v", err)
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	opts := search.ApplicationSearchOptions{
+		ChatNumbers: chatNumbers,
+		Collapse:    ctx.QueryBool("collapse", false),
+		PerChat:     perChat,
+		Sort:        sortMode,
+		Filters:     filters,
+		Highlight:   highlight,
+	}
+
+	logger.Info("searching application messages: app=%s, chats=%v, collapse=%t, query=%s, page=%d, per_page=%d",
+		appToken, chatNumbers, opts.Collapse, query, page, perPage)
+
+	result, err := s.SearchApplicationMessages(appToken, query, opts, page, perPage)
+	var queryErr *search.QueryError
+	if errors.As(err, &queryErr) {
+		logger.Error("rejected search query: %v", err)
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": queryErr.Error(),
+		})
+	}
+	var unavailable *search.UnavailableError
+	if errors.As(err, &unavailable) {
+		logger.Error("search unavailable: %v", err)
+		return searchUnavailable(ctx, unavailable)
+	}
+	if err != nil {
+		logger.Error("failed to search application messages: %v", err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to search messages",
+		})
+	}
+
+	if opts.Collapse {
+		// When collapsed, pages are made of chats rather than messages
+		totalPages := (result.TotalChats + perPage - 1) / perPage
+		logger.Info("found %d matching messages across %d chats", result.Total, result.TotalChats)
+		return ctx.Status(fiber.StatusOK).JSON(fiber.Map{
+			"data": result.Chats,
+			"meta": fiber.Map{
+				"sort":        sortMode,
+				"page":        page,
+				"per_page":    perPage,
+				"total":       result.Total,
+				"total_chats": result.TotalChats,
+				"total_pages": totalPages,
+			},
+		})
+	}
 
-	logger.Info("found %d messages matching query (total: %d)", len(messages), total)
+	totalPages := (result.Total + perPage - 1) / perPage
+	logger.Info("found %d messages matching query (total: %d)", len(result.Messages), result.Total)
 
 	return ctx.Status(fiber.StatusOK).JSON(fiber.Map{
-		"data": messages,
+		"data": result.Messages,
 		"meta": fiber.Map{
+			"sort":        sortMode,
 			"page":        page,
 			"per_page":    perPage,
-			"total":       total,
+			"total":       result.Total,
 			"total_pages": totalPages,
 		},
 	})
 }
+
+// bypassSearchCache reports whether the client asked for a fresh search with
+// X-Search-Cache: bypass or Cache-Control: no-cache
+func bypassSearchCache(ctx *fiber.Ctx) bool {
+	if strings.EqualFold(ctx.Get("X-Search-Cache"), "bypass") {
+		return true
+	}
+	return strings.Contains(strings.ToLower(ctx.Get(fiber.HeaderCacheControl)), "no-cache")
+}
+
+// searchUnavailable answers while the Elasticsearch circuit breaker is open
+func searchUnavailable(ctx *fiber.Ctx, unavailable *search.UnavailableError) error {
+	ctx.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(unavailable.RetryAfter.Seconds()))))
+	return ctx.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+		"error": "search is temporarily unavailable",
+	})
+}
+
+// parseFilters reads the optional created_from/created_to and
+// number_from/number_to ranges. Dates are RFC 3339 or plain YYYY-MM-DD, a
+// plain created_to covers the whole day.
+func parseFilters(ctx *fiber.Ctx) (search.Filters, error) {
+	var filters search.Filters
+	var err error
+
+	if raw := ctx.Query("created_from"); raw != "" {
+		if filters.CreatedFrom, _, err = parseFilterTime(raw); err != nil {
+			return filters, fmt.Errorf("created_from must be an RFC 3339 time or YYYY-MM-DD date")
+		}
+	}
+	if raw := ctx.Query("created_to"); raw != "" {
+		var dateOnly bool
+		if filters.CreatedTo, dateOnly, err = parseFilterTime(raw); err != nil {
+			return filters, fmt.Errorf("created_to must be an RFC 3339 time or YYYY-MM-DD date")
+		}
+		if dateOnly {
+			filters.CreatedTo = filters.CreatedTo.Add(24*time.Hour - time.Second)
+		}
+	}
+	if !filters.CreatedFrom.IsZero() && !filters.CreatedTo.IsZero() && filters.CreatedFrom.After(filters.CreatedTo) {
+		return filters, fmt.Errorf("created_from must not be after created_to")
+	}
+
+	if raw := ctx.Query("number_from"); raw != "" {
+		if filters.NumberFrom, err = strconv.Atoi(raw); err != nil || filters.NumberFrom < 1 {
+			return filters, fmt.Errorf("number_from must be a positive integer")
+		}
+	}
+	if raw := ctx.Query("number_to"); raw != "" {
+		if filters.NumberTo, err = strconv.Atoi(raw); err != nil || filters.NumberTo < 1 {
+			return filters, fmt.Errorf("number_to must be a positive integer")
+		}
+	}
+	if filters.NumberFrom > 0 && filters.NumberTo > 0 && filters.NumberFrom > filters.NumberTo {
+		return filters, fmt.Errorf("number_from must not be greater than number_to")
+	}
+
+	return filters, nil
+}
+
+func parseFilterTime(raw string) (time.Time, bool, error) {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, false, nil
+	}
+	t, err := time.Parse(time.DateOnly, raw)
+	return t, true, err
+}
+
+// parseResultOptions reads how results are ordered and highlighted
+func parseResultOptions(ctx *fiber.Ctx, defaultSort search.SortMode) (search.SortMode, *search.HighlightOptions, error) {
+	sortMode, err := search.ParseSortMode(ctx.Query("sort"), defaultSort)
+	if err != nil {
+		return "", nil, err
+	}
+
+	highlight, err := parseHighlight(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return sortMode, highlight, nil
+}
+
+// parseHighlight reads the highlight options. Highlights are on by default and
+// wrapped in <em> unless pre_tag/post_tag say otherwise.
+func parseHighlight(ctx *fiber.Ctx) (*search.HighlightOptions, error) {
+	if !ctx.QueryBool("highlight", true) {
+		return nil, nil
+	}
+
+	highlight := &search.HighlightOptions{
+		PreTag:  ctx.Query("pre_tag", "<em>"),
+		PostTag: ctx.Query("post_tag", "</em>"),
+	}
+	if len(highlight.PreTag) > maxHighlightTag || len(highlight.PostTag) > maxHighlightTag {
+		return nil, fmt.Errorf("pre_tag and post_tag must be at most %d characters", maxHighlightTag)
+	}
+
+	return highlight, nil
+}
+
+// parseChatNumbers reads a comma separated list of chat numbers
+func parseChatNumbers(raw string) ([]int, error) {
+	if raw == "" {
+		return nil, nil
+	}
+
+	parts := strings.Split(raw, ",")
+	if len(parts) > maxChatFilter {
+		return nil, fmt.Errorf("at most %d chats can be searched at once", maxChatFilter)
+	}
+
+	chatNumbers := make([]int, 0, len(parts))
+	for _, part := range parts {
+		chatNumber, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || chatNumber < 1 {
+			return nil, fmt.Errorf("chats must be a comma separated list of chat numbers")
+		}
+		chatNumbers = append(chatNumbers, chatNumber)
+	}
+
+	return chatNumbers, nil
+}
+
+// recordUnpublished notes a number lost to a failed publish, it stays a gap
+// in the numbering since numbers are never handed out twice
+func (s *Service) recordUnpublished(logger *logging.Logger, appToken string, ref string) {
+	if err := s.repo.RecordUnpublished(appToken, ref); err != nil {
+		logger.Error("failed to record unpublished number %s: %v", ref, err)
+	}
+}
+
+// traceHeaders collects the request identifiers that travel with queued messages
+func traceHeaders(ctx *fiber.Ctx) map[string]string {
+	headers := map[string]string{}
+	if trace, ok := ctx.Locals("trace").(string); ok && trace != "" {
+		headers[contract.HeaderTraceID] = trace
+	}
+	if requestID, ok := ctx.Locals("requestid").(string); ok && requestID != "" {
+		headers[contract.HeaderRequestID] = requestID
+	}
+	return headers
+}
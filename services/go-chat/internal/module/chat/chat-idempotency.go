@@ -0,0 +1,125 @@
+package chat
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"go-chat/internal/logging"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	IdempotencyKeyHeader      = "Idempotency-Key"
+	IdempotentReplayedHeader  = "Idempotent-Replayed"
+	maxIdempotencyKeyLength   = 255
+	idempotencyKeyLocalsField = "idempotency_key"
+)
+
+// IdempotencyMiddleware makes create endpoints safe to retry. The first request
+// carrying an Idempotency-Key is processed normally and its response stored,
+// later requests with the same key and body get that response replayed.
+func (s *Service) IdempotencyMiddleware(ctx *fiber.Ctx) error {
+	key := ctx.Get(IdempotencyKeyHeader)
+	if key == "" {
+		return ctx.Next()
+	}
+
+	logger := ctx.Locals("logger").(*logging.Logger)
+	appToken := ctx.Params("token")
+
+	if len(key) > maxIdempotencyKeyLength {
+		logger.Error("idempotency key too long: %d bytes", len(key))
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "idempotency key must be at most 255 characters",
+		})
+	}
+
+	fingerprint := requestFingerprint(ctx)
+	record, reserved, err := s.repo.ReserveIdempotencyKey(appToken, key, fingerprint)
+	if err != nil {
+		logger.Error("failed to reserve idempotency key: %v", err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to check idempotency key",
+		})
+	}
+
+	if !reserved {
+		return replayIdempotentResponse(ctx, logger, key, fingerprint, record)
+	}
+
+	ctx.Locals(idempotencyKeyLocalsField, key)
+	if err := ctx.Next(); err != nil {
+		s.releaseIdempotencyKey(logger, appToken, key)
+		return err
+	}
+
+	status := ctx.Response().StatusCode()
+	if status >= fiber.StatusBadRequest {
+		// Failed requests are not remembered so the client can retry them
+		s.releaseIdempotencyKey(logger, appToken, key)
+		return nil
+	}
+
+	body := ctx.Response().Body()
+	var numbered struct {
+		Number int64 `json:"number"`
+	}
+	_ = json.Unmarshal(body, &numbered)
+
+	record = &IdempotencyRecord{
+		Fingerprint: fingerprint,
+		Status:      status,
+		Number:      numbered.Number,
+		Response:    append(json.RawMessage(nil), body...),
+	}
+	if err := s.repo.SaveIdempotencyRecord(appToken, key, record); err != nil {
+		logger.Error("failed to store idempotent response for key %s: %v", key, err)
+	}
+
+	return nil
+}
+
+func replayIdempotentResponse(ctx *fiber.Ctx, logger *logging.Logger, key string, fingerprint string, record *IdempotencyRecord) error {
+	if record.Fingerprint != fingerprint {
+		logger.Error("idempotency key %s reused with a different request", key)
+		return ctx.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "idempotency key was already used with a different request",
+		})
+	}
+
+	if record.Status == 0 {
+		logger.Error("idempotency key %s is still being processed", key)
+		return ctx.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "a request with this idempotency key is still in progress",
+		})
+	}
+
+	logger.Info("replaying response for idempotency key %s: number=%d", key, record.Number)
+	ctx.Set(IdempotentReplayedHeader, "true")
+	ctx.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	return ctx.Status(record.Status).Send(record.Response)
+}
+
+func (s *Service) releaseIdempotencyKey(logger *logging.Logger, appToken string, key string) {
+	if err := s.repo.ReleaseIdempotencyKey(appToken, key); err != nil {
+		logger.Error("failed to release idempotency key %s: %v", key, err)
+	}
+}
+
+// requestFingerprint identifies a request by its method, path and body
+func requestFingerprint(ctx *fiber.Ctx) string {
+	hash := sha256.New()
+	hash.Write([]byte(ctx.Method()))
+	hash.Write([]byte{0})
+	hash.Write([]byte(ctx.Path()))
+	hash.Write([]byte{0})
+	hash.Write(ctx.Body())
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// idempotencyKeyFromCtx returns the key reserved by IdempotencyMiddleware, if any
+func idempotencyKeyFromCtx(ctx *fiber.Ctx) string {
+	key, _ := ctx.Locals(idempotencyKeyLocalsField).(string)
+	return key
+}
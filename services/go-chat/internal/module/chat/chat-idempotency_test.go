@@ -0,0 +1,163 @@
+package chat
+
+import (
+	"encoding/json"
+	"go-chat/internal/config"
+	"go-chat/internal/logging"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	testIdempotencyTTL = 24 * time.Hour
+	testPendingTTL     = 30 * time.Second
+)
+
+func newIdempotencyTestApp(t *testing.T, handler fiber.Handler) (*fiber.App, *miniredis.Miniredis) {
+	t.Helper()
+
+	m := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+
+	s := &Service{repo: &Repo{redisClient: rdb, idempotencyTTL: testIdempotencyTTL, pendingTTL: testPendingTTL}}
+	logger := logging.NewLogger(&config.Config{AppName: "chat-test", LogPath: t.TempDir()})
+
+	app := fiber.New()
+	app.Use(func(ctx *fiber.Ctx) error {
+		ctx.Locals("logger", logger)
+		return ctx.Next()
+	})
+	app.Post("/applications/:token/chats", s.IdempotencyMiddleware, handler)
+	return app, m
+}
+
+func post(t *testing.T, app *fiber.App, key, body string) (int, string, string) {
+	t.Helper()
+
+	req := httptest.NewRequest("POST", "/applications/app/chats", strings.NewReader(body))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	if key != "" {
+		req.Header.Set(IdempotencyKeyHeader, key)
+	}
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	defer resp.Body.Close()
+	raw, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(raw), resp.Header.Get(IdempotentReplayedHeader)
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	calls := 0
+	app, m := newIdempotencyTestApp(t, func(ctx *fiber.Ctx) error {
+		calls++
+		return ctx.Status(fiber.StatusCreated).JSON(fiber.Map{"number": calls})
+	})
+
+	status, body, replayed := post(t, app, "k1", `{"name":"a"}`)
+	if status != fiber.StatusCreated || replayed != "" {
+		t.Fatalf("first request = %d replayed=%q, want 201 not replayed", status, replayed)
+	}
+	if ttl := m.TTL(idempotencyKey("app", "k1")); ttl != testIdempotencyTTL {
+		t.Errorf("TTL of a saved response = %s, want %s", ttl, testIdempotencyTTL)
+	}
+
+	status, again, replayed := post(t, app, "k1", `{"name":"a"}`)
+	if status != fiber.StatusCreated || again != body || replayed != "true" {
+		t.Errorf("retry = %d %s replayed=%q, want 201 %s replayed", status, again, replayed, body)
+	}
+	if calls != 1 {
+		t.Errorf("handler ran %d times, want once", calls)
+	}
+
+	if status, _, _ := post(t, app, "k1", `{"name":"b"}`); status != fiber.StatusUnprocessableEntity {
+		t.Errorf("key reused with another body = %d, want 422", status)
+	}
+	if status, _, _ := post(t, app, "", `{"name":"a"}`); status != fiber.StatusCreated || calls != 2 {
+		t.Errorf("request without a key = %d after %d calls, want 201 handled", status, calls)
+	}
+}
+
+func TestIdempotencyPendingReservation(t *testing.T) {
+	var m *miniredis.Miniredis
+	var pendingTTL time.Duration
+	var app *fiber.App
+	app, m = newIdempotencyTestApp(t, func(ctx *fiber.Ctx) error {
+		pendingTTL = m.TTL(idempotencyKey("app", "k1"))
+
+		// A retry while this request is running
+		status, _, _ := post(t, app, "k1", `{}`)
+		if status != fiber.StatusConflict {
+			t.Errorf("retry while pending = %d, want 409", status)
+		}
+		return ctx.Status(fiber.StatusCreated).JSON(fiber.Map{"number": 1})
+	})
+
+	post(t, app, "k1", `{}`)
+	if pendingTTL != testPendingTTL {
+		t.Errorf("TTL of a pending reservation = %s, want %s", pendingTTL, testPendingTTL)
+	}
+}
+
+func TestIdempotencyCrashedReservationExpires(t *testing.T) {
+	app, m := newIdempotencyTestApp(t, func(ctx *fiber.Ctx) error {
+		return ctx.Status(fiber.StatusCreated).JSON(fiber.Map{"number": 7})
+	})
+
+	// An instance reserved the key and died before answering
+	pending, _ := json.Marshal(&IdempotencyRecord{Fingerprint: "whatever"})
+	m.Set(idempotencyKey("app", "k1"), string(pending))
+	m.SetTTL(idempotencyKey("app", "k1"), testPendingTTL)
+
+	if status, _, _ := post(t, app, "k1", `{}`); status != fiber.StatusUnprocessableEntity {
+		t.Fatalf("request during the reservation = %d, want 422", status)
+	}
+
+	m.FastForward(testPendingTTL)
+
+	if status, _, _ := post(t, app, "k1", `{}`); status != fiber.StatusCreated {
+		t.Errorf("request after the reservation expired = %d, want 201", status)
+	}
+}
+
+func TestIdempotencyFailuresAreNotRemembered(t *testing.T) {
+	fail := true
+	app, m := newIdempotencyTestApp(t, func(ctx *fiber.Ctx) error {
+		if fail {
+			return ctx.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "down"})
+		}
+		return ctx.Status(fiber.StatusCreated).JSON(fiber.Map{"number": 1})
+	})
+
+	if status, _, _ := post(t, app, "k1", `{}`); status != fiber.StatusServiceUnavailable {
+		t.Fatalf("failing request = %d, want 503", status)
+	}
+	if m.Exists(idempotencyKey("app", "k1")) {
+		t.Fatal("key of a failed request is still reserved")
+	}
+
+	fail = false
+	if status, _, replayed := post(t, app, "k1", `{}`); status != fiber.StatusCreated || replayed != "" {
+		t.Errorf("retry after a failure = %d replayed=%q, want 201 handled", status, replayed)
+	}
+}
+
+func TestIdempotencyKeyTooLong(t *testing.T) {
+	app, _ := newIdempotencyTestApp(t, func(ctx *fiber.Ctx) error {
+		t.Error("handler ran")
+		return nil
+	})
+
+	if status, _, _ := post(t, app, strings.Repeat("k", maxIdempotencyKeyLength+1), `{}`); status != fiber.StatusBadRequest {
+		t.Errorf("overlong key = %d, want 400", status)
+	}
+}
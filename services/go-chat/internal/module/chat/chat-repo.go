@@ -2,30 +2,52 @@ package chat
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
 	"fmt"
+	"go-chat/internal/config"
+	"go-chat/internal/contract"
 	"go-chat/internal/database"
+	"go-chat/internal/model"
+	"go-chat/internal/search"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-redis/redis/v8"
 )
 
 type Repo struct {
-	redisClient *redis.Client
+	redisClient    redis.UniversalClient
+	mysqlClient    *sql.DB
+	idempotencyTTL time.Duration
+	pendingTTL     time.Duration
 }
 
-func NewRepo(db *database.Database) *Repo {
+// IdempotencyRecord is what is remembered for an Idempotency-Key. A zero
+// Status means the original request is still being processed.
+type IdempotencyRecord struct {
+	Fingerprint string          `json:"fingerprint"`
+	Status      int             `json:"status"`
+	Number      int64           `json:"number,omitempty"`
+	Response    json.RawMessage `json:"response,omitempty"`
+}
+
+func NewRepo(db *database.Database, cfg *config.Config) *Repo {
 	return &Repo{
-		redisClient: db.RedisDB,
+		redisClient:    db.RedisDB,
+		mysqlClient:    db.MySqlDB,
+		idempotencyTTL: cfg.IdempotencyTTL,
+		pendingTTL:     cfg.IdempotencyPendingTTL,
 	}
 }
 
 func (r *Repo) IncrementChatCounter(appToken string) (int64, error) {
-	key := fmt.Sprintf("app:%s:chats_count", appToken)
-	return r.redisClient.Incr(context.Background(), key).Result()
+	return r.redisClient.Incr(context.Background(), contract.ChatCounter(appToken)).Result()
 }
 
 func (r *Repo) IncrementMessageCounter(appToken string, chatNumber int) (int64, error) {
-	key := fmt.Sprintf("app:%s:chat:%d:messages_count", appToken, chatNumber)
-	return r.redisClient.Incr(context.Background(), key).Result()
+	return r.redisClient.Incr(context.Background(), contract.MessageCounter(appToken, chatNumber)).Result()
 }
 
 func (r *Repo) CreateMessage(appToken string, chatNumber int, content string) error {
@@ -34,7 +56,7 @@ func (r *Repo) CreateMessage(appToken string, chatNumber int, content string) er
 		return err
 	}
 
-	key := fmt.Sprintf("app:%s:chat:%d:messages", appToken, chatNumber)
+	key := contract.ChatMessages(appToken, chatNumber)
 	messageData := map[string]interface{}{
 		"id":      messageID,
 		"content": content,
@@ -42,3 +64,246 @@ func (r *Repo) CreateMessage(appToken string, chatNumber int, content string) er
 
 	return r.redisClient.HSet(context.Background(), key, fmt.Sprintf("%d", messageID), messageData).Err()
 }
+
+func idempotencyKey(appToken string, key string) string {
+	return fmt.Sprintf("idempotency:%s:%s", appToken, key)
+}
+
+// ReserveIdempotencyKey claims the key for a new request. The reservation
+// only lasts the pending TTL, SaveIdempotencyRecord keeps the key for the
+// full TTL once there is a response. When the key is already taken the
+// existing record is returned instead.
+func (r *Repo) ReserveIdempotencyKey(appToken string, key string, fingerprint string) (*IdempotencyRecord, bool, error) {
+	ctx := context.Background()
+	pending, err := json.Marshal(&IdempotencyRecord{Fingerprint: fingerprint})
+	if err != nil {
+		return nil, false, err
+	}
+
+	redisKey := idempotencyKey(appToken, key)
+	reserved, err := r.redisClient.SetNX(ctx, redisKey, pending, r.pendingTTL).Result()
+	if err != nil {
+		return nil, false, err
+	}
+	if reserved {
+		return nil, true, nil
+	}
+
+	raw, err := r.redisClient.Get(ctx, redisKey).Bytes()
+	if err == redis.Nil {
+		// Expired between SETNX and GET, try again
+		return r.ReserveIdempotencyKey(appToken, key, fingerprint)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	var record IdempotencyRecord
+	if err := json.Unmarshal(raw, &record); err != nil {
+		return nil, false, err
+	}
+	return &record, false, nil
+}
+
+func (r *Repo) SaveIdempotencyRecord(appToken string, key string, record *IdempotencyRecord) error {
+	raw, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return r.redisClient.Set(context.Background(), idempotencyKey(appToken, key), raw, r.idempotencyTTL).Err()
+}
+
+func (r *Repo) ReleaseIdempotencyKey(appToken string, key string) error {
+	return r.redisClient.Del(context.Background(), idempotencyKey(appToken, key)).Err()
+}
+
+// ApplicationExists looks the application up in MySQL, where Rails creates it
+func (r *Repo) ApplicationExists(appToken string) (bool, error) {
+	var exists bool
+	err := r.mysqlClient.QueryRow(`SELECT EXISTS(SELECT 1 FROM applications WHERE token = ?)`, appToken).Scan(&exists)
+	return exists, err
+}
+
+// ChatExists tells whether a chat number has been handed out. Chats whose
+// persistence is still queued only exist in the Redis counter.
+func (r *Repo) ChatExists(appToken string, chatNumber int) (bool, error) {
+	allocated, err := r.redisClient.Get(context.Background(), contract.ChatCounter(appToken)).Int()
+	if err != nil && err != redis.Nil {
+		return false, err
+	}
+	if chatNumber <= allocated {
+		return true, nil
+	}
+
+	var exists bool
+	err = r.mysqlClient.QueryRow(`SELECT EXISTS(SELECT 1 FROM chats
+		INNER JOIN applications ON applications.id = chats.application_id
+		WHERE applications.token = ? AND chats.number = ?)`, appToken, chatNumber).Scan(&exists)
+	return exists, err
+}
+
+// Tombstone marks an application or chat as deleted. The mark is kept for
+// good, so numbers of a deleted chat are never handed out again.
+func (r *Repo) Tombstone(key string) error {
+	return r.redisClient.Set(context.Background(), key, time.Now().UTC().Format(time.RFC3339), 0).Err()
+}
+
+// IsDeleted checks the tombstones of an application and, when chatNumber is
+// set, of one of its chats
+func (r *Repo) IsDeleted(appToken string, chatNumber int) (bool, error) {
+	keys := []string{contract.ApplicationTombstone(appToken)}
+	if chatNumber > 0 {
+		keys = append(keys, contract.ChatTombstone(appToken, chatNumber))
+	}
+	found, err := r.redisClient.Exists(context.Background(), keys...).Result()
+	return found > 0, err
+}
+
+// RecordUnpublished remembers a number that was handed out but never
+// published, go-worker's gap detection reports it as such
+func (r *Repo) RecordUnpublished(appToken string, ref string) error {
+	return r.redisClient.SAdd(context.Background(), contract.UnpublishedNumbers(appToken), ref).Err()
+}
+
+// DeferralStats reads the counters go-worker keeps on deliveries that
+// arrived out of order
+func (r *Repo) DeferralStats() (map[string]int64, error) {
+	raw, err := r.redisClient.HGetAll(context.Background(), contract.DeferralMetricsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make(map[string]int64, len(raw))
+	for field, value := range raw {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid counter %s: %w", field, err)
+		}
+		stats[field] = n
+	}
+	return stats, nil
+}
+
+// FindMessagesAfter returns persisted messages of a chat numbered above
+// afterNumber, in ascending order
+func (r *Repo) FindMessagesAfter(appToken string, chatNumber int, afterNumber int, limit int) ([]*model.Message, error) {
+	query := `SELECT applications.token, applications.name, chats.number, messages.number, messages.content, messages.created_at
+		FROM messages
+		INNER JOIN chats ON chats.id = messages.chat_id
+		INNER JOIN applications ON applications.id = chats.application_id
+		WHERE applications.token = ? AND chats.number = ? AND messages.number > ?
+		ORDER BY messages.number ASC
+		LIMIT ?`
+
+	rows, err := r.mysqlClient.Query(query, appToken, chatNumber, afterNumber, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := make([]*model.Message, 0, limit)
+	for rows.Next() {
+		var msg model.Message
+		if err := rows.Scan(
+			&msg.ApplicationToken,
+			&msg.ApplicationName,
+			&msg.ChatNumber,
+			&msg.MessageNumber,
+			&msg.Content,
+			&msg.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		messages = append(messages, &msg)
+	}
+
+	return messages, rows.Err()
+}
+
+// fallbackMaxMatches bounds how far degraded searches scan and count
+const fallbackMaxMatches = 1000
+
+// SearchMessagesFallback is the degraded search used while Elasticsearch is
+// unavailable: a LIKE scan of one chat's messages requiring one term of every
+// group and none of the excluded ones. Matches are counted up to
+// fallbackMaxMatches.
+func (r *Repo) SearchMessagesFallback(appToken string, chatNumber int, groups [][]string, excluded []string, filters search.Filters, newestFirst bool, offset, limit int) ([]*model.Message, int, error) {
+	where := []string{"applications.token = ?", "chats.number = ?"}
+	args := []interface{}{appToken, chatNumber}
+
+	for _, group := range groups {
+		alternatives := make([]string, len(group))
+		for i, term := range group {
+			alternatives[i] = `messages.content LIKE ? ESCAPE '\\'`
+			args = append(args, "%"+likeEscaper.Replace(term)+"%")
+		}
+		where = append(where, "("+strings.Join(alternatives, " OR ")+")")
+	}
+	for _, term := range excluded {
+		where = append(where, `messages.content NOT LIKE ? ESCAPE '\\'`)
+		args = append(args, "%"+likeEscaper.Replace(term)+"%")
+	}
+	if !filters.CreatedFrom.IsZero() {
+		where = append(where, "messages.created_at >= ?")
+		args = append(args, filters.CreatedFrom)
+	}
+	if !filters.CreatedTo.IsZero() {
+		where = append(where, "messages.created_at <= ?")
+		args = append(args, filters.CreatedTo)
+	}
+	if filters.NumberFrom > 0 {
+		where = append(where, "messages.number >= ?")
+		args = append(args, filters.NumberFrom)
+	}
+	if filters.NumberTo > 0 {
+		where = append(where, "messages.number <= ?")
+		args = append(args, filters.NumberTo)
+	}
+
+	from := `FROM messages
+		INNER JOIN chats ON chats.id = messages.chat_id
+		INNER JOIN applications ON applications.id = chats.application_id
+		WHERE ` + strings.Join(where, " AND ")
+
+	var total int
+	countQuery := "SELECT COUNT(*) FROM (SELECT 1 " + from + " LIMIT ?) matches"
+	if err := r.mysqlClient.QueryRow(countQuery, append(args, fallbackMaxMatches)...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	order := "ASC"
+	if newestFirst {
+		order = "DESC"
+	}
+	query := `SELECT applications.token, applications.name, chats.number, messages.number, messages.content, messages.created_at
+		` + from + `
+		ORDER BY messages.number ` + order + `
+		LIMIT ? OFFSET ?`
+
+	rows, err := r.mysqlClient.Query(query, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	messages := make([]*model.Message, 0, limit)
+	for rows.Next() {
+		var msg model.Message
+		if err := rows.Scan(
+			&msg.ApplicationToken,
+			&msg.ApplicationName,
+			&msg.ChatNumber,
+			&msg.MessageNumber,
+			&msg.Content,
+			&msg.CreatedAt,
+		); err != nil {
+			return nil, 0, err
+		}
+		messages = append(messages, &msg)
+	}
+
+	return messages, total, rows.Err()
+}
+
+// likeEscaper escapes LIKE wildcards with the backslash named in ESCAPE
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
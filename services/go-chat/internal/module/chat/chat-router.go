@@ -1,15 +1,34 @@
 package chat
 
-import "github.com/gofiber/fiber/v2"
+import (
+	"go-chat/internal/auth"
+	"go-chat/internal/ratelimit"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+)
 
 func (s *Service) GetRouter() *fiber.App {
 	route := fiber.New()
 
 	apps := route.Group("/applications")
 	apps = apps.Group("/:token")
-	apps.Post("/chats", s.CreateChatHandler)
-	apps.Post("/chats/:number/messages", s.CreateMessageHandler)
-	apps.Get("/chats/:number/messages/search", s.SearchMessagesHandler)
+	canDelete := s.auth.Require(auth.ScopeFull)
+	canWrite := s.auth.Require(auth.ScopeWrite)
+	canSearch := s.auth.Require(auth.ScopeSearch)
+	canStream := s.auth.RequireStream(auth.ScopeSearch)
+	createLimit := s.limiter.PerApplication(ratelimit.ScopeCreate)
+	searchLimit := s.limiter.PerApplication(ratelimit.ScopeSearch)
+
+	apps.Delete("", canDelete, s.DeleteApplicationHandler)
+	apps.Post("/chats", canWrite, createLimit, s.RejectDeleted, s.IdempotencyMiddleware, s.CreateChatHandler)
+	apps.Delete("/chats/:number", canDelete, s.DeleteChatHandler)
+	apps.Post("/chats/:number/messages", canWrite, createLimit, s.RejectDeleted, s.IdempotencyMiddleware, s.CreateMessageHandler)
+	apps.Get("/messages/search", canSearch, searchLimit, s.SearchApplicationMessagesHandler)
+	apps.Get("/chats/:number/messages/search", canSearch, searchLimit, s.SearchMessagesHandler)
+	apps.Get("/chats/:number/messages/suggest", canSearch, searchLimit, s.SuggestMessagesHandler)
+	apps.Get("/chats/:number/stream", canStream, searchLimit, s.StreamMessagesHandler)
+	apps.Get("/chats/:number/ws", canStream, searchLimit, s.StreamUpgradeHandler, websocket.New(s.StreamMessagesWebSocket))
 
 	return route
 }
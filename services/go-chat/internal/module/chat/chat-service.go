@@ -1,22 +1,53 @@
 package chat
 
 import (
-	"go-chat/internal/elasticsearch"
+	"errors"
+	"go-chat/internal/auth"
+	"go-chat/internal/config"
+	"go-chat/internal/contract"
 	"go-chat/internal/model"
+	"go-chat/internal/numbering"
 	"go-chat/internal/queue"
+	"go-chat/internal/ratelimit"
+	"go-chat/internal/search"
+	"go-chat/internal/stream"
 )
 
 type Service struct {
-	repo  *Repo
-	queue *queue.AMQP
-	es    *elasticsearch.Client
+	repo    *Repo
+	queue   queue.Publisher
+	search  search.Backend
+	limiter *ratelimit.Limiter
+	auth    *auth.Authenticator
+	hub     *stream.Hub
+	cache   *search.Cache
+	numbers *numbering.Allocator
+
+	searchFallback bool
 }
 
-func NewChatService(repo *Repo, amqp *queue.AMQP, es *elasticsearch.Client) *Service {
+func NewChatService(
+	repo *Repo,
+	publisher queue.Publisher,
+	backend search.Backend,
+	limiter *ratelimit.Limiter,
+	authenticator *auth.Authenticator,
+	hub *stream.Hub,
+	cache *search.Cache,
+	numbers *numbering.Allocator,
+	cfg *config.Config,
+) *Service {
 	return &Service{
-		repo:  repo,
-		queue: amqp,
-		es:    es,
+		repo:    repo,
+		queue:   publisher,
+		search:  backend,
+		limiter: limiter,
+		auth:    authenticator,
+		hub:     hub,
+		cache:   cache,
+		numbers: numbers,
+
+		searchFallback: cfg.SearchFallback,
 	}
 }
 
@@ -28,14 +59,124 @@ func (s *Service) IncrementChatCounter(appToken string) (int64, error) {
 	return s.repo.IncrementChatCounter(appToken)
 }
 
+// IncrementMessageCounter goes through the allocator, which may hand the
+// number out of a leased block. Chat numbers are never leased: go-worker and
+// ChatExists take every number up to contract.ChatCounter as handed out.
 func (s *Service) IncrementMessageCounter(appToken string, chatNumber int) (int64, error) {
-	return s.repo.IncrementMessageCounter(appToken, chatNumber)
+	return s.numbers.NextMessageNumber(appToken, chatNumber)
+}
+
+// CloseStreams ends the open SSE and WebSocket streams, which otherwise only
+// finish when their client leaves
+func (s *Service) CloseStreams() {
+	s.hub.Close()
+}
+
+// Stop gives back the message numbers leased but not handed out
+func (s *Service) Stop() {
+	s.numbers.Stop()
+}
+
+func (s *Service) DeferralStats() (map[string]int64, error) {
+	return s.repo.DeferralStats()
+}
+
+func (s *Service) QueueMessage(payload contract.Payload, headers map[string]string, queueType queue.QueueType) error {
+	env, err := contract.NewEnvelope(payload, headers)
+	if err != nil {
+		return err
+	}
+	msg, err := queue.NewMessage(env)
+	if err != nil {
+		return err
+	}
+	return s.queue.Publish(queueType, msg)
+}
+
+// SearchMessages answers a chat search from the Redis cache when it can.
+// bypassCache runs the search regardless and refreshes the cached copy.
+func (s *Service) SearchMessages(appToken string, chatNumber int, query string, opts search.ChatSearchOptions, bypassCache bool) (*search.ChatSearchResult, search.CacheStatus, error) {
+	// Cursor pages hold a point in time that a cached copy would outlive
+	if !s.cache.Enabled() || opts.Cursor != "" {
+		result, err := s.searchMessages(appToken, chatNumber, query, opts)
+		return result, search.CacheSkipped, err
+	}
+
+	key := s.cache.Key(appToken, chatNumber, query, opts)
+	if key == "" {
+		result, err := s.searchMessages(appToken, chatNumber, query, opts)
+		return result, search.CacheSkipped, err
+	}
+
+	status := search.CacheMiss
+	if bypassCache {
+		s.cache.Bypassed()
+		status = search.CacheBypass
+	} else if cached, ok := s.cache.Get(key); ok {
+		return cached, search.CacheHit, nil
+	}
+
+	result, err := s.searchMessages(appToken, chatNumber, query, opts)
+	if err == nil && !result.Degraded {
+		s.cache.Set(key, result)
+	}
+	return result, status, err
+}
+
+func (s *Service) searchMessages(appToken string, chatNumber int, query string, opts search.ChatSearchOptions) (*search.ChatSearchResult, error) {
+	result, err := s.search.Search(appToken, chatNumber, query, opts)
+	if err != nil && s.searchFallback && errors.Is(err, search.ErrUnavailable) {
+		if fallback, ok := s.searchMessagesFallback(appToken, chatNumber, query, opts); ok {
+			return fallback, nil
+		}
+	}
+	return result, err
+}
+
+// searchMessagesFallback answers a chat search from MySQL while the breaker is
+// open. Cursors and deep pages are left to fail with the breaker's error.
+func (s *Service) searchMessagesFallback(appToken string, chatNumber int, query string, opts search.ChatSearchOptions) (*search.ChatSearchResult, bool) {
+	if opts.Cursor != "" || opts.Page*opts.PerPage > fallbackMaxMatches {
+		return nil, false
+	}
+
+	parsed, err := search.ParseQuery(query)
+	if err != nil {
+		return nil, false
+	}
+	groups := make([][]string, len(parsed.Groups()))
+	for i, group := range parsed.Groups() {
+		for _, term := range group {
+			groups[i] = append(groups[i], term.Text)
+		}
+	}
+	var excluded []string
+	for _, term := range parsed.Excluded() {
+		excluded = append(excluded, term.Text)
+	}
+
+	messages, total, err := s.repo.SearchMessagesFallback(appToken, chatNumber, groups, excluded, opts.Filters,
+		opts.Sort == search.SortNewest, (opts.Page-1)*opts.PerPage, opts.PerPage)
+	if err != nil {
+		return nil, false
+	}
+
+	hits := make([]*model.MessageHit, len(messages))
+	for i, msg := range messages {
+		hits[i] = &model.MessageHit{Message: msg}
+	}
+
+	return &search.ChatSearchResult{
+		Messages: hits,
+		Total:    total,
+		Degraded: true,
+	}, true
 }
 
-func (s *Service) QueueMessage(payload interface{}, queueType queue.QueueType) error {
-	return s.queue.PublishMessage(payload, queueType)
+func (s *Service) SuggestMessages(appToken string, chatNumber int, prefix string, opts search.SuggestOptions) ([]*model.MessageHit, error) {
+	return s.search.Suggest(appToken, chatNumber, prefix, opts)
 }
 
-func (s *Service) SearchMessages(appToken string, chatNumber int, query string, page int, pageSize int) ([]*model.Message, int, error) {
-	return s.es.Search(appToken, chatNumber, query, page, pageSize)
+func (s *Service) SearchApplicationMessages(appToken string, query string, opts search.ApplicationSearchOptions, page int, pageSize int) (*search.ApplicationSearchResult, error) {
+	return s.search.SearchApplication(appToken, query, opts, page, pageSize)
 }
@@ -0,0 +1,181 @@
+package chat
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"go-chat/internal/search"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// unavailableBackend answers every chat search as an open breaker would
+type unavailableBackend struct {
+	search.Backend
+	err error
+}
+
+func (b *unavailableBackend) Search(appToken string, chatNumber int, query string, opts search.ChatSearchOptions) (*search.ChatSearchResult, error) {
+	return nil, b.err
+}
+
+// fallbackDriver is a database/sql driver that answers the fallback's count
+// query with count and its select with one row per content, remembering the
+// arguments of the last select. A nil fallbackDriver refuses to connect.
+type fallbackDriver struct {
+	mu       sync.Mutex
+	count    int64
+	contents []string
+	args     []driver.Value
+}
+
+var fallbackDrivers sync.Map
+
+func init() {
+	sql.Register("chat-fallback-test", fallbackSQL{})
+}
+
+type fallbackSQL struct{}
+
+func (fallbackSQL) Open(name string) (driver.Conn, error) {
+	d, ok := fallbackDrivers.Load(name)
+	if !ok || d.(*fallbackDriver) == nil {
+		return nil, errors.New("connection refused")
+	}
+	return &fallbackConn{d.(*fallbackDriver)}, nil
+}
+
+type fallbackConn struct{ d *fallbackDriver }
+
+func (c *fallbackConn) Prepare(query string) (driver.Stmt, error) {
+	return &fallbackStmt{d: c.d, count: strings.HasPrefix(query, "SELECT COUNT(*)")}, nil
+}
+func (c *fallbackConn) Close() error              { return nil }
+func (c *fallbackConn) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+type fallbackStmt struct {
+	d     *fallbackDriver
+	count bool
+}
+
+func (s *fallbackStmt) Close() error  { return nil }
+func (s *fallbackStmt) NumInput() int { return -1 }
+func (s *fallbackStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("not supported")
+}
+
+func (s *fallbackStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if s.count {
+		return &fallbackRows{columns: []string{"count"}, rows: [][]driver.Value{{s.d.count}}}, nil
+	}
+
+	s.d.mu.Lock()
+	s.d.args = args
+	s.d.mu.Unlock()
+	rows := &fallbackRows{columns: []string{"token", "name", "chat", "number", "content", "created_at"}}
+	for i, content := range s.d.contents {
+		rows.rows = append(rows.rows, []driver.Value{"app", "App", int64(1), int64(i + 1), content, time.Now()})
+	}
+	return rows, nil
+}
+
+type fallbackRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fallbackRows) Columns() []string { return r.columns }
+func (r *fallbackRows) Close() error      { return nil }
+func (r *fallbackRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func newFallbackTestService(t *testing.T, d *fallbackDriver, enabled bool, backendErr error) *Service {
+	t.Helper()
+
+	fallbackDrivers.Store(t.Name(), d)
+	db, err := sql.Open("chat-fallback-test", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return &Service{
+		repo:           &Repo{mysqlClient: db},
+		search:         &unavailableBackend{err: backendErr},
+		searchFallback: enabled,
+	}
+}
+
+func TestSearchFallback(t *testing.T) {
+	unavailable := &search.UnavailableError{RetryAfter: time.Minute}
+	firstPage := search.ChatSearchOptions{Page: 1, PerPage: 20}
+
+	tests := []struct {
+		name       string
+		enabled    bool
+		backendErr error
+		mysql      bool
+		opts       search.ChatSearchOptions
+		degraded   bool
+	}{
+		{"answered from MySQL", true, unavailable, true, firstPage, true},
+		{"fallback disabled", false, unavailable, true, firstPage, false},
+		{"other search errors", true, errors.New("bad query"), true, firstPage, false},
+		{"cursors", true, unavailable, true, search.ChatSearchOptions{Page: 1, PerPage: 20, Cursor: "abc"}, false},
+		{"past the match cap", true, unavailable, true, search.ChatSearchOptions{Page: 11, PerPage: 100}, false},
+		{"MySQL down too", true, unavailable, false, firstPage, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var d *fallbackDriver
+			if tc.mysql {
+				d = &fallbackDriver{count: 2, contents: []string{"hello there", "hello again"}}
+			}
+			s := newFallbackTestService(t, d, tc.enabled, tc.backendErr)
+
+			result, err := s.searchMessages("app", 1, "hello", tc.opts)
+			if !tc.degraded {
+				if !errors.Is(err, tc.backendErr) {
+					t.Errorf("error = %v, want the search backend's %v", err, tc.backendErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !result.Degraded || result.Total != 2 || len(result.Messages) != 2 {
+				t.Fatalf("result = degraded %v, total %d, %d messages, want a degraded 2 of 2",
+					result.Degraded, result.Total, len(result.Messages))
+			}
+			if got := result.Messages[1].Content; got != "hello again" {
+				t.Errorf("second message = %q, want %q", got, "hello again")
+			}
+		})
+	}
+}
+
+func TestSearchFallbackPages(t *testing.T) {
+	d := &fallbackDriver{count: 1000}
+	s := newFallbackTestService(t, d, true, &search.UnavailableError{RetryAfter: time.Minute})
+
+	if _, err := s.searchMessages("app", 1, "hello", search.ChatSearchOptions{Page: 3, PerPage: 25}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The select ends in LIMIT ? OFFSET ?
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n := len(d.args)
+	if n < 2 || d.args[n-2] != int64(25) || d.args[n-1] != int64(50) {
+		t.Errorf("select args = %v, want it to end with limit 25 and offset 50", d.args)
+	}
+}
@@ -0,0 +1,210 @@
+package chat
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-chat/internal/logging"
+	"go-chat/internal/model"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	streamBackfillBatch = 500
+	streamHeartbeat     = 15 * time.Second
+	streamWriteTimeout  = 5 * time.Second
+)
+
+var errStreamLagging = errors.New("subscriber fell behind, client should resume")
+
+type streamRequest struct {
+	appToken   string
+	chatNumber int
+	// after is the last message number the client has seen, -1 when it only
+	// wants messages persisted from now on
+	after int
+}
+
+func parseStreamRequest(ctx *fiber.Ctx) (*streamRequest, error) {
+	chatNumber, err := strconv.Atoi(ctx.Params("number"))
+	if err != nil {
+		return nil, fmt.Errorf("chat number must be a valid integer")
+	}
+
+	req := &streamRequest{
+		appToken:   ctx.Params("token"),
+		chatNumber: chatNumber,
+		after:      -1,
+	}
+
+	// EventSource resends the last id it saw when it reconnects
+	lastSeen := ctx.Get("Last-Event-ID", ctx.Query("after"))
+	if lastSeen != "" {
+		after, err := strconv.Atoi(lastSeen)
+		if err != nil || after < 0 {
+			return nil, fmt.Errorf("last seen message number must be a non-negative integer")
+		}
+		req.after = after
+	}
+
+	return req, nil
+}
+
+// StreamMessagesHandler pushes persisted messages as Server-Sent Events
+func (s *Service) StreamMessagesHandler(ctx *fiber.Ctx) error {
+	logger := ctx.Locals("logger").(*logging.Logger)
+
+	req, err := parseStreamRequest(ctx)
+	if err != nil {
+		logger.Error("invalid stream request: %v", err)
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	logger.Info("opening event stream: app=%s, chat=%d, after=%d", req.appToken, req.chatNumber, req.after)
+
+	ctx.Set(fiber.HeaderContentType, "text/event-stream")
+	ctx.Set(fiber.HeaderCacheControl, "no-cache")
+	ctx.Set(fiber.HeaderConnection, "keep-alive")
+	ctx.Set("X-Accel-Buffering", "no")
+
+	ctx.Context().SetBodyStreamWriter(fasthttp.StreamWriter(func(w *bufio.Writer) {
+		send := func(msg *model.Message) error {
+			data, err := json.Marshal(msg)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "id: %d\nevent: message\ndata: %s\n\n", msg.MessageNumber, data)
+			return w.Flush()
+		}
+		heartbeat := func() error {
+			fmt.Fprint(w, ": ping\n\n")
+			return w.Flush()
+		}
+
+		if err := heartbeat(); err != nil {
+			return
+		}
+
+		err := s.streamMessages(req, send, heartbeat, nil)
+		logger.Info("event stream closed: app=%s, chat=%d (%v)", req.appToken, req.chatNumber, err)
+	}))
+
+	return nil
+}
+
+// StreamUpgradeHandler validates a WebSocket stream request before upgrading
+func (s *Service) StreamUpgradeHandler(ctx *fiber.Ctx) error {
+	logger := ctx.Locals("logger").(*logging.Logger)
+
+	if !websocket.IsWebSocketUpgrade(ctx) {
+		logger.Error("websocket endpoint called without upgrade")
+		return ctx.Status(fiber.StatusUpgradeRequired).JSON(fiber.Map{
+			"error": "websocket upgrade required",
+		})
+	}
+
+	req, err := parseStreamRequest(ctx)
+	if err != nil {
+		logger.Error("invalid stream request: %v", err)
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	ctx.Locals("stream_request", req)
+	return ctx.Next()
+}
+
+// StreamMessagesWebSocket pushes persisted messages as JSON text frames
+func (s *Service) StreamMessagesWebSocket(conn *websocket.Conn) {
+	logger := conn.Locals("logger").(*logging.Logger)
+	req := conn.Locals("stream_request").(*streamRequest)
+
+	logger.Info("opening websocket stream: app=%s, chat=%d, after=%d", req.appToken, req.chatNumber, req.after)
+
+	// The client never sends anything useful, reading only detects it leaving
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func(msg *model.Message) error {
+		_ = conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		return conn.WriteJSON(msg)
+	}
+	heartbeat := func() error {
+		return conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout))
+	}
+
+	err := s.streamMessages(req, send, heartbeat, done)
+	logger.Info("websocket stream closed: app=%s, chat=%d (%v)", req.appToken, req.chatNumber, err)
+}
+
+// streamMessages subscribes to live messages first, then replays what the
+// client missed from MySQL, then relays live messages until send fails or
+// done is closed. Subscribing before the replay ensures nothing falls in between.
+func (s *Service) streamMessages(req *streamRequest, send func(*model.Message) error, heartbeat func() error, done <-chan struct{}) error {
+	sub := s.hub.Subscribe(req.appToken, req.chatNumber)
+	defer sub.Close()
+
+	replayed := make(map[int]struct{})
+	if req.after >= 0 {
+		last := req.after
+		for {
+			batch, err := s.repo.FindMessagesAfter(req.appToken, req.chatNumber, last, streamBackfillBatch)
+			if err != nil {
+				return err
+			}
+			for _, msg := range batch {
+				if err := send(msg); err != nil {
+					return err
+				}
+				replayed[msg.MessageNumber] = struct{}{}
+				last = msg.MessageNumber
+			}
+			if len(batch) < streamBackfillBatch {
+				break
+			}
+		}
+	}
+
+	ticker := time.NewTicker(streamHeartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case msg, ok := <-sub.C:
+			if !ok {
+				return errStreamLagging
+			}
+			if msg.MessageNumber <= req.after {
+				continue
+			}
+			if _, seen := replayed[msg.MessageNumber]; seen {
+				continue
+			}
+			if err := send(msg); err != nil {
+				return err
+			}
+		case <-ticker.C:
+			if err := heartbeat(); err != nil {
+				return err
+			}
+		case <-done:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package numbering
+
+import (
+	"context"
+	"fmt"
+	"go-chat/internal/config"
+	"go-chat/internal/contract"
+	"go-chat/internal/database"
+	"go-chat/internal/logging"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	ModeStrict = "strict"
+	ModeBlock  = "block"
+)
+
+// Allocator hands out message numbers from the per-chat Redis counters.
+//
+// In strict mode every number is its own INCR, as it always was. In block
+// mode a chat starts the same way, and each time a lease is used up before it
+// expires the next one is twice as large, up to blockSize numbers taken with
+// a single INCRBY. Quiet chats therefore keep leasing one number at a time
+// and only busy chats skip round-trips. Numbers stay unique, but across
+// go-chat instances they are no longer handed out in arrival order.
+//
+// Numbers left in a lease when it expires, or when the allocator stops, are
+// added to contract.ReleasedNumbers so gap detection can tell them from lost
+// ones. A crashed instance releases nothing, its leftovers become lost gaps.
+type Allocator struct {
+	redis     redis.UniversalClient
+	logger    *logging.Logger
+	mode      string
+	blockSize int64
+	leaseTTL  time.Duration
+
+	mu       sync.Mutex
+	leases   map[string]*lease
+	ticker   *time.Ticker
+	stopChan chan struct{}
+	stopOnce sync.Once
+}
+
+// lease is the range of a chat's numbers this instance holds, next to end
+// inclusive. size is what the next INCRBY asks for. A retired lease has been
+// swept from the map and is not used again.
+type lease struct {
+	mu         sync.Mutex
+	appToken   string
+	chatNumber int
+	next       int64
+	end        int64
+	size       int64
+	expiresAt  time.Time
+	retired    bool
+}
+
+func NewAllocator(cfg *config.Config, db *database.Database, logger *logging.Logger) (*Allocator, error) {
+	a := &Allocator{
+		redis:     db.RedisDB,
+		logger:    logger.WithPrefix("NumberAllocator"),
+		mode:      cfg.NumberAllocation,
+		blockSize: int64(cfg.NumberBlockSize),
+		leaseTTL:  cfg.NumberLeaseTTL,
+		leases:    make(map[string]*lease),
+		stopChan:  make(chan struct{}),
+	}
+
+	switch a.mode {
+	case ModeStrict:
+		return a, nil
+	case ModeBlock:
+	default:
+		return nil, fmt.Errorf("unknown NUMBER_ALLOCATION %q", a.mode)
+	}
+	if a.blockSize < 1 {
+		return nil, fmt.Errorf("NUMBER_BLOCK_SIZE must be at least 1, got %d", a.blockSize)
+	}
+	if a.leaseTTL <= 0 {
+		return nil, fmt.Errorf("NUMBER_LEASE_TTL_SECONDS must be positive")
+	}
+
+	a.ticker = time.NewTicker(a.leaseTTL / 2)
+	go a.sweep()
+
+	return a, nil
+}
+
+// NextMessageNumber returns a message number for the chat that no other
+// request, on any instance, will get
+func (a *Allocator) NextMessageNumber(appToken string, chatNumber int) (int64, error) {
+	if a.mode == ModeStrict {
+		return a.redis.Incr(context.Background(), contract.MessageCounter(appToken, chatNumber)).Result()
+	}
+
+	var l *lease
+	for {
+		l = a.leaseFor(appToken, chatNumber)
+		l.mu.Lock()
+		if !l.retired {
+			break
+		}
+		l.mu.Unlock()
+	}
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if l.next <= l.end {
+		if now.Before(l.expiresAt) {
+			number := l.next
+			l.next++
+			return number, nil
+		}
+		// Too late for the rest, gap detection may have given up on them
+		a.release(l)
+		l.size = 1
+	} else if l.end > 0 && now.Before(l.expiresAt) {
+		// Used up in time, the chat is busy enough for a larger lease
+		l.size = min(l.size*2, a.blockSize)
+	}
+
+	end, err := a.redis.IncrBy(context.Background(), contract.MessageCounter(appToken, chatNumber), l.size).Result()
+	if err != nil {
+		return 0, err
+	}
+	l.next = end - l.size + 2
+	l.end = end
+	l.expiresAt = now.Add(a.leaseTTL)
+
+	return end - l.size + 1, nil
+}
+
+func (a *Allocator) leaseFor(appToken string, chatNumber int) *lease {
+	key := fmt.Sprintf("%s:%d", appToken, chatNumber)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	l, ok := a.leases[key]
+	if !ok {
+		// Empty, next past end, so nothing is released before the first INCRBY
+		l = &lease{appToken: appToken, chatNumber: chatNumber, next: 1, size: 1}
+		a.leases[key] = l
+	}
+	return l
+}
+
+// release gives back what is left of a lease, the caller holds its lock
+func (a *Allocator) release(l *lease) {
+	if l.next > l.end {
+		return
+	}
+
+	members := make([]interface{}, 0, l.end-l.next+1)
+	for number := l.next; number <= l.end; number++ {
+		members = append(members, contract.NumberRef(l.chatNumber, int(number)))
+	}
+	if err := a.redis.SAdd(context.Background(), contract.ReleasedNumbers(l.appToken), members...).Err(); err != nil {
+		a.logger.Error("failed to release %d numbers of chat %d of %s: %v", len(members), l.chatNumber, l.appToken, err)
+	} else {
+		a.logger.Info("released %d unused numbers of chat %d of %s", len(members), l.chatNumber, l.appToken)
+	}
+	l.next = l.end + 1
+}
+
+// sweep releases expired leases of chats that went quiet, and forgets them
+func (a *Allocator) sweep() {
+	for {
+		select {
+		case <-a.ticker.C:
+			a.releaseLeases(false)
+		case <-a.stopChan:
+			return
+		}
+	}
+}
+
+func (a *Allocator) releaseLeases(all bool) {
+	now := time.Now()
+
+	var retired []*lease
+	a.mu.Lock()
+	for key, l := range a.leases {
+		l.mu.Lock()
+		if all || !now.Before(l.expiresAt) {
+			l.retired = true
+			delete(a.leases, key)
+			retired = append(retired, l)
+		}
+		l.mu.Unlock()
+	}
+	a.mu.Unlock()
+
+	// Outside the map lock, requests for other chats go on meanwhile
+	for _, l := range retired {
+		l.mu.Lock()
+		a.release(l)
+		l.mu.Unlock()
+	}
+}
+
+// Stop gives back the unused numbers of every lease
+func (a *Allocator) Stop() {
+	a.stopOnce.Do(func() {
+		if a.mode != ModeBlock {
+			return
+		}
+		close(a.stopChan)
+		a.ticker.Stop()
+		a.releaseLeases(true)
+	})
+}
@@ -0,0 +1,261 @@
+package numbering
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"go-chat/internal/config"
+	"go-chat/internal/contract"
+	"go-chat/internal/database"
+	"go-chat/internal/logging"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// Tests run against miniredis. Benchmarks use the Redis at REDIS_URL when
+// it is set, so they measure a real round trip. Every test uses an
+// application token of its own and deletes its keys.
+
+func testRedis(tb testing.TB) redis.UniversalClient {
+	tb.Helper()
+
+	opts := &redis.Options{}
+	if url := os.Getenv("REDIS_URL"); url != "" && isBenchmark(tb) {
+		var err error
+		if opts, err = redis.ParseURL(url); err != nil {
+			tb.Fatalf("REDIS_URL: %v", err)
+		}
+	} else {
+		opts.Addr = miniredis.RunT(tb).Addr()
+	}
+	rdb := redis.NewClient(opts)
+	if err := rdb.Ping(context.Background()).Err(); err != nil {
+		tb.Fatalf("Redis at %s: %v", opts.Addr, err)
+	}
+	tb.Cleanup(func() { rdb.Close() })
+	return rdb
+}
+
+func isBenchmark(tb testing.TB) bool {
+	_, ok := tb.(*testing.B)
+	return ok
+}
+
+func testToken(tb testing.TB, rdb redis.UniversalClient, chats int) string {
+	tb.Helper()
+
+	raw := make([]byte, 8)
+	rand.Read(raw)
+	token := "numbering-test-" + hex.EncodeToString(raw)
+
+	tb.Cleanup(func() {
+		ctx := context.Background()
+		rdb.Del(ctx, contract.ReleasedNumbers(token))
+		for chat := 1; chat <= chats; chat++ {
+			rdb.Del(ctx, contract.MessageCounter(token, chat))
+		}
+	})
+	return token
+}
+
+func newTestAllocator(tb testing.TB, rdb redis.UniversalClient, mode string, blockSize int, leaseTTL time.Duration) *Allocator {
+	tb.Helper()
+
+	cfg := &config.Config{
+		AppName:          "numbering-test",
+		LogPath:          tb.TempDir(),
+		NumberAllocation: mode,
+		NumberBlockSize:  blockSize,
+		NumberLeaseTTL:   leaseTTL,
+	}
+	a, err := NewAllocator(cfg, &database.Database{RedisDB: rdb}, logging.NewLogger(cfg))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(a.Stop)
+	return a
+}
+
+func next(t *testing.T, a *Allocator, token string, chat int) int64 {
+	t.Helper()
+
+	number, err := a.NextMessageNumber(token, chat)
+	if err != nil {
+		t.Fatalf("NextMessageNumber: %v", err)
+	}
+	return number
+}
+
+func counter(t *testing.T, rdb redis.UniversalClient, token string, chat int) int64 {
+	t.Helper()
+
+	value, err := rdb.Get(context.Background(), contract.MessageCounter(token, chat)).Int64()
+	if err != nil {
+		t.Fatalf("reading counter: %v", err)
+	}
+	return value
+}
+
+func released(t *testing.T, rdb redis.UniversalClient, token string) []string {
+	t.Helper()
+
+	members, err := rdb.SMembers(context.Background(), contract.ReleasedNumbers(token)).Result()
+	if err != nil {
+		t.Fatalf("reading released numbers: %v", err)
+	}
+	sort.Strings(members)
+	return members
+}
+
+func TestAllocatorModes(t *testing.T) {
+	rdb := testRedis(t)
+
+	tests := []struct {
+		mode string
+		// counters is the chat's counter after each number handed out
+		counters []int64
+	}{
+		{ModeStrict, []int64{1, 2, 3, 4, 5, 6, 7, 8}},
+		// Leases of 1, 2, 4, then capped at the block size of 4
+		{ModeBlock, []int64{1, 3, 3, 7, 7, 7, 7, 11}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.mode, func(t *testing.T) {
+			token := testToken(t, rdb, 1)
+			a := newTestAllocator(t, rdb, tc.mode, 4, time.Minute)
+
+			for i, want := range tc.counters {
+				if number := next(t, a, token, 1); number != int64(i+1) {
+					t.Fatalf("number %d = %d, want %d", i, number, i+1)
+				}
+				if got := counter(t, rdb, token, 1); got != want {
+					t.Errorf("counter after number %d = %d, want %d", i+1, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestAllocatorLeaseExpiry(t *testing.T) {
+	rdb := testRedis(t)
+	token := testToken(t, rdb, 1)
+	a := newTestAllocator(t, rdb, ModeBlock, 8, 200*time.Millisecond)
+
+	// 1 on its own, then a lease of 2 and 3 of which 3 goes unused
+	for want := int64(1); want <= 2; want++ {
+		if number := next(t, a, token, 1); number != want {
+			t.Fatalf("number = %d, want %d", number, want)
+		}
+	}
+
+	time.Sleep(300 * time.Millisecond)
+
+	// The expired leftover is given back rather than handed out late, and
+	// the chat starts over with a lease of one
+	if number := next(t, a, token, 1); number != 4 {
+		t.Errorf("number after expiry = %d, want 4", number)
+	}
+	if got := counter(t, rdb, token, 1); got != 4 {
+		t.Errorf("counter = %d, want 4", got)
+	}
+	if got, want := released(t, rdb, token), []string{"1:3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("released = %v, want %v", got, want)
+	}
+}
+
+func TestAllocatorStopReleasesLeftovers(t *testing.T) {
+	rdb := testRedis(t)
+	token := testToken(t, rdb, 2)
+	a := newTestAllocator(t, rdb, ModeBlock, 8, time.Minute)
+
+	// Chat 1 leases 1, 2-3 and 4-7 and uses 1 to 4. Chat 2 leases 1 and 2-3
+	// and uses 1 and 2.
+	for i := 0; i < 4; i++ {
+		next(t, a, token, 1)
+	}
+	for i := 0; i < 2; i++ {
+		next(t, a, token, 2)
+	}
+	if got := released(t, rdb, token); len(got) != 0 {
+		t.Fatalf("released before Stop = %v, want none", got)
+	}
+
+	a.Stop()
+
+	want := []string{"1:5", "1:6", "1:7", "2:3"}
+	if got := released(t, rdb, token); !reflect.DeepEqual(got, want) {
+		t.Errorf("released = %v, want %v", got, want)
+	}
+}
+
+func TestAllocatorUniqueAcrossInstances(t *testing.T) {
+	rdb := testRedis(t)
+	token := testToken(t, rdb, 1)
+	instances := []*Allocator{
+		newTestAllocator(t, rdb, ModeBlock, 16, time.Minute),
+		newTestAllocator(t, rdb, ModeBlock, 16, time.Minute),
+		newTestAllocator(t, rdb, ModeStrict, 0, 0),
+	}
+
+	const perWorker = 200
+	var mu sync.Mutex
+	seen := make(map[int64]bool)
+	var wg sync.WaitGroup
+	for _, a := range instances {
+		for w := 0; w < 4; w++ {
+			wg.Add(1)
+			go func(a *Allocator) {
+				defer wg.Done()
+				for i := 0; i < perWorker; i++ {
+					number, err := a.NextMessageNumber(token, 1)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					mu.Lock()
+					if seen[number] {
+						t.Errorf("number %d handed out twice", number)
+					}
+					seen[number] = true
+					mu.Unlock()
+				}
+			}(a)
+		}
+	}
+	wg.Wait()
+
+	if want := len(instances) * 4 * perWorker; len(seen) != want {
+		t.Errorf("handed out %d numbers, want %d", len(seen), want)
+	}
+}
+
+func benchmarkAllocator(b *testing.B, mode string) {
+	rdb := testRedis(b)
+	token := testToken(b, rdb, 1)
+	a := newTestAllocator(b, rdb, mode, 100, time.Minute)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := a.NextMessageNumber(token, 1); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkAllocatorStrict(b *testing.B) {
+	benchmarkAllocator(b, ModeStrict)
+}
+
+func BenchmarkAllocatorBlock(b *testing.B) {
+	benchmarkAllocator(b, ModeBlock)
+}
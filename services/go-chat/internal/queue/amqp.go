@@ -2,7 +2,6 @@ package queue
 
 import (
 	"context"
-	"encoding/json"
 	"go-chat/internal/config"
 	"go-chat/internal/logging"
 	"time"
@@ -15,8 +14,11 @@ type QueueType string
 const (
 	ChatsQueue    QueueType = "chats_queue"
 	MessagesQueue QueueType = "messages_queue"
+	// DeletionsQueue carries application and chat deletions to go-worker
+	DeletionsQueue QueueType = "deletions_queue"
 )
 
+// AMQP publishes to RabbitMQ
 type AMQP struct {
 	conn    *amqp.Connection
 	channel *amqp.Channel
@@ -38,7 +40,7 @@ func NewAMQP(logger *logging.Logger, cfg *config.Config) (*AMQP, error) {
 		return nil, err
 	}
 
-	queues := []QueueType{ChatsQueue, MessagesQueue}
+	queues := []QueueType{ChatsQueue, MessagesQueue, DeletionsQueue}
 	for _, queueType := range queues {
 		logger.Info("Declaring AMQP '%s' queue", queueType)
 		_, err = channel.QueueDeclare(
@@ -63,10 +65,13 @@ func NewAMQP(logger *logging.Logger, cfg *config.Config) (*AMQP, error) {
 	}, nil
 }
 
-func (a *AMQP) PublishMessage(payload interface{}, queueType QueueType) error {
-	body, err := json.Marshal(payload)
-	if err != nil {
-		return err
+func (a *AMQP) Publish(queueType QueueType, msg *Message) error {
+	var headers amqp.Table
+	if len(msg.Headers) > 0 {
+		headers = make(amqp.Table, len(msg.Headers))
+		for key, value := range msg.Headers {
+			headers[key] = value
+		}
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -80,7 +85,11 @@ func (a *AMQP) PublishMessage(payload interface{}, queueType QueueType) error {
 		false,
 		amqp.Publishing{
 			ContentType: "application/json",
-			Body:        body,
+			MessageId:   msg.ID,
+			Type:        msg.Type,
+			Timestamp:   msg.Timestamp,
+			Headers:     headers,
+			Body:        msg.Body,
 		},
 	)
 }
@@ -0,0 +1,41 @@
+package queue
+
+import (
+	"go-chat/internal/contract"
+	"time"
+)
+
+// Brokers selectable with BROKER
+const (
+	BrokerRabbitMQ = "rabbitmq"
+	BrokerRedis    = "redis"
+	BrokerMemory   = "memory"
+)
+
+// Message is what is put on a queue
+type Message struct {
+	ID        string
+	Type      string
+	Timestamp time.Time
+	Headers   map[string]string
+	Body      []byte
+}
+
+// NewMessage wraps an envelope for publishing
+func NewMessage(env *contract.Envelope) (*Message, error) {
+	body, err := env.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return &Message{
+		ID:        env.ID,
+		Type:      string(env.Type),
+		Timestamp: env.ProducedAt,
+		Body:      body,
+	}, nil
+}
+
+// Publisher puts messages on the queues go-worker consumes
+type Publisher interface {
+	Publish(queueType QueueType, msg *Message) error
+}
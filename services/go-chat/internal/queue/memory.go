@@ -0,0 +1,39 @@
+package queue
+
+import "sync"
+
+// memoryQueueSize is how many messages a queue keeps, older ones are dropped
+const memoryQueueSize = 10000
+
+// Memory keeps published messages in process, for tests. Nothing reaches
+// go-worker.
+type Memory struct {
+	mu       sync.Mutex
+	messages map[QueueType][]*Message
+}
+
+func NewMemory() *Memory {
+	return &Memory{messages: make(map[QueueType][]*Message)}
+}
+
+func (m *Memory) Publish(queueType QueueType, msg *Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	messages := m.messages[queueType]
+	if len(messages) >= memoryQueueSize {
+		messages = messages[1:]
+	}
+	m.messages[queueType] = append(messages, msg)
+	return nil
+}
+
+// Drain returns the messages published on a queue so far and forgets them
+func (m *Memory) Drain(queueType QueueType) []*Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	messages := m.messages[queueType]
+	delete(m.messages, queueType)
+	return messages
+}
@@ -0,0 +1,52 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"go-chat/internal/contract"
+	"go-chat/internal/database"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// streamEntry is a Message as stored in the "message" field of a stream
+// entry, the encoding go-worker reads
+type streamEntry struct {
+	ID        string            `json:"id"`
+	Type      string            `json:"type"`
+	Timestamp time.Time         `json:"timestamp"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Body      []byte            `json:"body"`
+}
+
+// RedisStreams publishes to Redis Streams, one contract.QueueStream per
+// queue, which go-worker reads through a consumer group
+type RedisStreams struct {
+	redis redis.UniversalClient
+}
+
+func NewRedisStreams(db *database.Database) *RedisStreams {
+	return &RedisStreams{redis: db.RedisDB}
+}
+
+func (r *RedisStreams) Publish(queueType QueueType, msg *Message) error {
+	entry, err := json.Marshal(&streamEntry{
+		ID:        msg.ID,
+		Type:      msg.Type,
+		Timestamp: msg.Timestamp,
+		Headers:   msg.Headers,
+		Body:      msg.Body,
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return r.redis.XAdd(ctx, &redis.XAddArgs{
+		Stream: contract.QueueStream(string(queueType)),
+		Values: map[string]interface{}{"message": string(entry)},
+	}).Err()
+}
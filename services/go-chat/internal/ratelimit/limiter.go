@@ -0,0 +1,164 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"go-chat/internal/config"
+	"go-chat/internal/database"
+	"go-chat/internal/logging"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Scope separates the quotas of write and read endpoints
+type Scope string
+
+const (
+	ScopeCreate Scope = "create"
+	ScopeSearch Scope = "search"
+)
+
+const quotaCacheTTL = time.Minute
+
+type Quota struct {
+	Limit  int
+	Window time.Duration
+}
+
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	Reset     time.Duration
+}
+
+// Limiter is a Redis-backed sliding window limiter shared by every go-chat
+// instance, so limits hold regardless of how many replicas are running
+type Limiter struct {
+	redis    redis.UniversalClient
+	mysql    *sql.DB
+	logger   *logging.Logger
+	window   time.Duration
+	defaults map[Scope]int
+	perIP    int
+	seq      uint64
+}
+
+// slidingWindowScript keeps one sorted-set entry per accepted request scored by
+// its timestamp in milliseconds, evicting entries older than the window. It
+// only touches KEYS[1], so in Redis Cluster it runs on the node owning it.
+var slidingWindowScript = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+
+redis.call('ZREMRANGEBYSCORE', key, 0, now - window)
+local count = redis.call('ZCARD', key)
+local allowed = 0
+if count < limit then
+    redis.call('ZADD', key, now, ARGV[4])
+    count = count + 1
+    allowed = 1
+end
+redis.call('PEXPIRE', key, window)
+
+local reset = window
+local oldest = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+if oldest[2] then
+    reset = tonumber(oldest[2]) + window - now
+end
+return {allowed, limit - count, reset}
+`)
+
+func NewLimiter(db *database.Database, cfg *config.Config, logger *logging.Logger) *Limiter {
+	return &Limiter{
+		redis:  db.RedisDB,
+		mysql:  db.MySqlDB,
+		logger: logger.WithPrefix("RateLimiter"),
+		window: cfg.RateLimitWindow,
+		defaults: map[Scope]int{
+			ScopeCreate: cfg.RateLimitCreate,
+			ScopeSearch: cfg.RateLimitSearch,
+		},
+		perIP: cfg.RateLimitPerIP,
+	}
+}
+
+// Allow records a request against key if it fits within quota
+func (l *Limiter) Allow(ctx context.Context, key string, quota Quota) (*Result, error) {
+	now := time.Now().UnixMilli()
+	member := fmt.Sprintf("%d-%d", now, atomic.AddUint64(&l.seq, 1))
+
+	values, err := slidingWindowScript.Run(ctx, l.redis, []string{key},
+		now, quota.Window.Milliseconds(), quota.Limit, member).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Result{
+		Allowed:   values[0] == 1,
+		Limit:     quota.Limit,
+		Remaining: int(values[1]),
+		Reset:     time.Duration(values[2]) * time.Millisecond,
+	}, nil
+}
+
+// QuotaFor returns the application's own quota for scope, falling back to the
+// service default when the application has none configured
+func (l *Limiter) QuotaFor(ctx context.Context, appToken string, scope Scope) Quota {
+	quota := Quota{Limit: l.defaults[scope], Window: l.window}
+
+	limit, err := l.applicationLimit(ctx, appToken, scope)
+	if err != nil {
+		l.logger.Error("failed to load quota for %s, using default: %v", appToken, err)
+		return quota
+	}
+	if limit > 0 {
+		quota.Limit = limit
+	}
+	return quota
+}
+
+func (l *Limiter) IPQuota() (Quota, bool) {
+	return Quota{Limit: l.perIP, Window: l.window}, l.perIP > 0
+}
+
+// applicationLimit reads the per-application limit from a short-lived Redis
+// cache, loading both scopes from MySQL on a miss. Zero means no override.
+func (l *Limiter) applicationLimit(ctx context.Context, appToken string, scope Scope) (int, error) {
+	cacheKey := fmt.Sprintf("ratelimit:quota:%s", appToken)
+
+	cached, err := l.redis.HGet(ctx, cacheKey, string(scope)).Result()
+	if err == nil {
+		return strconv.Atoi(cached)
+	}
+	if err != redis.Nil {
+		return 0, err
+	}
+
+	var createLimit, searchLimit sql.NullInt64
+	query := "SELECT rate_limit_create, rate_limit_search FROM applications WHERE token = ?"
+	err = l.mysql.QueryRowContext(ctx, query, appToken).Scan(&createLimit, &searchLimit)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	limits := map[Scope]int{
+		ScopeCreate: int(createLimit.Int64),
+		ScopeSearch: int(searchLimit.Int64),
+	}
+
+	pipe := l.redis.Pipeline()
+	pipe.HSet(ctx, cacheKey, string(ScopeCreate), limits[ScopeCreate], string(ScopeSearch), limits[ScopeSearch])
+	pipe.Expire(ctx, cacheKey, quotaCacheTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		l.logger.Error("failed to cache quota for %s: %v", appToken, err)
+	}
+
+	return limits[scope], nil
+}
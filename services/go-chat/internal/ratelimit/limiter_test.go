@@ -0,0 +1,173 @@
+package ratelimit
+
+import (
+	"context"
+	"go-chat/internal/config"
+	"go-chat/internal/logging"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/gofiber/fiber/v2"
+)
+
+// newTestLimiter keeps quotas in the Redis cache only, the tests never
+// reach MySQL
+func newTestLimiter(t *testing.T, perIP int) (*Limiter, *miniredis.Miniredis) {
+	t.Helper()
+
+	m := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+
+	logger := logging.NewLogger(&config.Config{AppName: "ratelimit-test", LogPath: t.TempDir()})
+	return &Limiter{
+		redis:    rdb,
+		logger:   logger,
+		window:   time.Minute,
+		defaults: map[Scope]int{ScopeCreate: 3, ScopeSearch: 5},
+		perIP:    perIP,
+	}, m
+}
+
+func TestAllowSlidingWindow(t *testing.T) {
+	l, _ := newTestLimiter(t, 0)
+	ctx := context.Background()
+	quota := Quota{Limit: 2, Window: 200 * time.Millisecond}
+
+	tests := []struct {
+		wait      time.Duration
+		allowed   bool
+		remaining int
+	}{
+		{0, true, 1},
+		{0, true, 0},
+		{0, false, 0},
+		// Both requests have left the window
+		{250 * time.Millisecond, true, 1},
+	}
+	for i, tc := range tests {
+		time.Sleep(tc.wait)
+		result, err := l.Allow(ctx, "ratelimit:test", quota)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Allowed != tc.allowed || result.Remaining != tc.remaining {
+			t.Errorf("request %d: allowed %v with %d remaining, want %v with %d",
+				i+1, result.Allowed, result.Remaining, tc.allowed, tc.remaining)
+		}
+		if result.Reset <= 0 || result.Reset > quota.Window {
+			t.Errorf("request %d: reset in %v, want within %v", i+1, result.Reset, quota.Window)
+		}
+	}
+}
+
+func TestQuotaFor(t *testing.T) {
+	l, m := newTestLimiter(t, 0)
+	m.HSet("ratelimit:quota:custom", string(ScopeCreate), "10", string(ScopeSearch), "0")
+
+	tests := []struct {
+		token string
+		scope Scope
+		want  int
+	}{
+		{"custom", ScopeCreate, 10},
+		// Zero is no override
+		{"custom", ScopeSearch, 5},
+	}
+	for _, tc := range tests {
+		quota := l.QuotaFor(context.Background(), tc.token, tc.scope)
+		if quota.Limit != tc.want || quota.Window != time.Minute {
+			t.Errorf("QuotaFor(%s, %s) = %+v, want %d per minute", tc.token, tc.scope, quota, tc.want)
+		}
+	}
+}
+
+func TestPerApplication(t *testing.T) {
+	l, m := newTestLimiter(t, 0)
+	m.HSet("ratelimit:quota:app", string(ScopeCreate), "2", string(ScopeSearch), "0")
+
+	app := fiber.New()
+	app.Post("/applications/:token/chats", l.PerApplication(ScopeCreate), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusCreated)
+	})
+
+	tests := []struct {
+		want      int
+		remaining string
+	}{
+		{fiber.StatusCreated, "1"},
+		{fiber.StatusCreated, "0"},
+		{fiber.StatusTooManyRequests, "0"},
+	}
+	for i, tc := range tests {
+		resp, err := app.Test(httptest.NewRequest("POST", "/applications/app/chats", nil), -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tc.want {
+			t.Errorf("request %d: status = %d, want %d", i+1, resp.StatusCode, tc.want)
+		}
+		if got := resp.Header.Get(HeaderLimit); got != "2" {
+			t.Errorf("request %d: %s = %q, want 2", i+1, HeaderLimit, got)
+		}
+		if got := resp.Header.Get(HeaderRemaining); got != tc.remaining {
+			t.Errorf("request %d: %s = %q, want %s", i+1, HeaderRemaining, got, tc.remaining)
+		}
+		limited := resp.Header.Get(fiber.HeaderRetryAfter) != ""
+		if limited != (tc.want == fiber.StatusTooManyRequests) {
+			t.Errorf("request %d: Retry-After %q", i+1, resp.Header.Get(fiber.HeaderRetryAfter))
+		}
+	}
+}
+
+func TestPerIP(t *testing.T) {
+	tests := []struct {
+		name  string
+		perIP int
+		want  []int
+	}{
+		{"disabled", 0, []int{fiber.StatusOK, fiber.StatusOK, fiber.StatusOK}},
+		{"one per window", 1, []int{fiber.StatusOK, fiber.StatusTooManyRequests, fiber.StatusTooManyRequests}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			l, _ := newTestLimiter(t, tc.perIP)
+			app := fiber.New()
+			app.Get("/", l.PerIP(), func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) })
+
+			for i, want := range tc.want {
+				resp, err := app.Test(httptest.NewRequest("GET", "/", nil), -1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if resp.StatusCode != want {
+					t.Errorf("request %d: status = %d, want %d", i+1, resp.StatusCode, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFailsOpenWithoutRedis(t *testing.T) {
+	l, m := newTestLimiter(t, 1)
+	m.Close()
+
+	app := fiber.New()
+	app.Get("/", l.PerIP(), func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) })
+
+	for i := 0; i < 2; i++ {
+		resp, err := app.Test(httptest.NewRequest("GET", "/", nil), -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != fiber.StatusOK {
+			t.Errorf("request %d: status = %d, want 200", i+1, resp.StatusCode)
+		}
+		if got := resp.Header.Get(HeaderLimit); got != "" {
+			t.Errorf("request %d: %s = %q without a limiter", i+1, HeaderLimit, got)
+		}
+	}
+}
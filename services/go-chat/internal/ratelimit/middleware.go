@@ -0,0 +1,77 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"go-chat/internal/logging"
+	"math"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	HeaderLimit     = "RateLimit-Limit"
+	HeaderRemaining = "RateLimit-Remaining"
+	HeaderReset     = "RateLimit-Reset"
+)
+
+// PerApplication limits requests by the :token route parameter using the
+// application's quota for scope
+func (l *Limiter) PerApplication(scope Scope) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		appToken := c.Params("token")
+		if appToken == "" {
+			return c.Next()
+		}
+
+		ctx := context.Background()
+		quota := l.QuotaFor(ctx, appToken, scope)
+		key := fmt.Sprintf("ratelimit:app:%s:%s", appToken, scope)
+		return l.enforce(c, key, quota)
+	}
+}
+
+// PerIP limits requests by client IP when RATE_LIMIT_PER_IP is set
+func (l *Limiter) PerIP() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		quota, enabled := l.IPQuota()
+		if !enabled {
+			return c.Next()
+		}
+
+		key := fmt.Sprintf("ratelimit:ip:%s", c.IP())
+		return l.enforce(c, key, quota)
+	}
+}
+
+func (l *Limiter) enforce(c *fiber.Ctx, key string, quota Quota) error {
+	result, err := l.Allow(context.Background(), key, quota)
+	if err != nil {
+		// Fail open, an unavailable Redis should not take the API down with it
+		l.requestLogger(c).Error("rate limiter unavailable for %s: %v", key, err)
+		return c.Next()
+	}
+
+	resetSeconds := strconv.Itoa(int(math.Ceil(result.Reset.Seconds())))
+	c.Set(HeaderLimit, strconv.Itoa(result.Limit))
+	c.Set(HeaderRemaining, strconv.Itoa(result.Remaining))
+	c.Set(HeaderReset, resetSeconds)
+
+	if !result.Allowed {
+		l.requestLogger(c).Error("rate limit exceeded for %s", key)
+		c.Set(fiber.HeaderRetryAfter, resetSeconds)
+		return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+			"error": "rate limit exceeded",
+		})
+	}
+
+	return c.Next()
+}
+
+func (l *Limiter) requestLogger(c *fiber.Ctx) *logging.Logger {
+	if logger, ok := c.Locals("logger").(*logging.Logger); ok {
+		return logger
+	}
+	return l.logger
+}
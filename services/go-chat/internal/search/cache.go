@@ -0,0 +1,169 @@
+package search
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go-chat/internal/config"
+	"go-chat/internal/database"
+	"go-chat/internal/logging"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// CacheStatus tells how a search was answered with regard to the cache
+type CacheStatus string
+
+const (
+	CacheHit    CacheStatus = "HIT"
+	CacheMiss   CacheStatus = "MISS"
+	CacheBypass CacheStatus = "BYPASS"
+	// CacheSkipped searches are never cached: cursor pages, or the cache is
+	// disabled or unreachable
+	CacheSkipped CacheStatus = ""
+)
+
+// Cache keeps chat search results in Redis. Entries are keyed on the
+// normalized request and on the chat's generation, a counter go-worker bumps
+// after each flush that indexed messages of that chat, so new messages are
+// searchable right away instead of once the TTL runs out.
+type Cache struct {
+	redis  redis.UniversalClient
+	logger *logging.Logger
+	ttl    time.Duration
+
+	hits     uint64
+	misses   uint64
+	bypasses uint64
+	errors   uint64
+}
+
+// CacheStats are the counters of this go-chat instance since it started
+type CacheStats struct {
+	Hits     uint64  `json:"hits"`
+	Misses   uint64  `json:"misses"`
+	Bypasses uint64  `json:"bypasses"`
+	Errors   uint64  `json:"errors"`
+	HitRatio float64 `json:"hit_ratio"`
+}
+
+func NewCache(cfg *config.Config, db *database.Database, logger *logging.Logger) *Cache {
+	return &Cache{
+		redis:  db.RedisDB,
+		logger: logger.WithPrefix("search-cache"),
+		ttl:    cfg.SearchCacheTTL,
+	}
+}
+
+// generationKey holds the search generation of a chat
+func generationKey(appToken string, chatNumber int) string {
+	return fmt.Sprintf("search:generation:%s:%d", appToken, chatNumber)
+}
+
+func (c *Cache) Enabled() bool {
+	return c.ttl > 0
+}
+
+// cacheRequest is what makes two searches the same. Page and per_page are
+// already clamped by the handler, the query has its whitespace collapsed.
+type cacheRequest struct {
+	Query     string            `json:"q"`
+	Page      int               `json:"page"`
+	PerPage   int               `json:"per_page"`
+	Sort      SortMode          `json:"sort"`
+	Filters   Filters           `json:"filters"`
+	Highlight *HighlightOptions `json:"highlight"`
+}
+
+// Key returns the cache key of a search at the chat's current generation.
+// It is empty when the generation cannot be read, the search then goes
+// uncached.
+func (c *Cache) Key(appToken string, chatNumber int, query string, opts ChatSearchOptions) string {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	generation, err := c.redis.Get(ctx, generationKey(appToken, chatNumber)).Int64()
+	if err != nil && err != redis.Nil {
+		atomic.AddUint64(&c.errors, 1)
+		c.logger.Error("failed to read search generation of %s:%d: %v", appToken, chatNumber, err)
+		return ""
+	}
+
+	request, _ := json.Marshal(cacheRequest{
+		Query:     strings.Join(strings.Fields(query), " "),
+		Page:      opts.Page,
+		PerPage:   opts.PerPage,
+		Sort:      opts.Sort,
+		Filters:   opts.Filters,
+		Highlight: opts.Highlight,
+	})
+	digest := sha256.Sum256(request)
+
+	return fmt.Sprintf("search:cache:%s:%d:%d:%s", appToken, chatNumber, generation, hex.EncodeToString(digest[:16]))
+}
+
+// Get looks a search up, counting it as a hit or a miss
+func (c *Cache) Get(key string) (*ChatSearchResult, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	raw, err := c.redis.Get(ctx, key).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			atomic.AddUint64(&c.errors, 1)
+			c.logger.Error("failed to read cached search: %v", err)
+		}
+		atomic.AddUint64(&c.misses, 1)
+		return nil, false
+	}
+
+	var result ChatSearchResult
+	if err := json.Unmarshal(raw, &result); err != nil {
+		atomic.AddUint64(&c.errors, 1)
+		c.logger.Error("failed to decode cached search: %v", err)
+		atomic.AddUint64(&c.misses, 1)
+		return nil, false
+	}
+
+	atomic.AddUint64(&c.hits, 1)
+	return &result, true
+}
+
+func (c *Cache) Set(key string, result *ChatSearchResult) {
+	raw, err := json.Marshal(result)
+	if err != nil {
+		c.logger.Error("failed to encode search result: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := c.redis.Set(ctx, key, raw, c.ttl).Err(); err != nil {
+		atomic.AddUint64(&c.errors, 1)
+		c.logger.Error("failed to cache search: %v", err)
+	}
+}
+
+// Bypassed counts a search the client asked to run uncached
+func (c *Cache) Bypassed() {
+	atomic.AddUint64(&c.bypasses, 1)
+}
+
+func (c *Cache) Stats() CacheStats {
+	stats := CacheStats{
+		Hits:     atomic.LoadUint64(&c.hits),
+		Misses:   atomic.LoadUint64(&c.misses),
+		Bypasses: atomic.LoadUint64(&c.bypasses),
+		Errors:   atomic.LoadUint64(&c.errors),
+	}
+	if lookups := stats.Hits + stats.Misses; lookups > 0 {
+		stats.HitRatio = float64(stats.Hits) / float64(lookups)
+	}
+	return stats
+}
@@ -0,0 +1,96 @@
+package search
+
+import (
+	"go-chat/internal/config"
+	"go-chat/internal/logging"
+	"go-chat/internal/model"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func newTestCache(t *testing.T) (*Cache, *miniredis.Miniredis) {
+	t.Helper()
+
+	m := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+
+	logger := logging.NewLogger(&config.Config{AppName: "search-test", LogPath: t.TempDir()})
+	return &Cache{redis: rdb, logger: logger.WithPrefix("search-cache"), ttl: time.Minute}, m
+}
+
+func TestCacheKey(t *testing.T) {
+	c, _ := newTestCache(t)
+	opts := ChatSearchOptions{Page: 1, PerPage: 20, Sort: SortRelevance}
+	base := c.Key("app", 1, "pay invoice", opts)
+
+	tests := []struct {
+		name  string
+		token string
+		chat  int
+		query string
+		opts  ChatSearchOptions
+		same  bool
+	}{
+		{"whitespace", "app", 1, "  pay   invoice ", opts, true},
+		{"query", "app", 1, "pay invoices", opts, false},
+		{"chat", "app", 2, "pay invoice", opts, false},
+		{"application", "other", 1, "pay invoice", opts, false},
+		{"page", "app", 1, "pay invoice", ChatSearchOptions{Page: 2, PerPage: 20, Sort: SortRelevance}, false},
+		{"sort", "app", 1, "pay invoice", ChatSearchOptions{Page: 1, PerPage: 20, Sort: SortNewest}, false},
+		{"filters", "app", 1, "pay invoice", ChatSearchOptions{Page: 1, PerPage: 20, Sort: SortRelevance, Filters: Filters{NumberFrom: 3}}, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			key := c.Key(tc.token, tc.chat, tc.query, tc.opts)
+			if (key == base) != tc.same {
+				t.Errorf("key %s, same as %s: %v, want %v", key, base, key == base, tc.same)
+			}
+		})
+	}
+}
+
+func TestCacheGenerations(t *testing.T) {
+	c, m := newTestCache(t)
+	opts := ChatSearchOptions{Page: 1, PerPage: 20}
+
+	key := c.Key("app", 1, "invoice", opts)
+	if _, ok := c.Get(key); ok {
+		t.Fatal("hit before anything was cached")
+	}
+	c.Set(key, &ChatSearchResult{Total: 1, Messages: []*model.MessageHit{{Message: &model.Message{Content: "invoice"}}}})
+
+	cached, ok := c.Get(c.Key("app", 1, "invoice", opts))
+	if !ok || cached.Total != 1 || cached.Messages[0].Content != "invoice" {
+		t.Fatalf("cached = %+v, %v, want the stored result", cached, ok)
+	}
+	if ttl := m.TTL(key); ttl != time.Minute {
+		t.Errorf("TTL = %v, want a minute", ttl)
+	}
+
+	// go-worker indexed new messages of the chat
+	m.Incr(generationKey("app", 1), 1)
+	if _, ok := c.Get(c.Key("app", 1, "invoice", opts)); ok {
+		t.Error("hit after the chat's generation was bumped")
+	}
+
+	want := CacheStats{Hits: 1, Misses: 2, HitRatio: 1.0 / 3}
+	if stats := c.Stats(); stats != want {
+		t.Errorf("Stats = %+v, want %+v", stats, want)
+	}
+}
+
+func TestCacheWithoutRedis(t *testing.T) {
+	c, m := newTestCache(t)
+	m.Close()
+
+	if key := c.Key("app", 1, "invoice", ChatSearchOptions{}); key != "" {
+		t.Errorf("Key = %q with Redis down, want none so the search goes uncached", key)
+	}
+	if stats := c.Stats(); stats.Errors != 1 {
+		t.Errorf("Errors = %d, want 1", stats.Errors)
+	}
+}
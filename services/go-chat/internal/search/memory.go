@@ -0,0 +1,761 @@
+package search
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go-chat/internal/contract"
+	"go-chat/internal/database"
+	"go-chat/internal/logging"
+	"go-chat/internal/model"
+	"go-chat/internal/stream"
+	"html"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	weightExact  = 1.0
+	weightPrefix = 0.7
+	weightFuzzy  = 0.5
+
+	// memorySweepInterval is how often documents of deleted applications and
+	// chats, and messages past their retention period, are dropped
+	memorySweepInterval = 30 * time.Second
+)
+
+// MemoryBackend is an inverted index held in process memory. It loads every
+// message from MySQL on startup and follows new ones through the stream hub,
+// so it needs neither Elasticsearch nor go-worker's indexing queue. Matching
+// mirrors the Elasticsearch mapping closely enough for development and small
+// deployments: prefixes, fuzziness and phrases, but no language analysis.
+//
+// go-worker deletes rows without telling go-chat, so a sweep drops what was
+// tombstoned or fell out of the retention period.
+type MemoryBackend struct {
+	mysql  *sql.DB
+	redis  redis.UniversalClient
+	logger *logging.Logger
+
+	mu   sync.RWMutex
+	apps map[string]*memoryApp
+}
+
+type memoryApp struct {
+	docs     map[string]*memoryDoc
+	postings map[string]map[string]*memoryDoc
+}
+
+type memoryDoc struct {
+	id      string
+	message *model.Message
+	tokens  []string
+}
+
+// memoryMatch is why a document matched: its score and the tokens to highlight
+type memoryMatch struct {
+	doc     *memoryDoc
+	score   float64
+	matched map[string]struct{}
+}
+
+var _ Backend = (*MemoryBackend)(nil)
+
+func NewMemoryBackend(db *database.Database, hub *stream.Hub, logger *logging.Logger) *MemoryBackend {
+	b := &MemoryBackend{
+		mysql:  db.MySqlDB,
+		redis:  db.RedisDB,
+		logger: logger.WithPrefix("MemorySearch"),
+		apps:   make(map[string]*memoryApp),
+	}
+
+	// Listen before loading so nothing persisted in between is missed,
+	// indexing the same message twice just replaces it
+	hub.Listen(func(message *model.Message) {
+		_ = b.Index(message)
+	})
+	go b.load()
+	go b.sweepEvery(memorySweepInterval)
+
+	return b
+}
+
+const loadBatchSize = 1000
+
+func (b *MemoryBackend) load() {
+	query := `SELECT messages.id, applications.token, applications.name, chats.number, messages.number, messages.content, messages.created_at
+		FROM messages
+		INNER JOIN chats ON chats.id = messages.chat_id
+		INNER JOIN applications ON applications.id = chats.application_id
+		WHERE messages.id > ?
+		ORDER BY messages.id ASC
+		LIMIT ?`
+
+	var lastID uint64
+	total := 0
+	for {
+		rows, err := b.mysql.Query(query, lastID, loadBatchSize)
+		if err != nil {
+			b.logger.Error("failed to load messages: %v", err)
+			return
+		}
+
+		batch := make([]*model.Message, 0, loadBatchSize)
+		for rows.Next() {
+			var msg model.Message
+			if err := rows.Scan(&lastID, &msg.ApplicationToken, &msg.ApplicationName, &msg.ChatNumber,
+				&msg.MessageNumber, &msg.Content, &msg.CreatedAt); err != nil {
+				rows.Close()
+				b.logger.Error("failed to load messages: %v", err)
+				return
+			}
+			batch = append(batch, &msg)
+		}
+		rows.Close()
+
+		_ = b.Bulk(batch)
+		total += len(batch)
+		if len(batch) < loadBatchSize {
+			break
+		}
+	}
+
+	b.logger.Info("loaded %d messages", total)
+}
+
+func (b *MemoryBackend) Index(message *model.Message) error {
+	return b.Bulk([]*model.Message{message})
+}
+
+func (b *MemoryBackend) Bulk(messages []*model.Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, message := range messages {
+		app := b.apps[message.ApplicationToken]
+		if app == nil {
+			app = &memoryApp{
+				docs:     make(map[string]*memoryDoc),
+				postings: make(map[string]map[string]*memoryDoc),
+			}
+			b.apps[message.ApplicationToken] = app
+		}
+
+		id := fmt.Sprintf("%d:%d", message.ChatNumber, message.MessageNumber)
+		app.remove(id)
+
+		doc := &memoryDoc{id: id, message: message, tokens: analyze(message.Content)}
+		app.docs[id] = doc
+		for _, token := range doc.tokens {
+			if app.postings[token] == nil {
+				app.postings[token] = make(map[string]*memoryDoc)
+			}
+			app.postings[token][id] = doc
+		}
+	}
+
+	return nil
+}
+
+func (b *MemoryBackend) Delete(appToken string, chatNumber int, messageNumber int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if app := b.apps[appToken]; app != nil {
+		app.remove(fmt.Sprintf("%d:%d", chatNumber, messageNumber))
+	}
+	return nil
+}
+
+// DropApplication removes every document of an application
+func (b *MemoryBackend) DropApplication(appToken string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	app := b.apps[appToken]
+	if app == nil {
+		return 0
+	}
+	delete(b.apps, appToken)
+	return len(app.docs)
+}
+
+// DropChat removes every document of one chat
+func (b *MemoryBackend) DropChat(appToken string, chatNumber int) int {
+	return b.dropWhere(appToken, func(message *model.Message) bool {
+		return message.ChatNumber == chatNumber
+	})
+}
+
+// ExpireBefore removes an application's messages created before cutoff
+func (b *MemoryBackend) ExpireBefore(appToken string, cutoff time.Time) int {
+	return b.dropWhere(appToken, func(message *model.Message) bool {
+		return message.CreatedAt.Before(cutoff)
+	})
+}
+
+func (b *MemoryBackend) dropWhere(appToken string, match func(*model.Message) bool) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	app := b.apps[appToken]
+	if app == nil {
+		return 0
+	}
+
+	dropped := 0
+	for id, doc := range app.docs {
+		if match(doc.message) {
+			app.remove(id)
+			dropped++
+		}
+	}
+	if len(app.docs) == 0 {
+		delete(b.apps, appToken)
+	}
+	return dropped
+}
+
+func (b *MemoryBackend) sweepEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		b.sweep()
+	}
+}
+
+// sweep drops applications and chats that have a tombstone or are gone from
+// MySQL, and messages older than their application's retention_days
+func (b *MemoryBackend) sweep() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	b.mu.RLock()
+	chats := make(map[string][]int, len(b.apps))
+	for token, app := range b.apps {
+		seen := make(map[int]bool)
+		for _, doc := range app.docs {
+			if number := doc.message.ChatNumber; !seen[number] {
+				seen[number] = true
+				chats[token] = append(chats[token], number)
+			}
+		}
+	}
+	b.mu.RUnlock()
+
+	for token, numbers := range chats {
+		var retentionDays sql.NullInt64
+		err := b.mysql.QueryRowContext(ctx, "SELECT retention_days FROM applications WHERE token = ?", token).Scan(&retentionDays)
+		if err == sql.ErrNoRows {
+			b.logger.Info("dropped %d messages of deleted application %s", b.DropApplication(token), token)
+			continue
+		}
+		if err != nil {
+			b.logger.Error("failed to sweep application %s: %v", token, err)
+			continue
+		}
+
+		// The tombstones share the application's hash tag, one pipeline
+		// reaches them all in Redis Cluster too
+		pipe := b.redis.Pipeline()
+		appTombstone := pipe.Exists(ctx, contract.ApplicationTombstone(token))
+		chatTombstones := make([]*redis.IntCmd, len(numbers))
+		for i, number := range numbers {
+			chatTombstones[i] = pipe.Exists(ctx, contract.ChatTombstone(token, number))
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			b.logger.Error("failed to check tombstones of %s: %v", token, err)
+			continue
+		}
+
+		if appTombstone.Val() > 0 {
+			b.logger.Info("dropped %d messages of deleted application %s", b.DropApplication(token), token)
+			continue
+		}
+		for i, number := range numbers {
+			if chatTombstones[i].Val() > 0 {
+				b.logger.Info("dropped %d messages of deleted chat %s/%d", b.DropChat(token, number), token, number)
+			}
+		}
+
+		if retentionDays.Valid && retentionDays.Int64 > 0 {
+			cutoff := time.Now().AddDate(0, 0, -int(retentionDays.Int64))
+			if n := b.ExpireBefore(token, cutoff); n > 0 {
+				b.logger.Info("dropped %d messages of %s past retention", n, token)
+			}
+		}
+	}
+}
+
+func (a *memoryApp) remove(id string) {
+	doc := a.docs[id]
+	if doc == nil {
+		return
+	}
+
+	delete(a.docs, id)
+	for _, token := range doc.tokens {
+		delete(a.postings[token], id)
+		if len(a.postings[token]) == 0 {
+			delete(a.postings, token)
+		}
+	}
+}
+
+func (b *MemoryBackend) HealthCheck() error {
+	return nil
+}
+
+func (b *MemoryBackend) Search(appToken string, chatNumber int, query string, opts ChatSearchOptions) (*ChatSearchResult, error) {
+	parsed, err := ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	if opts.PerPage < 1 || opts.PerPage > 100 {
+		opts.PerPage = 20
+	}
+	if opts.Page < 1 {
+		opts.Page = 1
+	}
+
+	scope := memoryCursorScope(appToken, chatNumber, query, opts.Sort, opts.Filters)
+	offset := (opts.Page - 1) * opts.PerPage
+	if opts.Cursor != "" {
+		if offset, err = decodeMemoryCursor(opts.Cursor, scope); err != nil {
+			return nil, err
+		}
+	}
+
+	matches := b.find(appToken, parsed, func(doc *memoryDoc) bool {
+		return doc.message.ChatNumber == chatNumber &&
+			opts.Filters.Match(doc.message.CreatedAt, doc.message.MessageNumber)
+	})
+	sortMatches(matches, opts.Sort, false)
+
+	result := &ChatSearchResult{
+		Messages: hitsOf(pageOf(matches, offset, opts.PerPage), opts.Highlight),
+		Total:    len(matches),
+	}
+	if offset+opts.PerPage < len(matches) {
+		result.NextCursor = encodeMemoryCursor(offset+opts.PerPage, scope)
+	}
+
+	return result, nil
+}
+
+func (b *MemoryBackend) SearchApplication(appToken string, query string, opts ApplicationSearchOptions, page int, pageSize int) (*ApplicationSearchResult, error) {
+	parsed, err := ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+	if opts.PerChat < 1 {
+		opts.PerChat = 3
+	}
+
+	chats := make(map[int]bool, len(opts.ChatNumbers))
+	for _, chatNumber := range opts.ChatNumbers {
+		chats[chatNumber] = true
+	}
+
+	matches := b.find(appToken, parsed, func(doc *memoryDoc) bool {
+		return (len(chats) == 0 || chats[doc.message.ChatNumber]) &&
+			opts.Filters.Match(doc.message.CreatedAt, doc.message.MessageNumber)
+	})
+	sortMatches(matches, opts.Sort, true)
+
+	out := &ApplicationSearchResult{Total: len(matches)}
+	offset := (page - 1) * pageSize
+	if !opts.Collapse {
+		out.Messages = hitsOf(pageOf(matches, offset, pageSize), opts.Highlight)
+		return out, nil
+	}
+
+	// Groups are ordered by their best hit, inner hits use the in-chat order
+	var order []int
+	groups := make(map[int][]*memoryMatch)
+	for _, match := range matches {
+		chatNumber := match.doc.message.ChatNumber
+		if groups[chatNumber] == nil {
+			order = append(order, chatNumber)
+		}
+		groups[chatNumber] = append(groups[chatNumber], match)
+	}
+
+	out.TotalChats = len(order)
+	out.Chats = make([]*ChatHits, 0, pageSize)
+	for _, chatNumber := range pageOf(order, offset, pageSize) {
+		group := groups[chatNumber]
+		sortMatches(group, opts.Sort, false)
+		out.Chats = append(out.Chats, &ChatHits{
+			ChatNumber: chatNumber,
+			Total:      len(group),
+			Messages:   hitsOf(pageOf(group, 0, opts.PerChat), opts.Highlight),
+		})
+	}
+
+	return out, nil
+}
+
+// Suggest treats the last word of prefix as incomplete and the others as
+// typed, best matches first and newest among equals
+func (b *MemoryBackend) Suggest(appToken string, chatNumber int, prefix string, opts SuggestOptions) ([]*model.MessageHit, error) {
+	if opts.Size < 1 || opts.Size > 10 {
+		opts.Size = 5
+	}
+
+	words := analyze(prefix)
+	if len(words) == 0 {
+		return []*model.MessageHit{}, nil
+	}
+
+	query := &Query{}
+	for i, word := range words {
+		kind := TermWord
+		if i == len(words)-1 {
+			kind = TermPrefix
+		}
+		query.groups = append(query.groups, []Term{{Kind: kind, Text: word}})
+	}
+
+	matches := b.find(appToken, query, func(doc *memoryDoc) bool {
+		return doc.message.ChatNumber == chatNumber
+	})
+	sortMatches(matches, SortNewest, false)
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].score > matches[j].score
+	})
+
+	return hitsOf(pageOf(matches, 0, opts.Size), opts.Highlight), nil
+}
+
+// find evaluates query against one application's documents accepted by keep
+func (b *MemoryBackend) find(appToken string, query *Query, keep func(*memoryDoc) bool) []*memoryMatch {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	app := b.apps[appToken]
+	if app == nil {
+		return nil
+	}
+
+	var candidates map[string]*memoryMatch
+	for _, group := range query.groups {
+		groupMatches := make(map[string]*memoryMatch)
+		for _, term := range group {
+			for id, match := range app.match(term, false) {
+				if existing := groupMatches[id]; existing == nil || existing.score < match.score {
+					groupMatches[id] = match
+				}
+			}
+		}
+
+		if candidates == nil {
+			candidates = groupMatches
+			continue
+		}
+		for id, match := range candidates {
+			other, ok := groupMatches[id]
+			if !ok {
+				delete(candidates, id)
+				continue
+			}
+			match.score += other.score
+			for token := range other.matched {
+				match.matched[token] = struct{}{}
+			}
+		}
+	}
+
+	for _, term := range query.excluded {
+		for id := range app.match(term, true) {
+			delete(candidates, id)
+		}
+	}
+
+	matches := make([]*memoryMatch, 0, len(candidates))
+	for _, match := range candidates {
+		if keep(match.doc) {
+			matches = append(matches, match)
+		}
+	}
+	return matches
+}
+
+// match finds the documents containing term. Exact matching is used for
+// exclusions, which would otherwise drop near misses too.
+func (a *memoryApp) match(term Term, exact bool) map[string]*memoryMatch {
+	words := analyze(term.Text)
+	if len(words) == 0 {
+		return nil
+	}
+
+	if term.Kind == TermPhrase {
+		matches := make(map[string]*memoryMatch)
+		for id, doc := range a.postings[words[0]] {
+			if containsSequence(doc.tokens, words) {
+				match := newMemoryMatch(doc, weightExact*float64(len(words)))
+				for _, word := range words {
+					match.matched[word] = struct{}{}
+				}
+				matches[id] = match
+			}
+		}
+		return matches
+	}
+
+	// A word the tokenizer splits, like "e-mail", needs all its parts
+	var matches map[string]*memoryMatch
+	for _, word := range words {
+		wordMatches := make(map[string]*memoryMatch)
+		for token, docs := range a.postings {
+			weight := tokenWeight(word, token, term.Kind == TermPrefix, exact)
+			if weight == 0 {
+				continue
+			}
+			for id, doc := range docs {
+				match := wordMatches[id]
+				if match == nil {
+					match = newMemoryMatch(doc, 0)
+					wordMatches[id] = match
+				}
+				if weight > match.score {
+					match.score = weight
+				}
+				match.matched[token] = struct{}{}
+			}
+		}
+
+		if matches == nil {
+			matches = wordMatches
+			continue
+		}
+		for id, match := range matches {
+			other, ok := wordMatches[id]
+			if !ok {
+				delete(matches, id)
+				continue
+			}
+			match.score += other.score
+			for token := range other.matched {
+				match.matched[token] = struct{}{}
+			}
+		}
+	}
+	return matches
+}
+
+func newMemoryMatch(doc *memoryDoc, score float64) *memoryMatch {
+	return &memoryMatch{doc: doc, score: score, matched: make(map[string]struct{})}
+}
+
+// tokenWeight scores how well an indexed token matches a searched word,
+// following the content.partial and content.fuzzy subfields
+func tokenWeight(word, token string, prefixOnly, exact bool) float64 {
+	switch {
+	case token == word:
+		return weightExact
+	case prefixOnly:
+		if strings.HasPrefix(token, word) {
+			return weightPrefix
+		}
+		return 0
+	case exact:
+		return 0
+	case len([]rune(word)) >= 2 && strings.HasPrefix(token, word):
+		return weightPrefix
+	case withinDistance(word, token, fuzziness(word)):
+		return weightFuzzy
+	}
+	return 0
+}
+
+// fuzziness follows Elasticsearch's AUTO setting
+func fuzziness(word string) int {
+	switch n := len([]rune(word)); {
+	case n < 3:
+		return 0
+	case n < 6:
+		return 1
+	default:
+		return 2
+	}
+}
+
+func withinDistance(a, b string, max int) bool {
+	if max == 0 {
+		return false
+	}
+	ra, rb := []rune(a), []rune(b)
+	if diff := len(ra) - len(rb); diff > max || -diff > max {
+		return false
+	}
+
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		best := curr[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			best = min(best, curr[j])
+		}
+		if best > max {
+			return false
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)] <= max
+}
+
+func containsSequence(tokens, words []string) bool {
+	for i := 0; i+len(words) <= len(tokens); i++ {
+		found := true
+		for j, word := range words {
+			if tokens[i+j] != word {
+				found = false
+				break
+			}
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+// sortMatches mirrors the sort clauses used against Elasticsearch
+func sortMatches(matches []*memoryMatch, mode SortMode, acrossChats bool) {
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i].doc.message, matches[j].doc.message
+		if mode == SortRelevance && matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		newest := mode == SortNewest
+		if acrossChats && mode != SortRelevance && !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.After(b.CreatedAt) == newest
+		}
+		if acrossChats && a.ChatNumber != b.ChatNumber {
+			return (a.ChatNumber > b.ChatNumber) == newest
+		}
+		return (a.MessageNumber > b.MessageNumber) == newest
+	})
+}
+
+func pageOf[T any](items []T, offset, size int) []T {
+	if offset >= len(items) {
+		return nil
+	}
+	end := offset + size
+	if end > len(items) {
+		end = len(items)
+	}
+	return items[offset:end]
+}
+
+func hitsOf(matches []*memoryMatch, highlight *HighlightOptions) []*model.MessageHit {
+	hits := make([]*model.MessageHit, len(matches))
+	for i, match := range matches {
+		score := match.score
+		hits[i] = &model.MessageHit{Message: match.doc.message, Score: &score}
+		if highlight != nil {
+			hits[i].Highlights = []string{highlightContent(match.doc.message.Content, match.matched, highlight)}
+		}
+	}
+	return hits
+}
+
+// highlightContent html escapes content and wraps the matched tokens in the
+// highlight tags
+func highlightContent(content string, matched map[string]struct{}, highlight *HighlightOptions) string {
+	var out strings.Builder
+	var word []rune
+	flush := func() {
+		if len(word) == 0 {
+			return
+		}
+		text := html.EscapeString(string(word))
+		if _, ok := matched[strings.ToLower(string(word))]; ok {
+			text = highlight.PreTag + text + highlight.PostTag
+		}
+		out.WriteString(text)
+		word = word[:0]
+	}
+
+	for _, r := range content {
+		if isTokenRune(r) {
+			word = append(word, r)
+			continue
+		}
+		flush()
+		out.WriteString(html.EscapeString(string(r)))
+	}
+	flush()
+
+	return out.String()
+}
+
+// analyze splits text on anything but letters and digits and lowercases it,
+// much like the standard tokenizer with a lowercase filter
+func analyze(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !isTokenRune(r)
+	})
+}
+
+func isTokenRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+type memoryCursor struct {
+	Offset int    `json:"offset"`
+	Scope  string `json:"scope"`
+}
+
+func encodeMemoryCursor(offset int, scope string) string {
+	raw, _ := json.Marshal(memoryCursor{Offset: offset, Scope: scope})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeMemoryCursor(encoded, scope string) (int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+
+	var c memoryCursor
+	if err := json.Unmarshal(raw, &c); err != nil || c.Offset < 0 {
+		return 0, ErrInvalidCursor
+	}
+	if c.Scope != scope {
+		return 0, fmt.Errorf("%w: cursor belongs to a different search", ErrInvalidCursor)
+	}
+	return c.Offset, nil
+}
+
+func memoryCursorScope(parts ...interface{}) string {
+	sum := sha256.Sum256([]byte(fmt.Sprint(parts...)))
+	return hex.EncodeToString(sum[:8])
+}
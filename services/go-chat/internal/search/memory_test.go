@@ -0,0 +1,350 @@
+package search
+
+import (
+	"errors"
+	"go-chat/internal/config"
+	"go-chat/internal/logging"
+	"go-chat/internal/model"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var memoryTestStart = time.Date(2025, 11, 26, 9, 0, 0, 0, time.UTC)
+
+// newTestMemoryBackend indexes a few messages of chat 1 of "app", one hour
+// apart, and one message of chat 2
+func newTestMemoryBackend(t *testing.T) *MemoryBackend {
+	t.Helper()
+
+	b := &MemoryBackend{
+		logger: logging.NewLogger(&config.Config{AppName: "search-test", LogPath: t.TempDir()}),
+		apps:   make(map[string]*memoryApp),
+	}
+
+	contents := []string{
+		"Invoice for next week",
+		"Please pay the invoice",
+		"Draft invoice attached",
+		"Billing question about payments",
+		"Lunch tomorrow?",
+		"<b>invoice</b> & co",
+	}
+	messages := make([]*model.Message, 0, len(contents)+1)
+	for i, content := range contents {
+		messages = append(messages, &model.Message{
+			ApplicationToken: "app",
+			ApplicationName:  "Test App",
+			ChatNumber:       1,
+			MessageNumber:    i + 1,
+			Content:          content,
+			CreatedAt:        memoryTestStart.Add(time.Duration(i) * time.Hour),
+		})
+	}
+	messages = append(messages, &model.Message{
+		ApplicationToken: "app",
+		ChatNumber:       2,
+		MessageNumber:    1,
+		Content:          "invoice in another chat",
+		CreatedAt:        memoryTestStart,
+	})
+
+	if err := b.Bulk(messages); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func messageNumbers(hits []*model.MessageHit) []int {
+	numbers := []int{}
+	for _, hit := range hits {
+		numbers = append(numbers, hit.MessageNumber)
+	}
+	return numbers
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"   ",
+		"OR invoice",
+		"invoice OR",
+		"invoice OR OR billing",
+		"invoice -",
+		`"unterminated`,
+		`""`,
+		"a*",
+		"pay*ment",
+		"-draft",
+		"invoice OR -draft",
+	}
+	for _, query := range tests {
+		t.Run(query, func(t *testing.T) {
+			_, err := ParseQuery(query)
+			var queryErr *QueryError
+			if !errors.As(err, &queryErr) {
+				t.Errorf("ParseQuery(%q) error = %v, want a QueryError", query, err)
+			}
+		})
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		query    string
+		groups   [][]Term
+		excluded []Term
+	}{
+		{
+			query:  "invoice",
+			groups: [][]Term{{{Kind: TermWord, Text: "invoice"}}},
+		},
+		{
+			query:    `"next week" -draft Pay*`,
+			groups:   [][]Term{{{Kind: TermPhrase, Text: "next week"}}, {{Kind: TermPrefix, Text: "pay"}}},
+			excluded: []Term{{Kind: TermWord, Text: "draft"}},
+		},
+		{
+			query: "lunch OR billing invoice",
+			groups: [][]Term{
+				{{Kind: TermWord, Text: "lunch"}, {Kind: TermWord, Text: "billing"}},
+				{{Kind: TermWord, Text: "invoice"}},
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.query, func(t *testing.T) {
+			q, err := ParseQuery(tc.query)
+			if err != nil {
+				t.Fatalf("ParseQuery: %v", err)
+			}
+			if !reflect.DeepEqual(q.Groups(), tc.groups) {
+				t.Errorf("groups = %v, want %v", q.Groups(), tc.groups)
+			}
+			if !reflect.DeepEqual(q.Excluded(), tc.excluded) {
+				t.Errorf("excluded = %v, want %v", q.Excluded(), tc.excluded)
+			}
+		})
+	}
+}
+
+func TestMemorySearch(t *testing.T) {
+	b := newTestMemoryBackend(t)
+
+	tests := []struct {
+		name  string
+		query string
+		opts  ChatSearchOptions
+		want  []int
+	}{
+		{"word", "invoice", ChatSearchOptions{}, []int{1, 2, 3, 6}},
+		{"case insensitive", "INVOICE", ChatSearchOptions{}, []int{1, 2, 3, 6}},
+		{"fuzzy", "invoce", ChatSearchOptions{}, []int{1, 2, 3, 6}},
+		{"prefix", "pay*", ChatSearchOptions{}, []int{2, 4}},
+		{"phrase", `"next week"`, ChatSearchOptions{}, []int{1}},
+		{"phrase out of order", `"week next"`, ChatSearchOptions{}, []int{}},
+		{"exclusion", "invoice -draft", ChatSearchOptions{}, []int{1, 2, 6}},
+		{"or group", "lunch OR billing", ChatSearchOptions{}, []int{4, 5}},
+		{"every group", "invoice please OR draft", ChatSearchOptions{}, []int{2, 3}},
+		{"no match", "zebra", ChatSearchOptions{}, []int{}},
+
+		{"number range", "invoice", ChatSearchOptions{Filters: Filters{NumberFrom: 2, NumberTo: 3}}, []int{2, 3}},
+		{"created from", "invoice", ChatSearchOptions{Filters: Filters{CreatedFrom: memoryTestStart.Add(90 * time.Minute)}}, []int{3, 6}},
+		{"created to", "invoice", ChatSearchOptions{Filters: Filters{CreatedTo: memoryTestStart.Add(time.Hour)}}, []int{1, 2}},
+
+		{"newest", "invoice", ChatSearchOptions{Sort: SortNewest}, []int{6, 3, 2, 1}},
+		{"relevance", "pay", ChatSearchOptions{Sort: SortRelevance}, []int{2, 4}},
+		{"relevance newest", "pay", ChatSearchOptions{Sort: SortNewest}, []int{4, 2}},
+
+		{"page", "invoice", ChatSearchOptions{Page: 2, PerPage: 3}, []int{6}},
+		{"past the last page", "invoice", ChatSearchOptions{Page: 3, PerPage: 3}, []int{}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.opts.Sort == "" {
+				tc.opts.Sort = SortOldest
+			}
+			result, err := b.Search("app", 1, tc.query, tc.opts)
+			if err != nil {
+				t.Fatalf("Search: %v", err)
+			}
+			if got := messageNumbers(result.Messages); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Search(%q) = %v, want %v", tc.query, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMemorySearchHighlight(t *testing.T) {
+	b := newTestMemoryBackend(t)
+
+	tests := []struct {
+		query string
+		want  map[int]string
+	}{
+		{"invoice", map[int]string{
+			1: "[Invoice] for next week",
+			2: "Please pay the [invoice]",
+			6: "&lt;b&gt;[invoice]&lt;/b&gt; &amp; co",
+		}},
+		{`"next week"`, map[int]string{1: "Invoice for [next] [week]"}},
+		{"pay*", map[int]string{4: "Billing question about [payments]"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.query, func(t *testing.T) {
+			result, err := b.Search("app", 1, tc.query, ChatSearchOptions{
+				Sort:      SortOldest,
+				Highlight: &HighlightOptions{PreTag: "[", PostTag: "]"},
+			})
+			if err != nil {
+				t.Fatalf("Search: %v", err)
+			}
+			for _, hit := range result.Messages {
+				want, ok := tc.want[hit.MessageNumber]
+				if !ok {
+					continue
+				}
+				if len(hit.Highlights) != 1 || hit.Highlights[0] != want {
+					t.Errorf("message %d highlights = %q, want %q", hit.MessageNumber, hit.Highlights, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMemorySearchCursor(t *testing.T) {
+	b := newTestMemoryBackend(t)
+	opts := ChatSearchOptions{PerPage: 2, Sort: SortOldest}
+
+	var pages [][]int
+	for i := 0; ; i++ {
+		result, err := b.Search("app", 1, "invoice", opts)
+		if err != nil {
+			t.Fatalf("Search: %v", err)
+		}
+		pages = append(pages, messageNumbers(result.Messages))
+		if result.Total != 4 {
+			t.Errorf("total = %d, want 4", result.Total)
+		}
+		if result.NextCursor == "" {
+			break
+		}
+		if i > 3 {
+			t.Fatal("cursor never ends")
+		}
+		opts.Cursor = result.NextCursor
+	}
+	if want := [][]int{{1, 2}, {3, 6}}; !reflect.DeepEqual(pages, want) {
+		t.Errorf("pages = %v, want %v", pages, want)
+	}
+
+	first, _ := b.Search("app", 1, "invoice", ChatSearchOptions{PerPage: 2, Sort: SortOldest})
+	rejected := []struct {
+		name   string
+		query  string
+		cursor string
+		sort   SortMode
+	}{
+		{"not base64", "invoice", "%%%", SortOldest},
+		{"not a cursor", "invoice", "bm9wZQ", SortOldest},
+		{"other query", "billing", first.NextCursor, SortOldest},
+		{"other sort", "invoice", first.NextCursor, SortNewest},
+	}
+	for _, tc := range rejected {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := b.Search("app", 1, tc.query, ChatSearchOptions{PerPage: 2, Sort: tc.sort, Cursor: tc.cursor})
+			if !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("Search error = %v, want %v", err, ErrInvalidCursor)
+			}
+		})
+	}
+}
+
+func TestMemorySearchApplication(t *testing.T) {
+	b := newTestMemoryBackend(t)
+
+	result, err := b.SearchApplication("app", "invoice", ApplicationSearchOptions{Sort: SortOldest, Collapse: true, PerChat: 1}, 1, 20)
+	if err != nil {
+		t.Fatalf("SearchApplication: %v", err)
+	}
+	if result.Total != 5 || result.TotalChats != 2 {
+		t.Errorf("total = %d in %d chats, want 5 in 2", result.Total, result.TotalChats)
+	}
+	for _, chat := range result.Chats {
+		if len(chat.Messages) != 1 {
+			t.Errorf("chat %d has %d hits, want 1", chat.ChatNumber, len(chat.Messages))
+		}
+	}
+
+	result, err = b.SearchApplication("app", "invoice", ApplicationSearchOptions{Sort: SortOldest, ChatNumbers: []int{2}}, 1, 20)
+	if err != nil {
+		t.Fatalf("SearchApplication: %v", err)
+	}
+	if len(result.Messages) != 1 || result.Messages[0].ChatNumber != 2 {
+		t.Errorf("hits = %v, want only chat 2", result.Messages)
+	}
+}
+
+func TestMemoryDeletions(t *testing.T) {
+	tests := []struct {
+		name    string
+		drop    func(b *MemoryBackend) int
+		dropped int
+		chat1   []int
+		chat2   []int
+	}{
+		{
+			name:    "message",
+			drop:    func(b *MemoryBackend) int { _ = b.Delete("app", 1, 2); return 1 },
+			dropped: 1,
+			chat1:   []int{1, 3, 6},
+			chat2:   []int{1},
+		},
+		{
+			name:    "chat",
+			drop:    func(b *MemoryBackend) int { return b.DropChat("app", 2) },
+			dropped: 1,
+			chat1:   []int{1, 2, 3, 6},
+			chat2:   []int{},
+		},
+		{
+			name:    "application",
+			drop:    func(b *MemoryBackend) int { return b.DropApplication("app") },
+			dropped: 7,
+			chat1:   []int{},
+			chat2:   []int{},
+		},
+		{
+			name:    "retention",
+			drop:    func(b *MemoryBackend) int { return b.ExpireBefore("app", memoryTestStart.Add(2*time.Hour)) },
+			dropped: 3,
+			chat1:   []int{3, 6},
+			chat2:   []int{},
+		},
+		{
+			name:    "other application",
+			drop:    func(b *MemoryBackend) int { return b.DropApplication("other") },
+			dropped: 0,
+			chat1:   []int{1, 2, 3, 6},
+			chat2:   []int{1},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			b := newTestMemoryBackend(t)
+			if dropped := tc.drop(b); dropped != tc.dropped {
+				t.Errorf("dropped %d, want %d", dropped, tc.dropped)
+			}
+
+			for chatNumber, want := range map[int][]int{1: tc.chat1, 2: tc.chat2} {
+				result, err := b.Search("app", chatNumber, "invoice", ChatSearchOptions{Sort: SortOldest})
+				if err != nil {
+					t.Fatalf("Search: %v", err)
+				}
+				if got := messageNumbers(result.Messages); !reflect.DeepEqual(got, want) {
+					t.Errorf("chat %d = %v, want %v", chatNumber, got, want)
+				}
+			}
+		})
+	}
+}
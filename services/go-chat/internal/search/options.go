@@ -0,0 +1,59 @@
+package search
+
+import (
+	"fmt"
+	"time"
+)
+
+// SortMode is the order search results are returned in
+type SortMode string
+
+const (
+	SortRelevance SortMode = "relevance"
+	SortOldest    SortMode = "oldest"
+	SortNewest    SortMode = "newest"
+)
+
+func ParseSortMode(value string, fallback SortMode) (SortMode, error) {
+	switch mode := SortMode(value); mode {
+	case "":
+		return fallback, nil
+	case SortRelevance, SortOldest, SortNewest:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("sort must be one of relevance, oldest, newest")
+	}
+}
+
+// HighlightOptions turns on highlighted content fragments in search hits
+type HighlightOptions struct {
+	PreTag  string
+	PostTag string
+}
+
+// Filters narrows a search by creation time and message number. Zero values
+// leave that side of the range open.
+type Filters struct {
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	NumberFrom  int
+	NumberTo    int
+}
+
+// Match reports whether a message created at createdAt with number passes
+// the filters
+func (f Filters) Match(createdAt time.Time, number int) bool {
+	if !f.CreatedFrom.IsZero() && createdAt.Before(f.CreatedFrom) {
+		return false
+	}
+	if !f.CreatedTo.IsZero() && createdAt.After(f.CreatedTo) {
+		return false
+	}
+	if f.NumberFrom > 0 && number < f.NumberFrom {
+		return false
+	}
+	if f.NumberTo > 0 && number > f.NumberTo {
+		return false
+	}
+	return true
+}
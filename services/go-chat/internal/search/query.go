@@ -0,0 +1,203 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+const (
+	maxQueryTerms   = 32
+	maxTermLength   = 100
+	minPrefixLength = 2
+)
+
+// QueryError is a problem with the user's search syntax, reported as a 400
+type QueryError struct {
+	Message string
+}
+
+func (e *QueryError) Error() string {
+	return "invalid query: " + e.Message
+}
+
+func queryError(message string) error {
+	return &QueryError{Message: message}
+}
+
+type TermKind int
+
+const (
+	TermWord TermKind = iota
+	TermPhrase
+	TermPrefix
+)
+
+// Term is one searched word, phrase or prefix. Prefixes are lowercased and
+// stored without their '*'.
+type Term struct {
+	Kind TermKind
+	Text string
+}
+
+// Query is a parsed search expression. Whitespace separated terms must all
+// match, OR joins neighbouring terms into alternatives, a leading - excludes a
+// term, "quotes" match a phrase and a trailing * matches a prefix:
+//
+//	invoice "next week" -draft pay* OR billing*
+//
+// Backends only ever evaluate these terms, user input is never handed to a
+// query language of their own.
+type Query struct {
+	groups   [][]Term
+	excluded []Term
+}
+
+// Groups returns the terms that must all match, each group being a list of
+// OR alternatives
+func (q *Query) Groups() [][]Term {
+	return q.groups
+}
+
+// Excluded returns the terms no match may contain
+func (q *Query) Excluded() []Term {
+	return q.excluded
+}
+
+type token struct {
+	text    string
+	quoted  bool
+	negated bool
+	or      bool
+}
+
+func ParseQuery(input string) (*Query, error) {
+	tokens, err := tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) > maxQueryTerms {
+		return nil, queryError("too many terms")
+	}
+
+	q := &Query{}
+	pendingOr := false
+	afterPositive := false
+
+	for _, tok := range tokens {
+		if tok.or {
+			if !afterPositive || pendingOr {
+				return nil, queryError("OR must be placed between two terms")
+			}
+			pendingOr = true
+			continue
+		}
+
+		t, err := tok.term()
+		if err != nil {
+			return nil, err
+		}
+
+		if tok.negated {
+			if pendingOr {
+				return nil, queryError("an exclusion cannot be part of an OR")
+			}
+			q.excluded = append(q.excluded, t)
+			afterPositive = false
+			continue
+		}
+
+		if pendingOr {
+			last := len(q.groups) - 1
+			q.groups[last] = append(q.groups[last], t)
+			pendingOr = false
+		} else {
+			q.groups = append(q.groups, []Term{t})
+		}
+		afterPositive = true
+	}
+
+	if pendingOr {
+		return nil, queryError("OR must be followed by a term")
+	}
+	if len(q.groups) == 0 {
+		return nil, queryError("at least one term to match is required")
+	}
+
+	return q, nil
+}
+
+func tokenize(input string) ([]token, error) {
+	runes := []rune(input)
+	var tokens []token
+
+	for i := 0; i < len(runes); {
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+
+		negated := false
+		if runes[i] == '-' {
+			negated = true
+			i++
+			if i >= len(runes) || unicode.IsSpace(runes[i]) {
+				return nil, queryError("'-' must be followed by a term")
+			}
+		}
+
+		if runes[i] == '"' {
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			if end >= len(runes) {
+				return nil, queryError("unterminated quoted phrase")
+			}
+
+			phrase := strings.TrimSpace(string(runes[i+1 : end]))
+			if phrase == "" {
+				return nil, queryError("empty quoted phrase")
+			}
+			tokens = append(tokens, token{text: phrase, quoted: true, negated: negated})
+			i = end + 1
+			continue
+		}
+
+		start := i
+		for i < len(runes) && !unicode.IsSpace(runes[i]) && runes[i] != '"' {
+			i++
+		}
+		word := string(runes[start:i])
+
+		if word == "OR" && !negated {
+			tokens = append(tokens, token{or: true})
+			continue
+		}
+		tokens = append(tokens, token{text: word, negated: negated})
+	}
+
+	return tokens, nil
+}
+
+func (tok token) term() (Term, error) {
+	if len(tok.text) > maxTermLength {
+		return Term{}, queryError("terms must be at most 100 characters")
+	}
+
+	if tok.quoted {
+		return Term{Kind: TermPhrase, Text: tok.text}, nil
+	}
+
+	stem, isPrefix := strings.CutSuffix(tok.text, "*")
+	if strings.Contains(stem, "*") {
+		return Term{}, queryError("'*' is only supported at the end of a term")
+	}
+	if !isPrefix {
+		return Term{Kind: TermWord, Text: tok.text}, nil
+	}
+	if len([]rune(stem)) < minPrefixLength {
+		return Term{}, queryError("prefix searches need at least 2 characters before '*'")
+	}
+
+	return Term{Kind: TermPrefix, Text: strings.ToLower(stem)}, nil
+}
@@ -0,0 +1,93 @@
+package search
+
+import (
+	"errors"
+	"fmt"
+	"go-chat/internal/model"
+	"time"
+)
+
+// Backend stores and searches messages. Elasticsearch is the production
+// backend, MemoryBackend serves local development, tests and small deployments
+// from an index held in process.
+type Backend interface {
+	Index(message *model.Message) error
+	Bulk(messages []*model.Message) error
+	Delete(appToken string, chatNumber int, messageNumber int) error
+
+	Search(appToken string, chatNumber int, query string, opts ChatSearchOptions) (*ChatSearchResult, error)
+	SearchApplication(appToken string, query string, opts ApplicationSearchOptions, page int, pageSize int) (*ApplicationSearchResult, error)
+	Suggest(appToken string, chatNumber int, prefix string, opts SuggestOptions) ([]*model.MessageHit, error)
+
+	HealthCheck() error
+}
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrCursorExpired = errors.New("cursor expired")
+	ErrUnavailable   = errors.New("search is temporarily unavailable")
+)
+
+// UnavailableError is returned without reaching the backend while it is
+// considered down. RetryAfter is when it will be tried again.
+type UnavailableError struct {
+	RetryAfter time.Duration
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("%s, retry in %v", ErrUnavailable, e.RetryAfter.Round(time.Second))
+}
+
+func (e *UnavailableError) Unwrap() error {
+	return ErrUnavailable
+}
+
+type ChatSearchOptions struct {
+	Page    int
+	PerPage int
+	// Cursor continues a previous search past the 10,000 hits from/size
+	// pagination can reach
+	Cursor    string
+	Sort      SortMode
+	Filters   Filters
+	Highlight *HighlightOptions
+}
+
+type ChatSearchResult struct {
+	Messages   []*model.MessageHit
+	Total      int
+	NextCursor string
+	// Degraded results come from the MySQL fallback: no scores, highlights
+	// or cursors, and Total is capped
+	Degraded bool
+}
+
+type ApplicationSearchOptions struct {
+	// ChatNumbers restricts the search to these chats when not empty
+	ChatNumbers []int
+	// Collapse groups hits per chat, keeping the PerChat best of each
+	Collapse  bool
+	PerChat   int
+	Sort      SortMode
+	Filters   Filters
+	Highlight *HighlightOptions
+}
+
+// ChatHits is one chat's group of hits in a collapsed search
+type ChatHits struct {
+	ChatNumber int                 `json:"chat_number"`
+	Total      int                 `json:"total"`
+	Messages   []*model.MessageHit `json:"messages"`
+}
+
+type ApplicationSearchResult struct {
+	Total      int
+	TotalChats int
+	Messages   []*model.MessageHit
+	Chats      []*ChatHits
+}
+
+type SuggestOptions struct {
+	Size      int
+	Highlight *HighlightOptions
+}
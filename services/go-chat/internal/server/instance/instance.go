@@ -15,14 +15,14 @@ import (
 var counter uint64 = 0
 
 type Instance struct {
-	Config     *config.Config
-	Logger     *logging.Logger
-	Database   *database.Database
-	AMQPClient *queue.AMQP
-	reqSource  string
-	reqAgent   string
-	trace      string
-	closed     bool
+	Config    *config.Config
+	Logger    *logging.Logger
+	Database  *database.Database
+	Publisher queue.Publisher
+	reqSource string
+	reqAgent  string
+	trace     string
+	closed    bool
 }
 
 func newTrace(appname string) string {
@@ -31,14 +31,14 @@ func newTrace(appname string) string {
 	return fmt.Sprintf("%s.%08X.%08X", strings.ToUpper(appname), t.Unix(), id)
 }
 
-func New(config *config.Config, logger *logging.Logger, database *database.Database, amqpClient *queue.AMQP) *Instance {
+func New(config *config.Config, logger *logging.Logger, database *database.Database, publisher queue.Publisher) *Instance {
 	inst := &Instance{
-		Config:     config,
-		Logger:     logger,
-		Database:   database,
-		AMQPClient: amqpClient,
-		trace:      newTrace(config.AppName),
-		closed:     false,
+		Config:    config,
+		Logger:    logger,
+		Database:  database,
+		Publisher: publisher,
+		trace:     newTrace(config.AppName),
+		closed:    false,
 	}
 
 	return inst
@@ -5,12 +5,16 @@ import (
 	"go-chat/internal/config"
 	"go-chat/internal/logging"
 	"go-chat/internal/module/chat"
+	"go-chat/internal/ratelimit"
+	"go-chat/internal/search"
+	"os"
+	"os/signal"
 	"strings"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/middleware/limiter"
 	"github.com/gofiber/fiber/v2/middleware/monitor"
 	"github.com/gofiber/fiber/v2/middleware/requestid"
 )
@@ -18,6 +22,8 @@ import (
 type Server struct {
 	Config      *config.Config
 	ChatService *chat.Service
+	Limiter     *ratelimit.Limiter
+	SearchCache *search.Cache
 	fiberApp    *fiber.App
 	logger      *logging.Logger
 }
@@ -26,13 +32,40 @@ func (s *Server) Start() error {
 	host := s.Config.ListenAddr
 	port := s.Config.ListenPort
 
-	return s.fiberApp.Listen(fmt.Sprintf("%s:%d", host, port))
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- s.fiberApp.Listen(fmt.Sprintf("%s:%d", host, port))
+	}()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+
+	select {
+	case err := <-errChan:
+		s.ChatService.Stop()
+		return err
+	case <-sigChan:
+	}
+
+	s.logger.Info("shutting down")
+	// Streams never finish by themselves, Shutdown would wait on them forever
+	s.ChatService.CloseStreams()
+	err := s.fiberApp.ShutdownWithTimeout(s.Config.ShutdownTimeout)
+	if err != nil {
+		s.logger.Error("requests still running after %s: %v", s.Config.ShutdownTimeout, err)
+	}
+	// Requests have finished or been cut off, nothing else takes a leased
+	// number
+	s.ChatService.Stop()
+	return err
 }
 
-func NewServer(cfg *config.Config, logger *logging.Logger, chatService *chat.Service) *Server {
+func NewServer(cfg *config.Config, logger *logging.Logger, chatService *chat.Service, limiter *ratelimit.Limiter, searchCache *search.Cache) *Server {
 	server := &Server{
 		Config:      cfg,
 		ChatService: chatService,
+		Limiter:     limiter,
+		SearchCache: searchCache,
 		logger:      logger,
 		fiberApp:    fiber.New(),
 	}
@@ -54,20 +87,29 @@ func (s *Server) setupRoutes() {
 	s.fiberApp.Mount("/", chatRouter)
 }
 
+// setupRateLimiter installs the optional per-IP limit, per-application limits
+// are attached to the individual chat routes
 func (s *Server) setupRateLimiter() {
 	app := s.fiberApp
-	app.Use(limiter.New(limiter.Config{
-		Max:        10,
-		Expiration: 5 * time.Second,
-		LimitReached: func(c *fiber.Ctx) error {
-			return c.Status(fiber.StatusTooManyRequests).Send(nil)
-		},
-	}))
+	app.Use(s.Limiter.PerIP())
 }
 
 func (s *Server) setupLogger() {
 	app := s.fiberApp
 	app.Get("/metrics", monitor.New())
+	app.Get("/metrics/search-cache", func(c *fiber.Ctx) error {
+		return c.JSON(s.SearchCache.Stats())
+	})
+	app.Get("/metrics/deferrals", func(c *fiber.Ctx) error {
+		stats, err := s.ChatService.DeferralStats()
+		if err != nil {
+			s.logger.Error("failed to read deferral metrics: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "failed to read deferral metrics",
+			})
+		}
+		return c.JSON(stats)
+	})
 	app.Use(requestid.New())
 	// app.Use(logger.New(
 	// 	logger.Config{
@@ -77,6 +119,7 @@ func (s *Server) setupLogger() {
 	app.Use(func(c *fiber.Ctx) error {
 		trace := newTrace(s.Config.AppName)
 		reqLogger := s.logger.WithPrefix(trace)
+		c.Locals("trace", trace)
 		c.Locals("logger", reqLogger)
 		return c.Next()
 	})
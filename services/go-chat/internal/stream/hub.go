@@ -0,0 +1,166 @@
+package stream
+
+import (
+	"context"
+	"go-chat/internal/contract"
+	"go-chat/internal/database"
+	"go-chat/internal/logging"
+	"go-chat/internal/model"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const subscriptionBuffer = 64
+
+// Hub holds a single Redis pattern subscription for the whole process and fans
+// persisted messages out to the local subscribers of each chat
+type Hub struct {
+	redis  redis.UniversalClient
+	logger *logging.Logger
+
+	mu          sync.RWMutex
+	subscribers map[string]map[*Subscription]struct{}
+	listeners   []func(*model.Message)
+	closed      bool
+}
+
+// Subscription receives messages for one chat. C is closed when the
+// subscriber falls too far behind, clients are then expected to resume.
+type Subscription struct {
+	C       chan *model.Message
+	hub     *Hub
+	channel string
+	once    sync.Once
+}
+
+func NewHub(db *database.Database, logger *logging.Logger) *Hub {
+	h := &Hub{
+		redis:       db.RedisDB,
+		logger:      logger.WithPrefix("StreamHub"),
+		subscribers: make(map[string]map[*Subscription]struct{}),
+	}
+
+	go h.run()
+
+	return h
+}
+
+func (h *Hub) Subscribe(appToken string, chatNumber int) *Subscription {
+	sub := &Subscription{
+		C:       make(chan *model.Message, subscriptionBuffer),
+		hub:     h,
+		channel: contract.StreamChannel(appToken, chatNumber),
+	}
+
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		sub.once.Do(func() { close(sub.C) })
+		return sub
+	}
+	if h.subscribers[sub.channel] == nil {
+		h.subscribers[sub.channel] = make(map[*Subscription]struct{})
+	}
+	h.subscribers[sub.channel][sub] = struct{}{}
+	h.mu.Unlock()
+
+	return sub
+}
+
+// Listen calls fn with every message persisted in any chat, in the order they
+// arrive. fn runs on the hub's goroutine and must not block.
+func (h *Hub) Listen(fn func(*model.Message)) {
+	h.mu.Lock()
+	h.listeners = append(h.listeners, fn)
+	h.mu.Unlock()
+}
+
+// Close ends every subscription, and those made afterwards straight away, so
+// open streams finish and their clients resume on another instance
+func (h *Hub) Close() {
+	h.mu.Lock()
+	h.closed = true
+	var subs []*Subscription
+	for _, channel := range h.subscribers {
+		for sub := range channel {
+			subs = append(subs, sub)
+		}
+	}
+	h.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.Close()
+	}
+}
+
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		h := s.hub
+		h.mu.Lock()
+		delete(h.subscribers[s.channel], s)
+		if len(h.subscribers[s.channel]) == 0 {
+			delete(h.subscribers, s.channel)
+		}
+		h.mu.Unlock()
+		close(s.C)
+	})
+}
+
+func (h *Hub) run() {
+	pubsub := h.redis.PSubscribe(context.Background(), contract.StreamChannelPattern)
+	defer pubsub.Close()
+
+	h.logger.Info("Subscribed to %s", contract.StreamChannelPattern)
+	for msg := range pubsub.Channel() {
+		message, err := decodeMessage(msg.Payload)
+		if err != nil {
+			h.logger.Error("Dropping stream event on %s: %v", msg.Channel, err)
+			continue
+		}
+		h.dispatch(msg.Channel, message)
+	}
+}
+
+func (h *Hub) dispatch(channel string, message *model.Message) {
+	var lagging []*Subscription
+
+	h.mu.RLock()
+	for _, fn := range h.listeners {
+		fn(message)
+	}
+	for sub := range h.subscribers[channel] {
+		select {
+		case sub.C <- message:
+		default:
+			lagging = append(lagging, sub)
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, sub := range lagging {
+		h.logger.Error("Subscriber on %s is lagging, closing it", channel)
+		sub.Close()
+	}
+}
+
+func decodeMessage(raw string) (*model.Message, error) {
+	env, err := contract.Parse([]byte(raw))
+	if err != nil {
+		return nil, err
+	}
+
+	var payload contract.MessagePersisted
+	if err := env.Decode(&payload); err != nil {
+		return nil, err
+	}
+
+	return &model.Message{
+		ApplicationToken: payload.ApplicationToken,
+		ApplicationName:  payload.ApplicationName,
+		ChatNumber:       payload.ChatNumber,
+		MessageNumber:    payload.MessageNumber,
+		Content:          payload.Content,
+		CreatedAt:        payload.CreatedAt,
+	}, nil
+}
@@ -0,0 +1,95 @@
+package stream
+
+import (
+	"go-chat/internal/config"
+	"go-chat/internal/logging"
+	"go-chat/internal/model"
+	"testing"
+)
+
+// newTestHub builds a hub without its Redis subscription, messages are
+// handed to dispatch directly
+func newTestHub(t *testing.T) *Hub {
+	t.Helper()
+
+	logger := logging.NewLogger(&config.Config{AppName: "stream-test", LogPath: t.TempDir()})
+	return &Hub{
+		logger:      logger.WithPrefix("StreamHub"),
+		subscribers: make(map[string]map[*Subscription]struct{}),
+	}
+}
+
+func closed(sub *Subscription) bool {
+	select {
+	case _, ok := <-sub.C:
+		return !ok
+	default:
+		return false
+	}
+}
+
+func TestHubDispatch(t *testing.T) {
+	h := newTestHub(t)
+	sub := h.Subscribe("app", 1)
+	other := h.Subscribe("app", 2)
+	defer sub.Close()
+	defer other.Close()
+
+	h.dispatch(sub.channel, &model.Message{MessageNumber: 7})
+
+	select {
+	case msg := <-sub.C:
+		if msg.MessageNumber != 7 {
+			t.Errorf("message number = %d, want 7", msg.MessageNumber)
+		}
+	default:
+		t.Fatal("subscriber of the chat got nothing")
+	}
+	if len(other.C) != 0 {
+		t.Error("subscriber of another chat got the message")
+	}
+}
+
+func TestHubClosesLaggingSubscriber(t *testing.T) {
+	h := newTestHub(t)
+	sub := h.Subscribe("app", 1)
+
+	for i := 0; i <= subscriptionBuffer; i++ {
+		h.dispatch(sub.channel, &model.Message{MessageNumber: i + 1})
+	}
+
+	for range sub.C {
+	}
+	if _, ok := h.subscribers[sub.channel]; ok {
+		t.Error("lagging subscriber is still registered")
+	}
+}
+
+func TestHubClose(t *testing.T) {
+	h := newTestHub(t)
+	subs := []*Subscription{h.Subscribe("app", 1), h.Subscribe("app", 1), h.Subscribe("other", 3)}
+
+	h.Close()
+
+	for i, sub := range subs {
+		if !closed(sub) {
+			t.Errorf("subscription %d is still open", i)
+		}
+	}
+	if len(h.subscribers) != 0 {
+		t.Errorf("%d channels still registered", len(h.subscribers))
+	}
+
+	late := h.Subscribe("app", 1)
+	if !closed(late) {
+		t.Error("subscription made after Close is open")
+	}
+	if len(h.subscribers) != 0 {
+		t.Error("subscription made after Close was registered")
+	}
+
+	// Streams close their subscription on the way out
+	for _, sub := range append(subs, late) {
+		sub.Close()
+	}
+}
@@ -1,13 +1,18 @@
 package main
 
 import (
+	"fmt"
 	"go-worker/internal/config"
 	"go-worker/internal/database"
 	"go-worker/internal/elasticsearch"
 	"go-worker/internal/logging"
+	"go-worker/internal/migrate"
 	"go-worker/internal/queue"
+	"go-worker/internal/search"
 	"go-worker/internal/service"
 	"go-worker/internal/worker"
+	"os"
+	"strconv"
 
 	"go.uber.org/dig"
 )
@@ -18,16 +23,124 @@ func buildDigContainer() *dig.Container {
 	container.Provide(config.NewConfig)
 	container.Provide(logging.NewLogger)
 	container.Provide(database.ConnectDatabase)
-	container.Provide(queue.NewAMQP)
-	container.Provide(elasticsearch.NewClient)
-	container.Provide(queue.NewConsumer)
+	container.Provide(database.NewRepository)
+	container.Provide(newBroker)
+	container.Provide(newSearchBackend)
+	container.Provide(queue.NewRegistry)
 	container.Provide(worker.NewWorkers)
 	container.Provide(service.NewWorkerService)
 
 	return container
 }
 
+// newSearchBackend builds the search backend selected by SEARCH_BACKEND
+func newSearchBackend(cfg *config.Config, logger *logging.Logger) (search.Backend, error) {
+	switch cfg.SearchBackend {
+	case "elasticsearch":
+		return elasticsearch.NewClient(cfg, logger)
+	case "memory":
+		return search.NewMemoryBackend(), nil
+	default:
+		return nil, fmt.Errorf("unknown SEARCH_BACKEND %q", cfg.SearchBackend)
+	}
+}
+
+// newBroker builds the message broker selected by BROKER
+func newBroker(cfg *config.Config, db *database.Database, logger *logging.Logger) (queue.Broker, error) {
+	switch cfg.Broker {
+	case queue.BrokerRabbitMQ:
+		return queue.NewAMQP(logger, cfg)
+	case queue.BrokerRedis:
+		return queue.NewRedisStreams(db, cfg, logger), nil
+	case queue.BrokerMemory:
+		return queue.NewMemory(logger), nil
+	default:
+		return nil, fmt.Errorf("unknown BROKER %q", cfg.Broker)
+	}
+}
+
+// runMigrations handles `migrate [up|down [steps]|status|unlock|redis-keys]`.
+// It only needs MySQL, and Redis for redis-keys, so it runs without the rest
+// of the container.
+func runMigrations(args []string) error {
+	cfg, err := config.NewConfig()
+	if err != nil {
+		return err
+	}
+	logger := logging.NewLogger(cfg)
+
+	// Schema changes may run for long, whatever timeouts the workers use
+	pool := cfg.MySqlPrimaryPool
+	pool.ReadTimeout, pool.WriteTimeout = 0, 0
+	db, err := database.NewMySQLClient(cfg.MySqlDsn, pool)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrator, err := migrate.NewMigrator(db, logger)
+	if err != nil {
+		return err
+	}
+
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	switch command {
+	case "up":
+		count, err := migrator.Up()
+		logger.Info("Applied %d migrations", count)
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("steps must be a positive integer, got %q", args[1])
+			}
+		}
+		count, err := migrator.Down(steps)
+		logger.Info("Reverted %d migrations", count)
+		return err
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			state := "pending"
+			if status.Applied {
+				state = "applied"
+			}
+			fmt.Printf("%-8s %s_%s\n", state, status.Version, status.Name)
+		}
+		return nil
+	case "unlock":
+		return migrator.ForceUnlock()
+	case "redis-keys":
+		rdb, err := database.NewRedisClient(cfg)
+		if err != nil {
+			return err
+		}
+		defer rdb.Close()
+		count, err := migrate.NewRedisKeys(rdb, db, logger).Run()
+		logger.Info("Moved %d Redis keys to hash tagged names", count)
+		return err
+	default:
+		return fmt.Errorf("unknown migrate command %q, expected up, down, status, unlock or redis-keys", command)
+	}
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrations(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "migrate: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	var logger *logging.Logger
 	container := buildDigContainer()
 
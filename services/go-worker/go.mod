@@ -2,12 +2,17 @@ module go-worker
 
 go 1.24.6
 
+require (
+	github.com/alicebob/miniredis/v2 v2.37.0
+	github.com/go-redis/redis/v8 v8.11.5
+	github.com/go-sql-driver/mysql v1.9.3
+	github.com/rabbitmq/amqp091-go v1.10.0
+	go.uber.org/dig v1.19.0
+)
+
 require (
 	filippo.io/edwards25519 v1.1.0 // indirect
 	github.com/cespare/xxhash/v2 v2.1.2 // indirect
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
-	github.com/go-redis/redis/v8 v8.11.5 // indirect
-	github.com/go-sql-driver/mysql v1.9.3 // indirect
-	github.com/rabbitmq/amqp091-go v1.10.0 // indirect
-	go.uber.org/dig v1.19.0 // indirect
+	github.com/yuin/gopher-lua v1.1.1 // indirect
 )
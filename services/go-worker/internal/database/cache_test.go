@@ -0,0 +1,122 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRUCacheEviction(t *testing.T) {
+	tests := []struct {
+		name string
+		// ops are "set:<key>", "get:<key>" or "del:<key>", applied in order
+		ops     []string
+		present []string
+		absent  []string
+	}{
+		{
+			name:    "oldest goes first",
+			ops:     []string{"set:a", "set:b", "set:c", "set:d"},
+			present: []string{"b", "c", "d"},
+			absent:  []string{"a"},
+		},
+		{
+			name:    "a read keeps an entry",
+			ops:     []string{"set:a", "set:b", "set:c", "get:a", "set:d"},
+			present: []string{"a", "c", "d"},
+			absent:  []string{"b"},
+		},
+		{
+			name:    "a write keeps an entry",
+			ops:     []string{"set:a", "set:b", "set:c", "set:a", "set:d"},
+			present: []string{"a", "c", "d"},
+			absent:  []string{"b"},
+		},
+		{
+			name:    "a deletion frees a slot",
+			ops:     []string{"set:a", "set:b", "set:c", "del:b", "set:d"},
+			present: []string{"a", "c", "d"},
+			absent:  []string{"b"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newLRUCache[string](3, time.Minute)
+			for _, op := range tc.ops {
+				key := op[4:]
+				switch op[:3] {
+				case "set":
+					c.set(key, "value of "+key)
+				case "get":
+					c.get(key)
+				case "del":
+					c.delete(key)
+				}
+			}
+
+			for _, key := range tc.present {
+				if value, ok := c.get(key); !ok || value != "value of "+key {
+					t.Errorf("get(%q) = %q, %v, want it cached", key, value, ok)
+				}
+			}
+			for _, key := range tc.absent {
+				if _, ok := c.get(key); ok {
+					t.Errorf("get(%q) hit, want it evicted", key)
+				}
+			}
+			if n := c.order.Len(); n != len(c.entries) || n > 3 {
+				t.Errorf("list holds %d entries and map %d, want the same and at most 3", n, len(c.entries))
+			}
+		})
+	}
+}
+
+func TestLRUCacheTTL(t *testing.T) {
+	c := newLRUCache[int](10, 200*time.Millisecond)
+	c.set("old", 1)
+	time.Sleep(120 * time.Millisecond)
+	c.set("new", 2)
+
+	// Reading does not extend an entry, writing again does
+	c.get("old")
+	time.Sleep(120 * time.Millisecond)
+	if _, ok := c.get("old"); ok {
+		t.Error("entry outlived its TTL")
+	}
+	if _, ok := c.entries["old"]; ok {
+		t.Error("expired entry was not removed on read")
+	}
+	if value, ok := c.get("new"); !ok || value != 2 {
+		t.Errorf("get(new) = %d, %v, want 2 within its TTL", value, ok)
+	}
+
+	c.set("new", 3)
+	time.Sleep(120 * time.Millisecond)
+	if value, ok := c.get("new"); !ok || value != 3 {
+		t.Errorf("get(new) = %d, %v, want the rewritten entry to live on", value, ok)
+	}
+}
+
+func TestLRUCacheDisabled(t *testing.T) {
+	c := newLRUCache[int](0, time.Minute)
+	c.set("a", 1)
+	if _, ok := c.get("a"); ok {
+		t.Error("a cache of size 0 returned an entry")
+	}
+}
+
+func TestLRUCacheDeleteWhere(t *testing.T) {
+	c := newLRUCache[int](10, time.Minute)
+	for i, key := range []string{"a", "b", "c", "d"} {
+		c.set(key, i)
+	}
+	c.deleteWhere(func(value int) bool { return value%2 == 0 })
+
+	for key, want := range map[string]bool{"a": false, "b": true, "c": false, "d": true} {
+		if _, ok := c.get(key); ok != want {
+			t.Errorf("get(%q) present = %v, want %v", key, ok, want)
+		}
+	}
+	if c.order.Len() != 2 {
+		t.Errorf("list holds %d entries, want 2", c.order.Len())
+	}
+}
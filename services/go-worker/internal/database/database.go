@@ -9,29 +9,45 @@ import (
 )
 
 type Database struct {
-	RedisDB *redis.Client
+	RedisDB redis.UniversalClient
 	MySqlDB *sql.DB
+	// MySqlReplicas picks where read-only queries go, MySqlDB without replicas
+	MySqlReplicas *Replicas
 }
 
 func ConnectDatabase(logger *logging.Logger, cfg *config.Config) (*Database, error) {
 	logger.Info("Connecting to Redis")
-	redisDb, err := connectRedis(cfg.RedisURL)
+	redisDb, err := NewRedisClient(cfg)
 	if err != nil {
 		logger.Error("%s", err.Error())
 		return nil, err
 	}
-	logger.Info("Connected to Redis successfully")
+	if err := checkKeyScheme(redisDb); err != nil {
+		logger.Error("%s", err.Error())
+		return nil, err
+	}
+	logger.Info("Connected to Redis successfully, mode %s", cfg.RedisMode)
 
 	logger.Info("Connecting to MySQL")
-	mysqlDb, err := NewMySQLClient(cfg.MySqlDsn)
+	mysqlDb, err := NewMySQLClient(cfg.MySqlDsn, cfg.MySqlPrimaryPool)
 	if err != nil {
 		logger.Error("failed to connect to MySQL: %v", err)
 		return nil, err
 	}
 	logger.Info("Connected to MySQL successfully")
 
+	replicas, err := newReplicas(mysqlDb, cfg, logger)
+	if err != nil {
+		logger.Error("failed to set up MySQL replicas: %v", err)
+		return nil, err
+	}
+	if len(cfg.MySqlReplicaDsns) > 0 {
+		logger.Info("Reading from %d MySQL replicas", len(cfg.MySqlReplicaDsns))
+	}
+
 	return &Database{
-		RedisDB: redisDb,
-		MySqlDB: mysqlDb,
+		RedisDB:       redisDb,
+		MySqlDB:       mysqlDb,
+		MySqlReplicas: replicas,
 	}, nil
 }
@@ -2,26 +2,52 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
-	"time"
+	"go-worker/internal/config"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 )
 
-func NewMySQLClient(dsn string) (*sql.DB, error) {
-	db, err := sql.Open("mysql", dsn)
+func NewMySQLClient(dsn string, pool config.MySqlPool) (*sql.DB, error) {
+	db, err := openMySQL(dsn, pool)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %w", err)
+		return nil, err
 	}
 
-	// Connection pool settings
-	db.SetMaxOpenConns(25)
-	db.SetMaxIdleConns(5)
-	db.SetConnMaxLifetime(5 * time.Minute)
-
 	if err := db.Ping(); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
 	return db, nil
 }
+
+// openMySQL sets up a pool without connecting yet
+func openMySQL(dsn string, pool config.MySqlPool) (*sql.DB, error) {
+	parsed, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid database DSN: %w", err)
+	}
+	parsed.Timeout = pool.DialTimeout
+	parsed.ReadTimeout = pool.ReadTimeout
+	parsed.WriteTimeout = pool.WriteTimeout
+
+	db, err := sql.Open("mysql", parsed.FormatDSN())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	// Connection pool settings
+	db.SetMaxOpenConns(pool.MaxOpenConns)
+	db.SetMaxIdleConns(pool.MaxIdleConns)
+	db.SetConnMaxLifetime(pool.ConnMaxLifetime)
+
+	return db, nil
+}
+
+// IsDuplicateKey reports a write rejected by a unique index
+func IsDuplicateKey(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == 1062
+}
@@ -0,0 +1,77 @@
+package database
+
+import (
+	"errors"
+	"go-worker/internal/config"
+	"go-worker/internal/contract"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func TestCheckKeyScheme(t *testing.T) {
+	tests := []struct {
+		name string
+		keys map[string]string
+		want error
+	}{
+		{"empty Redis", nil, nil},
+		{"new layout", map[string]string{contract.ChatCounter("app"): "3"}, nil},
+		{"already migrated", map[string]string{contract.KeySchemeKey: contract.KeyScheme, "app:app:chats_count": "3"}, nil},
+		{"legacy counter", map[string]string{"app:app:chats_count": "3"}, errLegacyKeys},
+		{"legacy delta", map[string]string{"delta:chat:7:messages": "1"}, errLegacyKeys},
+		{"legacy tombstone", map[string]string{"tombstone:app:app": "1"}, errLegacyKeys},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			m := miniredis.RunT(t)
+			rdb := redis.NewClient(&redis.Options{Addr: m.Addr()})
+			t.Cleanup(func() { rdb.Close() })
+			for key, value := range tc.keys {
+				m.Set(key, value)
+			}
+
+			if err := checkKeyScheme(rdb); !errors.Is(err, tc.want) {
+				t.Fatalf("checkKeyScheme = %v, want %v", err, tc.want)
+			}
+			// A Redis found clean is marked so later starts skip the scan
+			scheme, _ := m.Get(contract.KeySchemeKey)
+			if marked := scheme == contract.KeyScheme; marked != (tc.want == nil) {
+				t.Errorf("%s = %q", contract.KeySchemeKey, scheme)
+			}
+		})
+	}
+}
+
+func TestNewRedisClient(t *testing.T) {
+	m := miniredis.RunT(t)
+
+	tests := []struct {
+		name string
+		cfg  config.Config
+		want string
+	}{
+		{"standalone", config.Config{RedisMode: RedisStandalone, RedisURL: "redis://" + m.Addr()}, ""},
+		{"sentinel without a master", config.Config{RedisMode: RedisSentinel, RedisAddrs: []string{m.Addr()}}, "REDIS_MASTER_NAME"},
+		{"cluster without nodes", config.Config{RedisMode: RedisCluster}, "REDIS_ADDRS"},
+		{"unknown mode", config.Config{RedisMode: "ring"}, `unknown REDIS_MODE "ring"`},
+		{"unreachable", config.Config{RedisMode: RedisStandalone, RedisURL: "redis://127.0.0.1:1"}, "failed to connect"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			client, err := NewRedisClient(&tc.cfg)
+			if tc.want == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				client.Close()
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("NewRedisClient error = %v, want one containing %q", err, tc.want)
+			}
+		})
+	}
+}
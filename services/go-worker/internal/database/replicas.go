@@ -0,0 +1,195 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"go-worker/internal/config"
+	"go-worker/internal/logging"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const replicaCheckInterval = 5 * time.Second
+
+// Replicas routes read-only queries to MySQL read replicas. Every replica is
+// checked every few seconds, one that cannot be reached, has replication
+// stopped or lags more than maxLag is left out until it recovers. Without a
+// usable replica reads go to the primary.
+//
+// Replicas only suit reads that tolerate being a little behind. A caller
+// that must see its own writes, or that takes a missing row as final, reads
+// the primary or falls back to it.
+type Replicas struct {
+	primary  *sql.DB
+	replicas []*replica
+	maxLag   time.Duration
+	logger   *logging.Logger
+	next     uint64
+	stopChan chan struct{}
+	stopOnce sync.Once
+}
+
+type replica struct {
+	db     *sql.DB
+	host   string
+	usable atomic.Bool
+}
+
+func newReplicas(primary *sql.DB, cfg *config.Config, logger *logging.Logger) (*Replicas, error) {
+	r := &Replicas{
+		primary:  primary,
+		maxLag:   cfg.MySqlReplicaMaxLag,
+		logger:   logger.WithPrefix("MySQLReplicas"),
+		stopChan: make(chan struct{}),
+	}
+
+	for i, dsn := range cfg.MySqlReplicaDsns {
+		db, err := openMySQL(dsn, cfg.MySqlReplicaPool)
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("replica %d: %w", i+1, err)
+		}
+		replica := &replica{db: db, host: replicaHost(dsn, i)}
+		// Taken as usable until the first check, so a failing one gets logged
+		replica.usable.Store(true)
+		r.replicas = append(r.replicas, replica)
+	}
+
+	if len(r.replicas) > 0 {
+		// A replica that is down at startup only means reads use the primary
+		r.check()
+		go r.monitor()
+	}
+
+	return r, nil
+}
+
+func replicaHost(dsn string, index int) string {
+	if parsed, err := mysql.ParseDSN(dsn); err == nil {
+		return parsed.Addr
+	}
+	return "replica " + strconv.Itoa(index+1)
+}
+
+// Reader returns a usable replica, taking turns between them, or the primary
+func (r *Replicas) Reader() *sql.DB {
+	if len(r.replicas) == 0 {
+		return r.primary
+	}
+
+	start := atomic.AddUint64(&r.next, 1)
+	for i := range r.replicas {
+		candidate := r.replicas[(start+uint64(i))%uint64(len(r.replicas))]
+		if candidate.usable.Load() {
+			return candidate.db
+		}
+	}
+	return r.primary
+}
+
+// Primary is where writes, and reads that cannot be behind, go
+func (r *Replicas) Primary() *sql.DB {
+	return r.primary
+}
+
+// Pools lists the primary and every replica
+func (r *Replicas) Pools() []*sql.DB {
+	pools := []*sql.DB{r.primary}
+	for _, replica := range r.replicas {
+		pools = append(pools, replica.db)
+	}
+	return pools
+}
+
+func (r *Replicas) monitor() {
+	ticker := time.NewTicker(replicaCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.check()
+		case <-r.stopChan:
+			return
+		}
+	}
+}
+
+func (r *Replicas) check() {
+	for _, replica := range r.replicas {
+		lag, err := replicationLag(replica.db)
+		usable := err == nil && lag <= r.maxLag
+
+		if usable != replica.usable.Load() {
+			switch {
+			case usable:
+				r.logger.Info("Replica %s is back, lag %s", replica.host, lag)
+			case err != nil:
+				r.logger.Error("Replica %s left out: %v", replica.host, err)
+			default:
+				r.logger.Error("Replica %s left out, lag %s over %s", replica.host, lag, r.maxLag)
+			}
+		}
+		replica.usable.Store(usable)
+	}
+}
+
+// replicationLag reads Seconds_Behind_Source, or Seconds_Behind_Master before
+// MySQL 8.0.22. The user needs the REPLICATION CLIENT privilege. A server
+// that is not a replica at all reports no lag.
+func replicationLag(db *sql.DB) (time.Duration, error) {
+	rows, err := db.Query("SHOW REPLICA STATUS")
+	if err != nil {
+		rows, err = db.Query("SHOW SLAVE STATUS")
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	if !rows.Next() {
+		return 0, rows.Err()
+	}
+
+	values := make([]sql.RawBytes, len(columns))
+	targets := make([]interface{}, len(columns))
+	for i := range values {
+		targets[i] = &values[i]
+	}
+	if err := rows.Scan(targets...); err != nil {
+		return 0, err
+	}
+
+	for i, column := range columns {
+		if column != "Seconds_Behind_Source" && column != "Seconds_Behind_Master" {
+			continue
+		}
+		if values[i] == nil {
+			return 0, fmt.Errorf("replication is not running")
+		}
+		seconds, err := strconv.Atoi(string(values[i]))
+		if err != nil {
+			return 0, fmt.Errorf("unexpected %s %q", column, values[i])
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return 0, fmt.Errorf("replica status has no lag column")
+}
+
+// Close stops the checks and closes the replica pools
+func (r *Replicas) Close() {
+	r.stopOnce.Do(func() {
+		close(r.stopChan)
+		for _, replica := range r.replicas {
+			replica.db.Close()
+		}
+	})
+}
@@ -0,0 +1,76 @@
+package database
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func newTestReplicas(t *testing.T, usable ...bool) (*Replicas, []*sql.DB) {
+	t.Helper()
+
+	// Nothing is dialled until a query runs
+	open := func() *sql.DB {
+		db, err := sql.Open("mysql", "user@tcp(127.0.0.1:1)/chat")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		return db
+	}
+
+	r := &Replicas{primary: open(), stopChan: make(chan struct{})}
+	var dbs []*sql.DB
+	for _, ok := range usable {
+		replica := &replica{db: open()}
+		replica.usable.Store(ok)
+		r.replicas = append(r.replicas, replica)
+		dbs = append(dbs, replica.db)
+	}
+	return r, dbs
+}
+
+func TestReplicasReader(t *testing.T) {
+	tests := []struct {
+		name   string
+		usable []bool
+		// want are the indexes of the replicas read in turn, -1 the primary
+		want []int
+	}{
+		{"no replicas", nil, []int{-1, -1}},
+		{"taking turns", []bool{true, true}, []int{1, 0, 1, 0}},
+		{"lagging replica left out", []bool{true, false, true}, []int{2, 2, 0, 2}},
+		{"none usable", []bool{false, false}, []int{-1, -1}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r, dbs := newTestReplicas(t, tc.usable...)
+			for i, want := range tc.want {
+				expected := r.Primary()
+				if want >= 0 {
+					expected = dbs[want]
+				}
+				if got := r.Reader(); got != expected {
+					t.Errorf("read %d went to the wrong pool, want %d", i+1, want)
+				}
+			}
+			if pools := r.Pools(); len(pools) != len(tc.usable)+1 {
+				t.Errorf("Pools lists %d, want the primary and %d replicas", len(pools), len(tc.usable))
+			}
+		})
+	}
+}
+
+func TestReplicaHost(t *testing.T) {
+	tests := []struct {
+		dsn  string
+		want string
+	}{
+		{"reader:secret@tcp(replica-1:3306)/chat", "replica-1:3306"},
+		{"not a dsn", "replica 3"},
+	}
+	for _, tc := range tests {
+		if got := replicaHost(tc.dsn, 2); got != tc.want {
+			t.Errorf("replicaHost(%q) = %q, want %q", tc.dsn, got, tc.want)
+		}
+	}
+}
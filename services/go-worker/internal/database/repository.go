@@ -1,26 +1,221 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"go-worker/internal/config"
 	"go-worker/internal/model"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var (
+	ErrApplicationNotFound = errors.New("application not found")
+	ErrChatNotFound        = errors.New("chat not found")
 )
 
-// Repository provides database operations
+// Repository provides database operations. One instance is shared by every
+// worker: the statements run for each delivery are prepared once per pool,
+// and application and chat lookups are cached in process for a short while.
+// Applications are also cached in Redis for applicationTTL, shared by all
+// go-worker instances. Rails drops that entry when an application changes.
+// Deletions are broadcast on cacheInvalidationChannel so every instance
+// forgets the application or chat at once. Lookups and the gap detection
+// scans read from replicas when there are any.
 type Repository struct {
-	db *sql.DB
+	db       *sql.DB
+	replicas *Replicas
+	redis    redis.UniversalClient
+
+	createMessage *sql.Stmt
+	mu            sync.Mutex
+	lookups       map[*sql.DB]*lookups
+
+	applications   *lruCache[model.Application]
+	chats          *lruCache[model.Chat]
+	applicationTTL time.Duration
+	invalidations  *redis.PubSub
+}
+
+// cacheInvalidationChannel carries "app:<id>:<token>" and "chat:<application
+// id>:<number>" for the applications and chats every go-worker instance has
+// to drop from its in-process caches. The id is 0 when the deleting instance
+// did not know it.
+const cacheInvalidationChannel = "worker:cache:invalidate"
+
+// lookups are the reads run for each delivery, prepared on one pool
+type lookups struct {
+	findApplication *sql.Stmt
+	findChat        *sql.Stmt
+	findMessage     *sql.Stmt
+}
+
+func NewRepository(db *Database, cfg *config.Config) (*Repository, error) {
+	r := &Repository{
+		db:             db.MySqlDB,
+		replicas:       db.MySqlReplicas,
+		redis:          db.RedisDB,
+		lookups:        make(map[*sql.DB]*lookups),
+		applications:   newLRUCache[model.Application](cfg.RepositoryCacheSize, cfg.RepositoryCacheTTL),
+		chats:          newLRUCache[model.Chat](cfg.RepositoryCacheSize, cfg.RepositoryCacheTTL),
+		applicationTTL: cfg.ApplicationCacheTTL,
+	}
+
+	createMessage, err := r.db.Prepare("INSERT INTO messages (chat_id, number, content, created_at, updated_at) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare message insert: %w", err)
+	}
+	r.createMessage = createMessage
+
+	// Replicas are prepared on first use, they may well be down right now
+	if _, err := r.lookupsOn(r.db); err != nil {
+		r.Close()
+		return nil, err
+	}
+
+	// Subscribed before any lookup is cached, so no deletion is missed.
+	// While the subscription reconnects, the tombstones still stop writes.
+	r.invalidations = r.redis.Subscribe(context.Background(), cacheInvalidationChannel)
+	if _, err := r.invalidations.Receive(context.Background()); err != nil {
+		r.Close()
+		return nil, fmt.Errorf("failed to subscribe to cache invalidations: %w", err)
+	}
+	go r.listenInvalidations(r.invalidations.Channel())
+
+	return r, nil
+}
+
+func (r *Repository) listenInvalidations(messages <-chan *redis.Message) {
+	for msg := range messages {
+		kind, rest, _ := strings.Cut(msg.Payload, ":")
+		switch kind {
+		case "app":
+			id, token, _ := strings.Cut(rest, ":")
+			applicationID, _ := strconv.ParseUint(id, 10, 64)
+			r.dropApplication(token, uint(applicationID))
+		case "chat":
+			var applicationID uint
+			var number int
+			if _, err := fmt.Sscanf(rest, "%d:%d", &applicationID, &number); err == nil {
+				r.chats.delete(chatCacheKey(applicationID, number))
+			}
+		}
+	}
+}
+
+func (r *Repository) lookupsOn(pool *sql.DB) (*lookups, error) {
+	r.mu.Lock()
+	l, ok := r.lookups[pool]
+	r.mu.Unlock()
+	if ok {
+		return l, nil
+	}
+
+	// Prepared without the lock, other pools stay usable meanwhile
+	l = &lookups{}
+	statements := []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&l.findApplication, "SELECT id, token, name, chats_count, created_at, updated_at FROM applications WHERE token = ?"},
+		{&l.findChat, "SELECT id, application_id, number, messages_count, created_at, updated_at FROM chats WHERE application_id = ? AND number = ?"},
+		{&l.findMessage, "SELECT id, chat_id, number, content, created_at, updated_at FROM messages WHERE chat_id = ? AND number = ?"},
+	}
+	for _, s := range statements {
+		stmt, err := pool.Prepare(s.query)
+		if err != nil {
+			l.close()
+			return nil, fmt.Errorf("failed to prepare %q: %w", s.query, err)
+		}
+		*s.stmt = stmt
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if existing, ok := r.lookups[pool]; ok {
+		l.close()
+		return existing, nil
+	}
+	r.lookups[pool] = l
+	return l, nil
+}
+
+func (l *lookups) close() {
+	for _, stmt := range []*sql.Stmt{l.findApplication, l.findChat, l.findMessage} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+}
+
+// queryRow runs a lookup on a replica when one is usable, and on the primary
+// when the replica fails. With primaryIfMissing, a row the replica does not
+// have is looked for on the primary too, it may not have replicated yet.
+func (r *Repository) queryRow(pick func(*lookups) *sql.Stmt, primaryIfMissing bool, args []interface{}, dest ...interface{}) error {
+	if reader := r.replicas.Reader(); reader != r.db {
+		if l, err := r.lookupsOn(reader); err == nil {
+			err := pick(l).QueryRow(args...).Scan(dest...)
+			if err == nil || (err == sql.ErrNoRows && !primaryIfMissing) {
+				return err
+			}
+		}
+	}
+
+	l, err := r.lookupsOn(r.db)
+	if err != nil {
+		return err
+	}
+	return pick(l).QueryRow(args...).Scan(dest...)
+}
+
+// Close releases the prepared statements
+func (r *Repository) Close() {
+	if r.invalidations != nil {
+		r.invalidations.Close()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, l := range r.lookups {
+		l.close()
+	}
+	if r.createMessage != nil {
+		r.createMessage.Close()
+	}
+}
+
+// ApplicationCacheKey is the Redis entry holding an application's metadata
+// for go-worker
+func ApplicationCacheKey(token string) string {
+	return fmt.Sprintf("worker:application:%s", token)
 }
 
-func NewRepository(db *sql.DB) *Repository {
-	return &Repository{db: db}
+func chatCacheKey(applicationID uint, number int) string {
+	return fmt.Sprintf("%d:%d", applicationID, number)
 }
 
 func (r *Repository) FindApplicationByToken(token string) (*model.Application, error) {
+	if app, ok := r.applications.get(token); ok {
+		return &app, nil
+	}
+
+	ctx := context.Background()
 	var app model.Application
-	query := "SELECT id, token, name, chats_count, created_at, updated_at FROM applications WHERE token = ?"
+	if raw, err := r.redis.Get(ctx, ApplicationCacheKey(token)).Bytes(); err == nil && json.Unmarshal(raw, &app) == nil {
+		r.applications.set(token, app)
+		return &app, nil
+	}
 
-	err := r.db.QueryRow(query, token).Scan(
+	// Missing on a replica may only mean the application was just created
+	err := r.queryRow(func(l *lookups) *sql.Stmt { return l.findApplication }, true, []interface{}{token},
 		&app.ID,
 		&app.Token,
 		&app.Name,
@@ -30,20 +225,29 @@ func (r *Repository) FindApplicationByToken(token string) (*model.Application, e
 	)
 
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("application not found")
+		return nil, ErrApplicationNotFound
 	}
 	if err != nil {
 		return nil, err
 	}
 
+	// Counts in the cached copy go stale, nothing in go-worker reads them
+	if raw, err := json.Marshal(&app); err == nil {
+		r.redis.Set(ctx, ApplicationCacheKey(token), raw, r.applicationTTL)
+	}
+	r.applications.set(token, app)
+
 	return &app, nil
 }
 
 func (r *Repository) FindChatByApplicationAndNumber(applicationID uint, number int) (*model.Chat, error) {
-	var chat model.Chat
-	query := "SELECT id, application_id, number, messages_count, created_at, updated_at FROM chats WHERE application_id = ? AND number = ?"
+	key := chatCacheKey(applicationID, number)
+	if chat, ok := r.chats.get(key); ok {
+		return &chat, nil
+	}
 
-	err := r.db.QueryRow(query, applicationID, number).Scan(
+	var chat model.Chat
+	err := r.queryRow(func(l *lookups) *sql.Stmt { return l.findChat }, true, []interface{}{applicationID, number},
 		&chat.ID,
 		&chat.ApplicationID,
 		&chat.Number,
@@ -52,21 +256,62 @@ func (r *Repository) FindChatByApplicationAndNumber(applicationID uint, number i
 		&chat.UpdatedAt,
 	)
 
+	// Missing chats are not remembered, they are usually about to be created
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("chat not found")
+		return nil, ErrChatNotFound
 	}
 	if err != nil {
 		return nil, err
 	}
 
+	r.chats.set(key, chat)
 	return &chat, nil
 }
 
+// ForgetApplication drops a deleted application, and its chats, from the
+// caches of every go-worker instance
+func (r *Repository) ForgetApplication(token string) error {
+	var applicationID uint
+	if app, ok := r.applications.get(token); ok {
+		applicationID = app.ID
+	}
+	r.dropApplication(token, applicationID)
+
+	ctx := context.Background()
+	if err := r.redis.Del(ctx, ApplicationCacheKey(token)).Err(); err != nil {
+		return err
+	}
+	payload := fmt.Sprintf("app:%d:%s", applicationID, token)
+	return r.redis.Publish(ctx, cacheInvalidationChannel, payload).Err()
+}
+
+// dropApplication forgets an application and the chats of applicationID, or
+// of the cached application's ID when that is 0
+func (r *Repository) dropApplication(token string, applicationID uint) {
+	if app, ok := r.applications.get(token); ok && applicationID == 0 {
+		applicationID = app.ID
+	}
+	r.applications.delete(token)
+	if applicationID != 0 {
+		r.chats.deleteWhere(func(chat model.Chat) bool {
+			return chat.ApplicationID == applicationID
+		})
+	}
+}
+
+// ForgetChat drops a deleted chat from the caches of every go-worker instance
+func (r *Repository) ForgetChat(applicationID uint, number int) error {
+	r.chats.delete(chatCacheKey(applicationID, number))
+
+	payload := fmt.Sprintf("chat:%d:%d", applicationID, number)
+	return r.redis.Publish(context.Background(), cacheInvalidationChannel, payload).Err()
+}
+
 func (r *Repository) FindMessageByChatAndNumber(chatID uint, number int) (*model.Message, error) {
 	var message model.Message
-	query := "SELECT id, chat_id, number, content, created_at, updated_at FROM messages WHERE chat_id = ? AND number = ?"
-
-	err := r.db.QueryRow(query, chatID, number).Scan(
+	// A message the replica misses is inserted again and rejected by the
+	// unique index, not worth a second query for every new message
+	err := r.queryRow(func(l *lookups) *sql.Stmt { return l.findMessage }, false, []interface{}{chatID, number},
 		&message.ID,
 		&message.ChatID,
 		&message.Number,
@@ -85,32 +330,45 @@ func (r *Repository) FindMessageByChatAndNumber(chatID uint, number int) (*model
 	return &message, nil
 }
 
-func (r *Repository) CreateChat(chat *model.Chat) error {
-	query := "INSERT INTO chats (application_id, number, messages_count, created_at, updated_at) VALUES (?, ?, ?, ?, ?)"
+// CreateChatIfMissing inserts a chat unless its number already exists. Both
+// ChatWorker and MessageWorker create chats. The unique index on
+// (application_id, number) rejects the loser of a concurrent insert, or the
+// existence check's shared locks make it fail with a deadlock instead.
+func (r *Repository) CreateChatIfMissing(chat *model.Chat) (bool, error) {
+	query := `INSERT INTO chats (application_id, number, messages_count, created_at, updated_at)
+		SELECT ?, ?, ?, ?, ? FROM DUAL
+		WHERE NOT EXISTS (SELECT 1 FROM chats WHERE application_id = ? AND number = ?)`
 
 	now := time.Now()
-	result, err := r.db.Exec(query, chat.ApplicationID, chat.Number, chat.MessagesCount, now, now)
+	result, err := r.db.Exec(query, chat.ApplicationID, chat.Number, chat.MessagesCount, now, now,
+		chat.ApplicationID, chat.Number)
+	if IsDuplicateKey(err) {
+		return false, nil
+	}
 	if err != nil {
-		return err
+		return false, err
+	}
+
+	inserted, err := result.RowsAffected()
+	if err != nil || inserted == 0 {
+		return false, err
 	}
 
 	id, err := result.LastInsertId()
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	chat.ID = uint(id)
 	chat.CreatedAt = now
 	chat.UpdatedAt = now
 
-	return nil
+	return true, nil
 }
 
 func (r *Repository) CreateMessage(message *model.Message) error {
-	query := "INSERT INTO messages (chat_id, number, content, created_at, updated_at) VALUES (?, ?, ?, ?, ?)"
-
 	now := time.Now()
-	result, err := r.db.Exec(query, message.ChatID, message.Number, message.Content, now, now)
+	result, err := r.createMessage.Exec(message.ChatID, message.Number, message.Content, now, now)
 	if err != nil {
 		return err
 	}
@@ -138,3 +396,351 @@ func (r *Repository) IncrementChatMessageCount(chatID uint, delta int) error {
 	_, err := r.db.Exec(query, delta, chatID)
 	return err
 }
+
+// FindApplicationsNeedingSearchIndex lists applications whose language or
+// synonyms call for a dedicated index they are not on yet
+func (r *Repository) FindApplicationsNeedingSearchIndex(indexPrefix string) ([]*model.SearchSettings, error) {
+	query := `SELECT a.id, a.token, a.language, COALESCE(a.search_index, '')
+		FROM applications a
+		WHERE (a.language <> 'standard' OR EXISTS (SELECT 1 FROM synonym_rules s WHERE s.application_id = a.id))
+		AND (a.search_index IS NULL OR a.search_index <> CONCAT(?, a.token, '-', a.language))`
+
+	rows, err := r.db.Query(query, indexPrefix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var settings []*model.SearchSettings
+	for rows.Next() {
+		var s model.SearchSettings
+		if err := rows.Scan(&s.ApplicationID, &s.Token, &s.Language, &s.SearchIndex); err != nil {
+			return nil, err
+		}
+		settings = append(settings, &s)
+	}
+
+	return settings, rows.Err()
+}
+
+func (r *Repository) FindApplicationSearchIndex(token string) (string, error) {
+	var index string
+	query := "SELECT COALESCE(search_index, '') FROM applications WHERE token = ?"
+
+	err := r.db.QueryRow(query, token).Scan(&index)
+	if err == sql.ErrNoRows {
+		return "", ErrApplicationNotFound
+	}
+	return index, err
+}
+
+func (r *Repository) SetApplicationSearchIndex(appID uint, index string) error {
+	query := "UPDATE applications SET search_index = ? WHERE id = ?"
+	_, err := r.db.Exec(query, index, appID)
+	return err
+}
+
+func (r *Repository) FindSynonymRules(appID uint) ([]*model.SynonymRule, error) {
+	rows, err := r.db.Query("SELECT id, synonyms FROM synonym_rules WHERE application_id = ? ORDER BY id", appID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []*model.SynonymRule
+	for rows.Next() {
+		var rule model.SynonymRule
+		if err := rows.Scan(&rule.ID, &rule.Synonyms); err != nil {
+			return nil, err
+		}
+		rules = append(rules, &rule)
+	}
+
+	return rules, rows.Err()
+}
+
+func (r *Repository) FindRetentionPolicies() ([]*model.RetentionPolicy, error) {
+	query := "SELECT id, token, retention_days, COALESCE(search_index, '') FROM applications WHERE retention_days IS NOT NULL"
+
+	rows, err := r.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var policies []*model.RetentionPolicy
+	for rows.Next() {
+		var p model.RetentionPolicy
+		if err := rows.Scan(&p.ApplicationID, &p.Token, &p.RetentionDays, &p.SearchIndex); err != nil {
+			return nil, err
+		}
+		policies = append(policies, &p)
+	}
+
+	return policies, rows.Err()
+}
+
+// DeleteExpiredMessages deletes up to limit of an application's messages
+// created before cutoff, oldest first, and reports how many each chat lost
+func (r *Repository) DeleteExpiredMessages(appID uint, cutoff time.Time, limit int) ([]*model.ExpiredMessages, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT m.id, c.id, c.number FROM messages m
+		JOIN chats c ON c.id = m.chat_id
+		WHERE c.application_id = ? AND m.created_at < ?
+		ORDER BY m.id LIMIT ? FOR UPDATE`, appID, cutoff, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []interface{}
+	byChat := make(map[uint]*model.ExpiredMessages)
+	var expired []*model.ExpiredMessages
+	for rows.Next() {
+		var messageID uint
+		var chat model.ExpiredMessages
+		if err := rows.Scan(&messageID, &chat.ChatID, &chat.ChatNumber); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, messageID)
+
+		if byChat[chat.ChatID] == nil {
+			byChat[chat.ChatID] = &chat
+			expired = append(expired, &chat)
+		}
+		byChat[chat.ChatID].Count++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	if _, err := tx.Exec("DELETE FROM messages WHERE id IN ("+placeholders+")", ids...); err != nil {
+		return nil, err
+	}
+
+	return expired, tx.Commit()
+}
+
+func (r *Repository) CreateRetentionPurge(purge *model.RetentionPurge) error {
+	query := `INSERT INTO retention_purges
+		(application_id, cutoff, status, messages_deleted, chats_affected, started_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+
+	now := time.Now()
+	result, err := r.db.Exec(query, purge.ApplicationID, purge.Cutoff, purge.Status,
+		purge.MessagesDeleted, purge.ChatsAffected, purge.StartedAt, now, now)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	purge.ID = uint(id)
+
+	return nil
+}
+
+func (r *Repository) UpdateRetentionPurge(purge *model.RetentionPurge) error {
+	query := `UPDATE retention_purges SET status = ?, messages_deleted = ?, chats_affected = ?,
+		documents_deleted = ?, error = NULLIF(?, ''), finished_at = ?, updated_at = ? WHERE id = ?`
+
+	var finishedAt interface{}
+	if !purge.FinishedAt.IsZero() {
+		finishedAt = purge.FinishedAt
+	}
+
+	_, err := r.db.Exec(query, purge.Status, purge.MessagesDeleted, purge.ChatsAffected,
+		purge.DocumentsDeleted, purge.Error, finishedAt, time.Now(), purge.ID)
+	return err
+}
+
+// FindChatsByApplication returns the id and number of every chat of an
+// application
+func (r *Repository) FindChatsByApplication(appID uint) ([]*model.Chat, error) {
+	rows, err := r.db.Query("SELECT id, number FROM chats WHERE application_id = ?", appID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chats []*model.Chat
+	for rows.Next() {
+		chat := model.Chat{ApplicationID: appID}
+		if err := rows.Scan(&chat.ID, &chat.Number); err != nil {
+			return nil, err
+		}
+		chats = append(chats, &chat)
+	}
+
+	return chats, rows.Err()
+}
+
+// DeleteChatMessages deletes up to limit of a chat's messages and reports how
+// many were deleted
+func (r *Repository) DeleteChatMessages(chatID uint, limit int) (int, error) {
+	result, err := r.db.Exec("DELETE FROM messages WHERE chat_id = ? LIMIT ?", chatID, limit)
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := result.RowsAffected()
+	return int(deleted), err
+}
+
+// DeleteChat deletes a chat row, its messages have to be gone already
+func (r *Repository) DeleteChat(chatID uint) error {
+	_, err := r.db.Exec("DELETE FROM chats WHERE id = ?", chatID)
+	return err
+}
+
+// DeleteApplication deletes an application row with its API keys, synonym
+// rules, retention audit records and number gaps, its chats have to be gone
+// already. It returns the prefixes of the deleted API keys.
+func (r *Repository) DeleteApplication(appID uint) ([]string, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT prefix FROM api_keys WHERE application_id = ? FOR UPDATE", appID)
+	if err != nil {
+		return nil, err
+	}
+	var prefixes []string
+	for rows.Next() {
+		var prefix string
+		if err := rows.Scan(&prefix); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, table := range []string{"api_keys", "synonym_rules", "retention_purges", "number_gaps"} {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE application_id = ?", appID); err != nil {
+			return nil, fmt.Errorf("failed to delete %s: %w", table, err)
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM applications WHERE id = ?", appID); err != nil {
+		return nil, err
+	}
+
+	return prefixes, tx.Commit()
+}
+
+func (r *Repository) FindGapScopes() ([]*model.GapScope, error) {
+	rows, err := r.replicas.Reader().Query("SELECT id, token, retention_days IS NOT NULL FROM applications")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var scopes []*model.GapScope
+	for rows.Next() {
+		var scope model.GapScope
+		if err := rows.Scan(&scope.ApplicationID, &scope.Token, &scope.Retained); err != nil {
+			return nil, err
+		}
+		scopes = append(scopes, &scope)
+	}
+
+	return scopes, rows.Err()
+}
+
+// CountChatMessages returns how many messages a chat has and the lowest
+// message number among them
+func (r *Repository) CountChatMessages(chatID uint) (int, int, error) {
+	var count, lowest int
+	err := r.replicas.Reader().QueryRow("SELECT COUNT(*), COALESCE(MIN(number), 0) FROM messages WHERE chat_id = ?", chatID).
+		Scan(&count, &lowest)
+	return count, lowest, err
+}
+
+// FindMessageNumbers returns a chat's message numbers in ascending order
+func (r *Repository) FindMessageNumbers(chatID uint) ([]int, error) {
+	rows, err := r.replicas.Reader().Query("SELECT number FROM messages WHERE chat_id = ? ORDER BY number", chatID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var numbers []int
+	for rows.Next() {
+		var number int
+		if err := rows.Scan(&number); err != nil {
+			return nil, err
+		}
+		numbers = append(numbers, number)
+	}
+
+	return numbers, rows.Err()
+}
+
+func (r *Repository) FindNumberGaps(appID uint) ([]*model.NumberGap, error) {
+	query := `SELECT id, application_id, chat_number, message_number, cause, first_seen_at, last_seen_at, tombstoned_at
+		FROM number_gaps WHERE application_id = ?`
+
+	rows, err := r.db.Query(query, appID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var gaps []*model.NumberGap
+	for rows.Next() {
+		var gap model.NumberGap
+		var tombstonedAt sql.NullTime
+		if err := rows.Scan(&gap.ID, &gap.ApplicationID, &gap.ChatNumber, &gap.MessageNumber, &gap.Cause,
+			&gap.FirstSeenAt, &gap.LastSeenAt, &tombstonedAt); err != nil {
+			return nil, err
+		}
+		if tombstonedAt.Valid {
+			gap.TombstonedAt = &tombstonedAt.Time
+		}
+		gaps = append(gaps, &gap)
+	}
+
+	return gaps, rows.Err()
+}
+
+// SaveNumberGap records a gap, or refreshes the cause and last sighting of
+// one recorded before
+func (r *Repository) SaveNumberGap(gap *model.NumberGap) error {
+	query := `INSERT INTO number_gaps
+		(application_id, chat_number, message_number, cause, first_seen_at, last_seen_at, tombstoned_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE cause = VALUES(cause), last_seen_at = VALUES(last_seen_at),
+			tombstoned_at = VALUES(tombstoned_at), updated_at = VALUES(updated_at)`
+
+	now := time.Now()
+	_, err := r.db.Exec(query, gap.ApplicationID, gap.ChatNumber, gap.MessageNumber, gap.Cause,
+		gap.FirstSeenAt, gap.LastSeenAt, gap.TombstonedAt, now, now)
+	return err
+}
+
+func (r *Repository) DeleteNumberGap(gapID uint) error {
+	_, err := r.db.Exec("DELETE FROM number_gaps WHERE id = ?", gapID)
+	return err
+}
+
+// DeleteChatNumberGaps forgets the gaps of a deleted chat
+func (r *Repository) DeleteChatNumberGaps(appID uint, chatNumber int) error {
+	_, err := r.db.Exec("DELETE FROM number_gaps WHERE application_id = ? AND chat_number = ?", appID, chatNumber)
+	return err
+}
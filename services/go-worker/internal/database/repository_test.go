@@ -0,0 +1,54 @@
+package database
+
+import (
+	"go-worker/internal/model"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+func TestRepositoryInvalidations(t *testing.T) {
+	r := &Repository{
+		applications: newLRUCache[model.Application](10, time.Minute),
+		chats:        newLRUCache[model.Chat](10, time.Minute),
+	}
+	r.applications.set("cached", model.Application{ID: 1, Token: "cached"})
+	r.applications.set("other", model.Application{ID: 3, Token: "other"})
+	for _, chat := range []model.Chat{
+		{ID: 10, ApplicationID: 1, Number: 1},
+		{ID: 11, ApplicationID: 1, Number: 2},
+		{ID: 20, ApplicationID: 2, Number: 1},
+		{ID: 30, ApplicationID: 3, Number: 1},
+		{ID: 31, ApplicationID: 3, Number: 2},
+	} {
+		r.chats.set(chatCacheKey(chat.ApplicationID, chat.Number), chat)
+	}
+
+	messages := make(chan *redis.Message, 10)
+	for _, payload := range []string{
+		// Application 1 is cached here, the deleting instance did not know its id
+		"app:0:cached",
+		// Application 2 is not cached here, only its chat is
+		"app:2:uncached",
+		"chat:3:2",
+		"chat:nonsense",
+		"unknown:1",
+	} {
+		messages <- &redis.Message{Channel: cacheInvalidationChannel, Payload: payload}
+	}
+	close(messages)
+	r.listenInvalidations(messages)
+
+	if _, ok := r.applications.get("cached"); ok {
+		t.Error("deleted application is still cached")
+	}
+	if _, ok := r.applications.get("other"); !ok {
+		t.Error("other application was dropped")
+	}
+	for key, want := range map[string]bool{"1:1": false, "1:2": false, "2:1": false, "3:1": true, "3:2": false} {
+		if _, ok := r.chats.get(key); ok != want {
+			t.Errorf("chat %s cached = %v, want %v", key, ok, want)
+		}
+	}
+}
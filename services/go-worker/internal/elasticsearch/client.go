@@ -6,26 +6,45 @@ import (
 	"encoding/json"
 	"fmt"
 	"go-worker/internal/config"
+	"go-worker/internal/estransport"
 	"go-worker/internal/logging"
 	"io"
 	"net/http"
-	"strings"
 	"time"
 )
 
 type Client struct {
-	baseURL string
-	client  *http.Client
-	logger  *logging.Logger
+	baseURL      string
+	client       *http.Client
+	bulkMaxBytes int
+	logger       *logging.Logger
 }
 
-func NewClient(cfg *config.Config, logger *logging.Logger) *Client {
+func NewClient(cfg *config.Config, logger *logging.Logger) (*Client, error) {
+	logger = logger.WithPrefix("Elasticsearch")
+
+	transport, err := estransport.New(estransport.Config{
+		URLs:       estransport.SplitURLs(cfg.ElasticsearchURL),
+		Username:   cfg.ElasticsearchUsername,
+		Password:   cfg.ElasticsearchPassword,
+		APIKey:     cfg.ElasticsearchAPIKey,
+		CACert:     cfg.ElasticsearchCACert,
+		MaxRetries: cfg.ElasticsearchMaxRetries,
+		Compress:   cfg.ElasticsearchCompress,
+		Logger:     logger,
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	c := &Client{
-		baseURL: cfg.ElasticsearchURL,
+		baseURL: transport.BaseURL(),
 		client: &http.Client{
-			Timeout: 30 * time.Second,
+			Timeout:   30 * time.Second,
+			Transport: transport,
 		},
-		logger: logger.WithPrefix("Elasticsearch"),
+		bulkMaxBytes: cfg.ElasticsearchBulkMaxBytes,
+		logger:       logger,
 	}
 
 	c.waitForConnection()
@@ -34,7 +53,7 @@ func NewClient(cfg *config.Config, logger *logging.Logger) *Client {
 		c.logger.Error("Failed to create index (will retry on first index operation): %v", err)
 	}
 
-	return c
+	return c, nil
 }
 
 func (c *Client) waitForConnection() {
@@ -58,9 +77,14 @@ func (c *Client) waitForConnection() {
 	c.logger.Error("Failed to connect to Elasticsearch after %d attempts. Indexing worker will continue but indexing will fail until ES is available", maxRetries)
 }
 
-func (c *Client) BulkIndex(indexName, bulkBody string) error {
+// BulkIndex sends an NDJSON bulk body. refresh is passed on as the bulk
+// API's refresh parameter, empty leaves documents to the refresh interval.
+func (c *Client) BulkIndex(indexName string, bulkBody []byte, refresh string) error {
 	url := fmt.Sprintf("%s/%s/_bulk", c.baseURL, indexName)
-	req, err := http.NewRequest("POST", url, strings.NewReader(bulkBody))
+	if refresh != "" {
+		url += "?refresh=" + refresh
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(bulkBody))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
@@ -111,64 +135,10 @@ func (c *Client) EnsureIndex() error {
 
 	if resp.StatusCode == 200 {
 		c.logger.Info("[ES] Index '%s' already exists", indexName)
-		return nil
+		return c.ensureSuggestField(indexName)
 	}
 
-	mapping := map[string]interface{}{
-		"settings": map[string]interface{}{
-			"number_of_shards":   3,
-			"number_of_replicas": 1,
-			"analysis": map[string]interface{}{
-				"analyzer": map[string]interface{}{
-					"partial_analyzer": map[string]interface{}{
-						"type":      "custom",
-						"tokenizer": "standard",
-						"filter": []string{
-							"lowercase",
-							"edge_ngram_filter",
-						},
-					},
-					"standard_lowercase": map[string]interface{}{
-						"type":      "custom",
-						"tokenizer": "standard",
-						"filter": []string{
-							"lowercase",
-						},
-					},
-				},
-				"filter": map[string]interface{}{
-					"edge_ngram_filter": map[string]interface{}{
-						"type":     "edge_ngram",
-						"min_gram": 2,
-						"max_gram": 20,
-					},
-				},
-			},
-		},
-		"mappings": map[string]interface{}{
-			"properties": map[string]interface{}{
-				"application_token": map[string]string{"type": "keyword"},
-				"application_name":  map[string]string{"type": "text"},
-				"chat_number":       map[string]string{"type": "integer"},
-				"message_number":    map[string]string{"type": "integer"},
-				"content": map[string]interface{}{
-					"type": "text",
-					"fields": map[string]interface{}{
-						"partial": map[string]interface{}{
-							"type":            "text",
-							"analyzer":        "partial_analyzer",
-							"search_analyzer": "standard",
-						},
-						"fuzzy": map[string]interface{}{
-							"type":     "text",
-							"analyzer": "standard_lowercase",
-						},
-					},
-				},
-				"created_at": map[string]string{"type": "date"},
-			},
-		},
-	}
+	mapping := IndexBody(LanguageStandard, "")
 
 	body, err := json.Marshal(mapping)
 	if err != nil {
@@ -198,6 +168,78 @@ func (c *Client) EnsureIndex() error {
 	return nil
 }
 
+// ensureSuggestField adds content.suggest to an index created before it
+// existed, then backfills it in the background. New documents get it as soon as
+// the mapping is in place.
+func (c *Client) ensureSuggestField(indexName string) error {
+	url := fmt.Sprintf("%s/%s/_mapping/field/content.suggest", c.baseURL, indexName)
+	resp, err := c.client.Get(url)
+	if err != nil {
+		return fmt.Errorf("failed to check suggest field: %w", err)
+	}
+	bodyBytes, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("failed to check suggest field (status %d): %s", resp.StatusCode, string(bodyBytes))
+	}
+	if bytes.Contains(bodyBytes, []byte(`"content.suggest"`)) {
+		return nil
+	}
+
+	mapping := map[string]interface{}{
+		"properties": map[string]interface{}{
+			"content": map[string]interface{}{
+				"type": "text",
+				"fields": map[string]interface{}{
+					"suggest": suggestField,
+				},
+			},
+		},
+	}
+	if _, err := c.send("PUT", fmt.Sprintf("%s/%s/_mapping", c.baseURL, indexName), mapping); err != nil {
+		return fmt.Errorf("failed to add suggest field: %w", err)
+	}
+
+	// Reindexing in place fills the new subfield for existing documents
+	backfill := fmt.Sprintf("%s/%s/_update_by_query?conflicts=proceed&wait_for_completion=false", c.baseURL, indexName)
+	if _, err := c.send("POST", backfill, nil); err != nil {
+		return fmt.Errorf("failed to start suggest backfill: %w", err)
+	}
+
+	c.logger.Info("[ES] Added content.suggest to '%s', backfilling existing documents", indexName)
+	return nil
+}
+
+func (c *Client) send(method, url string, body interface{}) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode >= 400 {
+		return bodyBytes, fmt.Errorf("status %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+	return bodyBytes, nil
+}
+
 func (c *Client) HealthCheck() error {
 	url := fmt.Sprintf("%s/_cluster/health", c.baseURL)
 
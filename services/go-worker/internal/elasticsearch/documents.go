@@ -0,0 +1,144 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go-worker/internal/estransport"
+	"go-worker/internal/search"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var _ search.Backend = (*Client)(nil)
+
+func (c *Client) Index(doc search.Document) error {
+	return c.Bulk([]search.Document{doc})
+}
+
+// Bulk writes documents through the bulk API, each into its own index. It
+// waits for the next refresh, so the documents are searchable once it returns.
+func (c *Client) Bulk(docs []search.Document) error {
+	writer := estransport.NewBulkWriter(c.bulkMaxBytes, func(body *bytes.Buffer, items int) error {
+		return c.BulkIndex("messages", body.Bytes(), "wait_for")
+	})
+
+	for _, doc := range docs {
+		msg := doc.Message
+
+		action := map[string]interface{}{
+			"index": map[string]interface{}{
+				"_index": doc.Index,
+				"_id":    documentID(msg.ApplicationToken, msg.ChatNumber, msg.MessageNumber),
+				// Route by app+chat to keep all messages from same chat on same shard
+				"routing": fmt.Sprintf("%s:%d", msg.ApplicationToken, msg.ChatNumber),
+			},
+		}
+		source := map[string]interface{}{
+			"application_token": msg.ApplicationToken,
+			"application_name":  msg.ApplicationName,
+			"chat_number":       msg.ChatNumber,
+			"message_number":    msg.MessageNumber,
+			"content":           msg.Content,
+			"created_at":        msg.CreatedAt,
+		}
+		if err := writer.Add(action, source); err != nil {
+			return err
+		}
+	}
+
+	return writer.Flush()
+}
+
+func (c *Client) Delete(index string, appToken string, chatNumber int, messageNumber int) error {
+	endpoint := fmt.Sprintf("%s/%s/_doc/%s?routing=%s", c.baseURL, index,
+		url.PathEscape(documentID(appToken, chatNumber, messageNumber)),
+		url.QueryEscape(fmt.Sprintf("%s:%d", appToken, chatNumber)))
+
+	if _, err := c.send(http.MethodDelete, endpoint, nil); err != nil && !strings.Contains(err.Error(), "status 404") {
+		return fmt.Errorf("delete document failed: %w", err)
+	}
+	return nil
+}
+
+// DeleteBefore removes an application's documents created before cutoff and
+// waits for it to finish
+func (c *Client) DeleteBefore(index string, appToken string, cutoff time.Time) (int, error) {
+	query := map[string]interface{}{
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"filter": []interface{}{
+					map[string]interface{}{"term": map[string]interface{}{"application_token": appToken}},
+					map[string]interface{}{"range": map[string]interface{}{
+						"created_at": map[string]interface{}{"lt": cutoff.UTC().Format(time.RFC3339)},
+					}},
+				},
+			},
+		},
+	}
+
+	return c.deleteByQuery(index, query, "")
+}
+
+// DeleteChat removes every document of a chat, which all live on the shard
+// of the chat's routing value
+func (c *Client) DeleteChat(index string, appToken string, chatNumber int) (int, error) {
+	query := map[string]interface{}{
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"filter": []interface{}{
+					map[string]interface{}{"term": map[string]interface{}{"application_token": appToken}},
+					map[string]interface{}{"term": map[string]interface{}{"chat_number": chatNumber}},
+				},
+			},
+		},
+	}
+	return c.deleteByQuery(index, query, fmt.Sprintf("%s:%d", appToken, chatNumber))
+}
+
+// DeleteApplication removes every document of an application
+func (c *Client) DeleteApplication(index string, appToken string) (int, error) {
+	query := map[string]interface{}{
+		"query": map[string]interface{}{
+			"term": map[string]interface{}{"application_token": appToken},
+		},
+	}
+	return c.deleteByQuery(index, query, "")
+}
+
+// deleteByQuery runs a delete-by-query task, limited to the shard of routing
+// when it is set, and waits for it to finish. A missing index has nothing to
+// delete.
+func (c *Client) deleteByQuery(index string, query map[string]interface{}, routing string) (int, error) {
+	endpoint := fmt.Sprintf("%s/%s/_delete_by_query?conflicts=proceed&wait_for_completion=false", c.baseURL, index)
+	if routing != "" {
+		endpoint += "&routing=" + url.QueryEscape(routing)
+	}
+
+	body, err := c.send(http.MethodPost, endpoint, query)
+	if err != nil {
+		if strings.Contains(err.Error(), "status 404") {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to start delete by query on %s: %w", index, err)
+	}
+
+	var started struct {
+		Task string `json:"task"`
+	}
+	if err := json.Unmarshal(body, &started); err != nil || started.Task == "" {
+		return 0, fmt.Errorf("failed to read delete task: %s", string(body))
+	}
+
+	result, err := c.waitForTask(started.Task)
+	if err != nil {
+		return 0, err
+	}
+	return result.Deleted, nil
+}
+
+func documentID(appToken string, chatNumber int, messageNumber int) string {
+	return fmt.Sprintf("%s:%d:%d", appToken, chatNumber, messageNumber)
+}
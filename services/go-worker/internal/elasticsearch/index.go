@@ -0,0 +1,182 @@
+package elasticsearch
+
+// Languages an application can pick for its content. Everything but
+// LanguageStandard lives in a dedicated index analyzed for that language.
+const (
+	LanguageStandard = "standard"
+	LanguageEnglish  = "english"
+	LanguageGerman   = "german"
+	LanguageFrench   = "french"
+	LanguageSpanish  = "spanish"
+	LanguageArabic   = "arabic"
+	LanguageCJK      = "cjk"
+)
+
+// languageChain is the token filter chain of a language: normalize runs before
+// synonyms are applied, stem after
+type languageChain struct {
+	normalize []string
+	stem      []string
+	filters   map[string]interface{}
+}
+
+var languageChains = map[string]languageChain{
+	LanguageStandard: {normalize: []string{"lowercase"}},
+	LanguageEnglish: {
+		normalize: []string{"lowercase"},
+		stem:      []string{"english_stemmer"},
+		filters:   stemmerFilter("english_stemmer", "english"),
+	},
+	LanguageGerman: {
+		normalize: []string{"lowercase", "german_normalization"},
+		stem:      []string{"german_stemmer"},
+		filters:   stemmerFilter("german_stemmer", "light_german"),
+	},
+	LanguageFrench: {
+		normalize: []string{"lowercase"},
+		stem:      []string{"french_stemmer"},
+		filters:   stemmerFilter("french_stemmer", "light_french"),
+	},
+	LanguageSpanish: {
+		normalize: []string{"lowercase"},
+		stem:      []string{"spanish_stemmer"},
+		filters:   stemmerFilter("spanish_stemmer", "light_spanish"),
+	},
+	LanguageArabic: {
+		normalize: []string{"lowercase", "decimal_digit", "arabic_normalization"},
+		stem:      []string{"arabic_stemmer"},
+		filters:   stemmerFilter("arabic_stemmer", "arabic"),
+	},
+	LanguageCJK: {
+		normalize: []string{"cjk_width", "lowercase"},
+		stem:      []string{"cjk_bigram"},
+	},
+}
+
+func stemmerFilter(name, language string) map[string]interface{} {
+	return map[string]interface{}{
+		name: map[string]interface{}{"type": "stemmer", "language": language},
+	}
+}
+
+// SupportedLanguage reports whether IndexBody knows how to analyze language
+func SupportedLanguage(language string) bool {
+	_, ok := languageChains[language]
+	return ok
+}
+
+// suggestField backs the search-as-you-type endpoint with shingle and
+// edge-ngram subfields of the content
+var suggestField = map[string]interface{}{
+	"type":             "search_as_you_type",
+	"analyzer":         "standard_lowercase",
+	"max_shingle_size": 3,
+}
+
+// IndexBody returns the settings and mappings of a messages index. The shared
+// index uses LanguageStandard and no synonyms set. A dedicated index analyzes
+// content for its language at index time, and when synonymsSet is given its
+// search analyzers expand synonyms from that Elasticsearch synonyms set, which
+// can be updated at any time without reindexing.
+func IndexBody(language, synonymsSet string) map[string]interface{} {
+	chain, ok := languageChains[language]
+	if !ok {
+		chain = languageChains[LanguageStandard]
+	}
+
+	filters := map[string]interface{}{
+		"edge_ngram_filter": map[string]interface{}{
+			"type":     "edge_ngram",
+			"min_gram": 2,
+			"max_gram": 20,
+		},
+	}
+	for name, filter := range chain.filters {
+		filters[name] = filter
+	}
+
+	searchNormalize := chain.normalize
+	if synonymsSet != "" {
+		filters["app_synonyms"] = map[string]interface{}{
+			"type":         "synonym_graph",
+			"synonyms_set": synonymsSet,
+			"updateable":   true,
+		}
+		searchNormalize = append(append([]string{}, chain.normalize...), "app_synonyms")
+	}
+
+	analyzers := map[string]interface{}{
+		"partial_analyzer": map[string]interface{}{
+			"type":      "custom",
+			"tokenizer": "standard",
+			"filter":    append(append([]string{}, chain.normalize...), "edge_ngram_filter"),
+		},
+		"standard_lowercase": map[string]interface{}{
+			"type":      "custom",
+			"tokenizer": "standard",
+			"filter": []string{
+				"lowercase",
+			},
+		},
+	}
+
+	// The shared index keeps its original analysis, existing documents were
+	// indexed with it
+	contentAnalyzer, contentSearchAnalyzer := "standard_lowercase", "standard_lowercase"
+	partialSearchAnalyzer := "standard"
+	if language != LanguageStandard || synonymsSet != "" {
+		analyzers["content_analyzer"] = map[string]interface{}{
+			"type":      "custom",
+			"tokenizer": "standard",
+			"filter":    append(append([]string{}, chain.normalize...), chain.stem...),
+		}
+		analyzers["content_search_analyzer"] = map[string]interface{}{
+			"type":      "custom",
+			"tokenizer": "standard",
+			"filter":    append(append([]string{}, searchNormalize...), chain.stem...),
+		}
+		analyzers["partial_search_analyzer"] = map[string]interface{}{
+			"type":      "custom",
+			"tokenizer": "standard",
+			"filter":    searchNormalize,
+		}
+		contentAnalyzer, contentSearchAnalyzer = "content_analyzer", "content_search_analyzer"
+		partialSearchAnalyzer = "partial_search_analyzer"
+	}
+
+	return map[string]interface{}{
+		"settings": map[string]interface{}{
+			"number_of_shards":   3,
+			"number_of_replicas": 1,
+			"analysis": map[string]interface{}{
+				"analyzer": analyzers,
+				"filter":   filters,
+			},
+		},
+		"mappings": map[string]interface{}{
+			"properties": map[string]interface{}{
+				"application_token": map[string]string{"type": "keyword"},
+				"application_name":  map[string]string{"type": "text"},
+				"chat_number":       map[string]string{"type": "integer"},
+				"message_number":    map[string]string{"type": "integer"},
+				"content": map[string]interface{}{
+					"type": "text",
+					"fields": map[string]interface{}{
+						"partial": map[string]interface{}{
+							"type":            "text",
+							"analyzer":        "partial_analyzer",
+							"search_analyzer": partialSearchAnalyzer,
+						},
+						"fuzzy": map[string]interface{}{
+							"type":            "text",
+							"analyzer":        contentAnalyzer,
+							"search_analyzer": contentSearchAnalyzer,
+						},
+						"suggest": suggestField,
+					},
+				},
+				"created_at": map[string]string{"type": "date"},
+			},
+		},
+	}
+}
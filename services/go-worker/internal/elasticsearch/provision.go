@@ -0,0 +1,158 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	neturl "net/url"
+	"strings"
+	"time"
+)
+
+// SynonymRule is one rule of a synonyms set, in Solr format
+type SynonymRule struct {
+	ID       string `json:"id"`
+	Synonyms string `json:"synonyms"`
+}
+
+// PutSynonymsSet creates or replaces a synonyms set. Analyzers using it are
+// reloaded by Elasticsearch.
+func (c *Client) PutSynonymsSet(id string, rules []SynonymRule) error {
+	if rules == nil {
+		rules = []SynonymRule{}
+	}
+
+	url := fmt.Sprintf("%s/_synonyms/%s", c.baseURL, neturl.PathEscape(id))
+	if _, err := c.send("PUT", url, map[string]interface{}{"synonyms_set": rules}); err != nil {
+		return fmt.Errorf("failed to put synonyms set %s: %w", id, err)
+	}
+	return nil
+}
+
+// CreateIndex creates a messages index for language, tolerating one that
+// already exists from an interrupted provisioning
+func (c *Client) CreateIndex(indexName, language, synonymsSet string) error {
+	url := fmt.Sprintf("%s/%s", c.baseURL, indexName)
+	body, err := c.send("PUT", url, IndexBody(language, synonymsSet))
+	if err != nil && !bytes.Contains(body, []byte("resource_already_exists_exception")) {
+		return fmt.Errorf("failed to create index %s: %w", indexName, err)
+	}
+
+	c.logger.Info("[ES] Index '%s' ready (language: %s)", indexName, language)
+	return nil
+}
+
+// ReindexApplication copies an application's documents from source to dest
+// and waits for it to finish. Documents already in dest are newer and kept.
+func (c *Client) ReindexApplication(source, dest, appToken string) error {
+	reindex := map[string]interface{}{
+		"conflicts": "proceed",
+		"source": map[string]interface{}{
+			"index": source,
+			"query": map[string]interface{}{
+				"term": map[string]interface{}{"application_token": appToken},
+			},
+		},
+		"dest": map[string]interface{}{
+			"index":   dest,
+			"op_type": "create",
+		},
+	}
+
+	url := fmt.Sprintf("%s/_reindex?wait_for_completion=false", c.baseURL)
+	body, err := c.send("POST", url, reindex)
+	if err != nil {
+		return fmt.Errorf("failed to start reindex: %w", err)
+	}
+
+	var started struct {
+		Task string `json:"task"`
+	}
+	if err := json.Unmarshal(body, &started); err != nil || started.Task == "" {
+		return fmt.Errorf("failed to read reindex task: %s", string(body))
+	}
+
+	_, err = c.waitForTask(started.Task)
+	return err
+}
+
+// DeleteApplicationDocuments removes an application's documents from a
+// shared index in the background
+func (c *Client) DeleteApplicationDocuments(indexName, appToken string) error {
+	query := map[string]interface{}{
+		"query": map[string]interface{}{
+			"term": map[string]interface{}{"application_token": appToken},
+		},
+	}
+
+	url := fmt.Sprintf("%s/%s/_delete_by_query?conflicts=proceed&wait_for_completion=false", c.baseURL, indexName)
+	if _, err := c.send("POST", url, query); err != nil {
+		return fmt.Errorf("failed to delete documents of %s from %s: %w", appToken, indexName, err)
+	}
+	return nil
+}
+
+// DeleteSynonymsSet removes an application's synonyms set once no index uses
+// it anymore
+func (c *Client) DeleteSynonymsSet(id string) error {
+	url := fmt.Sprintf("%s/_synonyms/%s", c.baseURL, neturl.PathEscape(id))
+	if _, err := c.send("DELETE", url, nil); err != nil && !strings.Contains(err.Error(), "status 404") {
+		return fmt.Errorf("failed to delete synonyms set %s: %w", id, err)
+	}
+	return nil
+}
+
+// DeleteIndex drops an index, one that is already gone is left at that
+func (c *Client) DeleteIndex(indexName string) error {
+	if _, err := c.send("DELETE", fmt.Sprintf("%s/%s", c.baseURL, indexName), nil); err != nil && !strings.Contains(err.Error(), "status 404") {
+		return fmt.Errorf("failed to delete index %s: %w", indexName, err)
+	}
+	return nil
+}
+
+const (
+	taskPollInterval = 2 * time.Second
+	taskMaxWait      = 30 * time.Minute
+)
+
+// taskResponse is the outcome of a finished reindex or delete-by-query task
+type taskResponse struct {
+	Created  int               `json:"created"`
+	Deleted  int               `json:"deleted"`
+	Failures []json.RawMessage `json:"failures"`
+}
+
+func (c *Client) waitForTask(taskID string) (*taskResponse, error) {
+	url := fmt.Sprintf("%s/_tasks/%s", c.baseURL, neturl.PathEscape(taskID))
+	deadline := time.Now().Add(taskMaxWait)
+
+	for time.Now().Before(deadline) {
+		body, err := c.send("GET", url, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check task %s: %w", taskID, err)
+		}
+
+		var task struct {
+			Completed bool            `json:"completed"`
+			Error     json.RawMessage `json:"error"`
+			Response  taskResponse    `json:"response"`
+		}
+		if err := json.Unmarshal(body, &task); err != nil {
+			return nil, fmt.Errorf("failed to parse task %s: %w", taskID, err)
+		}
+
+		if task.Completed {
+			if len(task.Error) > 0 {
+				return nil, fmt.Errorf("task %s failed: %s", taskID, string(task.Error))
+			}
+			if len(task.Response.Failures) > 0 {
+				return nil, fmt.Errorf("task %s had %d failures: %s", taskID, len(task.Response.Failures), string(task.Response.Failures[0]))
+			}
+			return &task.Response, nil
+		}
+
+		time.Sleep(taskPollInterval)
+	}
+
+	return nil, fmt.Errorf("task %s did not finish within %v", taskID, taskMaxWait)
+}
@@ -0,0 +1,303 @@
+package migrate
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"go-worker/internal/database"
+	"go-worker/internal/logging"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var files embed.FS
+
+// Migration is a pair of SQL files named <version>_<name>.up.sql and
+// <version>_<name>.down.sql. Statements end with a semicolon at the end of a
+// line. MySQL commits DDL implicitly, so a migration holds at most one DDL
+// statement, the rest runs in a transaction with the version bookkeeping.
+type Migration struct {
+	Version string
+	Name    string
+	Up      []string
+	Down    []string
+}
+
+// Status is a migration and whether it has been applied
+type Status struct {
+	*Migration
+	Applied bool
+}
+
+// Migrator applies the schema changes owned by the Go services. Applied
+// versions go to schema_migrations, the table Rails keeps its own versions
+// in, so both sides see one history. Only one Migrator runs at a time, the
+// row in schema_migrations_lock says which.
+type Migrator struct {
+	db         *sql.DB
+	logger     *logging.Logger
+	migrations []*Migration
+	owner      string
+}
+
+func NewMigrator(db *sql.DB, logger *logging.Logger) (*Migrator, error) {
+	migrations, err := load(files)
+	if err != nil {
+		return nil, err
+	}
+
+	host, _ := os.Hostname()
+	return &Migrator{
+		db:         db,
+		logger:     logger.WithPrefix("Migrator"),
+		migrations: migrations,
+		owner:      fmt.Sprintf("%s:%d", host, os.Getpid()),
+	}, nil
+}
+
+// load pairs the up and down files under migrations in fsys by version
+func load(fsys fs.FS) ([]*Migration, error) {
+	entries, err := fs.ReadDir(fsys, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[string]*Migration)
+	for _, entry := range entries {
+		name := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("unexpected migration file %s", name)
+		}
+
+		version, rest, ok := strings.Cut(strings.TrimSuffix(name, "."+direction+".sql"), "_")
+		if !ok {
+			return nil, fmt.Errorf("migration file %s has no version", name)
+		}
+
+		raw, err := fs.ReadFile(fsys, path.Join("migrations", name))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: rest}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.Up = statements(string(raw))
+		} else {
+			m.Down = statements(string(raw))
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == nil {
+			return nil, fmt.Errorf("migration %s has no up file", m.Version)
+		}
+		migrations = append(migrations, m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// statements splits a file on semicolons ending a line, skipping comments
+func statements(raw string) []string {
+	result := []string{}
+	var current strings.Builder
+	for _, line := range strings.Split(raw, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			result = append(result, strings.TrimSuffix(strings.TrimSpace(current.String()), ";"))
+			current.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(current.String()); rest != "" {
+		result = append(result, rest)
+	}
+	return result
+}
+
+func (m *Migrator) prepare() error {
+	_, err := m.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version VARCHAR(255) NOT NULL PRIMARY KEY
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	_, err = m.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations_lock (
+		id TINYINT NOT NULL PRIMARY KEY,
+		locked_by VARCHAR(255) NOT NULL,
+		locked_at DATETIME NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations_lock: %w", err)
+	}
+	return nil
+}
+
+func (m *Migrator) lock() error {
+	_, err := m.db.Exec("INSERT INTO schema_migrations_lock (id, locked_by, locked_at) VALUES (1, ?, ?)", m.owner, time.Now().UTC())
+	if database.IsDuplicateKey(err) {
+		var owner string
+		var since time.Time
+		if err := m.db.QueryRow("SELECT locked_by, locked_at FROM schema_migrations_lock WHERE id = 1").Scan(&owner, &since); err != nil {
+			return fmt.Errorf("migrations are locked: %w", err)
+		}
+		return fmt.Errorf("migrations are locked by %s since %s, run `migrate unlock` if it is gone", owner, since.Format(time.RFC3339))
+	}
+	return err
+}
+
+func (m *Migrator) unlock() {
+	if _, err := m.db.Exec("DELETE FROM schema_migrations_lock WHERE id = 1 AND locked_by = ?", m.owner); err != nil {
+		m.logger.Error("Failed to release migration lock: %v", err)
+	}
+}
+
+// ForceUnlock removes the lock of a migrator that died holding it
+func (m *Migrator) ForceUnlock() error {
+	if err := m.prepare(); err != nil {
+		return err
+	}
+	_, err := m.db.Exec("DELETE FROM schema_migrations_lock WHERE id = 1")
+	return err
+}
+
+func (m *Migrator) applied() (map[string]bool, error) {
+	rows, err := m.db.Query("SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := make(map[string]bool)
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		versions[version] = true
+	}
+	return versions, rows.Err()
+}
+
+// Status lists the migrations in order with whether each is applied
+func (m *Migrator) Status() ([]*Status, error) {
+	if err := m.prepare(); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]*Status, len(m.migrations))
+	for i, migration := range m.migrations {
+		statuses[i] = &Status{Migration: migration, Applied: applied[migration.Version]}
+	}
+	return statuses, nil
+}
+
+// Up applies every pending migration in order and returns how many ran
+func (m *Migrator) Up() (int, error) {
+	if err := m.prepare(); err != nil {
+		return 0, err
+	}
+	if err := m.lock(); err != nil {
+		return 0, err
+	}
+	defer m.unlock()
+
+	applied, err := m.applied()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, migration := range m.migrations {
+		if applied[migration.Version] {
+			continue
+		}
+		m.logger.Info("Applying %s_%s", migration.Version, migration.Name)
+		if err := m.run(migration.Up, "INSERT INTO schema_migrations (version) VALUES (?)", migration.Version); err != nil {
+			return count, fmt.Errorf("migration %s_%s failed: %w", migration.Version, migration.Name, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+// Down reverts the last steps applied migrations, newest first
+func (m *Migrator) Down(steps int) (int, error) {
+	if err := m.prepare(); err != nil {
+		return 0, err
+	}
+	if err := m.lock(); err != nil {
+		return 0, err
+	}
+	defer m.unlock()
+
+	applied, err := m.applied()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for i := len(m.migrations) - 1; i >= 0 && count < steps; i-- {
+		migration := m.migrations[i]
+		if !applied[migration.Version] {
+			continue
+		}
+		if migration.Down == nil {
+			return count, fmt.Errorf("migration %s_%s cannot be reverted, it has no down file", migration.Version, migration.Name)
+		}
+		m.logger.Info("Reverting %s_%s", migration.Version, migration.Name)
+		if err := m.run(migration.Down, "DELETE FROM schema_migrations WHERE version = ?", migration.Version); err != nil {
+			return count, fmt.Errorf("reverting %s_%s failed: %w", migration.Version, migration.Name, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+// run executes a migration's statements and records the version change in
+// one transaction, as far as MySQL allows
+func (m *Migrator) run(statements []string, record string, version string) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return fmt.Errorf("statement %d: %w", i+1, err)
+		}
+	}
+	if _, err := tx.Exec(record, version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,137 @@
+package migrate
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestStatements(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want []string
+	}{
+		{"empty", "", []string{}},
+		{"only comments", "-- nothing to do\n\n  -- still nothing\n", []string{}},
+		{"one statement", "ALTER TABLE chats ADD INDEX i (number);\n", []string{"ALTER TABLE chats ADD INDEX i (number)"}},
+		{
+			"several statements",
+			"DELETE FROM chats;\nDELETE FROM messages;\n",
+			[]string{"DELETE FROM chats", "DELETE FROM messages"},
+		},
+		{
+			"statement over several lines",
+			"UPDATE chats\nSET number = 1\nWHERE id = 2;\n",
+			[]string{"UPDATE chats\nSET number = 1\nWHERE id = 2"},
+		},
+		{
+			"comments and blank lines in between",
+			"-- first\nDELETE FROM chats\n\n-- why\nWHERE id = 1;\n\n-- second\nDELETE FROM messages;\n",
+			[]string{"DELETE FROM chats\nWHERE id = 1", "DELETE FROM messages"},
+		},
+		{
+			"semicolon inside a line",
+			"UPDATE chats SET name = 'a;b'\nWHERE id = 1;\n",
+			[]string{"UPDATE chats SET name = 'a;b'\nWHERE id = 1"},
+		},
+		{"trailing whitespace", "DELETE FROM chats;   \r\n", []string{"DELETE FROM chats"}},
+		{"last statement without semicolon", "DELETE FROM chats;\nDELETE FROM messages\n", []string{"DELETE FROM chats", "DELETE FROM messages"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := statements(tc.raw); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("statements(%q) = %q, want %q", tc.raw, got, tc.want)
+			}
+		})
+	}
+}
+
+func file(content string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(content)}
+}
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/20250102000000_second.up.sql":       file("UPDATE b SET x = 1;\nUPDATE b SET y = 2;\n"),
+		"migrations/20250101000000_first_step.down.sql": file("DROP TABLE a;\n"),
+		"migrations/20250101000000_first_step.up.sql":   file("CREATE TABLE a (id INT);\n"),
+		"migrations/20250103000000_third.down.sql":      file("-- Nothing to undo\n"),
+		"migrations/20250103000000_third.up.sql":        file("DELETE FROM c;\n"),
+	}
+
+	migrations, err := load(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*Migration{
+		{Version: "20250101000000", Name: "first_step", Up: []string{"CREATE TABLE a (id INT)"}, Down: []string{"DROP TABLE a"}},
+		// A migration without a down file cannot be reverted
+		{Version: "20250102000000", Name: "second", Up: []string{"UPDATE b SET x = 1", "UPDATE b SET y = 2"}},
+		// An empty down file reverts to nothing
+		{Version: "20250103000000", Name: "third", Up: []string{"DELETE FROM c"}, Down: []string{}},
+	}
+	if !reflect.DeepEqual(migrations, want) {
+		for _, m := range migrations {
+			t.Logf("got %+v", *m)
+		}
+		t.Errorf("load paired %d migrations, want %d as listed", len(migrations), len(want))
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		fsys fstest.MapFS
+		want string
+	}{
+		{
+			"down without up",
+			fstest.MapFS{"migrations/20250101000000_first.down.sql": file("DROP TABLE a;\n")},
+			"migration 20250101000000 has no up file",
+		},
+		{
+			"unexpected file",
+			fstest.MapFS{"migrations/README.md": file("notes\n")},
+			"unexpected migration file README.md",
+		},
+		{
+			"no version",
+			fstest.MapFS{"migrations/first.up.sql": file("CREATE TABLE a (id INT);\n")},
+			"migration file first.up.sql has no version",
+		},
+		{
+			"no migrations directory",
+			fstest.MapFS{},
+			"migrations",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := load(tc.fsys)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("load error = %v, want one containing %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestLoadEmbedded(t *testing.T) {
+	migrations, err := load(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range migrations {
+		if len(m.Up) == 0 {
+			t.Errorf("%s_%s has no up statements", m.Version, m.Name)
+		}
+		if m.Down == nil {
+			t.Errorf("%s_%s has no down file", m.Version, m.Name)
+		}
+		if i > 0 && migrations[i-1].Version >= m.Version {
+			t.Errorf("%s is not after %s", m.Version, migrations[i-1].Version)
+		}
+	}
+}
@@ -0,0 +1 @@
+-- Removed duplicates are not restored
@@ -0,0 +1,68 @@
+-- Without unique indexes concurrent deliveries could persist the same number
+-- twice. The oldest row of each number is kept. Counts are corrected by what
+-- is removed rather than recounted, deltas not reconciled yet still apply.
+
+UPDATE applications
+INNER JOIN (
+  SELECT application_id, SUM(copies - 1) AS extra
+  FROM (
+    SELECT application_id, COUNT(*) AS copies
+    FROM chats
+    GROUP BY application_id, number
+    HAVING COUNT(*) > 1
+  ) duplicated
+  GROUP BY application_id
+) duplicates ON duplicates.application_id = applications.id
+SET applications.chats_count = GREATEST(applications.chats_count - duplicates.extra, 0);
+
+-- The kept chat takes over the messages of its copies, and their count
+UPDATE chats
+INNER JOIN (
+  SELECT MIN(id) AS id, SUM(messages_count) AS messages_count
+  FROM chats
+  GROUP BY application_id, number
+  HAVING COUNT(*) > 1
+) merged ON merged.id = chats.id
+SET chats.messages_count = merged.messages_count;
+
+UPDATE messages
+INNER JOIN chats spare ON spare.id = messages.chat_id
+INNER JOIN (
+  SELECT application_id, number, MIN(id) AS id
+  FROM chats
+  GROUP BY application_id, number
+  HAVING COUNT(*) > 1
+) kept ON kept.application_id = spare.application_id AND kept.number = spare.number
+SET messages.chat_id = kept.id
+WHERE spare.id <> kept.id;
+
+DELETE spare FROM chats spare
+INNER JOIN (
+  SELECT application_id, number, MIN(id) AS id
+  FROM chats
+  GROUP BY application_id, number
+  HAVING COUNT(*) > 1
+) kept ON kept.application_id = spare.application_id AND kept.number = spare.number
+WHERE spare.id <> kept.id;
+
+UPDATE chats
+INNER JOIN (
+  SELECT chat_id, SUM(copies - 1) AS extra
+  FROM (
+    SELECT chat_id, COUNT(*) AS copies
+    FROM messages
+    GROUP BY chat_id, number
+    HAVING COUNT(*) > 1
+  ) duplicated
+  GROUP BY chat_id
+) duplicates ON duplicates.chat_id = chats.id
+SET chats.messages_count = GREATEST(chats.messages_count - duplicates.extra, 0);
+
+DELETE spare FROM messages spare
+INNER JOIN (
+  SELECT chat_id, number, MIN(id) AS id
+  FROM messages
+  GROUP BY chat_id, number
+  HAVING COUNT(*) > 1
+) kept ON kept.chat_id = spare.chat_id AND kept.number = spare.number
+WHERE spare.id <> kept.id;
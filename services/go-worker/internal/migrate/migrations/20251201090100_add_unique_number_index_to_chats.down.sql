@@ -0,0 +1 @@
+ALTER TABLE chats DROP INDEX index_chats_on_application_id_and_number;
@@ -0,0 +1 @@
+ALTER TABLE chats ADD UNIQUE INDEX index_chats_on_application_id_and_number (application_id, number);
@@ -0,0 +1 @@
+ALTER TABLE messages DROP INDEX index_messages_on_chat_id_and_number;
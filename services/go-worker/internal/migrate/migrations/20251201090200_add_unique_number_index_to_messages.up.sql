@@ -0,0 +1 @@
+ALTER TABLE messages ADD UNIQUE INDEX index_messages_on_chat_id_and_number (chat_id, number);
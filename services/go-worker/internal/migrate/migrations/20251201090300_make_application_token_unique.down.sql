@@ -0,0 +1,3 @@
+ALTER TABLE applications
+  DROP INDEX index_applications_on_token,
+  ADD INDEX index_applications_on_token (token);
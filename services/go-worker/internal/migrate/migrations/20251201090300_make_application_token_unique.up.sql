@@ -0,0 +1,4 @@
+-- Tokens are random, a duplicate makes this fail and needs a look by hand
+ALTER TABLE applications
+  DROP INDEX index_applications_on_token,
+  ADD UNIQUE INDEX index_applications_on_token (token);
@@ -0,0 +1,158 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"go-worker/internal/contract"
+	"go-worker/internal/database"
+	"go-worker/internal/logging"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// RedisKeys renames the keys written before contract.KeyScheme, whose token
+// had no braces and whose count deltas had no token at all, then records the
+// new scheme so go-chat and go-worker start again.
+//
+// Nothing may write the old names meanwhile, so earlier go-chat and go-worker
+// instances have to be stopped first. RENAMENX needs both names on one node,
+// so this runs before a move to Redis Cluster, not after.
+type RedisKeys struct {
+	redis  redis.UniversalClient
+	db     *sql.DB
+	logger *logging.Logger
+
+	mu    sync.Mutex
+	moved int
+}
+
+func NewRedisKeys(rdb redis.UniversalClient, db *sql.DB, logger *logging.Logger) *RedisKeys {
+	return &RedisKeys{
+		redis:  rdb,
+		db:     db,
+		logger: logger.WithPrefix("RedisKeys"),
+	}
+}
+
+// Run renames every old key and returns how many were moved or merged
+func (m *RedisKeys) Run() (int, error) {
+	ctx := context.Background()
+
+	for _, pattern := range contract.LegacyKeyPatterns {
+		err := database.ScanKeys(ctx, m.redis, pattern, func(keys []string) error {
+			for _, key := range keys {
+				if err := m.move(ctx, key); err != nil {
+					return fmt.Errorf("%s: %w", key, err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return m.moved, err
+		}
+	}
+
+	if err := m.redis.Set(ctx, contract.KeySchemeKey, contract.KeyScheme, 0).Err(); err != nil {
+		return m.moved, err
+	}
+	return m.moved, nil
+}
+
+func (m *RedisKeys) move(ctx context.Context, key string) error {
+	target, err := m.target(key)
+	if errors.Is(err, sql.ErrNoRows) {
+		// A delta of a deleted application or chat, nothing left to count
+		m.logger.Info("Dropping %s, its row is gone", key)
+		return m.redis.Del(ctx, key).Err()
+	}
+	if err != nil {
+		return err
+	}
+
+	renamed, err := m.redis.RenameNX(ctx, key, target).Result()
+	if err != nil {
+		return err
+	}
+	if !renamed {
+		if err := m.merge(ctx, key, target); err != nil {
+			return err
+		}
+	}
+
+	m.mu.Lock()
+	m.moved++
+	m.mu.Unlock()
+	return nil
+}
+
+// target is the hash tagged name of an old key
+func (m *RedisKeys) target(key string) (string, error) {
+	parts := strings.Split(key, ":")
+	switch {
+	case parts[0] == "app" && len(parts) >= 3:
+		// app:<token>:chats_count, app:<token>:chat:<n>:messages[_count]
+		return fmt.Sprintf("app:{%s}:%s", parts[1], strings.Join(parts[2:], ":")), nil
+	case parts[0] == "tombstone" && len(parts) >= 3:
+		// tombstone:app:<token>[:chat:<n>[:message:<m>]]
+		rest := ""
+		if len(parts) > 3 {
+			rest = ":" + strings.Join(parts[3:], ":")
+		}
+		return fmt.Sprintf("tombstone:app:{%s}%s", parts[2], rest), nil
+	case parts[0] == "delta" && len(parts) == 4:
+		id, err := strconv.ParseUint(parts[2], 10, 64)
+		if err != nil {
+			break
+		}
+		var token string
+		switch parts[1] {
+		case "app":
+			err = m.db.QueryRow("SELECT token FROM applications WHERE id = ?", id).Scan(&token)
+			return contract.ChatCountDelta(token, uint(id)), err
+		case "chat":
+			err = m.db.QueryRow(`SELECT applications.token FROM chats
+				INNER JOIN applications ON applications.id = chats.application_id
+				WHERE chats.id = ?`, id).Scan(&token)
+			return contract.MessageCountDelta(token, uint(id)), err
+		}
+	}
+	return "", fmt.Errorf("unexpected key")
+}
+
+// merge folds an old key into a new one written in the meantime. Deltas add
+// up, counters keep the higher value so no number is handed out twice, and
+// for tombstones and message hashes the new key wins.
+func (m *RedisKeys) merge(ctx context.Context, key, target string) error {
+	switch {
+	case strings.HasPrefix(key, "delta:"):
+		delta, err := m.redis.Get(ctx, key).Int64()
+		if err != nil {
+			return err
+		}
+		if err := m.redis.IncrBy(ctx, target, delta).Err(); err != nil {
+			return err
+		}
+	case strings.HasSuffix(key, "_count"):
+		old, err := m.redis.Get(ctx, key).Int64()
+		if err != nil {
+			return err
+		}
+		current, err := m.redis.Get(ctx, target).Int64()
+		if err != nil {
+			return err
+		}
+		if old > current {
+			// INCRBY rather than SET keeps what was handed out since the read
+			if err := m.redis.IncrBy(ctx, target, old-current).Err(); err != nil {
+				return err
+			}
+		}
+	}
+	m.logger.Info("Merged %s into %s", key, target)
+	return m.redis.Del(ctx, key).Err()
+}
@@ -0,0 +1,181 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"go-worker/internal/config"
+	"go-worker/internal/contract"
+	"go-worker/internal/logging"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// tokenDriver is a database/sql driver answering the token lookups of
+// RedisKeys: application 1 and chat 7 belong to "app", everything else is
+// gone
+type tokenDriver struct{}
+
+func init() {
+	sql.Register("redis-keys-test", tokenDriver{})
+}
+
+func (tokenDriver) Open(string) (driver.Conn, error) { return tokenConn{}, nil }
+
+type tokenConn struct{}
+
+func (tokenConn) Prepare(query string) (driver.Stmt, error) {
+	return tokenStmt{chats: strings.Contains(query, "FROM chats")}, nil
+}
+func (tokenConn) Close() error              { return nil }
+func (tokenConn) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+type tokenStmt struct{ chats bool }
+
+func (tokenStmt) Close() error  { return nil }
+func (tokenStmt) NumInput() int { return 1 }
+func (tokenStmt) Exec([]driver.Value) (driver.Result, error) {
+	return nil, errors.New("not supported")
+}
+
+func (s tokenStmt) Query(args []driver.Value) (driver.Rows, error) {
+	known := int64(1)
+	if s.chats {
+		known = 7
+	}
+	if args[0] != known {
+		return &tokenRows{}, nil
+	}
+	return &tokenRows{tokens: []string{"app"}}, nil
+}
+
+type tokenRows struct{ tokens []string }
+
+func (*tokenRows) Columns() []string { return []string{"token"} }
+func (*tokenRows) Close() error      { return nil }
+func (r *tokenRows) Next(dest []driver.Value) error {
+	if len(r.tokens) == 0 {
+		return io.EOF
+	}
+	dest[0], r.tokens = r.tokens[0], r.tokens[1:]
+	return nil
+}
+
+func newTestRedisKeys(t *testing.T) (*RedisKeys, *miniredis.Miniredis) {
+	t.Helper()
+
+	m := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+
+	db, err := sql.Open("redis-keys-test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	logger := logging.NewLogger(&config.Config{AppName: "migrate-test", LogPath: t.TempDir()})
+	return NewRedisKeys(rdb, db, logger), m
+}
+
+func TestRedisKeysRenames(t *testing.T) {
+	r, m := newTestRedisKeys(t)
+
+	m.Set("app:app:chats_count", "5")
+	m.Set("app:app:chat:2:messages_count", "9")
+	m.HSet("app:app:chat:2:messages", "1", "hello")
+	m.Set("tombstone:app:gone", "1")
+	m.Set("tombstone:app:app:chat:3", "1")
+	m.Set("delta:app:1:chats", "2")
+	m.Set("delta:chat:7:messages", "4")
+	// Rows deleted since, their deltas have nothing left to count
+	m.Set("delta:app:2:chats", "1")
+	m.Set("delta:chat:8:messages", "1")
+	// Already in the new layout
+	m.Set(contract.ChatCounter("other"), "3")
+
+	moved, err := r.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved != 7 {
+		t.Errorf("moved %d keys, want 7", moved)
+	}
+
+	want := map[string]string{
+		contract.ChatCounter("app"):           "5",
+		contract.MessageCounter("app", 2):     "9",
+		contract.ApplicationTombstone("gone"): "1",
+		contract.ChatTombstone("app", 3):      "1",
+		contract.ChatCountDelta("app", 1):     "2",
+		contract.MessageCountDelta("app", 7):  "4",
+		contract.ChatCounter("other"):         "3",
+		contract.KeySchemeKey:                 contract.KeyScheme,
+	}
+	for key, value := range want {
+		if got, err := m.Get(key); err != nil || got != value {
+			t.Errorf("%s = %q (%v), want %q", key, got, err, value)
+		}
+	}
+	if got := m.HGet("app:{app}:chat:2:messages", "1"); got != "hello" {
+		t.Errorf("message hash was not moved, field 1 = %q", got)
+	}
+
+	keys := m.Keys()
+	if len(keys) != len(want)+1 {
+		t.Errorf("keys after Run = %v, want only the renamed ones", keys)
+	}
+	if _, err := r.Run(); err != nil {
+		t.Errorf("second Run: %v", err)
+	}
+}
+
+func TestRedisKeysMerges(t *testing.T) {
+	tests := []struct {
+		name        string
+		old, target string
+		oldValue    string
+		newValue    string
+		want        string
+	}{
+		// go-chat handed out numbers under both names, keep the higher
+		{"old counter ahead", "app:app:chats_count", contract.ChatCounter("app"), "9", "4", "9"},
+		{"new counter ahead", "app:app:chats_count", contract.ChatCounter("app"), "4", "9", "9"},
+		{"deltas add up", "delta:app:1:chats", contract.ChatCountDelta("app", 1), "2", "3", "5"},
+		{"new tombstone wins", "tombstone:app:app", contract.ApplicationTombstone("app"), "old", "new", "new"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r, m := newTestRedisKeys(t)
+			m.Set(tc.old, tc.oldValue)
+			m.Set(tc.target, tc.newValue)
+
+			if _, err := r.Run(); err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := m.Get(tc.target); got != tc.want {
+				t.Errorf("%s = %q, want %q", tc.target, got, tc.want)
+			}
+			if m.Exists(tc.old) {
+				t.Errorf("%s is still there", tc.old)
+			}
+		})
+	}
+}
+
+func TestRedisKeysRejectsUnknownKeys(t *testing.T) {
+	r, m := newTestRedisKeys(t)
+	m.Set("delta:app:x:chats", "1")
+
+	if _, err := r.Run(); err == nil || !strings.Contains(err.Error(), "delta:app:x:chats") {
+		t.Errorf("Run error = %v, want one naming the key", err)
+	}
+	if got, _ := r.redis.Get(context.Background(), contract.KeySchemeKey).Result(); got != "" {
+		t.Errorf("key scheme recorded as %q after a failed run", got)
+	}
+}
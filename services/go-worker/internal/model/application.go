@@ -10,3 +10,17 @@ type Application struct {
 	CreatedAt  time.Time `db:"created_at"`
 	UpdatedAt  time.Time `db:"updated_at"`
 }
+
+// SearchSettings is how an application's messages are indexed. SearchIndex is
+// empty while the application uses the shared index.
+type SearchSettings struct {
+	ApplicationID uint   `db:"id"`
+	Token         string `db:"token"`
+	Language      string `db:"language"`
+	SearchIndex   string `db:"search_index"`
+}
+
+type SynonymRule struct {
+	ID       uint   `db:"id"`
+	Synonyms string `db:"synonyms"`
+}
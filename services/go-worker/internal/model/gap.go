@@ -0,0 +1,39 @@
+package model
+
+import "time"
+
+// GapScope is an application checked for gaps. Retained applications lose
+// their oldest messages to the retention job, so only numbers from the
+// oldest remaining message on count.
+type GapScope struct {
+	ApplicationID uint   `db:"id"`
+	Token         string `db:"token"`
+	Retained      bool   `db:"retained"`
+}
+
+const (
+	// GapInFlight is a gap younger than the grace period, its delivery may
+	// still be queued or deferred
+	GapInFlight     = "in_flight"
+	GapUnpublished  = "unpublished"
+	GapDeadLettered = "dead_lettered"
+	// GapReleased is a message number go-chat leased in a block and gave back
+	// unused
+	GapReleased = "released"
+	// GapLost is older than the grace period with no known cause, usually a
+	// delivery go-worker dropped
+	GapLost = "lost"
+)
+
+// NumberGap is a chat number, or a message number when MessageNumber is set,
+// that was handed out but is missing from MySQL
+type NumberGap struct {
+	ID            uint       `db:"id"`
+	ApplicationID uint       `db:"application_id"`
+	ChatNumber    int        `db:"chat_number"`
+	MessageNumber int        `db:"message_number"`
+	Cause         string     `db:"cause"`
+	FirstSeenAt   time.Time  `db:"first_seen_at"`
+	LastSeenAt    time.Time  `db:"last_seen_at"`
+	TombstonedAt  *time.Time `db:"tombstoned_at"`
+}
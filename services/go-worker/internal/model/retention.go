@@ -0,0 +1,39 @@
+package model
+
+import "time"
+
+// RetentionPolicy is an application whose messages expire after
+// RetentionDays. SearchIndex is empty while it uses the shared index.
+type RetentionPolicy struct {
+	ApplicationID uint   `db:"id"`
+	Token         string `db:"token"`
+	RetentionDays int    `db:"retention_days"`
+	SearchIndex   string `db:"search_index"`
+}
+
+// ExpiredMessages is how many messages of one chat a purge batch deleted
+type ExpiredMessages struct {
+	ChatID     uint
+	ChatNumber int
+	Count      int
+}
+
+const (
+	RetentionRunning   = "running"
+	RetentionCompleted = "completed"
+	RetentionFailed    = "failed"
+)
+
+// RetentionPurge is the audit record of one retention run for an application
+type RetentionPurge struct {
+	ID               uint      `db:"id"`
+	ApplicationID    uint      `db:"application_id"`
+	Cutoff           time.Time `db:"cutoff"`
+	Status           string    `db:"status"`
+	MessagesDeleted  int       `db:"messages_deleted"`
+	ChatsAffected    int       `db:"chats_affected"`
+	DocumentsDeleted *int      `db:"documents_deleted"`
+	Error            string    `db:"error"`
+	StartedAt        time.Time `db:"started_at"`
+	FinishedAt       time.Time `db:"finished_at"`
+}
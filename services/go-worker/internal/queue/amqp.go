@@ -1,9 +1,10 @@
 package queue
 
 import (
-	"encoding/json"
+	"fmt"
 	"go-worker/internal/config"
 	"go-worker/internal/logging"
+	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
@@ -14,11 +15,40 @@ const (
 	ChatsQueue    QueueType = "chats_queue"
 	MessagesQueue QueueType = "messages_queue"
 	IndexingQueue QueueType = "indexing_queue"
+	// DeletionsQueue carries application and chat deletions from go-chat
+	DeletionsQueue QueueType = "deletions_queue"
+
+	// DeadLetterQueue receives deliveries that can never be processed
+	DeadLetterQueue QueueType = "dead_letter_queue"
 )
 
+// RetryDelays are how long a delivery deferred with ErrRetryLater waits
+// before each redelivery. It is dead-lettered once they are used up.
+var RetryDelays = []time.Duration{
+	time.Second,
+	5 * time.Second,
+	30 * time.Second,
+	2 * time.Minute,
+	10 * time.Minute,
+}
+
+// delayedQueues have a delay queue per retry delay. Each delay queue holds
+// deliveries for its TTL and then dead-letters them back to the work queue;
+// one queue per delay keeps a long wait from holding up shorter ones.
+// Every work queue has them, so no handler's ErrRetryLater is requeued
+// straight away.
+var delayedQueues = []QueueType{ChatsQueue, MessagesQueue, IndexingQueue, DeletionsQueue}
+
+func delayQueueName(queueType QueueType, delay time.Duration) string {
+	return fmt.Sprintf("%s.delay.%ds", queueType, int(delay.Seconds()))
+}
+
+// AMQP is the RabbitMQ broker. Deliveries deferred with PublishDelayed wait
+// in the delay queue of their delay and come back through dead-lettering.
 type AMQP struct {
 	conn    *amqp.Connection
 	channel *amqp.Channel
+	logger  *logging.Logger
 }
 
 func NewAMQP(logger *logging.Logger, cfg *config.Config) (*AMQP, error) {
@@ -37,7 +67,7 @@ func NewAMQP(logger *logging.Logger, cfg *config.Config) (*AMQP, error) {
 		return nil, err
 	}
 
-	queues := []QueueType{ChatsQueue, MessagesQueue, IndexingQueue}
+	queues := []QueueType{ChatsQueue, MessagesQueue, IndexingQueue, DeletionsQueue, DeadLetterQueue}
 	for _, queueType := range queues {
 		logger.Info("Declaring AMQP '%s' queue", queueType)
 		_, err = channel.QueueDeclare(
@@ -56,27 +86,157 @@ func NewAMQP(logger *logging.Logger, cfg *config.Config) (*AMQP, error) {
 		logger.Info("AMQP '%s' queue declared successfully", queueType)
 	}
 
+	for _, queueType := range delayedQueues {
+		for _, delay := range RetryDelays {
+			_, err = channel.QueueDeclare(
+				delayQueueName(queueType, delay),
+				true,
+				false,
+				false,
+				false,
+				amqp.Table{
+					"x-message-ttl":             delay.Milliseconds(),
+					"x-dead-letter-exchange":    "",
+					"x-dead-letter-routing-key": string(queueType),
+				},
+			)
+			if err != nil {
+				logger.Error("Failed to declare AMQP delay queue: %v", err)
+				return nil, err
+			}
+		}
+		logger.Info("AMQP '%s' delay queues declared successfully", queueType)
+	}
+
+	// Deliveries are handled one at a time per queue
+	if err := channel.Qos(1, 0, false); err != nil {
+		logger.Error("Failed to set QoS: %v", err)
+		return nil, err
+	}
+
 	return &AMQP{
 		conn:    conn,
 		channel: channel,
+		logger:  logger,
 	}, nil
 }
 
-func (a *AMQP) Publish(queueType QueueType, payload interface{}) error {
-	body, err := json.Marshal(payload)
-	if err != nil {
-		return err
+func (a *AMQP) Publish(queueType QueueType, msg *Message) error {
+	return a.publish(string(queueType), msg)
+}
+
+// PublishDelayed parks a message in the delay queue for delay, it is
+// redelivered on queueType once the delay has passed
+func (a *AMQP) PublishDelayed(queueType QueueType, msg *Message, delay time.Duration) error {
+	if !HasDelayQueues(string(queueType)) {
+		return fmt.Errorf("%s has no delay queues", queueType)
+	}
+	for _, declared := range RetryDelays {
+		if declared == delay {
+			return a.publish(delayQueueName(queueType, delay), msg)
+		}
+	}
+	return fmt.Errorf("%s has no delay queue for %v", queueType, delay)
+}
+
+func (a *AMQP) publish(queueName string, msg *Message) error {
+	var headers amqp.Table
+	if len(msg.Headers) > 0 {
+		headers = make(amqp.Table, len(msg.Headers))
+		for key, value := range msg.Headers {
+			headers[key] = value
+		}
 	}
 
 	return a.channel.Publish(
-		"",                // exchange
-		string(queueType), // routing key
-		false,             // mandatory
-		false,             // immediate
+		"",        // exchange
+		queueName, // routing key
+		false,     // mandatory
+		false,     // immediate
 		amqp.Publishing{
 			DeliveryMode: amqp.Persistent,
 			ContentType:  "application/json",
-			Body:         body,
+			MessageId:    msg.ID,
+			Type:         msg.Type,
+			Timestamp:    msg.Timestamp,
+			Headers:      headers,
+			Body:         msg.Body,
 		},
 	)
 }
+
+// HasDelayQueues tells whether deliveries of a queue can be deferred
+func HasDelayQueues(queueName string) bool {
+	for _, queueType := range delayedQueues {
+		if string(queueType) == queueName {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *AMQP) Subscribe(queueName string, handler MessageHandler) error {
+	a.logger.Info("Starting consumer for queue: %s", queueName)
+
+	msgs, err := a.channel.Consume(
+		queueName,
+		"",
+		false,
+		false,
+		false,
+		false,
+		nil,
+	)
+	if err != nil {
+		a.logger.Error("Failed to register consumer: %v", err)
+		return err
+	}
+
+	a.logger.Info("Consumer started for queue: %s", queueName)
+
+	go func() {
+		for delivery := range msgs {
+			deliver(a.logger, amqpMessage(delivery), handler)
+		}
+	}()
+
+	return nil
+}
+
+func amqpMessage(delivery amqp.Delivery) *Message {
+	// A redelivery from a delay queue keeps the routing key of its work queue
+	msg := &Message{
+		ID:        delivery.MessageId,
+		Type:      delivery.Type,
+		Timestamp: delivery.Timestamp,
+		Headers:   make(map[string]string),
+		Body:      delivery.Body,
+		Queue:     delivery.RoutingKey,
+		ack: func() error {
+			return delivery.Ack(false)
+		},
+		nack: func(requeue bool) error {
+			return delivery.Nack(false, requeue)
+		},
+	}
+	for key, value := range delivery.Headers {
+		switch value := value.(type) {
+		case string:
+			msg.Headers[key] = value
+		case int32, int64, int:
+			msg.Headers[key] = fmt.Sprint(value)
+		}
+	}
+	return msg
+}
+
+func (a *AMQP) Close() error {
+	if a.channel != nil {
+		a.channel.Close()
+	}
+	if a.conn != nil {
+		return a.conn.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package queue
+
+import (
+	"go-worker/internal/contract"
+	"go-worker/internal/logging"
+	"time"
+)
+
+// Brokers selectable with BROKER
+const (
+	BrokerRabbitMQ = "rabbitmq"
+	BrokerRedis    = "redis"
+	BrokerMemory   = "memory"
+)
+
+// Message is one message on a queue, as published or as delivered. A
+// delivered message is settled with Ack or Nack exactly once.
+type Message struct {
+	ID        string
+	Type      string
+	Timestamp time.Time
+	Headers   map[string]string
+	Body      []byte
+
+	// Queue is where a delivered message came from
+	Queue string
+
+	ack  func() error
+	nack func(requeue bool) error
+}
+
+// NewMessage wraps an envelope for publishing
+func NewMessage(env *contract.Envelope) (*Message, error) {
+	body, err := env.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return &Message{
+		ID:        env.ID,
+		Type:      string(env.Type),
+		Timestamp: env.ProducedAt,
+		Body:      body,
+	}, nil
+}
+
+// Ack removes a processed message from its queue
+func (m *Message) Ack() error {
+	if m.ack == nil {
+		return nil
+	}
+	return m.ack()
+}
+
+// Nack gives a message back to be delivered again, or drops it
+func (m *Message) Nack(requeue bool) error {
+	if m.nack == nil {
+		return nil
+	}
+	return m.nack(requeue)
+}
+
+// MessageHandler processes a delivery. The subscriber acks the message when
+// it returns nil and requeues it otherwise.
+type MessageHandler func(msg *Message) error
+
+// Publisher puts messages on queues
+type Publisher interface {
+	Publish(queueType QueueType, msg *Message) error
+	// PublishDelayed makes the message deliverable on the queue once delay
+	// has passed. Delays other than RetryDelays may not be supported.
+	PublishDelayed(queueType QueueType, msg *Message, delay time.Duration) error
+}
+
+// Subscriber delivers the messages of a queue to a handler, one at a time,
+// until it is closed
+type Subscriber interface {
+	Subscribe(queueName string, handler MessageHandler) error
+	Close() error
+}
+
+// Broker is a Publisher and Subscriber on the same queues
+type Broker interface {
+	Publisher
+	Subscriber
+}
+
+// deliver runs the handler and settles the message the way it says
+func deliver(logger *logging.Logger, msg *Message, handler MessageHandler) {
+	logger.Info("[%s] Received message", msg.Queue)
+	if err := handler(msg); err != nil {
+		logger.Error("[%s] Error processing message: %v", msg.Queue, err)
+		if err := msg.Nack(true); err != nil {
+			logger.Error("[%s] Failed to requeue message %s: %v", msg.Queue, msg.ID, err)
+		}
+		return
+	}
+	if err := msg.Ack(); err != nil {
+		logger.Error("[%s] Failed to ack message %s: %v", msg.Queue, msg.ID, err)
+		return
+	}
+	logger.Info("[%s] Message processed successfully", msg.Queue)
+}
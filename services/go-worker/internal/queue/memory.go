@@ -0,0 +1,145 @@
+package queue
+
+import (
+	"fmt"
+	"go-worker/internal/logging"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// memoryQueueSize is how many messages a queue of the in-memory broker holds
+	memoryQueueSize = 10000
+
+	// A requeued message waits memoryRequeueDelay before its first
+	// redelivery, doubling each time up to memoryMaxRequeueDelay, so a
+	// failing handler does not spin
+	memoryRequeueDelay    = 100 * time.Millisecond
+	memoryMaxRequeueDelay = 10 * time.Second
+)
+
+// Memory is a broker held in process, for tests and for running go-worker
+// without RabbitMQ or Redis. Nothing is shared with other processes and
+// messages still queued are lost on exit.
+type Memory struct {
+	mu       sync.Mutex
+	queues   map[string]chan *memoryDelivery
+	logger   *logging.Logger
+	stopChan chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// memoryDelivery is a queued message and how often it was requeued
+type memoryDelivery struct {
+	msg      *Message
+	requeues int
+}
+
+func NewMemory(logger *logging.Logger) *Memory {
+	return &Memory{
+		queues:   make(map[string]chan *memoryDelivery),
+		logger:   logger.WithPrefix("MemoryBroker"),
+		stopChan: make(chan struct{}),
+	}
+}
+
+func (m *Memory) queue(queueName string) chan *memoryDelivery {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	q, ok := m.queues[queueName]
+	if !ok {
+		q = make(chan *memoryDelivery, memoryQueueSize)
+		m.queues[queueName] = q
+	}
+	return q
+}
+
+func (m *Memory) Publish(queueType QueueType, msg *Message) error {
+	return m.enqueue(queueType, msg, 0)
+}
+
+func (m *Memory) enqueue(queueType QueueType, msg *Message, requeues int) error {
+	headers := make(map[string]string, len(msg.Headers)+1)
+	for key, value := range msg.Headers {
+		headers[key] = value
+	}
+	headers[DeliveryCountHeader] = strconv.Itoa(requeues + 1)
+
+	queued := &Message{
+		ID:        msg.ID,
+		Type:      msg.Type,
+		Timestamp: msg.Timestamp,
+		Headers:   headers,
+		Body:      msg.Body,
+		Queue:     string(queueType),
+	}
+
+	select {
+	case m.queue(string(queueType)) <- &memoryDelivery{msg: queued, requeues: requeues}:
+		return nil
+	default:
+		return fmt.Errorf("%s is full", queueType)
+	}
+}
+
+func (m *Memory) PublishDelayed(queueType QueueType, msg *Message, delay time.Duration) error {
+	time.AfterFunc(delay, func() {
+		if err := m.Publish(queueType, msg); err != nil {
+			m.logger.Error("Failed to publish delayed message %s: %v", msg.ID, err)
+		}
+	})
+	return nil
+}
+
+func (m *Memory) Subscribe(queueName string, handler MessageHandler) error {
+	q := m.queue(queueName)
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		for {
+			select {
+			case d := <-q:
+				d.msg.nack = func(requeue bool) error {
+					if requeue {
+						m.requeue(QueueType(queueName), d)
+					}
+					return nil
+				}
+				deliver(m.logger, d.msg, handler)
+			case <-m.stopChan:
+				return
+			}
+		}
+	}()
+
+	m.logger.Info("Consumer started for queue: %s", queueName)
+	return nil
+}
+
+// requeue puts a nacked message back once its backoff has passed
+func (m *Memory) requeue(queueType QueueType, d *memoryDelivery) {
+	delay := memoryRequeueDelay
+	for i := 0; i < d.requeues && delay < memoryMaxRequeueDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, memoryMaxRequeueDelay)
+
+	time.AfterFunc(delay, func() {
+		if err := m.enqueue(queueType, d.msg, d.requeues+1); err != nil {
+			m.logger.Error("Failed to requeue message %s: %v", d.msg.ID, err)
+		}
+	})
+}
+
+// Close stops delivering and waits for the deliveries being handled
+func (m *Memory) Close() error {
+	m.stopOnce.Do(func() {
+		close(m.stopChan)
+	})
+	m.wg.Wait()
+	return nil
+}
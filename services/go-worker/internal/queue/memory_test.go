@@ -0,0 +1,178 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"go-worker/internal/config"
+	"go-worker/internal/contract"
+	"go-worker/internal/database"
+	"go-worker/internal/logging"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+func newTestMemory(t *testing.T) (*Memory, *logging.Logger) {
+	t.Helper()
+
+	logger := logging.NewLogger(&config.Config{AppName: "queue-test", LogPath: t.TempDir()})
+	m := NewMemory(logger)
+	t.Cleanup(func() { m.Close() })
+	return m, logger
+}
+
+func testMessage(t *testing.T, payload contract.Payload) *Message {
+	t.Helper()
+
+	env, err := contract.NewEnvelope(payload, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := NewMessage(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func receive(t *testing.T, ch <-chan *Message, within time.Duration) *Message {
+	t.Helper()
+
+	select {
+	case msg := <-ch:
+		return msg
+	case <-time.After(within):
+		t.Fatalf("no delivery within %v", within)
+		return nil
+	}
+}
+
+func TestMemoryAck(t *testing.T) {
+	m, _ := newTestMemory(t)
+
+	var calls atomic.Int32
+	delivered := make(chan *Message, 10)
+	err := m.Subscribe(string(ChatsQueue), func(msg *Message) error {
+		calls.Add(1)
+		delivered <- msg
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sent := testMessage(t, &contract.ChatCreated{AppToken: "token", ChatNumber: 1})
+	if err := m.Publish(ChatsQueue, sent); err != nil {
+		t.Fatal(err)
+	}
+
+	got := receive(t, delivered, time.Second)
+	if got.ID != sent.ID || got.Queue != string(ChatsQueue) {
+		t.Errorf("delivered %s from %s, want %s from %s", got.ID, got.Queue, sent.ID, ChatsQueue)
+	}
+
+	// An acked message is not delivered again, even after a requeue would be due
+	time.Sleep(3 * memoryRequeueDelay)
+	if n := calls.Load(); n != 1 {
+		t.Errorf("handler ran %d times, want 1", n)
+	}
+}
+
+func TestMemoryNackRequeuesWithBackoff(t *testing.T) {
+	m, _ := newTestMemory(t)
+
+	const failures = 3
+	var calls atomic.Int32
+	attempts := make(chan time.Time, 10)
+	err := m.Subscribe(string(ChatsQueue), func(msg *Message) error {
+		attempts <- time.Now()
+		if calls.Add(1) <= failures {
+			return errors.New("not yet")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Publish(ChatsQueue, testMessage(t, &contract.ChatCreated{AppToken: "token", ChatNumber: 1})); err != nil {
+		t.Fatal(err)
+	}
+
+	var times []time.Time
+	for i := 0; i <= failures; i++ {
+		select {
+		case at := <-attempts:
+			times = append(times, at)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %d deliveries, want %d", len(times), failures+1)
+		}
+	}
+
+	// Each redelivery waits twice as long as the one before
+	for i := 1; i < len(times); i++ {
+		want := memoryRequeueDelay << (i - 1)
+		if gap := times[i].Sub(times[i-1]); gap < want {
+			t.Errorf("redelivery %d came after %v, want at least %v", i, gap, want)
+		}
+	}
+
+	time.Sleep(3 * memoryRequeueDelay)
+	if n := calls.Load(); n != failures+1 {
+		t.Errorf("handler ran %d times, want %d", n, failures+1)
+	}
+}
+
+func TestMemoryDeadLetter(t *testing.T) {
+	m, logger := newTestMemory(t)
+
+	// Nothing listens there, recording dead-lettered numbers fails and is
+	// only logged
+	rdb := redis.NewClient(&redis.Options{Addr: "127.0.0.1:0", DialTimeout: 10 * time.Millisecond, MaxRetries: -1})
+	t.Cleanup(func() { rdb.Close() })
+	registry := NewRegistry(m, &database.Database{RedisDB: rdb}, logger)
+	registry.Register(contract.TypeChatCreated, 1, func(env *contract.Envelope) error {
+		return fmt.Errorf("%w: application is gone", contract.ErrInvalid)
+	})
+
+	deadLettered := make(chan *Message, 10)
+	if err := m.Subscribe(string(DeadLetterQueue), func(msg *Message) error {
+		deadLettered <- msg
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Subscribe(string(ChatsQueue), registry.Dispatch); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		msg    *Message
+		reason error
+	}{
+		{"rejected by handler", testMessage(t, &contract.ChatCreated{AppToken: "token", ChatNumber: 1}), contract.ErrInvalid},
+		{"unknown type", testMessage(t, &contract.ChatDeleted{AppToken: "token", ChatNumber: 1}), contract.ErrUnknownType},
+		{"not an envelope", &Message{ID: "garbage", Body: []byte("{")}, contract.ErrInvalid},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := m.Publish(ChatsQueue, tc.msg); err != nil {
+				t.Fatal(err)
+			}
+
+			got := receive(t, deadLettered, 2*time.Second)
+			if got.ID != tc.msg.ID || string(got.Body) != string(tc.msg.Body) {
+				t.Errorf("dead-lettered %s %q, want %s %q", got.ID, got.Body, tc.msg.ID, tc.msg.Body)
+			}
+			if q := got.Headers["x-original-queue"]; q != string(ChatsQueue) {
+				t.Errorf("x-original-queue = %q, want %q", q, ChatsQueue)
+			}
+			if reason := got.Headers["x-reject-reason"]; reason == "" {
+				t.Errorf("x-reject-reason is empty, want it to mention %v", tc.reason)
+			}
+		})
+	}
+}
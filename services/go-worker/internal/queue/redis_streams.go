@@ -0,0 +1,316 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go-worker/internal/config"
+	"go-worker/internal/contract"
+	"go-worker/internal/database"
+	"go-worker/internal/logging"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// streamGroup is the consumer group every go-worker instance reads with, so
+// each message goes to one of them
+const streamGroup = "go-worker"
+
+// streamEntry is a Message as stored in the "message" field of a stream entry
+// and in the sorted set of delayed messages. go-chat writes the same encoding.
+type streamEntry struct {
+	ID        string            `json:"id"`
+	Type      string            `json:"type"`
+	Timestamp time.Time         `json:"timestamp"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Body      []byte            `json:"body"`
+}
+
+// RedisStreams is the Redis Streams broker. Each queue is the stream
+// contract.QueueStream, read through a consumer group. A delivery stays
+// pending until it is acked, then it is deleted from the stream. One left
+// pending for claimIdle, because it was nacked or its instance died, is
+// claimed with XAUTOCLAIM and delivered again, with the delivery count
+// XPENDING keeps for it so the registry gives up on it after MaxDeliveries.
+// Delayed messages wait in contract.QueueDelayed until they are due.
+type RedisStreams struct {
+	redis     redis.UniversalClient
+	logger    *logging.Logger
+	consumer  string
+	claimIdle time.Duration
+	stopChan  chan struct{}
+	stopOnce  sync.Once
+	wg        sync.WaitGroup
+}
+
+func NewRedisStreams(db *database.Database, cfg *config.Config, logger *logging.Logger) *RedisStreams {
+	host, _ := os.Hostname()
+	return &RedisStreams{
+		redis:     db.RedisDB,
+		logger:    logger.WithPrefix("RedisStreams"),
+		consumer:  fmt.Sprintf("%s:%d", host, os.Getpid()),
+		claimIdle: cfg.BrokerClaimIdle,
+		stopChan:  make(chan struct{}),
+	}
+}
+
+func (r *RedisStreams) Publish(queueType QueueType, msg *Message) error {
+	entry, err := encodeEntry(msg)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return r.redis.XAdd(ctx, &redis.XAddArgs{
+		Stream: contract.QueueStream(string(queueType)),
+		Values: map[string]interface{}{"message": entry},
+	}).Err()
+}
+
+func (r *RedisStreams) PublishDelayed(queueType QueueType, msg *Message, delay time.Duration) error {
+	entry, err := encodeEntry(msg)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	due := time.Now().Add(delay).UnixMilli()
+	return r.redis.ZAdd(ctx, contract.QueueDelayed(string(queueType)), &redis.Z{
+		Score:  float64(due),
+		Member: entry,
+	}).Err()
+}
+
+func encodeEntry(msg *Message) (string, error) {
+	raw, err := json.Marshal(&streamEntry{
+		ID:        msg.ID,
+		Type:      msg.Type,
+		Timestamp: msg.Timestamp,
+		Headers:   msg.Headers,
+		Body:      msg.Body,
+	})
+	return string(raw), err
+}
+
+// promoteScript moves due delayed messages to the stream. Both keys carry the
+// queue's hash tag, so in Redis Cluster they are on the node running it.
+var promoteScript = redis.NewScript(`
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, 100)
+for _, member in ipairs(due) do
+    redis.call('XADD', KEYS[2], '*', 'message', member)
+    redis.call('ZREM', KEYS[1], member)
+end
+return #due
+`)
+
+func (r *RedisStreams) Subscribe(queueName string, handler MessageHandler) error {
+	r.logger.Info("Starting consumer for queue: %s", queueName)
+
+	// From the start of the stream, what was published before the group
+	// existed is delivered too
+	stream := contract.QueueStream(queueName)
+	err := r.redis.XGroupCreateMkStream(context.Background(), stream, streamGroup, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		r.logger.Error("Failed to create consumer group on %s: %v", stream, err)
+		return err
+	}
+
+	r.wg.Add(1)
+	go r.consume(queueName, handler)
+
+	r.logger.Info("Consumer started for queue: %s", queueName)
+	return nil
+}
+
+func (r *RedisStreams) consume(queueName string, handler MessageHandler) {
+	defer r.wg.Done()
+
+	ctx := context.Background()
+	stream := contract.QueueStream(queueName)
+	var lastClaim time.Time
+
+	for {
+		select {
+		case <-r.stopChan:
+			return
+		default:
+		}
+
+		if err := r.promote(ctx, queueName); err != nil {
+			r.logger.Error("Failed to move due messages to %s: %v", stream, err)
+		}
+		if time.Since(lastClaim) >= r.claimIdle/2 {
+			r.claim(ctx, queueName, handler)
+			lastClaim = time.Now()
+		}
+
+		// Blocks for a second at most, so due and stuck messages are looked
+		// after and a stop is noticed
+		streams, err := r.redis.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    streamGroup,
+			Consumer: r.consumer,
+			Streams:  []string{stream, ">"},
+			Count:    1,
+			Block:    time.Second,
+		}).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			r.logger.Error("Failed to read from %s: %v", stream, err)
+			select {
+			case <-time.After(time.Second):
+			case <-r.stopChan:
+				return
+			}
+			continue
+		}
+
+		for _, s := range streams {
+			for _, entry := range s.Messages {
+				deliver(r.logger, r.message(queueName, entry.ID, entry.Values["message"], 1), handler)
+			}
+		}
+	}
+}
+
+func (r *RedisStreams) promote(ctx context.Context, queueName string) error {
+	keys := []string{contract.QueueDelayed(queueName), contract.QueueStream(queueName)}
+	return promoteScript.Run(ctx, r.redis, keys, time.Now().UnixMilli()).Err()
+}
+
+// claim takes over deliveries left pending for claimIdle and handles them.
+// XAUTOCLAIM is sent as is, the client only parses the reply of Redis 6.2,
+// which lacks the deleted IDs Redis 7 adds.
+func (r *RedisStreams) claim(ctx context.Context, queueName string, handler MessageHandler) {
+	stream := contract.QueueStream(queueName)
+	start := "0-0"
+	for {
+		reply, err := r.redis.Do(ctx, "XAUTOCLAIM", stream, streamGroup, r.consumer,
+			r.claimIdle.Milliseconds(), start, "COUNT", 10).Slice()
+		if err != nil {
+			r.logger.Error("Failed to claim stuck messages of %s: %v", stream, err)
+			return
+		}
+		if len(reply) < 2 {
+			r.logger.Error("Unexpected XAUTOCLAIM reply on %s: %v", stream, reply)
+			return
+		}
+
+		entries, _ := reply[1].([]interface{})
+		for _, raw := range entries {
+			entry, ok := raw.([]interface{})
+			if !ok || len(entry) < 2 {
+				continue
+			}
+			id, _ := entry[0].(string)
+			// Redis 6.2 lists entries deleted meanwhile without fields
+			fields, _ := entry[1].([]interface{})
+			var value interface{}
+			for i := 0; i+1 < len(fields); i += 2 {
+				if fields[i] == "message" {
+					value = fields[i+1]
+				}
+			}
+			if value == nil {
+				r.settle(ctx, queueName, id)
+				continue
+			}
+			deliveries, err := r.deliveries(ctx, stream, id)
+			if err != nil {
+				r.logger.Error("Failed to read the delivery count of %s on %s: %v", id, stream, err)
+			}
+			r.logger.Info("[%s] Claimed message %s left pending for %v, delivery %d", queueName, id, r.claimIdle, deliveries)
+			deliver(r.logger, r.message(queueName, id, value, deliveries), handler)
+		}
+
+		start, _ = reply[0].(string)
+		if start == "" || start == "0-0" {
+			return
+		}
+	}
+}
+
+// deliveries reads how often a pending entry has been delivered, claiming
+// it counts as a delivery
+func (r *RedisStreams) deliveries(ctx context.Context, stream, entryID string) (int, error) {
+	pending, err := r.redis.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: stream,
+		Group:  streamGroup,
+		Start:  entryID,
+		End:    entryID,
+		Count:  1,
+	}).Result()
+	if err != nil || len(pending) == 0 {
+		return 0, err
+	}
+	return int(pending[0].RetryCount), nil
+}
+
+// message decodes a stream entry. One that cannot be decoded is passed on
+// as it is, the registry dead-letters it.
+func (r *RedisStreams) message(queueName, entryID string, value interface{}, deliveries int) *Message {
+	raw, _ := value.(string)
+
+	var entry streamEntry
+	if err := json.Unmarshal([]byte(raw), &entry); err != nil {
+		entry = streamEntry{ID: entryID, Body: []byte(raw)}
+	}
+
+	headers := make(map[string]string, len(entry.Headers)+1)
+	for key, value := range entry.Headers {
+		headers[key] = value
+	}
+	if deliveries > 0 {
+		headers[DeliveryCountHeader] = strconv.Itoa(deliveries)
+	}
+
+	ctx := context.Background()
+	return &Message{
+		ID:        entry.ID,
+		Type:      entry.Type,
+		Timestamp: entry.Timestamp,
+		Headers:   headers,
+		Body:      entry.Body,
+		Queue:     queueName,
+		ack: func() error {
+			return r.settle(ctx, queueName, entryID)
+		},
+		nack: func(requeue bool) error {
+			if requeue {
+				// Left pending, claimed again once claimIdle has passed
+				return nil
+			}
+			return r.settle(ctx, queueName, entryID)
+		},
+	}
+}
+
+// settle acknowledges a delivery and deletes it, acked entries are never
+// read again
+func (r *RedisStreams) settle(ctx context.Context, queueName, entryID string) error {
+	stream := contract.QueueStream(queueName)
+	pipe := r.redis.Pipeline()
+	pipe.XAck(ctx, stream, streamGroup, entryID)
+	pipe.XDel(ctx, stream, entryID)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Close stops reading and waits for the deliveries being handled
+func (r *RedisStreams) Close() error {
+	r.stopOnce.Do(func() {
+		close(r.stopChan)
+	})
+	r.wg.Wait()
+	return nil
+}
@@ -0,0 +1,153 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"go-worker/internal/config"
+	"go-worker/internal/contract"
+	"go-worker/internal/database"
+	"go-worker/internal/logging"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+const testClaimIdle = 5 * time.Millisecond
+
+func newTestStreams(t *testing.T) (*RedisStreams, redis.UniversalClient, *logging.Logger) {
+	t.Helper()
+
+	m := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+
+	cfg := &config.Config{AppName: "queue-test", LogPath: t.TempDir(), BrokerClaimIdle: testClaimIdle}
+	logger := logging.NewLogger(cfg)
+	r := NewRedisStreams(&database.Database{RedisDB: rdb}, cfg, logger)
+	r.consumer = "test"
+	return r, rdb, logger
+}
+
+// readOnce delivers the next new entry of queueName to a consumer that never
+// settles it, as an instance that dies while handling it would
+func readOnce(t *testing.T, rdb redis.UniversalClient, queueName string) string {
+	t.Helper()
+
+	ctx := context.Background()
+	stream := contract.QueueStream(queueName)
+	if err := rdb.XGroupCreateMkStream(ctx, stream, streamGroup, "0").Err(); err != nil && err.Error() != "BUSYGROUP Consumer Group name already exists" {
+		t.Fatal(err)
+	}
+	streams, err := rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    streamGroup,
+		Consumer: "gone",
+		Streams:  []string{stream, ">"},
+		Count:    1,
+	}).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return streams[0].Messages[0].ID
+}
+
+func pendingCount(t *testing.T, rdb redis.UniversalClient, queueName string) int64 {
+	t.Helper()
+
+	pending, err := rdb.XPending(context.Background(), contract.QueueStream(queueName), streamGroup).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pending.Count
+}
+
+func TestRedisStreamsClaimCountsDeliveries(t *testing.T) {
+	r, rdb, _ := newTestStreams(t)
+	ctx := context.Background()
+
+	sent := testMessage(t, &contract.ChatCreated{AppToken: "token", ChatNumber: 1})
+	if err := r.Publish(ChatsQueue, sent); err != nil {
+		t.Fatal(err)
+	}
+	readOnce(t, rdb, string(ChatsQueue))
+
+	var counts []string
+	failing := func(msg *Message) error {
+		if msg.ID != sent.ID {
+			t.Errorf("claimed %s, want %s", msg.ID, sent.ID)
+		}
+		counts = append(counts, msg.Headers[DeliveryCountHeader])
+		return errors.New("still failing")
+	}
+	for i := 0; i < 3; i++ {
+		time.Sleep(2 * testClaimIdle)
+		r.claim(ctx, string(ChatsQueue), failing)
+	}
+
+	want := []string{"2", "3", "4"}
+	if len(counts) != len(want) {
+		t.Fatalf("claimed %d times, want %d", len(counts), len(want))
+	}
+	for i := range want {
+		if counts[i] != want[i] {
+			t.Errorf("delivery count of claim %d = %s, want %s", i+1, counts[i], want[i])
+		}
+	}
+	if n := pendingCount(t, rdb, string(ChatsQueue)); n != 1 {
+		t.Errorf("%d entries pending, want the failing one", n)
+	}
+}
+
+func TestRedisStreamsDeadLettersAfterMaxDeliveries(t *testing.T) {
+	r, rdb, logger := newTestStreams(t)
+	ctx := context.Background()
+
+	registry := NewRegistry(r, &database.Database{RedisDB: rdb}, logger)
+	var handled int
+	registry.Register(contract.TypeChatCreated, 1, func(env *contract.Envelope) error {
+		handled++
+		return errors.New("still failing")
+	})
+
+	sent := testMessage(t, &contract.ChatCreated{AppToken: "token", ChatNumber: 1})
+	if err := r.Publish(ChatsQueue, sent); err != nil {
+		t.Fatal(err)
+	}
+	readOnce(t, rdb, string(ChatsQueue))
+
+	// The first delivery went to the consumer that died, every claim after
+	// it is handled until MaxDeliveries is reached
+	for i := 0; i < MaxDeliveries; i++ {
+		time.Sleep(2 * testClaimIdle)
+		r.claim(ctx, string(ChatsQueue), registry.Dispatch)
+	}
+
+	if handled != MaxDeliveries-1 {
+		t.Errorf("handler ran %d times, want %d", handled, MaxDeliveries-1)
+	}
+	if n := pendingCount(t, rdb, string(ChatsQueue)); n != 0 {
+		t.Errorf("%d entries still pending, want none", n)
+	}
+
+	dead, err := rdb.XRange(ctx, contract.QueueStream(string(DeadLetterQueue)), "-", "+").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 1 {
+		t.Fatalf("%d dead-lettered entries, want 1", len(dead))
+	}
+	got := r.message(string(DeadLetterQueue), dead[0].ID, dead[0].Values["message"], 0)
+	if got.ID != sent.ID || got.Headers["x-original-queue"] != string(ChatsQueue) {
+		t.Errorf("dead-lettered %s from %q, want %s from %s", got.ID, got.Headers["x-original-queue"], sent.ID, ChatsQueue)
+	}
+	if reason := got.Headers["x-reject-reason"]; reason != "gave up after "+strconv.Itoa(MaxDeliveries)+" deliveries" {
+		t.Errorf("x-reject-reason = %q", reason)
+	}
+
+	// Gap detection hears of the number that will never be persisted
+	if ok, _ := rdb.SIsMember(ctx, contract.DeadLetteredNumbers("token"), contract.NumberRef(1, 0)).Result(); !ok {
+		t.Error("dead-lettered chat number was not recorded")
+	}
+}
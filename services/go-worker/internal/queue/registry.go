@@ -0,0 +1,211 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-worker/internal/contract"
+	"go-worker/internal/database"
+	"go-worker/internal/logging"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ErrRetryLater defers a delivery that cannot be processed yet, such as a
+// message whose chat is still on its way. It is redelivered after each of
+// RetryDelays in turn instead of being requeued at once.
+var ErrRetryLater = errors.New("retry later")
+
+// MaxDeliveries bounds how often a delivery that keeps failing is handed to
+// its handler. Brokers count deliveries in the DeliveryCountHeader, one
+// delivered more often than this is dead-lettered without being handled.
+const MaxDeliveries = 10
+
+// DeliveryCountHeader is the header RabbitMQ quorum queues count deliveries
+// in, the other brokers set it the same way
+const DeliveryCountHeader = "x-delivery-count"
+
+// EnvelopeHandler processes a decoded envelope. Returning an error wrapping
+// contract.ErrInvalid dead-letters the delivery, ErrRetryLater defers it and
+// any other error requeues it.
+type EnvelopeHandler func(env *contract.Envelope) error
+
+// Registry maps message types and schema versions to their handlers
+type Registry struct {
+	handlers  map[contract.MessageType]map[int]EnvelopeHandler
+	publisher Publisher
+	redis     redis.UniversalClient
+	logger    *logging.Logger
+}
+
+func NewRegistry(broker Broker, db *database.Database, logger *logging.Logger) *Registry {
+	return &Registry{
+		handlers:  make(map[contract.MessageType]map[int]EnvelopeHandler),
+		publisher: broker,
+		redis:     db.RedisDB,
+		logger:    logger.WithPrefix("Registry"),
+	}
+}
+
+func (r *Registry) Register(msgType contract.MessageType, version int, handler EnvelopeHandler) {
+	if r.handlers[msgType] == nil {
+		r.handlers[msgType] = make(map[int]EnvelopeHandler)
+	}
+	r.handlers[msgType][version] = handler
+	r.logger.Info("Registered handler for %s v%d", msgType, version)
+}
+
+// Dispatch is a MessageHandler that routes a delivery to the handler
+// registered for its envelope type and version
+func (r *Registry) Dispatch(msg *Message) error {
+	env, err := contract.Parse(msg.Body)
+	if err != nil {
+		return r.deadLetter(msg, err)
+	}
+
+	versions, ok := r.handlers[env.Type]
+	if !ok {
+		return r.deadLetter(msg, fmt.Errorf("%w: %s", contract.ErrUnknownType, env.Type))
+	}
+
+	handler, ok := versions[env.Version]
+	if !ok {
+		return r.deadLetter(msg, fmt.Errorf("%w: %s v%d", contract.ErrUnsupportedVersion, env.Type, env.Version))
+	}
+
+	if deliveries := messageDeliveries(msg); deliveries > MaxDeliveries {
+		return r.deadLetter(msg, fmt.Errorf("gave up after %d deliveries", deliveries-1))
+	}
+
+	err = handler(env)
+	deferrals := messageDeferrals(msg)
+	if errors.Is(err, ErrRetryLater) {
+		if !HasDelayQueues(msg.Queue) {
+			// Requeueing it at once would only hand it straight back
+			return r.deadLetter(msg, fmt.Errorf("%s cannot defer deliveries: %w", msg.Queue, err))
+		}
+		return r.retryLater(msg, env, deferrals, err)
+	}
+	if err == nil && deferrals > 0 {
+		r.countDeferral(env.Type, "recovered")
+	}
+	if errors.Is(err, contract.ErrInvalid) ||
+		errors.Is(err, contract.ErrUnknownType) ||
+		errors.Is(err, contract.ErrUnsupportedVersion) {
+		return r.deadLetter(msg, err)
+	}
+
+	return err
+}
+
+// deadLetter moves an unprocessable delivery to the dead-letter queue,
+// keeping the original body untouched and recording why it was rejected
+func (r *Registry) deadLetter(msg *Message, reason error) error {
+	r.logger.Error("Dead-lettering message %s from %s: %v", msg.ID, msg.Queue, reason)
+	err := r.publisher.Publish(DeadLetterQueue, &Message{
+		ID:        msg.ID,
+		Type:      msg.Type,
+		Timestamp: msg.Timestamp,
+		Headers: map[string]string{
+			"x-original-queue": msg.Queue,
+			"x-reject-reason":  reason.Error(),
+		},
+		Body: msg.Body,
+	})
+	if err != nil {
+		r.logger.Error("Failed to publish to dead-letter queue: %v", err)
+		return err
+	}
+	r.recordDeadLetteredNumber(msg)
+	return nil
+}
+
+// recordDeadLetteredNumber remembers the chat or message number a
+// dead-lettered creation carried, for gap detection to report
+func (r *Registry) recordDeadLetteredNumber(msg *Message) {
+	env, err := contract.Parse(msg.Body)
+	if err != nil {
+		return
+	}
+
+	var appToken, ref string
+	switch env.Type {
+	case contract.TypeChatCreated:
+		var payload contract.ChatCreated
+		if env.Decode(&payload) != nil {
+			return
+		}
+		appToken, ref = payload.AppToken, contract.NumberRef(payload.ChatNumber, 0)
+	case contract.TypeMessageCreated:
+		var payload contract.MessageCreated
+		if env.Decode(&payload) != nil {
+			return
+		}
+		appToken, ref = payload.AppToken, contract.NumberRef(payload.ChatNumber, payload.MessageNumber)
+	default:
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := r.redis.SAdd(ctx, contract.DeadLetteredNumbers(appToken), ref).Err(); err != nil {
+		r.logger.Error("Failed to record dead-lettered number %s of %s: %v", ref, appToken, err)
+	}
+}
+
+// retryLater parks the delivery in the delay queue for its next attempt, or
+// dead-letters it once every delay has been waited out
+func (r *Registry) retryLater(msg *Message, env *contract.Envelope, deferrals int, reason error) error {
+	if deferrals >= len(RetryDelays) {
+		r.countDeferral(env.Type, "dead_lettered")
+		return r.deadLetter(msg, fmt.Errorf("gave up after %d deferrals: %w", deferrals, reason))
+	}
+
+	delay := RetryDelays[deferrals]
+	err := r.publisher.PublishDelayed(QueueType(msg.Queue), &Message{
+		ID:        msg.ID,
+		Type:      msg.Type,
+		Timestamp: msg.Timestamp,
+		Headers: map[string]string{
+			"x-deferrals":    strconv.Itoa(deferrals + 1),
+			"x-defer-reason": reason.Error(),
+		},
+		Body: msg.Body,
+	}, delay)
+	if err != nil {
+		r.logger.Error("Failed to defer message %s: %v", msg.ID, err)
+		return err
+	}
+
+	r.logger.Info("Deferred message %s by %v (deferral %d/%d): %v",
+		msg.ID, delay, deferrals+1, len(RetryDelays), reason)
+	r.countDeferral(env.Type, "deferred")
+	return nil
+}
+
+// countDeferral adds one to a counter of the deferral metrics, which are
+// shared by every go-worker instance
+func (r *Registry) countDeferral(msgType contract.MessageType, event string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	field := fmt.Sprintf("%s:%s", msgType, event)
+	if err := r.redis.HIncrBy(ctx, contract.DeferralMetricsKey, field, 1).Err(); err != nil {
+		r.logger.Error("Failed to count %s: %v", field, err)
+	}
+}
+
+// messageDeliveries is how often the broker has delivered msg, 0 when it
+// does not count
+func messageDeliveries(msg *Message) int {
+	n, _ := strconv.Atoi(msg.Headers[DeliveryCountHeader])
+	return n
+}
+
+func messageDeferrals(msg *Message) int {
+	n, _ := strconv.Atoi(msg.Headers["x-deferrals"])
+	return n
+}
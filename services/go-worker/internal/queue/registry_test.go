@@ -0,0 +1,113 @@
+package queue
+
+import (
+	"fmt"
+	"go-worker/internal/contract"
+	"go-worker/internal/database"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func TestRegistryDefersOnEveryWorkQueue(t *testing.T) {
+	m, logger := newTestMemory(t)
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	t.Cleanup(func() { rdb.Close() })
+
+	var mu sync.Mutex
+	attempts := make(map[string]int)
+	registry := NewRegistry(m, &database.Database{RedisDB: rdb}, logger)
+	registry.Register(contract.TypeChatCreated, 1, func(env *contract.Envelope) error {
+		var payload contract.ChatCreated
+		if err := env.Decode(&payload); err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		attempts[payload.AppToken]++
+		if attempts[payload.AppToken] == 1 {
+			return fmt.Errorf("%w: not yet", ErrRetryLater)
+		}
+		return nil
+	})
+
+	redelivered := make(chan *Message, len(delayedQueues))
+	for _, queueType := range delayedQueues {
+		if err := m.Subscribe(string(queueType), func(msg *Message) error {
+			if msg.Headers["x-deferrals"] != "" {
+				redelivered <- msg
+			}
+			return registry.Dispatch(msg)
+		}); err != nil {
+			t.Fatal(err)
+		}
+		msg := testMessage(t, &contract.ChatCreated{AppToken: string(queueType), ChatNumber: 1})
+		if err := m.Publish(queueType, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Each comes back once, after the first retry delay rather than at once
+	seen := make(map[string]bool)
+	for range delayedQueues {
+		msg := receive(t, redelivered, RetryDelays[0]+2*time.Second)
+		if msg.Headers["x-deferrals"] != "1" {
+			t.Errorf("%s redelivered with x-deferrals %q, want 1", msg.Queue, msg.Headers["x-deferrals"])
+		}
+		seen[msg.Queue] = true
+	}
+	for _, queueType := range delayedQueues {
+		if !seen[string(queueType)] {
+			t.Errorf("%s was not deferred", queueType)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for token, n := range attempts {
+		if n != 2 {
+			t.Errorf("%s handled %d times, want 2", token, n)
+		}
+	}
+}
+
+func TestRegistryDeadLettersWhenItCannotDefer(t *testing.T) {
+	m, logger := newTestMemory(t)
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	t.Cleanup(func() { rdb.Close() })
+
+	registry := NewRegistry(m, &database.Database{RedisDB: rdb}, logger)
+	var handled int
+	registry.Register(contract.TypeChatCreated, 1, func(env *contract.Envelope) error {
+		handled++
+		return fmt.Errorf("%w: not yet", ErrRetryLater)
+	})
+
+	deadLettered := make(chan *Message, 1)
+	if err := m.Subscribe(string(DeadLetterQueue), func(msg *Message) error {
+		deadLettered <- msg
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	const undelayed QueueType = "undelayed_queue"
+	if err := m.Subscribe(string(undelayed), registry.Dispatch); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := testMessage(t, &contract.ChatCreated{AppToken: "token", ChatNumber: 1})
+	if err := m.Publish(undelayed, sent); err != nil {
+		t.Fatal(err)
+	}
+
+	got := receive(t, deadLettered, 2*time.Second)
+	if got.ID != sent.ID || got.Headers["x-original-queue"] != string(undelayed) {
+		t.Errorf("dead-lettered %s from %q, want %s from %s", got.ID, got.Headers["x-original-queue"], sent.ID, undelayed)
+	}
+	if handled != 1 {
+		t.Errorf("handled %d times, want 1", handled)
+	}
+}
@@ -0,0 +1,110 @@
+package search
+
+import (
+	"fmt"
+	"go-worker/internal/contract"
+	"sync"
+	"time"
+)
+
+// MemoryBackend keeps indexed documents in process memory. go-chat's
+// in-process backend builds its own index from MySQL and the stream hub, so
+// this only lets go-worker run without Elasticsearch and gives tests a
+// backend to inspect.
+type MemoryBackend struct {
+	mu   sync.RWMutex
+	docs map[string]Document
+}
+
+var _ Backend = (*MemoryBackend)(nil)
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{docs: make(map[string]Document)}
+}
+
+func (b *MemoryBackend) Index(doc Document) error {
+	return b.Bulk([]Document{doc})
+}
+
+func (b *MemoryBackend) Bulk(docs []Document) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, doc := range docs {
+		msg := doc.Message
+		b.docs[documentKey(msg.ApplicationToken, msg.ChatNumber, msg.MessageNumber)] = doc
+	}
+	return nil
+}
+
+func (b *MemoryBackend) Delete(index string, appToken string, chatNumber int, messageNumber int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.docs, documentKey(appToken, chatNumber, messageNumber))
+	return nil
+}
+
+func (b *MemoryBackend) DeleteBefore(index string, appToken string, cutoff time.Time) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	deleted := 0
+	for key, doc := range b.docs {
+		if doc.Message.ApplicationToken == appToken && doc.Message.CreatedAt.Before(cutoff) {
+			delete(b.docs, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (b *MemoryBackend) DeleteChat(index string, appToken string, chatNumber int) (int, error) {
+	return b.deleteWhere(func(msg contract.MessageIndex) bool {
+		return msg.ApplicationToken == appToken && msg.ChatNumber == chatNumber
+	}), nil
+}
+
+func (b *MemoryBackend) DeleteApplication(index string, appToken string) (int, error) {
+	return b.deleteWhere(func(msg contract.MessageIndex) bool {
+		return msg.ApplicationToken == appToken
+	}), nil
+}
+
+func (b *MemoryBackend) deleteWhere(match func(msg contract.MessageIndex) bool) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	deleted := 0
+	for key, doc := range b.docs {
+		if match(doc.Message) {
+			delete(b.docs, key)
+			deleted++
+		}
+	}
+	return deleted
+}
+
+func (b *MemoryBackend) HealthCheck() error {
+	return nil
+}
+
+// Get returns an indexed document, for tests
+func (b *MemoryBackend) Get(appToken string, chatNumber int, messageNumber int) (Document, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	doc, ok := b.docs[documentKey(appToken, chatNumber, messageNumber)]
+	return doc, ok
+}
+
+func (b *MemoryBackend) Len() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return len(b.docs)
+}
+
+func documentKey(appToken string, chatNumber int, messageNumber int) string {
+	return fmt.Sprintf("%s:%d:%d", appToken, chatNumber, messageNumber)
+}
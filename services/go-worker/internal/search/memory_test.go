@@ -0,0 +1,146 @@
+package search
+
+import (
+	"go-worker/internal/contract"
+	"testing"
+	"time"
+)
+
+var memoryTestStart = time.Date(2025, 11, 26, 9, 0, 0, 0, time.UTC)
+
+type docRef struct {
+	app     string
+	chat    int
+	message int
+}
+
+// newTestMemoryBackend indexes messages 1 to 3 of chats 1 and 2 of "app",
+// an hour apart, and message 1 of chat 1 of "other"
+func newTestMemoryBackend(t *testing.T) *MemoryBackend {
+	t.Helper()
+
+	var docs []Document
+	for _, ref := range allDocs() {
+		docs = append(docs, Document{
+			Index: "messages",
+			Message: contract.MessageIndex{
+				ApplicationToken: ref.app,
+				ChatNumber:       ref.chat,
+				MessageNumber:    ref.message,
+				Content:          "hello",
+				CreatedAt:        memoryTestStart.Add(time.Duration(ref.message-1) * time.Hour),
+			},
+		})
+	}
+
+	b := NewMemoryBackend()
+	if err := b.Bulk(docs); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func allDocs() []docRef {
+	refs := []docRef{{"other", 1, 1}}
+	for chat := 1; chat <= 2; chat++ {
+		for message := 1; message <= 3; message++ {
+			refs = append(refs, docRef{"app", chat, message})
+		}
+	}
+	return refs
+}
+
+func TestMemoryBackendIndex(t *testing.T) {
+	b := newTestMemoryBackend(t)
+	if b.Len() != 7 {
+		t.Fatalf("Len = %d, want 7", b.Len())
+	}
+
+	// Indexing a message again replaces it
+	err := b.Index(Document{Index: "messages-app-english", Message: contract.MessageIndex{
+		ApplicationToken: "app", ChatNumber: 1, MessageNumber: 2, Content: "edited",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, ok := b.Get("app", 1, 2)
+	if !ok || doc.Message.Content != "edited" || doc.Index != "messages-app-english" {
+		t.Errorf("Get = %+v, %v, want the edited document", doc, ok)
+	}
+	if b.Len() != 7 {
+		t.Errorf("Len = %d, want 7", b.Len())
+	}
+}
+
+func TestMemoryBackendDeletions(t *testing.T) {
+	tests := []struct {
+		name    string
+		delete  func(b *MemoryBackend) (int, error)
+		deleted int
+		gone    []docRef
+	}{
+		{
+			name: "message",
+			delete: func(b *MemoryBackend) (int, error) {
+				return 1, b.Delete("messages", "app", 1, 2)
+			},
+			deleted: 1,
+			gone:    []docRef{{"app", 1, 2}},
+		},
+		{
+			name: "chat",
+			delete: func(b *MemoryBackend) (int, error) {
+				return b.DeleteChat("messages", "app", 2)
+			},
+			deleted: 3,
+			gone:    []docRef{{"app", 2, 1}, {"app", 2, 2}, {"app", 2, 3}},
+		},
+		{
+			name: "application",
+			delete: func(b *MemoryBackend) (int, error) {
+				return b.DeleteApplication("messages", "app")
+			},
+			deleted: 6,
+			gone:    allDocs()[1:],
+		},
+		{
+			name: "before cutoff",
+			delete: func(b *MemoryBackend) (int, error) {
+				return b.DeleteBefore("messages", "app", memoryTestStart.Add(90*time.Minute))
+			},
+			deleted: 4,
+			gone:    []docRef{{"app", 1, 1}, {"app", 1, 2}, {"app", 2, 1}, {"app", 2, 2}},
+		},
+		{
+			name: "unknown chat",
+			delete: func(b *MemoryBackend) (int, error) {
+				return b.DeleteChat("messages", "app", 9)
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			b := newTestMemoryBackend(t)
+			deleted, err := tc.delete(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if deleted != tc.deleted {
+				t.Errorf("deleted %d, want %d", deleted, tc.deleted)
+			}
+
+			gone := make(map[docRef]bool)
+			for _, ref := range tc.gone {
+				gone[ref] = true
+			}
+			for _, ref := range allDocs() {
+				if _, ok := b.Get(ref.app, ref.chat, ref.message); ok == gone[ref] {
+					t.Errorf("%+v present = %v, want %v", ref, ok, !gone[ref])
+				}
+			}
+			if b.Len() != 7-len(tc.gone) {
+				t.Errorf("Len = %d, want %d", b.Len(), 7-len(tc.gone))
+			}
+		})
+	}
+}
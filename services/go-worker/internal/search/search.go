@@ -0,0 +1,30 @@
+package search
+
+import (
+	"go-worker/internal/contract"
+	"time"
+)
+
+// Document is a message to index. Index names the index it belongs to,
+// backends without separate indices ignore it.
+type Document struct {
+	Index   string
+	Message contract.MessageIndex
+}
+
+// Backend is where the IndexingWorker writes messages. Elasticsearch is the
+// production backend, MemoryBackend lets the worker run without it. Documents
+// are searchable once Index or Bulk return.
+type Backend interface {
+	Index(doc Document) error
+	Bulk(docs []Document) error
+	Delete(index string, appToken string, chatNumber int, messageNumber int) error
+	// DeleteBefore removes an application's documents created before cutoff
+	// and reports how many there were
+	DeleteBefore(index string, appToken string, cutoff time.Time) (int, error)
+	// DeleteChat and DeleteApplication remove every document of a deleted
+	// chat or application and report how many there were
+	DeleteChat(index string, appToken string, chatNumber int) (int, error)
+	DeleteApplication(index string, appToken string) (int, error)
+	HealthCheck() error
+}
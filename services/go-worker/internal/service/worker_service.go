@@ -10,18 +10,21 @@ import (
 )
 
 type WorkerService struct {
-	consumer *queue.Consumer
+	broker   queue.Broker
+	registry *queue.Registry
 	workers  *worker.Workers
 	logger   *logging.Logger
 }
 
 func NewWorkerService(
-	consumer *queue.Consumer,
+	broker queue.Broker,
+	registry *queue.Registry,
 	workers *worker.Workers,
 	logger *logging.Logger,
 ) *WorkerService {
 	return &WorkerService{
-		consumer: consumer,
+		broker:   broker,
+		registry: registry,
 		workers:  workers,
 		logger:   logger,
 	}
@@ -29,11 +32,12 @@ func NewWorkerService(
 
 func (s *WorkerService) Start() error {
 	s.logger.Info("Starting workers...")
+	s.workers.RegisterHandlers(s.registry)
 
 	// Start chat worker
-	err := s.consumer.ConsumeQueue(
+	err := s.broker.Subscribe(
 		string(queue.ChatsQueue),
-		s.workers.Chat.HandleMessage,
+		s.registry.Dispatch,
 	)
 	if err != nil {
 		return err
@@ -41,9 +45,9 @@ func (s *WorkerService) Start() error {
 	s.logger.Info("Chat worker started on queue: %s", queue.ChatsQueue)
 
 	// Start message worker
-	err = s.consumer.ConsumeQueue(
+	err = s.broker.Subscribe(
 		string(queue.MessagesQueue),
-		s.workers.Message.HandleMessage,
+		s.registry.Dispatch,
 	)
 	if err != nil {
 		return err
@@ -51,15 +55,25 @@ func (s *WorkerService) Start() error {
 	s.logger.Info("Message worker started on queue: %s", queue.MessagesQueue)
 
 	// Start indexing worker
-	err = s.consumer.ConsumeQueue(
+	err = s.broker.Subscribe(
 		string(queue.IndexingQueue),
-		s.workers.Indexing.HandleMessage,
+		s.registry.Dispatch,
 	)
 	if err != nil {
 		return err
 	}
 	s.logger.Info("Indexing worker started on queue: %s", queue.IndexingQueue)
 
+	// Start deletion worker
+	err = s.broker.Subscribe(
+		string(queue.DeletionsQueue),
+		s.registry.Dispatch,
+	)
+	if err != nil {
+		return err
+	}
+	s.logger.Info("Deletion worker started on queue: %s", queue.DeletionsQueue)
+
 	s.logger.Info("All workers started successfully")
 	s.logger.Info("Press Ctrl+C to stop")
 
@@ -77,5 +91,5 @@ func (s *WorkerService) Start() error {
 
 func (s *WorkerService) Stop() {
 	s.workers.Stop()
-	s.consumer.Close()
+	s.broker.Close()
 }
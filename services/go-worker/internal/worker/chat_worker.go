@@ -3,81 +3,90 @@ package worker
 import (
 	"context"
 	"fmt"
+	"go-worker/internal/contract"
 	"go-worker/internal/database"
 	"go-worker/internal/logging"
 	"go-worker/internal/model"
-	"go-worker/internal/queue"
 
 	"github.com/go-redis/redis/v8"
-	amqp "github.com/rabbitmq/amqp091-go"
 )
 
 type ChatWorker struct {
 	repo   *database.Repository
-	redis  *redis.Client
+	redis  redis.UniversalClient
 	logger *logging.Logger
 }
 
-type ChatPayload struct {
-	AppToken   string `json:"app_token"`
-	ChatNumber int    `json:"chat_number"`
-}
-
-func NewChatWorker(db *database.Database, logger *logging.Logger) *ChatWorker {
+func NewChatWorker(db *database.Database, repo *database.Repository, logger *logging.Logger) *ChatWorker {
 	return &ChatWorker{
-		repo:   database.NewRepository(db.MySqlDB),
+		repo:   repo,
 		redis:  db.RedisDB,
 		logger: logger.WithPrefix("ChatWorker"),
 	}
 }
 
-func (w *ChatWorker) HandleMessage(delivery amqp.Delivery) error {
-	var payload ChatPayload
-	if err := queue.ParseMessageBody(delivery, &payload); err != nil {
-		w.logger.Error("Failed to parse message: %v", err)
-		return nil
+func (w *ChatWorker) HandleMessage(env *contract.Envelope) error {
+	var payload contract.ChatCreated
+	if err := env.Decode(&payload); err != nil {
+		w.logger.Error("Failed to decode message %s: %v", env.ID, err)
+		return err
 	}
 
-	w.logger.Info("Processing: app_token=%s, chat_number=%d",
-		payload.AppToken, payload.ChatNumber)
+	w.logger.Info("Processing: app_token=%s, chat_number=%d, trace=%s",
+		payload.AppToken, payload.ChatNumber, env.Header(contract.HeaderTraceID))
 
-	if payload.AppToken == "" || payload.ChatNumber == 0 {
-		w.logger.Error("Missing required fields")
+	deleted, err := isTombstoned(w.redis, payload.AppToken, payload.ChatNumber, 0)
+	if err != nil {
+		w.logger.Error("Failed to check tombstone: %v", err)
+		return err
+	}
+	if deleted {
+		w.logger.Info("Dropping tombstoned chat %d of %s", payload.ChatNumber, payload.AppToken)
 		return nil
 	}
 
+	if payload.IdempotencyKey != "" {
+		key := fmt.Sprintf("idempotency:worker:%s:chats:%s", payload.AppToken, payload.IdempotencyKey)
+		claimed, err := claimIdempotencyKey(context.Background(), w.redis, key, payload.ChatNumber)
+		if err != nil {
+			w.logger.Error("Failed to check idempotency key: %v", err)
+			return err
+		}
+		if !claimed {
+			w.logger.Info("Skipping duplicate chat %d for idempotency key %s",
+				payload.ChatNumber, payload.IdempotencyKey)
+			return nil
+		}
+	}
+
 	application, err := w.repo.FindApplicationByToken(payload.AppToken)
 	if err != nil {
 		w.logger.Error("Application not found: %s - %v", payload.AppToken, err)
 		return nil
 	}
 
-	existingChat, err := w.repo.FindChatByApplicationAndNumber(application.ID, payload.ChatNumber)
-	if err == nil {
-		w.logger.Info("Chat already exists: id=%d", existingChat.ID)
-		return nil
-	}
-
 	chat := &model.Chat{
 		ApplicationID: application.ID,
 		Number:        payload.ChatNumber,
 		MessagesCount: 0,
 	}
 
-	if err := w.repo.CreateChat(chat); err != nil {
-		if isDuplicateError(err) {
-			w.logger.Error("Chat already exists (race condition)")
-			return nil
-		}
+	created, err := w.repo.CreateChatIfMissing(chat)
+	if err != nil {
 		w.logger.Error("Failed to create chat: %v", err)
 		return err
 	}
+	if !created {
+		// Persisted already, by a redelivery or by a message that arrived first
+		w.logger.Info("Chat already exists: app=%s, number=%d", payload.AppToken, payload.ChatNumber)
+		return nil
+	}
 
 	w.logger.Info("Chat created: id=%d, number=%d, app=%s",
 		chat.ID, chat.Number, payload.AppToken)
 
 	// Increment delta counter for reconciliation
-	w.incrementCounter(fmt.Sprintf("delta:app:%d:chats", application.ID))
+	w.incrementCounter(contract.ChatCountDelta(payload.AppToken, application.ID))
 
 	return nil
 }
@@ -0,0 +1,276 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-worker/internal/contract"
+	"go-worker/internal/database"
+	"go-worker/internal/elasticsearch"
+	"go-worker/internal/logging"
+	"go-worker/internal/model"
+	"go-worker/internal/search"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// Messages of a deleted chat go in batches, like expired ones
+	deletionBatchSize  = 1000
+	deletionBatchPause = 100 * time.Millisecond
+)
+
+// DeletionWorker removes what is stored for applications and chats deleted
+// through go-chat: MySQL rows, search documents and Redis keys. go-chat has
+// tombstoned them before publishing, so nothing new is written meanwhile.
+// Every step tolerates being repeated, a failed deletion is requeued.
+type DeletionWorker struct {
+	repo    *database.Repository
+	redis   redis.UniversalClient
+	backend search.Backend
+	// es is set when the backend is Elasticsearch, which also holds the
+	// dedicated index and synonyms set of an application
+	es     *elasticsearch.Client
+	logger *logging.Logger
+}
+
+func NewDeletionWorker(db *database.Database, repo *database.Repository, backend search.Backend, logger *logging.Logger) *DeletionWorker {
+	es, _ := backend.(*elasticsearch.Client)
+	return &DeletionWorker{
+		repo:    repo,
+		redis:   db.RedisDB,
+		backend: backend,
+		es:      es,
+		logger:  logger.WithPrefix("DeletionWorker"),
+	}
+}
+
+func (w *DeletionWorker) HandleApplicationDeleted(env *contract.Envelope) error {
+	var payload contract.ApplicationDeleted
+	if err := env.Decode(&payload); err != nil {
+		w.logger.Error("Failed to decode message %s: %v", env.ID, err)
+		return err
+	}
+	token := payload.AppToken
+
+	w.logger.Info("Deleting application %s, trace=%s", token, env.Header(contract.HeaderTraceID))
+
+	// A redelivery after the rows were deleted still purges the documents
+	// and keys left behind
+	app, err := w.repo.FindApplicationByToken(token)
+	if err != nil && !errors.Is(err, database.ErrApplicationNotFound) {
+		return err
+	}
+
+	if err := w.repo.ForgetApplication(token); err != nil {
+		return fmt.Errorf("failed to drop cached application: %w", err)
+	}
+
+	redisKeys := []string{
+		fmt.Sprintf("search:index:%s", token),
+		fmt.Sprintf("application:token:%s", token),
+		// Again, a lookup running meanwhile may have cached it back
+		database.ApplicationCacheKey(token),
+		fmt.Sprintf("ratelimit:quota:%s", token),
+		contract.UnpublishedNumbers(token),
+		contract.DeadLetteredNumbers(token),
+		contract.ReleasedNumbers(token),
+	}
+	index := ""
+	if app != nil {
+		if index, err = w.repo.FindApplicationSearchIndex(token); err != nil {
+			return err
+		}
+
+		chats, err := w.repo.FindChatsByApplication(app.ID)
+		if err != nil {
+			return err
+		}
+		messages := 0
+		for _, chat := range chats {
+			deleted, err := w.deleteChatRows(chat)
+			if err != nil {
+				return err
+			}
+			messages += deleted
+			redisKeys = append(redisKeys, contract.MessageCountDelta(token, chat.ID))
+		}
+
+		prefixes, err := w.repo.DeleteApplication(app.ID)
+		if err != nil {
+			return fmt.Errorf("failed to delete application %d: %w", app.ID, err)
+		}
+		for _, prefix := range prefixes {
+			redisKeys = append(redisKeys, fmt.Sprintf("apikey:%s", prefix))
+		}
+		redisKeys = append(redisKeys, contract.ChatCountDelta(token, app.ID))
+
+		w.logger.Info("Deleted application %d with %d chats and %d messages from MySQL", app.ID, len(chats), messages)
+	}
+
+	documents, err := w.deleteApplicationDocuments(token, index)
+	if err != nil {
+		return err
+	}
+
+	// The application tombstone stays, it keeps rejecting late writes
+	patterns := []string{
+		contract.ApplicationKeysPattern(token),
+		fmt.Sprintf("search:generation:%s:*", token),
+		fmt.Sprintf("search:cache:%s:*", token),
+		fmt.Sprintf("idempotency:%s:*", token),
+		fmt.Sprintf("idempotency:worker:%s:*", token),
+		fmt.Sprintf("ratelimit:app:%s:*", token),
+		contract.ChatTombstonesPattern(token),
+	}
+	keys, err := w.purgeRedis(redisKeys, patterns)
+	if err != nil {
+		return fmt.Errorf("failed to purge redis keys: %w", err)
+	}
+
+	w.logger.Info("Application %s deleted: %d documents, %d redis keys", token, documents, keys)
+	return nil
+}
+
+func (w *DeletionWorker) HandleChatDeleted(env *contract.Envelope) error {
+	var payload contract.ChatDeleted
+	if err := env.Decode(&payload); err != nil {
+		w.logger.Error("Failed to decode message %s: %v", env.ID, err)
+		return err
+	}
+	token, number := payload.AppToken, payload.ChatNumber
+
+	w.logger.Info("Deleting chat %d of %s, trace=%s", number, token, env.Header(contract.HeaderTraceID))
+
+	app, err := w.repo.FindApplicationByToken(token)
+	if errors.Is(err, database.ErrApplicationNotFound) {
+		// Gone with its application, whose deletion purged everything
+		w.logger.Info("Application %s already deleted, nothing left of chat %d", token, number)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var redisKeys []string
+	chat, err := w.repo.FindChatByApplicationAndNumber(app.ID, number)
+	if err != nil && !errors.Is(err, database.ErrChatNotFound) {
+		return err
+	}
+	if err := w.repo.ForgetChat(app.ID, number); err != nil {
+		return fmt.Errorf("failed to drop cached chat: %w", err)
+	}
+	if chat != nil {
+		messages, err := w.deleteChatRows(chat)
+		if err != nil {
+			return err
+		}
+		redisKeys = append(redisKeys, contract.MessageCountDelta(token, chat.ID))
+
+		// The application's chats_count follows through reconciliation
+		if err := w.redis.DecrBy(context.Background(), contract.ChatCountDelta(token, app.ID), 1).Err(); err != nil {
+			w.logger.Error("Failed to record chat count delta of application %d: %v", app.ID, err)
+		}
+
+		w.logger.Info("Deleted chat %d with %d messages from MySQL", chat.ID, messages)
+	}
+	if err := w.repo.DeleteChatNumberGaps(app.ID, number); err != nil {
+		return fmt.Errorf("failed to delete number gaps of chat %d: %w", number, err)
+	}
+
+	index, err := w.repo.FindApplicationSearchIndex(token)
+	if err != nil {
+		return err
+	}
+	if index == "" {
+		index = sharedIndex
+	}
+	documents, err := w.backend.DeleteChat(index, token, number)
+	if err != nil {
+		return fmt.Errorf("failed to delete documents of chat %d from %s: %w", number, index, err)
+	}
+
+	redisKeys = append(redisKeys,
+		contract.MessageCounter(token, number),
+		contract.ChatMessages(token, number),
+		searchGenerationKey(token, number),
+	)
+	patterns := []string{
+		fmt.Sprintf("search:cache:%s:%d:*", token, number),
+		fmt.Sprintf("idempotency:worker:%s:chat:%d:messages:*", token, number),
+	}
+	keys, err := w.purgeRedis(redisKeys, patterns)
+	if err != nil {
+		return fmt.Errorf("failed to purge redis keys: %w", err)
+	}
+
+	w.logger.Info("Chat %d of %s deleted: %d documents, %d redis keys", number, token, documents, keys)
+	return nil
+}
+
+// deleteChatRows deletes a chat's messages in batches, then the chat itself
+func (w *DeletionWorker) deleteChatRows(chat *model.Chat) (int, error) {
+	total := 0
+	for {
+		deleted, err := w.repo.DeleteChatMessages(chat.ID, deletionBatchSize)
+		if err != nil {
+			return total, fmt.Errorf("failed to delete messages of chat %d: %w", chat.ID, err)
+		}
+		total += deleted
+		if deleted < deletionBatchSize {
+			break
+		}
+		time.Sleep(deletionBatchPause)
+	}
+
+	if err := w.repo.DeleteChat(chat.ID); err != nil {
+		return total, fmt.Errorf("failed to delete chat %d: %w", chat.ID, err)
+	}
+	return total, nil
+}
+
+// deleteApplicationDocuments clears the application from the shared index,
+// where documents may remain from before a move to a dedicated index, and
+// drops its dedicated index and synonyms set
+func (w *DeletionWorker) deleteApplicationDocuments(token, index string) (int, error) {
+	documents, err := w.backend.DeleteApplication(sharedIndex, token)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete documents from %s: %w", sharedIndex, err)
+	}
+	if index == "" || index == sharedIndex {
+		return documents, nil
+	}
+
+	if w.es == nil {
+		dedicated, err := w.backend.DeleteApplication(index, token)
+		if err != nil {
+			return documents, fmt.Errorf("failed to delete documents from %s: %w", index, err)
+		}
+		return documents + dedicated, nil
+	}
+
+	if err := w.es.DeleteIndex(index); err != nil {
+		return documents, err
+	}
+	if err := w.es.DeleteSynonymsSet("app-" + token); err != nil {
+		return documents, err
+	}
+	return documents, nil
+}
+
+func (w *DeletionWorker) purgeRedis(keys []string, patterns []string) (int, error) {
+	deleted, err := deleteKeys(w.redis, keys)
+	if err != nil {
+		return deleted, err
+	}
+
+	for _, pattern := range patterns {
+		n, err := deleteMatching(w.redis, pattern)
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
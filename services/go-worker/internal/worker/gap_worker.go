@@ -0,0 +1,370 @@
+package worker
+
+import (
+	"context"
+	"go-worker/internal/config"
+	"go-worker/internal/contract"
+	"go-worker/internal/database"
+	"go-worker/internal/logging"
+	"go-worker/internal/model"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// maxGapsPerChat bounds what one run records for a chat, a chat missing more
+// than that has a bigger problem than gaps
+const maxGapsPerChat = 1000
+
+// GapWorker looks for chat and message numbers go-chat handed out that never
+// reached MySQL, between 1 and the Redis counters. Each gap is recorded in
+// number_gaps with what is known of its cause, rows of gaps that filled up
+// are removed. With tombstones enabled, gaps past the grace period are given
+// up on: queued work for them is dropped and the number never exists.
+type GapWorker struct {
+	repo        *database.Repository
+	redis       redis.UniversalClient
+	logger      *logging.Logger
+	gracePeriod time.Duration
+	tombstones  bool
+	ticker      *time.Ticker
+	stopChan    chan struct{}
+	lockKey     string
+	lockTTL     time.Duration
+}
+
+func NewGapWorker(db *database.Database, repo *database.Repository, cfg *config.Config, logger *logging.Logger) *GapWorker {
+	w := &GapWorker{
+		repo:        repo,
+		redis:       db.RedisDB,
+		logger:      logger.WithPrefix("GapWorker"),
+		gracePeriod: cfg.GapGracePeriod,
+		tombstones:  cfg.GapTombstones,
+		ticker:      time.NewTicker(10 * time.Minute),
+		stopChan:    make(chan struct{}),
+		lockKey:     "lock:gap-detection",
+		lockTTL:     10 * time.Minute,
+	}
+
+	go w.start()
+
+	return w
+}
+
+func (w *GapWorker) start() {
+	for {
+		select {
+		case <-w.ticker.C:
+			if err := w.detectGaps(); err != nil {
+				w.logger.Error("Gap detection failed: %v", err)
+			}
+		case <-w.stopChan:
+			return
+		}
+	}
+}
+
+// detectGaps scans every application under one lease, renewed for as long
+// as the scan takes so no other instance starts one meanwhile
+func (w *GapWorker) detectGaps() error {
+	ctx := context.Background()
+	l, err := acquireLease(ctx, w.redis, w.logger, w.lockKey, w.lockTTL)
+	if err != nil || l == nil {
+		return err
+	}
+	defer l.release(ctx)
+
+	scopes, err := w.repo.FindGapScopes()
+	if err != nil {
+		return err
+	}
+
+	for _, scope := range scopes {
+		if w.stopping() {
+			return nil
+		}
+		if err := w.scan(scope); err != nil {
+			w.logger.Error("Failed to check application %d for gaps: %v", scope.ApplicationID, err)
+		}
+	}
+
+	return nil
+}
+
+// gapScan is one application's pass
+type gapScan struct {
+	scope        *model.GapScope
+	now          time.Time
+	recorded     map[string]*model.NumberGap
+	seen         map[string]bool
+	unpublished  map[string]bool
+	deadLettered map[string]bool
+	released     map[string]bool
+	causes       map[string]int
+}
+
+func (w *GapWorker) scan(scope *model.GapScope) error {
+	ctx := context.Background()
+	token := scope.Token
+
+	s := &gapScan{
+		scope:    scope,
+		now:      time.Now(),
+		recorded: make(map[string]*model.NumberGap),
+		seen:     make(map[string]bool),
+		causes:   make(map[string]int),
+	}
+
+	gaps, err := w.repo.FindNumberGaps(scope.ApplicationID)
+	if err != nil {
+		return err
+	}
+	for _, gap := range gaps {
+		s.recorded[contract.NumberRef(gap.ChatNumber, gap.MessageNumber)] = gap
+	}
+
+	unpublished, err := w.redis.SMembers(ctx, contract.UnpublishedNumbers(token)).Result()
+	if err != nil {
+		return err
+	}
+	deadLettered, err := w.redis.SMembers(ctx, contract.DeadLetteredNumbers(token)).Result()
+	if err != nil {
+		return err
+	}
+	released, err := w.redis.SMembers(ctx, contract.ReleasedNumbers(token)).Result()
+	if err != nil {
+		return err
+	}
+	s.unpublished = toSet(unpublished)
+	s.deadLettered = toSet(deadLettered)
+	s.released = toSet(released)
+
+	chatsAllocated, err := w.counter(contract.ChatCounter(token))
+	if err != nil {
+		return err
+	}
+	chats, err := w.repo.FindChatsByApplication(scope.ApplicationID)
+	if err != nil {
+		return err
+	}
+	persisted := make(map[int]bool, len(chats))
+	for _, chat := range chats {
+		persisted[chat.Number] = true
+	}
+
+	for number := 1; number <= chatsAllocated; number++ {
+		if persisted[number] {
+			continue
+		}
+		if err := w.record(s, number, 0); err != nil {
+			return err
+		}
+	}
+
+	for _, chat := range chats {
+		if err := w.scanChat(s, chat); err != nil {
+			return err
+		}
+	}
+
+	// Gaps not seen again have been filled by a late delivery
+	for ref, gap := range s.recorded {
+		if s.seen[ref] {
+			continue
+		}
+		if gap.TombstonedAt != nil {
+			// Persisted before the tombstone took effect, the number exists
+			w.logger.Error("Tombstoned number %s of application %d exists after all", ref, scope.ApplicationID)
+			w.redis.Del(ctx, tombstoneKey(token, gap.ChatNumber, gap.MessageNumber))
+		}
+		if err := w.repo.DeleteNumberGap(gap.ID); err != nil {
+			return err
+		}
+	}
+
+	// Causes are kept on the rows from now on
+	if len(unpublished) > 0 {
+		w.redis.SRem(ctx, contract.UnpublishedNumbers(token), toMembers(unpublished)...)
+	}
+	if len(deadLettered) > 0 {
+		w.redis.SRem(ctx, contract.DeadLetteredNumbers(token), toMembers(deadLettered)...)
+	}
+	if len(released) > 0 {
+		w.redis.SRem(ctx, contract.ReleasedNumbers(token), toMembers(released)...)
+	}
+
+	if len(s.seen) > 0 {
+		w.logger.Info("Application %d has %d gaps: %v", scope.ApplicationID, len(s.seen), s.causes)
+	}
+	return nil
+}
+
+func (w *GapWorker) scanChat(s *gapScan, chat *model.Chat) error {
+	token := s.scope.Token
+	allocated, err := w.counter(contract.MessageCounter(token, chat.Number))
+	if err != nil || allocated == 0 {
+		return err
+	}
+
+	count, lowest, err := w.repo.CountChatMessages(chat.ID)
+	if err != nil {
+		return err
+	}
+
+	first, complete := scanStart(s.scope.Retained, count, lowest, allocated)
+	if complete {
+		return nil
+	}
+
+	numbers, err := w.repo.FindMessageNumbers(chat.ID)
+	if err != nil {
+		return err
+	}
+
+	missing := missingNumbers(numbers, first, allocated, maxGapsPerChat+1)
+	if len(missing) > maxGapsPerChat {
+		w.logger.Error("Chat %d has more than %d gaps, recording the first ones only", chat.ID, maxGapsPerChat)
+		missing = missing[:maxGapsPerChat]
+	}
+	for _, number := range missing {
+		if err := w.record(s, chat.Number, number); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanStart returns the message number a chat's gaps are looked for from,
+// and whether the chat is complete and needs no look at all. The retention
+// job deletes the oldest messages, so in a retained application numbers below
+// the oldest remaining one are expired rather than missing. A retained chat
+// with no messages left cannot tell expired numbers from missing ones and is
+// taken as complete.
+func scanStart(retained bool, count, lowest, allocated int) (int, bool) {
+	first := 1
+	if retained {
+		if count == 0 {
+			return 0, true
+		}
+		first = lowest
+	}
+	return first, count >= allocated-first+1
+}
+
+// missingNumbers returns up to limit numbers from first to allocated that are
+// not in numbers, which is sorted ascending
+func missingNumbers(numbers []int, first, allocated, limit int) []int {
+	var missing []int
+	next := first
+	for _, number := range append(numbers, allocated+1) {
+		for ; next < number && next <= allocated; next++ {
+			if len(missing) == limit {
+				return missing
+			}
+			missing = append(missing, next)
+		}
+		if number >= next {
+			next = number + 1
+		}
+	}
+	return missing
+}
+
+// record classifies a gap and saves it, tombstoning it when that is enabled
+// and the gap is past its grace period
+func (w *GapWorker) record(s *gapScan, chatNumber, messageNumber int) error {
+	token := s.scope.Token
+	ref := contract.NumberRef(chatNumber, messageNumber)
+
+	gap, known := s.recorded[ref]
+	if !known {
+		// A missing chat without a row is usually a deleted one, its
+		// tombstone predates gap detection
+		if messageNumber == 0 {
+			deleted, err := isTombstoned(w.redis, token, chatNumber, 0)
+			if err != nil || deleted {
+				return err
+			}
+		}
+		gap = &model.NumberGap{
+			ApplicationID: s.scope.ApplicationID,
+			ChatNumber:    chatNumber,
+			MessageNumber: messageNumber,
+			FirstSeenAt:   s.now,
+		}
+	}
+	s.seen[ref] = true
+	gap.LastSeenAt = s.now
+
+	switch {
+	case s.unpublished[ref]:
+		gap.Cause = model.GapUnpublished
+	case s.deadLettered[ref]:
+		gap.Cause = model.GapDeadLettered
+	case s.released[ref]:
+		gap.Cause = model.GapReleased
+	case gap.Cause == model.GapUnpublished || gap.Cause == model.GapDeadLettered || gap.Cause == model.GapReleased:
+		// Known from an earlier run
+	case s.now.Sub(gap.FirstSeenAt) < w.gracePeriod:
+		gap.Cause = model.GapInFlight
+	default:
+		gap.Cause = model.GapLost
+	}
+	s.causes[gap.Cause]++
+
+	if w.tombstones && gap.TombstonedAt == nil && gap.Cause != model.GapInFlight {
+		if err := w.redis.Set(context.Background(), tombstoneKey(token, chatNumber, messageNumber), s.now.UTC().Format(time.RFC3339), 0).Err(); err != nil {
+			return err
+		}
+		tombstonedAt := s.now
+		gap.TombstonedAt = &tombstonedAt
+		w.logger.Info("Tombstoned %s number %s of application %d", gap.Cause, ref, s.scope.ApplicationID)
+	}
+
+	return w.repo.SaveNumberGap(gap)
+}
+
+func (w *GapWorker) counter(key string) (int, error) {
+	value, err := w.redis.Get(context.Background(), key).Int()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return value, err
+}
+
+func tombstoneKey(appToken string, chatNumber, messageNumber int) string {
+	if messageNumber == 0 {
+		return contract.ChatTombstone(appToken, chatNumber)
+	}
+	return contract.MessageTombstone(appToken, chatNumber, messageNumber)
+}
+
+func toSet(members []string) map[string]bool {
+	set := make(map[string]bool, len(members))
+	for _, member := range members {
+		set[member] = true
+	}
+	return set
+}
+
+func toMembers(members []string) []interface{} {
+	values := make([]interface{}, len(members))
+	for i, member := range members {
+		values[i] = member
+	}
+	return values
+}
+
+func (w *GapWorker) stopping() bool {
+	select {
+	case <-w.stopChan:
+		return true
+	default:
+		return false
+	}
+}
+
+func (w *GapWorker) Stop() {
+	close(w.stopChan)
+	w.ticker.Stop()
+}
@@ -0,0 +1,58 @@
+package worker
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestScanStart(t *testing.T) {
+	tests := []struct {
+		name                     string
+		retained                 bool
+		count, lowest, allocated int
+		first                    int
+		complete                 bool
+	}{
+		{"nothing missing", false, 5, 1, 5, 1, true},
+		{"one missing", false, 4, 1, 5, 1, false},
+		{"every message missing", false, 0, 0, 5, 1, false},
+		{"retained, oldest purged", true, 3, 3, 5, 3, true},
+		{"retained, gap above the oldest", true, 2, 3, 5, 3, false},
+		// Purged down to nothing, the numbers are expired rather than missing
+		{"retained, every message purged", true, 0, 0, 5, 0, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			first, complete := scanStart(tc.retained, tc.count, tc.lowest, tc.allocated)
+			if first != tc.first || complete != tc.complete {
+				t.Errorf("scanStart = %d, %v, want %d, %v", first, complete, tc.first, tc.complete)
+			}
+		})
+	}
+}
+
+func TestMissingNumbers(t *testing.T) {
+	tests := []struct {
+		name             string
+		numbers          []int
+		first, allocated int
+		limit            int
+		want             []int
+	}{
+		{"none", []int{1, 2, 3}, 1, 3, 10, nil},
+		{"in between", []int{1, 3, 6}, 1, 6, 10, []int{2, 4, 5}},
+		{"at the end", []int{1, 2}, 1, 4, 10, []int{3, 4}},
+		{"all", nil, 1, 3, 10, []int{1, 2, 3}},
+		{"from first", []int{4, 7}, 4, 8, 10, []int{5, 6, 8}},
+		// Numbers persisted past the counter were handed out by a lease
+		{"past the counter", []int{1, 5}, 1, 3, 10, []int{2, 3}},
+		{"limited", nil, 1, 100, 3, []int{1, 2, 3}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := missingNumbers(tc.numbers, tc.first, tc.allocated, tc.limit); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("missingNumbers = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
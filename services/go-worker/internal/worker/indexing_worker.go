@@ -1,44 +1,36 @@
 package worker
 
 import (
-	"encoding/json"
 	"fmt"
-	"go-worker/internal/elasticsearch"
+	"go-worker/internal/contract"
+	"go-worker/internal/database"
 	"go-worker/internal/logging"
-	"go-worker/internal/queue"
+	"go-worker/internal/search"
 	"sync"
 	"time"
 
-	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/go-redis/redis/v8"
 )
 
 type IndexingWorker struct {
-	es          *elasticsearch.Client
+	backend     search.Backend
+	redis       redis.UniversalClient
+	indices     *indexResolver
 	logger      *logging.Logger
-	batch       []IndexPayload
+	batch       []contract.MessageIndex
 	batchMutex  sync.Mutex
 	batchSize   int
 	flushTicker *time.Ticker
 	stopChan    chan struct{}
 }
 
-type IndexPayload struct {
-	MessageID        uint      `json:"message_id"`
-	ApplicationID    uint      `json:"application_id"`
-	ApplicationToken string    `json:"application_token"`
-	ApplicationName  string    `json:"application_name"`
-	ChatID           uint      `json:"chat_id"`
-	ChatNumber       int       `json:"chat_number"`
-	MessageNumber    int       `json:"message_number"`
-	Content          string    `json:"content"`
-	CreatedAt        time.Time `json:"created_at"`
-}
-
-func NewIndexingWorker(es *elasticsearch.Client, logger *logging.Logger) *IndexingWorker {
+func NewIndexingWorker(db *database.Database, repo *database.Repository, backend search.Backend, logger *logging.Logger) *IndexingWorker {
 	w := &IndexingWorker{
-		es:          es,
+		backend:     backend,
+		redis:       db.RedisDB,
+		indices:     newIndexResolver(repo),
 		logger:      logger.WithPrefix("IndexingWorker"),
-		batch:       make([]IndexPayload, 0, 1000),
+		batch:       make([]contract.MessageIndex, 0, 1000),
 		batchSize:   1000,
 		flushTicker: time.NewTicker(5 * time.Second),
 		stopChan:    make(chan struct{}),
@@ -49,11 +41,11 @@ func NewIndexingWorker(es *elasticsearch.Client, logger *logging.Logger) *Indexi
 	return w
 }
 
-func (w *IndexingWorker) HandleMessage(delivery amqp.Delivery) error {
-	var payload IndexPayload
-	if err := queue.ParseMessageBody(delivery, &payload); err != nil {
-		w.logger.Error("Failed to parse: %v", err)
-		return nil
+func (w *IndexingWorker) HandleMessage(env *contract.Envelope) error {
+	var payload contract.MessageIndex
+	if err := env.Decode(&payload); err != nil {
+		w.logger.Error("Failed to decode message %s: %v", env.ID, err)
+		return err
 	}
 
 	w.batchMutex.Lock()
@@ -75,40 +67,29 @@ func (w *IndexingWorker) flush() error {
 		return nil
 	}
 
-	messages := make([]IndexPayload, len(w.batch))
+	messages := make([]contract.MessageIndex, len(w.batch))
 	copy(messages, w.batch)
 	w.batch = w.batch[:0]
 	w.batchMutex.Unlock()
 
-	w.logger.Info("Flushing %d messages to Elasticsearch", len(messages))
-	var bulkBody string
-	for _, msg := range messages {
-		// Route by app+chat to keep all messages from same chat on same shard
-		routing := fmt.Sprintf("%s:%d", msg.ApplicationToken, msg.ChatNumber)
-
-		action := map[string]interface{}{
-			"index": map[string]interface{}{
-				"_id": fmt.Sprintf("%s:%d:%d",
-					msg.ApplicationToken, msg.ChatNumber, msg.MessageNumber),
-				"routing": routing,
-			},
-		}
-		actionJSON, _ := json.Marshal(action)
-		bulkBody += string(actionJSON) + "\n"
-
-		doc := map[string]interface{}{
-			"application_token": msg.ApplicationToken,
-			"application_name":  msg.ApplicationName,
-			"chat_number":       msg.ChatNumber,
-			"message_number":    msg.MessageNumber,
-			"content":           msg.Content,
-			"created_at":        msg.CreatedAt,
+	messages = w.dropDeleted(messages)
+	if len(messages) == 0 {
+		return nil
+	}
+
+	w.logger.Info("Flushing %d messages to the search backend", len(messages))
+	docs := make([]search.Document, len(messages))
+	for i, msg := range messages {
+		// Applications with a language or synonyms have an index of their own
+		index, err := w.indices.indexFor(msg.ApplicationToken)
+		if err != nil {
+			w.logger.Error("Failed to resolve index of %s, using %s: %v", msg.ApplicationToken, sharedIndex, err)
+			index = sharedIndex
 		}
-		docJSON, _ := json.Marshal(doc)
-		bulkBody += string(docJSON) + "\n"
+		docs[i] = search.Document{Index: index, Message: msg}
 	}
 
-	if err := w.es.BulkIndex("messages", bulkBody); err != nil {
+	if err := w.backend.Bulk(docs); err != nil {
 		w.logger.Error("Bulk index failed: %v", err)
 
 		w.batchMutex.Lock()
@@ -119,11 +100,49 @@ func (w *IndexingWorker) flush() error {
 	}
 
 	w.logger.Info("Successfully indexed %d messages", len(messages))
+	w.bumpGenerations(messages)
 	w.resetFlushTimer()
 
 	return nil
 }
 
+// dropDeleted leaves out messages of deleted applications and chats, whose
+// documents would otherwise outlive the deletion
+func (w *IndexingWorker) dropDeleted(messages []contract.MessageIndex) []contract.MessageIndex {
+	deletedChats := make(map[string]bool)
+	kept := messages[:0]
+	for _, msg := range messages {
+		chat := fmt.Sprintf("%s:%d", msg.ApplicationToken, msg.ChatNumber)
+		deleted, seen := deletedChats[chat]
+		if !seen {
+			var err error
+			deleted, err = isTombstoned(w.redis, msg.ApplicationToken, msg.ChatNumber, 0)
+			if err != nil {
+				// Indexed anyway, a deletion still in progress removes it
+				w.logger.Error("Failed to check tombstone of %s:%d: %v", msg.ApplicationToken, msg.ChatNumber, err)
+			}
+			deletedChats[chat] = deleted
+		}
+		if !deleted {
+			kept = append(kept, msg)
+		}
+	}
+	return kept
+}
+
+// bumpGenerations moves every chat in the batch to a new search generation,
+// which retires go-chat's cached search results for it
+func (w *IndexingWorker) bumpGenerations(messages []contract.MessageIndex) {
+	keys := make(map[string]bool)
+	for _, msg := range messages {
+		keys[searchGenerationKey(msg.ApplicationToken, msg.ChatNumber)] = true
+	}
+
+	if err := bumpSearchGenerations(w.redis, keys); err != nil {
+		w.logger.Error("Failed to bump search generation of %d chats: %v", len(keys), err)
+	}
+}
+
 func (w *IndexingWorker) startAutoFlush() {
 	for {
 		select {
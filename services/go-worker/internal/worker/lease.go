@@ -0,0 +1,103 @@
+package worker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"go-worker/internal/logging"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// renewLeaseScript extends a lease only while it still holds our token, and
+// releaseLeaseScript deletes it on the same condition, so a lease that
+// expired and was taken by another instance is left alone
+var (
+	renewLeaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+    return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+	releaseLeaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+    return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+)
+
+// lease is a Redis lock held with a random token and renewed in the
+// background until it is released, for work that may outlast any fixed TTL
+type lease struct {
+	redis  redis.UniversalClient
+	logger *logging.Logger
+	key    string
+	token  string
+	ttl    time.Duration
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// acquireLease takes key for ttl at a time. It returns nil when another
+// holder has it.
+func acquireLease(ctx context.Context, rdb redis.UniversalClient, logger *logging.Logger, key string, ttl time.Duration) (*lease, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	token := hex.EncodeToString(raw)
+
+	acquired, err := rdb.SetNX(ctx, key, token, ttl).Result()
+	if err != nil || !acquired {
+		return nil, err
+	}
+
+	l := &lease{
+		redis:  rdb,
+		logger: logger,
+		key:    key,
+		token:  token,
+		ttl:    ttl,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go l.renew()
+	return l, nil
+}
+
+func (l *lease) renew() {
+	defer close(l.done)
+
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			renewed, err := renewLeaseScript.Run(context.Background(), l.redis,
+				[]string{l.key}, l.token, l.ttl.Milliseconds()).Int()
+			if err != nil {
+				l.logger.Error("Failed to renew %s: %v", l.key, err)
+				continue
+			}
+			if renewed == 0 {
+				l.logger.Error("Lost %s to another holder", l.key)
+				return
+			}
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+// release stops renewing and deletes the lease if it is still ours
+func (l *lease) release(ctx context.Context) {
+	close(l.stop)
+	<-l.done
+
+	if err := releaseLeaseScript.Run(ctx, l.redis, []string{l.key}, l.token).Err(); err != nil {
+		l.logger.Error("Failed to release %s: %v", l.key, err)
+	}
+}
@@ -0,0 +1,92 @@
+package worker
+
+import (
+	"context"
+	"go-worker/internal/config"
+	"go-worker/internal/logging"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, redis.UniversalClient) {
+	t.Helper()
+
+	m := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return m, rdb
+}
+
+func newTestLogger(t *testing.T) *logging.Logger {
+	t.Helper()
+	return logging.NewLogger(&config.Config{AppName: "worker-test", LogPath: t.TempDir()})
+}
+
+func mustAcquire(t *testing.T, rdb redis.UniversalClient, logger *logging.Logger, key string, ttl time.Duration) *lease {
+	t.Helper()
+
+	l, err := acquireLease(context.Background(), rdb, logger, key, ttl)
+	if err != nil {
+		t.Fatalf("acquireLease: %v", err)
+	}
+	if l == nil {
+		t.Fatalf("%s is held", key)
+	}
+	return l
+}
+
+func TestLeaseExclusive(t *testing.T) {
+	m, rdb := newTestRedis(t)
+	logger := newTestLogger(t)
+	ctx := context.Background()
+
+	first := mustAcquire(t, rdb, logger, "lock:test", time.Minute)
+
+	second, err := acquireLease(ctx, rdb, logger, "lock:test", time.Minute)
+	if err != nil || second != nil {
+		t.Fatalf("second acquireLease = %v, %v, want nil, nil", second, err)
+	}
+
+	first.release(ctx)
+	if m.Exists("lock:test") {
+		t.Fatal("released lease still exists")
+	}
+
+	mustAcquire(t, rdb, logger, "lock:test", time.Minute).release(ctx)
+}
+
+func TestLeaseRenews(t *testing.T) {
+	m, rdb := newTestRedis(t)
+	l := mustAcquire(t, rdb, newTestLogger(t), "lock:test", 300*time.Millisecond)
+	defer l.release(context.Background())
+
+	// Without renewal the lease would be gone by now
+	m.SetTTL("lock:test", 10*time.Millisecond)
+	time.Sleep(150 * time.Millisecond)
+
+	if ttl := m.TTL("lock:test"); ttl != 300*time.Millisecond {
+		t.Errorf("TTL after a renewal = %s, want 300ms", ttl)
+	}
+}
+
+func TestLeaseLeavesOtherHolders(t *testing.T) {
+	m, rdb := newTestRedis(t)
+	l := mustAcquire(t, rdb, newTestLogger(t), "lock:test", 300*time.Millisecond)
+
+	// The lease expired and another instance took the key
+	m.Set("lock:test", "other")
+	m.SetTTL("lock:test", time.Minute)
+	time.Sleep(150 * time.Millisecond)
+
+	if ttl := m.TTL("lock:test"); ttl != time.Minute {
+		t.Errorf("TTL of the other holder = %s, want 1m", ttl)
+	}
+
+	l.release(context.Background())
+	if got, err := m.Get("lock:test"); err != nil || got != "other" {
+		t.Errorf("key after release = %q, %v, want the other holder's", got, err)
+	}
+}
@@ -2,55 +2,70 @@ package worker
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"go-worker/internal/contract"
 	"go-worker/internal/database"
 	"go-worker/internal/logging"
 	"go-worker/internal/model"
 	"go-worker/internal/queue"
 
 	"github.com/go-redis/redis/v8"
-	amqp "github.com/rabbitmq/amqp091-go"
 )
 
 type MessageWorker struct {
-	repo   *database.Repository
-	redis  *redis.Client
-	amqp   *queue.AMQP
-	logger *logging.Logger
+	repo      *database.Repository
+	redis     redis.UniversalClient
+	publisher queue.Publisher
+	logger    *logging.Logger
 }
 
-type MessagePayload struct {
-	AppToken      string `json:"app_token"`
-	ChatNumber    int    `json:"chat_number"`
-	MessageNumber int    `json:"message_number"`
-	Content       string `json:"content"`
-}
-
-func NewMessageWorker(db *database.Database, amqp *queue.AMQP, logger *logging.Logger) *MessageWorker {
+func NewMessageWorker(db *database.Database, repo *database.Repository, publisher queue.Publisher, logger *logging.Logger) *MessageWorker {
 	return &MessageWorker{
-		repo:   database.NewRepository(db.MySqlDB),
-		redis:  db.RedisDB,
-		amqp:   amqp,
-		logger: logger.WithPrefix("MessageWorker"),
+		repo:      repo,
+		redis:     db.RedisDB,
+		publisher: publisher,
+		logger:    logger.WithPrefix("MessageWorker"),
 	}
 }
 
-func (w *MessageWorker) HandleMessage(delivery amqp.Delivery) error {
-	var payload MessagePayload
-	if err := queue.ParseMessageBody(delivery, &payload); err != nil {
-		w.logger.Error("Failed to parse message: %v", err)
-		return nil
+func (w *MessageWorker) HandleMessage(env *contract.Envelope) error {
+	var payload contract.MessageCreated
+	if err := env.Decode(&payload); err != nil {
+		w.logger.Error("Failed to decode message %s: %v", env.ID, err)
+		return err
 	}
 
-	w.logger.Info("Processing: app=%s, chat=%d, msg=%d",
-		payload.AppToken, payload.ChatNumber, payload.MessageNumber)
+	w.logger.Info("Processing: app=%s, chat=%d, msg=%d, trace=%s",
+		payload.AppToken, payload.ChatNumber, payload.MessageNumber, env.Header(contract.HeaderTraceID))
 
-	if payload.AppToken == "" || payload.ChatNumber == 0 ||
-		payload.MessageNumber == 0 || payload.Content == "" {
-		w.logger.Error("Missing required fields")
+	// Without this, a message of a deleted chat would be deferred waiting for
+	// the chat
+	deleted, err := isTombstoned(w.redis, payload.AppToken, payload.ChatNumber, payload.MessageNumber)
+	if err != nil {
+		w.logger.Error("Failed to check tombstone: %v", err)
+		return err
+	}
+	if deleted {
+		w.logger.Info("Dropping tombstoned message %d of chat %d", payload.MessageNumber, payload.ChatNumber)
 		return nil
 	}
 
+	if payload.IdempotencyKey != "" {
+		key := fmt.Sprintf("idempotency:worker:%s:chat:%d:messages:%s",
+			payload.AppToken, payload.ChatNumber, payload.IdempotencyKey)
+		claimed, err := claimIdempotencyKey(context.Background(), w.redis, key, payload.MessageNumber)
+		if err != nil {
+			w.logger.Error("Failed to check idempotency key: %v", err)
+			return err
+		}
+		if !claimed {
+			w.logger.Info("Skipping duplicate message %d for idempotency key %s",
+				payload.MessageNumber, payload.IdempotencyKey)
+			return nil
+		}
+	}
+
 	application, err := w.repo.FindApplicationByToken(payload.AppToken)
 	if err != nil {
 		w.logger.Error("Application not found: %s - %v", payload.AppToken, err)
@@ -58,10 +73,13 @@ func (w *MessageWorker) HandleMessage(delivery amqp.Delivery) error {
 	}
 
 	chat, err := w.repo.FindChatByApplicationAndNumber(application.ID, payload.ChatNumber)
+	if errors.Is(err, database.ErrChatNotFound) {
+		chat, err = w.createAllocatedChat(application, payload.ChatNumber)
+	}
 	if err != nil {
-		w.logger.Error("Chat not found: app=%s, chat_number=%d - %v",
+		w.logger.Error("Chat not available: app=%s, chat_number=%d - %v",
 			payload.AppToken, payload.ChatNumber, err)
-		return fmt.Errorf("chat not found") // Requeue - chat might be processing
+		return err
 	}
 
 	existingMessage, err := w.repo.FindMessageByChatAndNumber(chat.ID, payload.MessageNumber)
@@ -89,16 +107,56 @@ func (w *MessageWorker) HandleMessage(delivery amqp.Delivery) error {
 		message.ID, message.Number, chat.ID)
 
 	// Increment delta counter for reconciliation
-	w.incrementCounter(fmt.Sprintf("delta:chat:%d:messages", chat.ID))
+	w.incrementCounter(contract.MessageCountDelta(application.Token, chat.ID))
 
 	// Queue for Elasticsearch indexing
-	if err := w.queueForIndexing(message, chat, application); err != nil {
+	if err := w.queueForIndexing(message, chat, application, env.Headers); err != nil {
 		w.logger.Error("Failed to queue for indexing: %v", err)
 	}
 
+	// Push to clients streaming this chat, missed events are recovered on resume
+	if err := w.broadcastPersisted(message, chat, application, env.Headers); err != nil {
+		w.logger.Error("Failed to broadcast message: %v", err)
+	}
+
 	return nil
 }
 
+// createAllocatedChat persists a chat ahead of ChatWorker. Chats and messages
+// travel on separate queues, so a message often arrives first; a chat number
+// go-chat has handed out is known to exist. Any other number is deferred,
+// its chat may still show up.
+func (w *MessageWorker) createAllocatedChat(app *model.Application, number int) (*model.Chat, error) {
+	ctx := context.Background()
+	allocated, err := w.redis.Get(ctx, contract.ChatCounter(app.Token)).Int()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	if number > allocated {
+		return nil, fmt.Errorf("%w: chat %d of %s is not persisted yet", queue.ErrRetryLater, number, app.Token)
+	}
+
+	chat := &model.Chat{ApplicationID: app.ID, Number: number}
+	created, err := w.repo.CreateChatIfMissing(chat)
+	if err != nil {
+		return nil, err
+	}
+	if !created {
+		// ChatWorker got there in the meantime
+		return w.repo.FindChatByApplicationAndNumber(app.ID, number)
+	}
+
+	w.logger.Info("Chat created ahead of its chat.created: id=%d, number=%d, app=%s", chat.ID, number, app.Token)
+	w.incrementCounter(contract.ChatCountDelta(app.Token, app.ID))
+
+	field := fmt.Sprintf("%s:chats_created", contract.TypeMessageCreated)
+	if err := w.redis.HIncrBy(ctx, contract.DeferralMetricsKey, field, 1).Err(); err != nil {
+		w.logger.Error("Failed to count %s: %v", field, err)
+	}
+
+	return chat, nil
+}
+
 func (w *MessageWorker) incrementCounter(key string) {
 	ctx := context.Background()
 	if err := w.redis.Incr(ctx, key).Err(); err != nil {
@@ -106,18 +164,51 @@ func (w *MessageWorker) incrementCounter(key string) {
 	}
 }
 
-func (w *MessageWorker) queueForIndexing(message *model.Message, chat *model.Chat, app *model.Application) error {
-	indexPayload := map[string]interface{}{
-		"message_id":        message.ID,
-		"application_id":    app.ID,
-		"application_token": app.Token,
-		"application_name":  app.Name,
-		"chat_id":           chat.ID,
-		"chat_number":       chat.Number,
-		"message_number":    message.Number,
-		"content":           message.Content,
-		"created_at":        message.CreatedAt,
+func (w *MessageWorker) queueForIndexing(message *model.Message, chat *model.Chat, app *model.Application, headers map[string]string) error {
+	indexPayload := &contract.MessageIndex{
+		MessageID:        message.ID,
+		ApplicationID:    app.ID,
+		ApplicationToken: app.Token,
+		ApplicationName:  app.Name,
+		ChatID:           chat.ID,
+		ChatNumber:       chat.Number,
+		MessageNumber:    message.Number,
+		Content:          message.Content,
+		CreatedAt:        message.CreatedAt,
+	}
+
+	env, err := contract.NewEnvelope(indexPayload, headers)
+	if err != nil {
+		return err
+	}
+
+	msg, err := queue.NewMessage(env)
+	if err != nil {
+		return err
+	}
+	return w.publisher.Publish(queue.IndexingQueue, msg)
+}
+
+func (w *MessageWorker) broadcastPersisted(message *model.Message, chat *model.Chat, app *model.Application, headers map[string]string) error {
+	payload := &contract.MessagePersisted{
+		ApplicationToken: app.Token,
+		ApplicationName:  app.Name,
+		ChatNumber:       chat.Number,
+		MessageNumber:    message.Number,
+		Content:          message.Content,
+		CreatedAt:        message.CreatedAt,
+	}
+
+	env, err := contract.NewEnvelope(payload, headers)
+	if err != nil {
+		return err
+	}
+
+	body, err := env.Marshal()
+	if err != nil {
+		return err
 	}
 
-	return w.amqp.Publish(queue.IndexingQueue, indexPayload)
+	channel := contract.StreamChannel(app.Token, chat.Number)
+	return w.redis.Publish(context.Background(), channel, body).Err()
 }
@@ -3,10 +3,11 @@ package worker
 import (
 	"context"
 	"fmt"
+	"go-worker/internal/contract"
 	"go-worker/internal/database"
 	"go-worker/internal/logging"
 	"strconv"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -14,7 +15,7 @@ import (
 
 type ReconciliationWorker struct {
 	repo         *database.Repository
-	redis        *redis.Client
+	redis        redis.UniversalClient
 	logger       *logging.Logger
 	ticker       *time.Ticker
 	stopChan     chan struct{}
@@ -30,9 +31,9 @@ type reconcileConfig struct {
 	extractIDFunc func(string) (uint, error)
 }
 
-func NewReconciliationWorker(db *database.Database, logger *logging.Logger) *ReconciliationWorker {
+func NewReconciliationWorker(db *database.Database, repo *database.Repository, logger *logging.Logger) *ReconciliationWorker {
 	w := &ReconciliationWorker{
-		repo:         database.NewRepository(db.MySqlDB),
+		repo:         repo,
 		redis:        db.RedisDB,
 		logger:       logger.WithPrefix("ReconciliationWorker"),
 		syncInterval: 15 * time.Second,
@@ -79,33 +80,17 @@ func (w *ReconciliationWorker) reconcile() error {
 	// w.logger.Info("Acquired reconciliation lock, starting reconciliation")
 
 	appChatConfig := reconcileConfig{
-		pattern:    "delta:app:*:chats",
-		entityName: "application",
-		updateFunc: w.repo.IncrementApplicationChatCount,
-		extractIDFunc: func(key string) (uint, error) {
-			// Extract from: delta:app:123:chats
-			parts := strings.Split(key, ":")
-			if len(parts) != 4 {
-				return 0, fmt.Errorf("invalid key format: %s", key)
-			}
-			id, err := strconv.ParseUint(parts[2], 10, 64)
-			return uint(id), err
-		},
+		pattern:       contract.ChatCountDeltaPattern,
+		entityName:    "application",
+		updateFunc:    w.repo.IncrementApplicationChatCount,
+		extractIDFunc: contract.DeltaID,
 	}
 
 	chatMessageConfig := reconcileConfig{
-		pattern:    "delta:chat:*:messages",
-		entityName: "chat",
-		updateFunc: w.repo.IncrementChatMessageCount,
-		extractIDFunc: func(key string) (uint, error) {
-			// Extract from: delta:chat:456:messages
-			parts := strings.Split(key, ":")
-			if len(parts) != 4 {
-				return 0, fmt.Errorf("invalid key format: %s", key)
-			}
-			id, err := strconv.ParseUint(parts[2], 10, 64)
-			return uint(id), err
-		},
+		pattern:       contract.MessageCountDeltaPattern,
+		entityName:    "chat",
+		updateFunc:    w.repo.IncrementChatMessageCount,
+		extractIDFunc: contract.DeltaID,
 	}
 
 	if err := w.reconcileEntity(ctx, appChatConfig); err != nil {
@@ -160,44 +145,45 @@ func (w *ReconciliationWorker) processKey(ctx context.Context, key string, confi
 	return nil
 }
 
+// scanKeys collects the keys matching pattern on every Redis master
 func (w *ReconciliationWorker) scanKeys(ctx context.Context, pattern string) ([]string, error) {
-	var cursor uint64
+	var mu sync.Mutex
 	var keys []string
 
-	for {
-		batch, newCursor, err := w.redis.Scan(ctx, cursor, pattern, 100).Result()
-		if err != nil {
-			return nil, err
-		}
+	err := database.ScanKeys(ctx, w.redis, pattern, func(batch []string) error {
+		mu.Lock()
+		defer mu.Unlock()
 		keys = append(keys, batch...)
-		cursor = newCursor
-		if cursor == 0 {
-			break
-		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return keys, nil
 }
 
+// getDelScript reads and removes a delta in one step. I can simply use GETDEL,
+// but its atomicity is not guaranteed across multiple instances, and I like
+// defensive programming :) It only touches KEYS[1], so in Redis Cluster it
+// runs on the node owning that key.
+var getDelScript = redis.NewScript(`
+local value = redis.call('GET', KEYS[1])
+if value then
+    redis.call('DEL', KEYS[1])
+    return value
+end
+return nil
+`)
+
 func (w *ReconciliationWorker) atomicGetDel(ctx context.Context, key string) (int, error) {
-	// I can simply use GETDEL, but its atomicity is not guaranteed across multiple instances
-	// And I like defensive programming :)
-	script := `
-        local value = redis.call('GET', KEYS[1])
-        if value then
-            redis.call('DEL', KEYS[1])
-            return value
-        end
-        return nil
-    `
-
-	result, err := w.redis.Eval(ctx, script, []string{key}).Result()
+	result, err := getDelScript.Run(ctx, w.redis, []string{key}).Result()
+	if err == redis.Nil {
+		return 0, nil
+	}
 	if err != nil {
 		return 0, err
 	}
-	if result == nil {
-		return 0, nil
-	}
 
 	delta, err := strconv.Atoi(fmt.Sprintf("%v", result))
 	if err != nil {
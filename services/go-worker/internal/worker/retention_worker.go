@@ -0,0 +1,219 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-worker/internal/contract"
+	"go-worker/internal/database"
+	"go-worker/internal/logging"
+	"go-worker/internal/model"
+	"go-worker/internal/search"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// Messages are deleted in small batches with a pause in between, so a
+	// large purge does not hold locks or saturate MySQL for long
+	retentionBatchSize  = 500
+	retentionBatchPause = 200 * time.Millisecond
+)
+
+// RetentionWorker purges messages older than their application's retention
+// period from MySQL and the search backend. Chat message counts follow
+// through the reconciliation deltas, and every run that removed anything
+// leaves a retention_purges record behind.
+type RetentionWorker struct {
+	repo     *database.Repository
+	redis    redis.UniversalClient
+	backend  search.Backend
+	logger   *logging.Logger
+	ticker   *time.Ticker
+	stopChan chan struct{}
+	lockKey  string
+	lockTTL  time.Duration
+}
+
+func NewRetentionWorker(db *database.Database, repo *database.Repository, backend search.Backend, logger *logging.Logger) *RetentionWorker {
+	w := &RetentionWorker{
+		repo:     repo,
+		redis:    db.RedisDB,
+		backend:  backend,
+		logger:   logger.WithPrefix("RetentionWorker"),
+		ticker:   time.NewTicker(time.Hour),
+		stopChan: make(chan struct{}),
+		lockKey:  "lock:retention",
+		lockTTL:  5 * time.Minute,
+	}
+
+	go w.start()
+
+	return w
+}
+
+func (w *RetentionWorker) start() {
+	for {
+		select {
+		case <-w.ticker.C:
+			if err := w.purgeExpired(); err != nil {
+				w.logger.Error("Retention run failed: %v", err)
+			}
+		case <-w.stopChan:
+			return
+		}
+	}
+}
+
+// purgeExpired runs under a lease renewed until the run ends. A large purge
+// outlasts any fixed TTL, and two overlapping runs would lower chat counts
+// twice for the same messages.
+func (w *RetentionWorker) purgeExpired() error {
+	ctx := context.Background()
+	l, err := acquireLease(ctx, w.redis, w.logger, w.lockKey, w.lockTTL)
+	if err != nil || l == nil {
+		return err
+	}
+	defer l.release(ctx)
+
+	policies, err := w.repo.FindRetentionPolicies()
+	if err != nil {
+		return err
+	}
+
+	for _, policy := range policies {
+		if w.stopping() {
+			return nil
+		}
+		if err := w.purge(policy); err != nil {
+			w.logger.Error("Failed to purge application %d: %v", policy.ApplicationID, err)
+		}
+	}
+
+	return nil
+}
+
+func (w *RetentionWorker) purge(policy *model.RetentionPolicy) error {
+	record := &model.RetentionPurge{
+		ApplicationID: policy.ApplicationID,
+		Cutoff:        time.Now().AddDate(0, 0, -policy.RetentionDays),
+		Status:        model.RetentionRunning,
+		StartedAt:     time.Now(),
+	}
+
+	chats := make(map[string]bool)
+	for !w.stopping() {
+		expired, err := w.repo.DeleteExpiredMessages(policy.ApplicationID, record.Cutoff, retentionBatchSize)
+		if err != nil {
+			return w.fail(record, fmt.Errorf("failed to delete expired messages: %w", err))
+		}
+		if len(expired) == 0 {
+			break
+		}
+
+		// The audit record exists as soon as anything is gone, so an
+		// interrupted run still shows up
+		if record.ID == 0 {
+			if err := w.repo.CreateRetentionPurge(record); err != nil {
+				w.logger.Error("Failed to create retention audit record: %v", err)
+			}
+		}
+
+		deleted := w.releaseCounts(policy.Token, expired)
+		record.MessagesDeleted += deleted
+		for _, chat := range expired {
+			chats[searchGenerationKey(policy.Token, chat.ChatNumber)] = true
+		}
+		record.ChatsAffected = len(chats)
+
+		if deleted < retentionBatchSize {
+			break
+		}
+		time.Sleep(retentionBatchPause)
+	}
+
+	if w.stopping() {
+		if record.ID == 0 {
+			return nil
+		}
+		return w.fail(record, errors.New("interrupted by shutdown"))
+	}
+
+	// Documents are purged even when MySQL had nothing left, in case a
+	// previous run failed between the two
+	index := policy.SearchIndex
+	if index == "" {
+		index = sharedIndex
+	}
+	documents, err := w.backend.DeleteBefore(index, policy.Token, record.Cutoff)
+	if err != nil {
+		return w.fail(record, fmt.Errorf("failed to delete expired documents from %s: %w", index, err))
+	}
+	record.DocumentsDeleted = &documents
+
+	if err := bumpSearchGenerations(w.redis, chats); err != nil {
+		w.logger.Error("Failed to bump search generation of %d chats: %v", len(chats), err)
+	}
+
+	if record.MessagesDeleted == 0 && documents == 0 {
+		return nil
+	}
+
+	record.Status = model.RetentionCompleted
+	record.FinishedAt = time.Now()
+	w.logger.Info("Purged %d messages from %d chats and %d documents of application %d (cutoff %s)",
+		record.MessagesDeleted, record.ChatsAffected, documents, policy.ApplicationID, record.Cutoff.Format(time.RFC3339))
+	return w.saveRecord(record)
+}
+
+// releaseCounts hands the deleted messages to the reconciliation worker as
+// negative deltas, the same way new messages are counted
+func (w *RetentionWorker) releaseCounts(appToken string, expired []*model.ExpiredMessages) int {
+	ctx := context.Background()
+	deleted := 0
+
+	pipe := w.redis.Pipeline()
+	for _, chat := range expired {
+		pipe.IncrBy(ctx, contract.MessageCountDelta(appToken, chat.ChatID), -int64(chat.Count))
+		deleted += chat.Count
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		w.logger.Error("Failed to record message count deltas for %d chats: %v", len(expired), err)
+	}
+
+	return deleted
+}
+
+func (w *RetentionWorker) fail(record *model.RetentionPurge, cause error) error {
+	record.Status = model.RetentionFailed
+	record.Error = cause.Error()
+	record.FinishedAt = time.Now()
+	if err := w.saveRecord(record); err != nil {
+		w.logger.Error("Failed to save retention audit record: %v", err)
+	}
+	return cause
+}
+
+func (w *RetentionWorker) saveRecord(record *model.RetentionPurge) error {
+	if record.ID == 0 {
+		if err := w.repo.CreateRetentionPurge(record); err != nil {
+			return err
+		}
+	}
+	return w.repo.UpdateRetentionPurge(record)
+}
+
+func (w *RetentionWorker) stopping() bool {
+	select {
+	case <-w.stopChan:
+		return true
+	default:
+		return false
+	}
+}
+
+func (w *RetentionWorker) Stop() {
+	close(w.stopChan)
+	w.ticker.Stop()
+}
@@ -0,0 +1,55 @@
+package worker
+
+import (
+	"go-worker/internal/contract"
+	"go-worker/internal/model"
+	"testing"
+	"time"
+)
+
+func TestRetentionSkipsWhileLeased(t *testing.T) {
+	m, rdb := newTestRedis(t)
+	w := &RetentionWorker{
+		redis:    rdb,
+		logger:   newTestLogger(t),
+		stopChan: make(chan struct{}),
+		lockKey:  "lock:retention",
+		lockTTL:  time.Minute,
+	}
+
+	// Another instance is purging, this one must not touch the repository,
+	// which it does not have
+	m.Set(w.lockKey, "other")
+
+	if err := w.purgeExpired(); err != nil {
+		t.Fatalf("purgeExpired: %v", err)
+	}
+	if got, _ := m.Get(w.lockKey); got != "other" {
+		t.Errorf("lock = %q, want the other instance's", got)
+	}
+}
+
+func TestRetentionReleasesCounts(t *testing.T) {
+	m, rdb := newTestRedis(t)
+	w := &RetentionWorker{redis: rdb, logger: newTestLogger(t)}
+
+	// Chat 4 already has messages counted that reconciliation has not
+	// applied yet
+	m.Set(contract.MessageCountDelta("app", 4), "3")
+
+	deleted := w.releaseCounts("app", []*model.ExpiredMessages{
+		{ChatID: 4, ChatNumber: 1, Count: 5},
+		{ChatID: 9, ChatNumber: 2, Count: 2},
+	})
+	if deleted != 7 {
+		t.Errorf("releaseCounts = %d, want 7", deleted)
+	}
+
+	want := map[uint]string{4: "-2", 9: "-2"}
+	for chatID, value := range want {
+		key := contract.MessageCountDelta("app", chatID)
+		if got, _ := m.Get(key); got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-worker/internal/database"
+	"go-worker/internal/elasticsearch"
+	"go-worker/internal/logging"
+	"go-worker/internal/model"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	sharedIndex          = "messages"
+	dedicatedIndexPrefix = "messages-"
+	// indexCacheTTL bounds how long a worker keeps writing to an
+	// application's previous index after it moved
+	indexCacheTTL = 30 * time.Second
+	// staleSynonymsKey lists the tokens of applications whose synonyms set
+	// Rails failed to update after a rule change
+	staleSynonymsKey = "search:synonyms:stale"
+)
+
+// SearchIndexWorker moves applications with a content language or synonyms
+// to a dedicated index built for them: it creates the index and synonyms set,
+// switches reads and writes over, copies the existing documents and removes
+// them from the previous index. It also rewrites the synonyms sets Rails
+// could not update.
+type SearchIndexWorker struct {
+	repo     *database.Repository
+	redis    redis.UniversalClient
+	es       *elasticsearch.Client
+	logger   *logging.Logger
+	ticker   *time.Ticker
+	stopChan chan struct{}
+	lockKey  string
+	lockTTL  time.Duration
+}
+
+func NewSearchIndexWorker(db *database.Database, repo *database.Repository, es *elasticsearch.Client, logger *logging.Logger) *SearchIndexWorker {
+	w := &SearchIndexWorker{
+		repo:     repo,
+		redis:    db.RedisDB,
+		es:       es,
+		logger:   logger.WithPrefix("SearchIndexWorker"),
+		ticker:   time.NewTicker(30 * time.Second),
+		stopChan: make(chan struct{}),
+		lockKey:  "lock:search-index",
+		lockTTL:  5 * time.Minute,
+	}
+
+	go w.start()
+
+	return w
+}
+
+func (w *SearchIndexWorker) start() {
+	for {
+		select {
+		case <-w.ticker.C:
+			if err := w.provisionPending(); err != nil {
+				w.logger.Error("Provisioning failed: %v", err)
+			}
+			if err := w.syncStaleSynonyms(); err != nil {
+				w.logger.Error("Synonyms sync failed: %v", err)
+			}
+		case <-w.stopChan:
+			return
+		}
+	}
+}
+
+// provisionPending moves each pending application under a lease of its own,
+// so instances share the work and no lock is held across applications. The
+// copies and the wait for other workers can outlast lockTTL, the lease is
+// renewed until the application is done.
+func (w *SearchIndexWorker) provisionPending() error {
+	ctx := context.Background()
+
+	pending, err := w.repo.FindApplicationsNeedingSearchIndex(dedicatedIndexPrefix)
+	if err != nil {
+		return err
+	}
+
+	for _, app := range pending {
+		select {
+		case <-w.stopChan:
+			return nil
+		default:
+		}
+
+		l, err := acquireLease(ctx, w.redis, w.logger, w.lockKey+":"+app.Token, w.lockTTL)
+		if err != nil {
+			w.logger.Error("Failed to lock application %d: %v", app.ApplicationID, err)
+			continue
+		}
+		if l == nil {
+			continue
+		}
+
+		if err := w.provisionLocked(ctx, app); err != nil {
+			w.logger.Error("Failed to provision index for application %d: %v", app.ApplicationID, err)
+		}
+		l.release(ctx)
+	}
+
+	return nil
+}
+
+// provisionLocked provisions app unless another instance moved it between
+// the listing and the lease
+func (w *SearchIndexWorker) provisionLocked(ctx context.Context, app *model.SearchSettings) error {
+	current, err := w.repo.FindApplicationSearchIndex(app.Token)
+	if err != nil {
+		return err
+	}
+	if current != app.SearchIndex {
+		return nil
+	}
+	return w.provision(ctx, app)
+}
+
+func (w *SearchIndexWorker) provision(ctx context.Context, app *model.SearchSettings) error {
+	if !elasticsearch.SupportedLanguage(app.Language) {
+		return fmt.Errorf("unsupported language %q", app.Language)
+	}
+
+	index := dedicatedIndexPrefix + app.Token + "-" + app.Language
+	previous := app.SearchIndex
+	if previous == "" {
+		previous = sharedIndex
+	}
+	w.logger.Info("Moving application %d from %s to %s", app.ApplicationID, previous, index)
+
+	// The index's search analyzers reference the set, it has to exist first.
+	// Rails keeps it up to date from here on.
+	synonymsSet, err := w.putSynonyms(app.ApplicationID, app.Token)
+	if err != nil {
+		return err
+	}
+
+	if err := w.es.CreateIndex(index, app.Language, synonymsSet); err != nil {
+		return err
+	}
+	if err := w.es.ReindexApplication(previous, index, app.Token); err != nil {
+		return err
+	}
+
+	if err := w.repo.SetApplicationSearchIndex(app.ApplicationID, index); err != nil {
+		return err
+	}
+	w.redis.Del(ctx, "search:index:"+app.Token)
+
+	// Catch up with what was written to the previous index while copying,
+	// once every worker has picked up the switch
+	time.Sleep(indexCacheTTL)
+	if err := w.es.ReindexApplication(previous, index, app.Token); err != nil {
+		return err
+	}
+
+	if previous == sharedIndex {
+		err = w.es.DeleteApplicationDocuments(sharedIndex, app.Token)
+	} else {
+		err = w.es.DeleteIndex(previous)
+	}
+	if err != nil {
+		return err
+	}
+
+	w.logger.Info("Application %d now searches %s", app.ApplicationID, index)
+	return nil
+}
+
+// putSynonyms writes an application's synonyms set from its rules in MySQL
+// and returns the set's id
+func (w *SearchIndexWorker) putSynonyms(applicationID uint, token string) (string, error) {
+	rules, err := w.repo.FindSynonymRules(applicationID)
+	if err != nil {
+		return "", err
+	}
+	setRules := make([]elasticsearch.SynonymRule, len(rules))
+	for i, rule := range rules {
+		setRules[i] = elasticsearch.SynonymRule{ID: strconv.FormatUint(uint64(rule.ID), 10), Synonyms: rule.Synonyms}
+	}
+	synonymsSet := "app-" + token
+	return synonymsSet, w.es.PutSynonymsSet(synonymsSet, setRules)
+}
+
+// syncStaleSynonyms rewrites the synonyms sets Rails flagged. A token is
+// taken off the list before its rules are read, so a change flagged again
+// meanwhile is picked up on the next run.
+func (w *SearchIndexWorker) syncStaleSynonyms() error {
+	ctx := context.Background()
+
+	tokens, err := w.redis.SMembers(ctx, staleSynonymsKey).Result()
+	if err != nil {
+		return err
+	}
+
+	for _, token := range tokens {
+		if err := w.redis.SRem(ctx, staleSynonymsKey, token).Err(); err != nil {
+			return err
+		}
+
+		app, err := w.repo.FindApplicationByToken(token)
+		if errors.Is(err, database.ErrApplicationNotFound) {
+			continue
+		}
+		if err == nil {
+			_, err = w.putSynonyms(app.ID, token)
+		}
+		if err != nil {
+			w.logger.Error("Failed to sync synonyms of application %s: %v", token, err)
+			w.redis.SAdd(ctx, staleSynonymsKey, token)
+			continue
+		}
+		w.logger.Info("Synced synonyms of application %d", app.ID)
+	}
+
+	return nil
+}
+
+func (w *SearchIndexWorker) Stop() {
+	close(w.stopChan)
+	w.ticker.Stop()
+}
+
+// indexResolver caches which index each application writes to
+type indexResolver struct {
+	repo    *database.Repository
+	mu      sync.Mutex
+	entries map[string]resolvedIndex
+}
+
+type resolvedIndex struct {
+	name      string
+	expiresAt time.Time
+}
+
+func newIndexResolver(repo *database.Repository) *indexResolver {
+	return &indexResolver{repo: repo, entries: make(map[string]resolvedIndex)}
+}
+
+func (r *indexResolver) indexFor(appToken string) (string, error) {
+	r.mu.Lock()
+	entry, ok := r.entries[appToken]
+	r.mu.Unlock()
+	if ok && time.Now().Before(entry.expiresAt) {
+		return entry.name, nil
+	}
+
+	name, err := r.repo.FindApplicationSearchIndex(appToken)
+	if err != nil {
+		return "", err
+	}
+	if name == "" {
+		name = sharedIndex
+	}
+
+	r.mu.Lock()
+	r.entries[appToken] = resolvedIndex{name: name, expiresAt: time.Now().Add(indexCacheTTL)}
+	r.mu.Unlock()
+
+	return name, nil
+}
@@ -1,12 +1,24 @@
 package worker
 
 import (
+	"context"
+	"fmt"
+	"go-worker/internal/contract"
+	"go-worker/internal/database"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
+
+	"github.com/go-redis/redis/v8"
 )
 
 const (
 	DayTTL = 24 * time.Hour
+
+	// generationTTL outlives any cached search result by far, so a generation
+	// that expires and starts over cannot match entries from its previous run
+	generationTTL = DayTTL
 )
 
 func isDuplicateError(err error) bool {
@@ -18,3 +30,102 @@ func isDuplicateError(err error) bool {
 		strings.Contains(errMsg, "duplicate key") ||
 		strings.Contains(errMsg, "UNIQUE constraint failed")
 }
+
+// claimIdempotencyKey binds an idempotency key to the number it was first seen
+// with. It reports false when the key already belongs to a different number,
+// meaning the delivery is a duplicate of an earlier request.
+func claimIdempotencyKey(ctx context.Context, rdb redis.UniversalClient, key string, number int) (bool, error) {
+	claimed, err := rdb.SetNX(ctx, key, number, DayTTL).Result()
+	if err != nil {
+		return false, err
+	}
+	if claimed {
+		return true, nil
+	}
+
+	existing, err := rdb.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return claimIdempotencyKey(ctx, rdb, key, number)
+	}
+	if err != nil {
+		return false, err
+	}
+	return existing == strconv.Itoa(number), nil
+}
+
+// searchGenerationKey changes value whenever a chat's searchable messages
+// change, go-chat keys its cached search results on it
+func searchGenerationKey(appToken string, chatNumber int) string {
+	return fmt.Sprintf("search:generation:%s:%d", appToken, chatNumber)
+}
+
+func bumpSearchGenerations(rdb redis.UniversalClient, keys map[string]bool) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	pipe := rdb.Pipeline()
+	for key := range keys {
+		pipe.Incr(ctx, key)
+		pipe.Expire(ctx, key, generationTTL)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// isTombstoned tells whether go-chat deleted the application or, when
+// chatNumber is set, the chat, or whether gap detection gave up on the chat
+// or on messageNumber. Queued work for any of them is dropped.
+func isTombstoned(rdb redis.UniversalClient, appToken string, chatNumber, messageNumber int) (bool, error) {
+	keys := []string{contract.ApplicationTombstone(appToken)}
+	if chatNumber > 0 {
+		keys = append(keys, contract.ChatTombstone(appToken, chatNumber))
+	}
+	if messageNumber > 0 {
+		keys = append(keys, contract.MessageTombstone(appToken, chatNumber, messageNumber))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	// The tombstones share the token's hash tag, one EXISTS holds in a cluster
+	found, err := rdb.Exists(ctx, keys...).Result()
+	return found > 0, err
+}
+
+// deleteMatching removes every key matching pattern, scanning in batches so
+// Redis is never blocked by KEYS
+func deleteMatching(rdb redis.UniversalClient, pattern string) (int, error) {
+	var deleted int64
+	err := database.ScanKeys(context.Background(), rdb, pattern, func(keys []string) error {
+		n, err := deleteKeys(rdb, keys)
+		atomic.AddInt64(&deleted, int64(n))
+		return err
+	})
+	return int(deleted), err
+}
+
+// deleteKeys removes keys with one DEL each, sent together. In Redis Cluster
+// a DEL of several keys is refused unless they share a slot.
+func deleteKeys(rdb redis.UniversalClient, keys []string) (int, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	ctx := context.Background()
+	pipe := rdb.Pipeline()
+	commands := make([]*redis.IntCmd, len(keys))
+	for i, key := range keys {
+		commands[i] = pipe.Del(ctx, key)
+	}
+	_, err := pipe.Exec(ctx)
+
+	deleted := 0
+	for _, command := range commands {
+		deleted += int(command.Val())
+	}
+	return deleted, err
+}
@@ -1,10 +1,13 @@
 package worker
 
 import (
+	"go-worker/internal/config"
+	"go-worker/internal/contract"
 	"go-worker/internal/database"
 	"go-worker/internal/elasticsearch"
 	"go-worker/internal/logging"
 	"go-worker/internal/queue"
+	"go-worker/internal/search"
 	"sync"
 )
 
@@ -13,21 +16,46 @@ type Workers struct {
 	Chat           *ChatWorker
 	Message        *MessageWorker
 	Indexing       *IndexingWorker
+	Deletion       *DeletionWorker
 	Reconciliation *ReconciliationWorker
+	SearchIndex    *SearchIndexWorker
+	Retention      *RetentionWorker
+	Gap            *GapWorker
 }
 
 func NewWorkers(
+	cfg *config.Config,
 	db *database.Database,
-	amqp *queue.AMQP,
-	es *elasticsearch.Client,
+	repo *database.Repository,
+	broker queue.Broker,
+	backend search.Backend,
 	logger *logging.Logger,
 ) *Workers {
-	return &Workers{
-		Chat:           NewChatWorker(db, logger),
-		Message:        NewMessageWorker(db, amqp, logger),
-		Indexing:       NewIndexingWorker(es, logger),
-		Reconciliation: NewReconciliationWorker(db, logger),
+	workers := &Workers{
+		Chat:           NewChatWorker(db, repo, logger),
+		Message:        NewMessageWorker(db, repo, broker, logger),
+		Indexing:       NewIndexingWorker(db, repo, backend, logger),
+		Deletion:       NewDeletionWorker(db, repo, backend, logger),
+		Reconciliation: NewReconciliationWorker(db, repo, logger),
+		Retention:      NewRetentionWorker(db, repo, backend, logger),
+		Gap:            NewGapWorker(db, repo, cfg, logger),
 	}
+
+	// Dedicated per-application indices only exist in Elasticsearch
+	if es, ok := backend.(*elasticsearch.Client); ok {
+		workers.SearchIndex = NewSearchIndexWorker(db, repo, es, logger)
+	}
+
+	return workers
+}
+
+// RegisterHandlers binds every queue-consuming worker to its message type
+func (w *Workers) RegisterHandlers(registry *queue.Registry) {
+	registry.Register(contract.TypeChatCreated, 1, w.Chat.HandleMessage)
+	registry.Register(contract.TypeMessageCreated, 1, w.Message.HandleMessage)
+	registry.Register(contract.TypeMessageIndex, 1, w.Indexing.HandleMessage)
+	registry.Register(contract.TypeApplicationDeleted, 1, w.Deletion.HandleApplicationDeleted)
+	registry.Register(contract.TypeChatDeleted, 1, w.Deletion.HandleChatDeleted)
 }
 
 func (w *Workers) Stop() {
@@ -39,4 +67,13 @@ func (w *Workers) Stop() {
 	if w.Reconciliation != nil {
 		w.Reconciliation.Stop()
 	}
+	if w.SearchIndex != nil {
+		w.SearchIndex.Stop()
+	}
+	if w.Retention != nil {
+		w.Retention.Stop()
+	}
+	if w.Gap != nil {
+		w.Gap.Stop()
+	}
 }
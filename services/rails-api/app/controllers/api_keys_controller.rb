@@ -0,0 +1,34 @@
+class ApiKeysController < ApplicationController
+  before_action :require_admin
+  before_action :set_application
+  before_action :set_api_key, only: %i[rotate destroy]
+
+  def index
+    render json: { data: @application.api_keys.order(created_at: :desc) }
+  end
+
+  def create
+    api_key = @application.api_keys.create!(scope: params.fetch(:scope, "full"))
+    render json: api_key.as_json.merge(key: api_key.plaintext_key), status: :created
+  end
+
+  def rotate
+    @api_key.rotate!
+    render json: @api_key.as_json.merge(key: @api_key.plaintext_key)
+  end
+
+  def destroy
+    @api_key.revoke!
+    head :no_content
+  end
+
+  private
+
+  def set_application
+    @application = Application.find_by!(token: params[:token])
+  end
+
+  def set_api_key
+    @api_key = @application.api_keys.active.find(params[:id])
+  end
+end
@@ -1,2 +1,3 @@
 class ApplicationController < ActionController::API
+  include AdminAuthorization
 end
@@ -1,4 +1,10 @@
 class ApplicationsController < ApplicationController
+  # Quotas are what keeps one tenant from starving the others, and a retention
+  # period makes go-worker purge messages for good, so only an admin sets them
+  QUOTA_FIELDS = %i[rate_limit_create rate_limit_search retention_days].freeze
+
+  before_action :require_admin, only: %i[create update], if: :quota_change?
+
   def index
     page = params.fetch(:page, 1).to_i
     page = 1 if page < 1
@@ -43,9 +49,20 @@ class ApplicationsController < ApplicationController
     render json: { token: application.token, name: application.name }
   end
 
+  def update
+    application = Application.find_by!(token: params[:token])
+    application.update!(application_params)
+    render json: application
+  end
+
   private
 
+  def quota_change?
+    fields = params[:application]
+    fields.respond_to?(:key?) && QUOTA_FIELDS.any? { |field| fields.key?(field) }
+  end
+
   def application_params
-    params.require(:application).permit(:name)
+    params.require(:application).permit(:name, :rate_limit_create, :rate_limit_search, :language, :retention_days)
   end
 end
@@ -0,0 +1,38 @@
+# Guards the management endpoints of an application. A request passes with the
+# ADMIN_TOKEN secret in X-Admin-Token, or with an active full scope API key of
+# the application in the URL, sent as a bearer token like on go-chat.
+module AdminAuthorization
+  extend ActiveSupport::Concern
+
+  private
+
+  def require_admin
+    return if admin_token?
+
+    key = bearer_api_key
+    if key.nil?
+      response.headers["WWW-Authenticate"] = 'Bearer realm="rails-api"'
+      render json: { error: "missing or invalid credentials" }, status: :unauthorized
+    elsif key.application.token != params[:token]
+      render json: { error: "API key does not belong to this application" }, status: :forbidden
+    elsif key.scope != "full"
+      render json: { error: "API key is not allowed to perform this action" }, status: :forbidden
+    end
+  end
+
+  def admin_token?
+    expected = ENV["ADMIN_TOKEN"].to_s
+    given = request.headers["X-Admin-Token"].to_s
+    expected.present? && given.present? &&
+      ActiveSupport::SecurityUtils.secure_compare(given, expected)
+  end
+
+  def bearer_api_key
+    raw = request.authorization.to_s.delete_prefix("Bearer ").strip
+    prefix, secret = raw.split(".", 2)
+    return if prefix.blank? || secret.blank?
+
+    key = ApiKey.active.includes(:application).find_by(prefix: prefix)
+    key if key && ActiveSupport::SecurityUtils.secure_compare(key.secret_digest, ApiKey.digest(secret))
+  end
+end
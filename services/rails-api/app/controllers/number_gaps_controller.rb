@@ -0,0 +1,30 @@
+class NumberGapsController < ApplicationController
+  before_action :require_admin
+
+  def index
+    application = Application.find_by!(token: params[:token])
+
+    page = params.fetch(:page, 1).to_i
+    page = 1 if page < 1
+    per_page = params.fetch(:per_page, 20).to_i.clamp(1, 100)
+
+    gaps = application.number_gaps
+    gaps = gaps.where(cause: params[:cause]) if params[:cause].present?
+    gaps = gaps.where(chat_number: params[:chat_number]) if params[:chat_number].present?
+    if params[:tombstoned].present?
+      tombstoned = ActiveModel::Type::Boolean.new.cast(params[:tombstoned])
+      gaps = tombstoned ? gaps.where.not(tombstoned_at: nil) : gaps.where(tombstoned_at: nil)
+    end
+    total = gaps.count
+
+    render json: {
+      data: gaps.order(:chat_number, :message_number).offset((page - 1) * per_page).limit(per_page),
+      meta: {
+        page: page,
+        per_page: per_page,
+        total: total,
+        total_pages: (total.to_f / per_page).ceil
+      }
+    }
+  end
+end
@@ -0,0 +1,24 @@
+class RetentionPurgesController < ApplicationController
+  before_action :require_admin
+
+  def index
+    application = Application.find_by!(token: params[:token])
+
+    page = params.fetch(:page, 1).to_i
+    page = 1 if page < 1
+    per_page = params.fetch(:per_page, 20).to_i.clamp(1, 100)
+
+    purges = application.retention_purges
+    total = purges.count
+
+    render json: {
+      data: purges.order(started_at: :desc).offset((page - 1) * per_page).limit(per_page),
+      meta: {
+        page: page,
+        per_page: per_page,
+        total: total,
+        total_pages: (total.to_f / per_page).ceil
+      }
+    }
+  end
+end
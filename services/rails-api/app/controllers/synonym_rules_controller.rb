@@ -0,0 +1,34 @@
+class SynonymRulesController < ApplicationController
+  before_action :require_admin
+  before_action :set_application
+  before_action :set_synonym_rule, only: %i[update destroy]
+
+  def index
+    render json: { data: @application.synonym_rules.order(:id) }
+  end
+
+  def create
+    synonym_rule = @application.synonym_rules.create!(synonyms: params.require(:synonyms))
+    render json: synonym_rule, status: :created
+  end
+
+  def update
+    @synonym_rule.update!(synonyms: params.require(:synonyms))
+    render json: @synonym_rule
+  end
+
+  def destroy
+    @synonym_rule.destroy!
+    head :no_content
+  end
+
+  private
+
+  def set_application
+    @application = Application.find_by!(token: params[:token])
+  end
+
+  def set_synonym_rule
+    @synonym_rule = @application.synonym_rules.find(params[:id])
+  end
+end
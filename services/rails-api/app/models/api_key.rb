@@ -0,0 +1,51 @@
+class ApiKey < ApplicationRecord
+  # full: every go-chat endpoint, write: create chats/messages, search: search only
+  SCOPES = %w[full write search].freeze
+
+  belongs_to :application
+  validates :scope, inclusion: { in: SCOPES }
+  validates :prefix, presence: true, uniqueness: true
+  validates :secret_digest, presence: true
+  before_validation :generate_secret, on: :create
+  after_commit :expire_cache, on: %i[update destroy]
+
+  scope :active, -> { where(revoked_at: nil) }
+
+  # Plaintext secret, only available right after creation or rotation
+  attr_reader :secret
+
+  # go-chat verifies keys by comparing this same digest
+  def self.digest(secret)
+    Digest::SHA256.hexdigest(secret)
+  end
+
+  def plaintext_key
+    "#{prefix}.#{secret}" if secret
+  end
+
+  def rotate!
+    generate_secret
+    self.rotated_at = Time.current
+    save!
+  end
+
+  def revoke!
+    update!(revoked_at: Time.current)
+  end
+
+  def as_json(options = {})
+    super(options.merge(only: %i[id prefix scope rotated_at revoked_at created_at]))
+  end
+
+  private
+
+  def generate_secret
+    self.prefix ||= "ak_#{SecureRandom.hex(6)}"
+    @secret = SecureRandom.urlsafe_base64(32)
+    self.secret_digest = self.class.digest(@secret)
+  end
+
+  def expire_cache
+    $redis.del("apikey:#{prefix}")
+  end
+end
@@ -1,12 +1,35 @@
 class Application < ApplicationRecord
+  # Analyzer go-worker builds the application's index with. Anything other than
+  # standard, or any synonym rule, moves the application to a dedicated index.
+  LANGUAGES = %w[standard english german french spanish arabic cjk].freeze
+
   has_many :chats, dependent: :destroy
+  has_many :api_keys, dependent: :destroy
+  has_many :synonym_rules, dependent: :destroy
+  has_many :retention_purges, dependent: :delete_all
+  has_many :number_gaps, dependent: :delete_all
   validates :name, presence: true
   validates :token, presence: true, uniqueness: true
+  validates :rate_limit_create, :rate_limit_search,
+            numericality: { only_integer: true, greater_than: 0 }, allow_nil: true
+  validates :retention_days, numericality: { only_integer: true, greater_than: 0 }, allow_nil: true
+  validates :language, inclusion: { in: LANGUAGES }
   before_validation :generate_token, on: :create
+  after_commit :expire_cache, on: :update
+
+  # Synonyms set used by the search analyzers of this application's index
+  def synonyms_set_id
+    "app-#{token}"
+  end
 
   private
 
   def generate_token
     self.token ||= SecureRandom.uuid
   end
+
+  def expire_cache
+    # One DEL per key, they live in different slots of a Redis Cluster
+    %W[application:token:#{token} search:index:#{token} worker:application:#{token}].each { |key| $redis.del(key) }
+  end
 end
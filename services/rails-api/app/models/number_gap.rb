@@ -0,0 +1,12 @@
+# Written by go-worker's gap detection job, one row per missing chat or
+# message number. Rows of gaps that fill up are removed, tombstoned ones stay.
+class NumberGap < ApplicationRecord
+  CAUSES = %w[in_flight unpublished dead_lettered released lost].freeze
+
+  belongs_to :application
+  validates :cause, inclusion: { in: CAUSES }
+
+  def as_json(options = {})
+    super(options.merge(except: %i[application_id])).merge("tombstoned" => tombstoned_at.present?)
+  end
+end
@@ -0,0 +1,11 @@
+# Written by go-worker's retention job, one row per application and run
+class RetentionPurge < ApplicationRecord
+  STATUSES = %w[running completed failed].freeze
+
+  belongs_to :application
+  validates :status, inclusion: { in: STATUSES }
+
+  def as_json(options = {})
+    super(options.merge(except: %i[application_id]))
+  end
+end
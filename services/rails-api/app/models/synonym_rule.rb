@@ -0,0 +1,37 @@
+class SynonymRule < ApplicationRecord
+  # Solr format: "laptop, notebook" (equivalent) or "ny => new york" (explicit)
+  FORMAT = /\A[^,=>]+(,[^,=>]+)+\z|\A[^,=>]+(,[^,=>]+)*=>[^,=>]+(,[^,=>]+)*\z/
+
+  # Tokens of applications whose synonyms set could not be updated, go-worker
+  # rewrites those sets from MySQL
+  STALE_SETS_KEY = "search:synonyms:stale"
+
+  belongs_to :application
+  validates :synonyms, presence: true, length: { maximum: 1024 }, format: { with: FORMAT }
+  before_validation :normalize
+  after_commit :sync_synonyms_set
+
+  def as_json(options = {})
+    super(options.merge(only: %i[id synonyms created_at updated_at]))
+  end
+
+  private
+
+  def normalize
+    self.synonyms = synonyms.to_s.downcase.split(/\s*(,|=>)\s*/).join.strip
+  end
+
+  # Search analyzers read the set on the fly, so rule changes need no reindex.
+  # The rule is committed by now, failing the request would only make the
+  # client send it again, so a failed update is left to go-worker.
+  def sync_synonyms_set
+    ElasticsearchService.new.put_synonyms(application)
+  rescue => e
+    Rails.logger.error "Synonyms set of application #{application.id} left to go-worker: #{e.message}"
+    begin
+      $redis.sadd(STALE_SETS_KEY, application.token)
+    rescue Redis::BaseError => redis_error
+      Rails.logger.error "Failed to flag synonyms set of application #{application.id}: #{redis_error.message}"
+    end
+  end
+end
@@ -50,4 +50,17 @@ class ElasticsearchService
     Rails.logger.error "Elasticsearch search failed: #{e.message}"
     raise
   end
+
+  # Replaces the application's synonyms set. Elasticsearch reloads every search
+  # analyzer using it, no reindex needed.
+  def put_synonyms(application)
+    rules = application.synonym_rules.order(:id).map do |rule|
+      { id: rule.id.to_s, synonyms: rule.synonyms }
+    end
+
+    @client.synonyms.put_synonym(id: application.synonyms_set_id, body: { synonyms_set: rules })
+  rescue => e
+    Rails.logger.error "Elasticsearch synonyms update failed: #{e.message}"
+    raise
+  end
 end
@@ -1 +1,24 @@
-$redis = Redis.new(url: ENV.fetch("REDIS_URL") { "redis://localhost:6379/0" })
+# REDIS_MODE matches go-chat and go-worker: standalone uses REDIS_URL, sentinel
+# and cluster read REDIS_ADDRS as host:port entries separated by commas.
+redis_addrs = ENV.fetch("REDIS_ADDRS", "").split(",").map(&:strip).reject(&:empty?)
+redis_auth = { username: ENV["REDIS_USERNAME"].presence, password: ENV["REDIS_PASSWORD"].presence }.compact
+
+$redis =
+  case ENV.fetch("REDIS_MODE", "standalone")
+  when "sentinel"
+    sentinels = redis_addrs.map do |addr|
+      host, port = addr.split(":")
+      { host: host, port: (port || 26379).to_i, password: ENV["REDIS_SENTINEL_PASSWORD"].presence }.compact
+    end
+    Redis.new(name: ENV.fetch("REDIS_MASTER_NAME"), sentinels: sentinels, role: :master,
+              db: ENV.fetch("REDIS_DB", 0).to_i, **redis_auth)
+  when "cluster"
+    begin
+      require "redis-clustering"
+    rescue LoadError
+      raise "REDIS_MODE cluster needs the redis-clustering gem in the Gemfile"
+    end
+    Redis::Cluster.new(nodes: redis_addrs.map { |addr| "redis://#{addr}" }, **redis_auth)
+  else
+    Redis.new(url: ENV.fetch("REDIS_URL") { "redis://localhost:6379/0" })
+  end
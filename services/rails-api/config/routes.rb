@@ -8,6 +8,25 @@ Rails.application.routes.draw do
   get  "applications",          to: "applications#index"
   post "applications",          to: "applications#create"
   get  "applications/:token",   to: "applications#show"
+  patch "applications/:token",  to: "applications#update"
+
+  # API keys used to authenticate against go-chat
+  get    "applications/:token/api_keys",            to: "api_keys#index"
+  post   "applications/:token/api_keys",            to: "api_keys#create"
+  post   "applications/:token/api_keys/:id/rotate", to: "api_keys#rotate"
+  delete "applications/:token/api_keys/:id",        to: "api_keys#destroy"
+
+  # Per-application search synonyms
+  get    "applications/:token/synonyms",     to: "synonym_rules#index"
+  post   "applications/:token/synonyms",     to: "synonym_rules#create"
+  put    "applications/:token/synonyms/:id", to: "synonym_rules#update"
+  delete "applications/:token/synonyms/:id", to: "synonym_rules#destroy"
+
+  # Audit trail of messages purged by the retention policy
+  get "applications/:token/retention_purges", to: "retention_purges#index"
+
+  # Chat and message numbers that were handed out but never persisted
+  get "applications/:token/number_gaps", to: "number_gaps#index"
 
   # Message search endpoint
   get "applications/:application_token/chats/:chat_number/messages/search",
@@ -0,0 +1,7 @@
+class AddRateLimitsToApplications < ActiveRecord::Migration[8.1]
+  def change
+    # Requests allowed per rate limit window in go-chat, NULL means service default
+    add_column :applications, :rate_limit_create, :integer
+    add_column :applications, :rate_limit_search, :integer
+  end
+end
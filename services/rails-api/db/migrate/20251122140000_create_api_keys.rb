@@ -0,0 +1,15 @@
+class CreateApiKeys < ActiveRecord::Migration[8.1]
+  def change
+    create_table :api_keys do |t|
+      t.references :application, null: false, foreign_key: true
+      t.string :prefix, null: false
+      t.string :secret_digest, null: false
+      t.string :scope, null: false, default: "full"
+      t.datetime :rotated_at
+      t.datetime :revoked_at
+
+      t.timestamps
+    end
+    add_index :api_keys, :prefix, unique: true
+  end
+end
@@ -0,0 +1,14 @@
+class AddSearchSettingsToApplications < ActiveRecord::Migration[8.1]
+  def change
+    add_column :applications, :language, :string, null: false, default: "standard"
+    # Dedicated Elasticsearch index, set by go-worker once provisioned
+    add_column :applications, :search_index, :string
+
+    create_table :synonym_rules do |t|
+      t.references :application, null: false, foreign_key: true
+      t.string :synonyms, null: false, limit: 1024
+
+      t.timestamps
+    end
+  end
+end
@@ -0,0 +1,22 @@
+class AddRetentionToApplications < ActiveRecord::Migration[8.1]
+  def change
+    # Messages older than this many days are purged by go-worker, nil keeps them forever
+    add_column :applications, :retention_days, :integer
+
+    # Audit trail of every retention run, written by go-worker
+    create_table :retention_purges do |t|
+      t.references :application, null: false, foreign_key: true
+      t.datetime :cutoff, null: false
+      t.string :status, null: false, default: "running"
+      t.integer :messages_deleted, null: false, default: 0
+      t.integer :chats_affected, null: false, default: 0
+      t.integer :documents_deleted
+      t.text :error
+      t.datetime :started_at, null: false
+      t.datetime :finished_at
+
+      t.timestamps
+    end
+    add_index :messages, :created_at
+  end
+end
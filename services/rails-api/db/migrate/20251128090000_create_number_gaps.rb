@@ -0,0 +1,20 @@
+class CreateNumberGaps < ActiveRecord::Migration[8.1]
+  def change
+    # Chat and message numbers handed out by go-chat that never made it to
+    # MySQL, found by go-worker's gap detection job
+    create_table :number_gaps do |t|
+      t.references :application, null: false, foreign_key: true
+      t.integer :chat_number, null: false
+      # 0 when the chat itself is missing
+      t.integer :message_number, null: false, default: 0
+      t.string :cause, null: false
+      t.datetime :first_seen_at, null: false
+      t.datetime :last_seen_at, null: false
+      # Set once the number is given up on, it will never exist
+      t.datetime :tombstoned_at
+
+      t.timestamps
+    end
+    add_index :number_gaps, %i[application_id chat_number message_number], unique: true, name: "index_number_gaps_on_number"
+  end
+end
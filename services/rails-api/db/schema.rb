@@ -10,14 +10,32 @@
 #
 # It's strongly recommended that you check this file into your version control system.
 
-ActiveRecord::Schema[8.1].define(version: 2025_11_09_222341) do
+ActiveRecord::Schema[8.1].define(version: 2025_12_01_090300) do
+  create_table "api_keys", charset: "utf8mb4", collation: "utf8mb4_0900_ai_ci", force: :cascade do |t|
+    t.bigint "application_id", null: false
+    t.datetime "created_at", null: false
+    t.string "prefix", null: false
+    t.datetime "revoked_at"
+    t.datetime "rotated_at"
+    t.string "scope", default: "full", null: false
+    t.string "secret_digest", null: false
+    t.datetime "updated_at", null: false
+    t.index ["application_id"], name: "index_api_keys_on_application_id"
+    t.index ["prefix"], name: "index_api_keys_on_prefix", unique: true
+  end
+
   create_table "applications", charset: "utf8mb4", collation: "utf8mb4_0900_ai_ci", force: :cascade do |t|
     t.integer "chats_count", default: 0, null: false
     t.datetime "created_at", null: false
+    t.string "language", default: "standard", null: false
     t.string "name"
+    t.integer "rate_limit_create"
+    t.integer "rate_limit_search"
+    t.integer "retention_days"
+    t.string "search_index"
     t.string "token"
     t.datetime "updated_at", null: false
-    t.index ["token"], name: "index_applications_on_token"
+    t.index ["token"], name: "index_applications_on_token", unique: true
   end
 
   create_table "chats", charset: "utf8mb4", collation: "utf8mb4_0900_ai_ci", force: :cascade do |t|
@@ -27,6 +45,7 @@ ActiveRecord::Schema[8.1].define(version: 2025_11_09_222341) do
     t.integer "number"
     t.datetime "updated_at", null: false
     t.index ["application_id"], name: "index_chats_on_application_id"
+    t.index ["application_id", "number"], name: "index_chats_on_application_id_and_number", unique: true
   end
 
   create_table "messages", charset: "utf8mb4", collation: "utf8mb4_0900_ai_ci", force: :cascade do |t|
@@ -36,8 +55,51 @@ ActiveRecord::Schema[8.1].define(version: 2025_11_09_222341) do
     t.integer "number"
     t.datetime "updated_at", null: false
     t.index ["chat_id"], name: "index_messages_on_chat_id"
+    t.index ["chat_id", "number"], name: "index_messages_on_chat_id_and_number", unique: true
+    t.index ["created_at"], name: "index_messages_on_created_at"
+  end
+
+  create_table "number_gaps", charset: "utf8mb4", collation: "utf8mb4_0900_ai_ci", force: :cascade do |t|
+    t.bigint "application_id", null: false
+    t.string "cause", null: false
+    t.integer "chat_number", null: false
+    t.datetime "created_at", null: false
+    t.datetime "first_seen_at", null: false
+    t.datetime "last_seen_at", null: false
+    t.integer "message_number", default: 0, null: false
+    t.datetime "tombstoned_at"
+    t.datetime "updated_at", null: false
+    t.index ["application_id"], name: "index_number_gaps_on_application_id"
+    t.index ["application_id", "chat_number", "message_number"], name: "index_number_gaps_on_number", unique: true
+  end
+
+  create_table "retention_purges", charset: "utf8mb4", collation: "utf8mb4_0900_ai_ci", force: :cascade do |t|
+    t.bigint "application_id", null: false
+    t.integer "chats_affected", default: 0, null: false
+    t.datetime "created_at", null: false
+    t.datetime "cutoff", null: false
+    t.integer "documents_deleted"
+    t.text "error"
+    t.datetime "finished_at"
+    t.integer "messages_deleted", default: 0, null: false
+    t.datetime "started_at", null: false
+    t.string "status", default: "running", null: false
+    t.datetime "updated_at", null: false
+    t.index ["application_id"], name: "index_retention_purges_on_application_id"
+  end
+
+  create_table "synonym_rules", charset: "utf8mb4", collation: "utf8mb4_0900_ai_ci", force: :cascade do |t|
+    t.bigint "application_id", null: false
+    t.datetime "created_at", null: false
+    t.string "synonyms", limit: 1024, null: false
+    t.datetime "updated_at", null: false
+    t.index ["application_id"], name: "index_synonym_rules_on_application_id"
   end
 
+  add_foreign_key "api_keys", "applications"
   add_foreign_key "chats", "applications"
   add_foreign_key "messages", "chats"
+  add_foreign_key "number_gaps", "applications"
+  add_foreign_key "retention_purges", "applications"
+  add_foreign_key "synonym_rules", "applications"
 end
@@ -0,0 +1,58 @@
+require "test_helper"
+
+class ApiKeysControllerTest < ActionDispatch::IntegrationTest
+  setup do
+    @application = Application.create!(name: "tenant")
+    @full_key = @application.api_keys.create!(scope: "full")
+    @search_key = @application.api_keys.create!(scope: "search")
+  end
+
+  test "listing keys needs credentials" do
+    get "/applications/#{@application.token}/api_keys"
+
+    assert_response :unauthorized
+    assert_equal 'Bearer realm="rails-api"', response.headers["WWW-Authenticate"]
+  end
+
+  test "a key of a narrower scope cannot list keys" do
+    get "/applications/#{@application.token}/api_keys", headers: bearer_headers(@search_key)
+
+    assert_response :forbidden
+  end
+
+  test "a key of another application cannot list keys" do
+    other = Application.create!(name: "other").api_keys.create!(scope: "full")
+
+    get "/applications/#{@application.token}/api_keys", headers: bearer_headers(other)
+
+    assert_response :forbidden
+  end
+
+  test "a full scope key of the application lists keys" do
+    get "/applications/#{@application.token}/api_keys", headers: bearer_headers(@full_key)
+
+    assert_response :success
+    assert_equal 2, response.parsed_body["data"].size
+  end
+
+  test "an admin issues, rotates and revokes keys" do
+    post "/applications/#{@application.token}/api_keys", params: { scope: "write" }, headers: admin_headers, as: :json
+    assert_response :created
+    id = response.parsed_body["id"]
+
+    post "/applications/#{@application.token}/api_keys/#{id}/rotate", headers: admin_headers
+    assert_response :success
+    assert response.parsed_body["key"].present?
+
+    delete "/applications/#{@application.token}/api_keys/#{id}", headers: admin_headers
+    assert_response :no_content
+    assert ApiKey.find(id).revoked_at.present?
+  end
+
+  test "issuing a key needs credentials" do
+    assert_no_difference -> { ApiKey.count } do
+      post "/applications/#{@application.token}/api_keys", params: { scope: "full" }, as: :json
+    end
+    assert_response :unauthorized
+  end
+end
@@ -0,0 +1,65 @@
+require "test_helper"
+
+class ApplicationsControllerTest < ActionDispatch::IntegrationTest
+  setup do
+    @application = Application.create!(name: "tenant")
+  end
+
+  test "anyone can rename an application" do
+    patch "/applications/#{@application.token}", params: { application: { name: "renamed" } }, as: :json
+
+    assert_response :success
+    assert_equal "renamed", @application.reload.name
+  end
+
+  test "a non-admin update of the limits is rejected" do
+    %i[rate_limit_create rate_limit_search retention_days].each do |field|
+      patch "/applications/#{@application.token}", params: { application: { field => 100_000 } }, as: :json
+
+      assert_response :unauthorized, "#{field} was accepted without credentials"
+      assert_nil @application.reload.public_send(field)
+    end
+  end
+
+  test "a key of a narrower scope cannot update the limits" do
+    api_key = @application.api_keys.create!(scope: "write")
+
+    patch "/applications/#{@application.token}", params: { application: { rate_limit_create: 100_000 } },
+                                                 headers: bearer_headers(api_key), as: :json
+
+    assert_response :forbidden
+    assert_nil @application.reload.rate_limit_create
+  end
+
+  test "an admin can update the limits" do
+    patch "/applications/#{@application.token}", params: { application: { rate_limit_create: 50, rate_limit_search: 20 } },
+                                                 headers: admin_headers, as: :json
+
+    assert_response :success
+    @application.reload
+    assert_equal 50, @application.rate_limit_create
+    assert_equal 20, @application.rate_limit_search
+  end
+
+  test "a full scope key of the application can update the limits" do
+    api_key = @application.api_keys.create!(scope: "full")
+
+    patch "/applications/#{@application.token}", params: { application: { rate_limit_search: 20 } },
+                                                 headers: bearer_headers(api_key), as: :json
+
+    assert_response :success
+    assert_equal 20, @application.reload.rate_limit_search
+  end
+
+  test "creating an application with limits needs an admin" do
+    assert_no_difference -> { Application.count } do
+      post "/applications", params: { application: { name: "greedy", rate_limit_create: 100_000 } }, as: :json
+    end
+    assert_response :unauthorized
+
+    post "/applications", params: { application: { name: "granted", rate_limit_create: 50 } },
+                          headers: admin_headers, as: :json
+    assert_response :success
+    assert_equal 50, Application.find_by!(name: "granted").rate_limit_create
+  end
+end
@@ -0,0 +1,35 @@
+require "test_helper"
+require "minitest/mock"
+
+class SynonymRuleTest < ActiveSupport::TestCase
+  class FailingElasticsearch
+    def put_synonyms(_application)
+      raise "connection refused"
+    end
+  end
+
+  setup do
+    @application = Application.create!(name: "tenant")
+    $redis.srem(SynonymRule::STALE_SETS_KEY, @application.token)
+  end
+
+  teardown do
+    $redis.srem(SynonymRule::STALE_SETS_KEY, @application.token)
+  end
+
+  test "normalizes rules" do
+    rule = SynonymRule.new(application: @application, synonyms: " Laptop ,  Notebook ")
+    rule.validate
+
+    assert_equal "laptop,notebook", rule.synonyms
+  end
+
+  test "a failed set update keeps the rule and leaves the set to go-worker" do
+    rule = ElasticsearchService.stub(:new, FailingElasticsearch.new) do
+      @application.synonym_rules.create!(synonyms: "laptop, notebook")
+    end
+
+    assert rule.persisted?
+    assert $redis.sismember(SynonymRule::STALE_SETS_KEY, @application.token)
+  end
+end
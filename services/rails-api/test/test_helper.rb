@@ -0,0 +1,30 @@
+ENV["RAILS_ENV"] ||= "test"
+require_relative "../config/environment"
+require "rails/test_help"
+
+module ActiveSupport
+  class TestCase
+    parallelize(workers: :number_of_processors)
+  end
+end
+
+class ActionDispatch::IntegrationTest
+  ADMIN_TOKEN = "test-admin-token"
+
+  setup do
+    @admin_token_was = ENV["ADMIN_TOKEN"]
+    ENV["ADMIN_TOKEN"] = ADMIN_TOKEN
+  end
+
+  teardown do
+    ENV["ADMIN_TOKEN"] = @admin_token_was
+  end
+
+  def admin_headers
+    { "X-Admin-Token" => ADMIN_TOKEN }
+  end
+
+  def bearer_headers(api_key)
+    { "Authorization" => "Bearer #{api_key.plaintext_key}" }
+  end
+end